@@ -16,7 +16,8 @@ import (
 func TestAmplify(t *testing.T) {
 	threshold := 8
 	dbLen := 1024 * 1024 // dbLen is specified in bits
-	db := database.CreateRandomBinaryLWEWithLength(utils.RandomPRG(), dbLen)
+	db, err := database.CreateRandomBinaryLWEWithLength(utils.RandomPRG(), dbLen)
+	require.NoError(t, err)
 	p := utils.ParamsWithDatabaseSize(db.Info.NumRows, db.Info.NumColumns)
 
 	retrieveBlocksAmplify(t, db, p, threshold, "TestAmplify")
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
@@ -21,13 +23,18 @@ import (
 	"github.com/si-co/vpir-code/lib/utils"
 	"golang.org/x/xerrors"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/status"
 )
 
 const (
 	configEnvKey = "VPIR_CONFIG"
 
+	defaultRetries = 2
+
 	defaultConfigFile = "config.toml"
 )
 
@@ -52,6 +59,12 @@ type flags struct {
 
 	listenAddr string
 
+	// only for local benchmarks, disables TLS
+	insecure bool
+
+	// number of times a query is sent again after a transient failure
+	retries int
+
 	scheme    string
 	id        string
 	target    string
@@ -118,16 +131,15 @@ func main() {
 }
 
 func (lc *localClient) connectToServers() error {
-	// load servers certificates
-	creds, err := utils.LoadServersCertificates()
+	creds, err := lc.transportCredentials()
 	if err != nil {
-		return xerrors.Errorf("could not load servers certificates: %v", err)
+		return err
 	}
 
 	// connect to servers and store connections
 	lc.connections = make(map[string]*grpc.ClientConn)
 	for _, s := range lc.config.Addresses {
-		conn, err := connectToServer(creds, s)
+		conn, err := connectToServer(creds[s], s)
 		if err != nil {
 			return xerrors.Errorf("failed to connect: %v", err)
 		}
@@ -138,6 +150,28 @@ func (lc *localClient) connectToServers() error {
 	return nil
 }
 
+// transportCredentials returns the credentials used to connect to every
+// server, keyed by address, i.e., the servers certificates set in the
+// config unless TLS is disabled
+func (lc *localClient) transportCredentials() (map[string]credentials.TransportCredentials, error) {
+	if lc.flags.insecure {
+		log.Println("WARNING: TLS is disabled, -insecure must not be used in production")
+		creds := make(map[string]credentials.TransportCredentials, len(lc.config.Addresses))
+		for _, addr := range lc.config.Addresses {
+			creds[addr] = insecure.NewCredentials()
+		}
+		return creds, nil
+	}
+
+	// load servers certificates
+	creds, err := utils.LoadConfigCredentials(lc.config)
+	if err != nil {
+		return nil, xerrors.Errorf("could not load servers certificates: %v", err)
+	}
+
+	return creds, nil
+}
+
 func (lc *localClient) closeConnections() {
 	for _, conn := range lc.connections {
 		err := conn.Close()
@@ -153,6 +187,9 @@ func (lc *localClient) exec() (string, error) {
 	// In the Keyd PoC application, we will hardcode the database
 	// information in the client.
 	lc.retrieveDBInfo()
+	if err := client.ValidatePIRType(lc.flags.scheme, lc.dbInfo); err != nil {
+		return "", err
+	}
 
 	// start correct client, which can be either IT or DPF.
 	switch lc.flags.scheme {
@@ -279,7 +316,7 @@ func (lc *localClient) retrieveKeyGivenId(id string) (string, error) {
 	t := time.Now()
 
 	// compute hash key for id
-	hashKey := database.HashToIndex(id, lc.dbInfo.NumRows*lc.dbInfo.NumColumns)
+	hashKey := database.HashToIndex(id, lc.dbInfo.NumBlocks())
 	log.Printf("id: %s, hashKey: %d", id, hashKey)
 
 	// query given hash key
@@ -343,12 +380,16 @@ func (lc *localClient) retrieveDBInfo() {
 	subCtx, cancel := context.WithTimeout(lc.ctx, time.Hour)
 	defer cancel()
 
+	type result struct {
+		target string
+		info   *database.Info
+	}
 	wg := sync.WaitGroup{}
-	resCh := make(chan *database.Info, len(lc.connections))
+	resCh := make(chan result, len(lc.connections))
 	for _, conn := range lc.connections {
 		wg.Add(1)
 		go func(conn *grpc.ClientConn) {
-			resCh <- dbInfo(subCtx, conn, lc.callOptions)
+			resCh <- result{conn.Target(), dbInfo(subCtx, conn, lc.callOptions)}
 			wg.Done()
 		}(conn)
 	}
@@ -356,16 +397,21 @@ func (lc *localClient) retrieveDBInfo() {
 	close(resCh)
 
 	dbInfo := make([]*database.Info, 0)
-	for i := range resCh {
-		dbInfo = append(dbInfo, i)
+	targets := make([]string, 0)
+	for r := range resCh {
+		dbInfo = append(dbInfo, r.info)
+		targets = append(targets, r.target)
 	}
 
 	// check if db info are all equal before returning
 	if !equalDBInfo(dbInfo) {
 		log.Fatal("got different database info from servers")
 	}
+	if err := database.CheckMerkleRoots(dbInfo, targets); err != nil {
+		log.Fatal(err)
+	}
 
-	log.Printf("databaseInfo: %#v", dbInfo[0])
+	log.Printf("databaseInfo: %v", dbInfo[0])
 
 	lc.dbInfo = dbInfo[0]
 }
@@ -384,9 +430,13 @@ func dbInfo(ctx context.Context, conn *grpc.ClientConn, opts []grpc.CallOption)
 		NumRows:    int(answer.GetNumRows()),
 		NumColumns: int(answer.GetNumColumns()),
 		BlockSize:  int(answer.GetBlockLength()),
-		PIRType:    answer.GetPirType(),
+		PIRType:    database.PIRType(answer.GetPirType()),
 		Merkle:     &database.Merkle{Root: answer.GetRoot(), ProofLen: int(answer.GetProofLen())},
 	}
+	// the client checks the retrieved blocks against their hashes, if any
+	if h := answer.GetBlockHashes(); len(h) > 0 {
+		dbInfo.BlockHashes = &database.BlockHashes{HashKey: answer.GetHashKey(), Hashes: h}
+	}
 
 	return dbInfo
 }
@@ -401,7 +451,7 @@ func (lc *localClient) runQueries(queries [][]byte) [][]byte {
 	for _, conn := range lc.connections {
 		wg.Add(1)
 		go func(j int, conn *grpc.ClientConn) {
-			resCh <- queryServer(subCtx, conn, lc.callOptions, queries[j])
+			resCh <- queryServer(subCtx, conn, lc.callOptions, queries[j], lc.retries())
 			wg.Done()
 		}(j, conn)
 		j++
@@ -418,18 +468,53 @@ func (lc *localClient) runQueries(queries [][]byte) [][]byte {
 	return q
 }
 
-func queryServer(ctx context.Context, conn *grpc.ClientConn, opts []grpc.CallOption, query []byte) []byte {
-	c := proto.NewVPIRClient(conn)
-	q := &proto.QueryRequest{Query: query}
-	answer, err := c.Query(ctx, q, opts...)
-	if err != nil {
-		log.Fatalf("could not query %s: %v",
-			conn.Target(), err)
+// retryBackoff is the delay before the first retry of a query, doubled at
+// every retry
+var retryBackoff = time.Second
+
+// retries returns the number of retries of a query, the default one if the
+// client was not started with flags
+func (lc *localClient) retries() int {
+	if lc.flags == nil {
+		return defaultRetries
 	}
-	log.Printf("sent query to %s", conn.Target())
-	log.Printf("query size in bytes %d", len(query))
+	return lc.flags.retries
+}
 
-	return answer.GetAnswer()
+// queryServer sends the query to the server of conn, and sends it again up
+// to retries times if the server is unavailable. All the attempts carry the
+// same request ID, so that the server answers a query it already computed
+// from its cache.
+func queryServer(ctx context.Context, conn *grpc.ClientConn, opts []grpc.CallOption, query []byte, retries int) []byte {
+	c := proto.NewVPIRClient(conn)
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		log.Fatalf("could not generate request ID: %v", err)
+	}
+	q := &proto.QueryRequest{Query: query, RequestId: hex.EncodeToString(id)}
+
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		answer, err := c.Query(ctx, q, opts...)
+		if err == nil {
+			log.Printf("sent query to %s", conn.Target())
+			log.Printf("query size in bytes %d", len(query))
+			if answer.GetCached() {
+				log.Printf("answer of %s served from its cache", conn.Target())
+			}
+			return answer.GetAnswer()
+		}
+		if status.Code(err) != codes.Unavailable || attempt == retries {
+			log.Fatalf("could not query %s: %v", conn.Target(), err)
+		}
+		log.Printf("query to %s failed, retrying in %v: %v", conn.Target(), backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			log.Fatalf("could not query %s: %v", conn.Target(), ctx.Err())
+		}
+		backoff *= 2
+	}
 }
 
 func connectToServer(creds credentials.TransportCredentials, address string) (*grpc.ClientConn, error) {
@@ -468,6 +553,8 @@ func parseFlags() *flags {
 	// experiment flags
 	flag.BoolVar(&f.experiment, "experiment", false, "run for experiments")
 	flag.IntVar(&f.cores, "cores", -1, "num of cores used for experiment")
+	flag.BoolVar(&f.insecure, "insecure", false, "disable TLS, only for local benchmarks")
+	flag.IntVar(&f.retries, "retries", defaultRetries, "times a query failing with a transient error is sent again, under the same request ID")
 
 	// scheme flags
 	flag.StringVar(&f.scheme, "scheme", "", "scheme to use: it, dpf or pit-it, pir-dpf")
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/si-co/vpir-code/lib/client"
+	"github.com/si-co/vpir-code/lib/database"
+	"github.com/si-co/vpir-code/lib/proto"
+	"github.com/si-co/vpir-code/lib/proto/prototest"
+	"github.com/si-co/vpir-code/lib/server"
+	"github.com/si-co/vpir-code/lib/utils"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/status"
+)
+
+func TestLoopbackRetrieveBlock(t *testing.T) {
+	dbLen := 8 * 16 * 16 * 64
+	nRows, blockLen := 16, 64
+
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), dbLen, nRows, blockLen)
+	require.NoError(t, err)
+
+	lc := &localClient{
+		ctx:         context.Background(),
+		callOptions: []grpc.CallOption{grpc.UseCompressor(gzip.Name)},
+		connections: make(map[string]*grpc.ClientConn),
+		prg:         utils.RandomPRG(),
+	}
+
+	// two in-process servers on the same db
+	for i := 0; i < 2; i++ {
+		l := prototest.NewLoopback(prototest.NewVPIRServer(server.NewPIR(db)))
+		defer l.Close()
+		conn, err := l.Dial(lc.ctx)
+		require.NoError(t, err)
+		lc.connections[fmt.Sprintf("server%d", i)] = conn
+	}
+	defer lc.closeConnections()
+
+	lc.retrieveDBInfo()
+	require.Equal(t, db.NumRows, lc.dbInfo.NumRows)
+	require.Equal(t, db.NumColumns, lc.dbInfo.NumColumns)
+	lc.vpirClient = client.NewPIR(lc.prg, lc.dbInfo)
+
+	index := 42
+	in := make([]byte, 4)
+	binary.BigEndian.PutUint32(in, uint32(index))
+	queries, err := lc.vpirClient.QueryBytes(in, len(lc.connections))
+	require.NoError(t, err)
+
+	res, err := lc.vpirClient.ReconstructBytes(lc.runQueries(queries))
+	require.NoError(t, err)
+	require.Equal(t, db.Entries[index*blockLen:(index+1)*blockLen], res)
+}
+
+func TestDatabaseInfoBlockHashes(t *testing.T) {
+	db := randomBytesDB(t)
+	require.NoError(t, database.AddBlockHashes(db, []byte("block hashes key")))
+	l := prototest.NewLoopback(prototest.NewVPIRServer(server.NewPIR(db)))
+	defer l.Close()
+	conn, err := l.Dial(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// the client gets the block hashes to verify the retrieved blocks
+	info := dbInfo(context.Background(), conn, nil)
+	require.Equal(t, db.BlockHashes, info.BlockHashes)
+	ok, err := info.VerifyBlock(42, db.Entries[42*db.BlockSize:43*db.BlockSize])
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestInsecureDatabaseInfo(t *testing.T) {
+	db := randomBytesDB(t)
+
+	// plain TCP server without TLS
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	rpcServer := grpc.NewServer(grpc.Creds(insecure.NewCredentials()))
+	proto.RegisterVPIRServer(rpcServer, prototest.NewVPIRServer(server.NewPIR(db)))
+	go rpcServer.Serve(lis)
+	defer rpcServer.Stop()
+
+	lc := &localClient{
+		ctx:    context.Background(),
+		config: &utils.Config{Addresses: []string{lis.Addr().String()}},
+		flags:  &flags{insecure: true},
+	}
+	require.NoError(t, lc.connectToServers())
+	defer lc.closeConnections()
+
+	lc.retrieveDBInfo()
+	require.Equal(t, db.NumRows, lc.dbInfo.NumRows)
+	require.Equal(t, db.NumColumns, lc.dbInfo.NumColumns)
+	require.Equal(t, db.BlockSize, lc.dbInfo.BlockSize)
+}
+
+// flakyServer fails the first queries as unavailable and records the
+// request IDs of all the queries
+type flakyServer struct {
+	proto.VPIRServer
+	failures int
+	ids      []string
+}
+
+func (s *flakyServer) Query(ctx context.Context, qr *proto.QueryRequest) (*proto.QueryResponse, error) {
+	s.ids = append(s.ids, qr.GetRequestId())
+	if len(s.ids) <= s.failures {
+		return nil, status.Error(codes.Unavailable, "not ready")
+	}
+	return s.VPIRServer.Query(ctx, qr)
+}
+
+func TestQueryServerRetries(t *testing.T) {
+	defer func(d time.Duration) { retryBackoff = d }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	db := randomBytesDB(t)
+	queries, err := client.NewPIR(utils.RandomPRG(), &db.Info).QueryBytes([]byte{0, 0, 0, 42}, 2)
+	require.NoError(t, err)
+
+	fs := &flakyServer{VPIRServer: prototest.NewVPIRServer(server.NewPIR(db)), failures: 2}
+	l := prototest.NewLoopback(fs)
+	defer l.Close()
+	conn, err := l.Dial(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// the query is answered at the third attempt, always under the same ID
+	answer := queryServer(context.Background(), conn, nil, queries[0], 2)
+	expected, err := server.NewPIR(db).AnswerBytes(queries[0])
+	require.NoError(t, err)
+	require.Equal(t, expected, answer)
+	require.Len(t, fs.ids, 3)
+	require.NotEmpty(t, fs.ids[0])
+	require.Equal(t, fs.ids[0], fs.ids[1])
+	require.Equal(t, fs.ids[0], fs.ids[2])
+}
+
+func TestPerServerTLS(t *testing.T) {
+	db := randomBytesDB(t)
+	dir := t.TempDir()
+
+	// two servers with certificates from different CAs and names, loaded
+	// from files as with -cert and -key, and a config file giving the CAs
+	// relative to its directory
+	config := ""
+	for i := 0; i < 2; i++ {
+		name := fmt.Sprintf("server%d.test", i)
+		certPEM, keyPEM := selfSignedCertificate(t, name)
+		certFile := filepath.Join(dir, fmt.Sprintf("ca%d.pem", i))
+		keyFile := filepath.Join(dir, fmt.Sprintf("key%d.pem", i))
+		require.NoError(t, os.WriteFile(certFile, certPEM, 0o600))
+		require.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+		cert, err := utils.ServerCertificate(certFile, keyFile, i)
+		require.NoError(t, err)
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		rpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(
+			&tls.Config{Certificates: []tls.Certificate{cert}})))
+		proto.RegisterVPIRServer(rpcServer, prototest.NewVPIRServer(server.NewPIR(db)))
+		go rpcServer.Serve(lis)
+		defer rpcServer.Stop()
+
+		addr := lis.Addr().(*net.TCPAddr)
+		config += fmt.Sprintf(`
+[servers.%d]
+ip = "127.0.0.1"
+port = %d
+
+[tls."%s"]
+ca = "ca%d.pem"
+serverName = "%s"
+`, i, addr.Port, addr, i, name)
+	}
+	configFile := filepath.Join(dir, "config.toml")
+	require.NoError(t, os.WriteFile(configFile, []byte(config), 0o600))
+	c, err := utils.LoadConfig(configFile)
+	require.NoError(t, err)
+
+	lc := &localClient{
+		ctx:    context.Background(),
+		config: c,
+		flags:  &flags{},
+	}
+	require.NoError(t, lc.connectToServers())
+	defer lc.closeConnections()
+
+	lc.retrieveDBInfo()
+	require.Equal(t, db.NumRows, lc.dbInfo.NumRows)
+	require.Equal(t, db.NumColumns, lc.dbInfo.NumColumns)
+
+	// every server address needs a TLS entry
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+[servers.0]
+ip = "127.0.0.1"
+port = 50050
+
+[servers.1]
+ip = "127.0.0.1"
+port = 50051
+
+[tls."127.0.0.1:50050"]
+ca = "ca0.pem"
+`), 0o600))
+	_, err = utils.LoadConfig(configFile)
+	require.ErrorContains(t, err, "no TLS entry for server 127.0.0.1:50051")
+
+	// the certificate needs its key
+	_, err = utils.ServerCertificate(filepath.Join(dir, "ca0.pem"), "", 0)
+	require.Error(t, err)
+}
+
+// selfSignedCertificate returns the PEM encodings of a self-signed
+// certificate for name, to be used as its own CA, and of its key
+func selfSignedCertificate(t *testing.T, name string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+// randomBytesDB returns a random bytes db of 16 rows of 16 blocks of 64
+// bytes, the db of most of the tests
+func randomBytesDB(t testing.TB) *database.Bytes {
+	t.Helper()
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), 8*16*16*64, 16, 64)
+	require.NoError(t, err)
+	return db
+}
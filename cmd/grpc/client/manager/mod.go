@@ -37,7 +37,7 @@ func (m *Manager) Connect() (Actor, error) {
 	servers := make([]server, len(m.config.Addresses))
 
 	// load servers certificates
-	creds, err := utils.LoadServersCertificates()
+	creds, err := utils.LoadConfigCredentials(&m.config)
 	if err != nil {
 		return Actor{}, xerrors.Errorf("failed to load servers certificates: %v", err)
 	}
@@ -46,7 +46,7 @@ func (m *Manager) Connect() (Actor, error) {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 		defer cancel()
 
-		conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(creds),
+		conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(creds[addr]),
 			grpc.WithBlock())
 		if err != nil {
 			return Actor{}, xerrors.Errorf("failed to connect to %s: %v", addr, err)
@@ -72,7 +72,7 @@ func (a *Actor) GetKey(id string, dbInfo database.Info, client *client.PIR) (str
 	t := time.Now()
 
 	// compute hash key for id
-	hashKey := database.HashToIndex(id, dbInfo.NumRows*dbInfo.NumColumns)
+	hashKey := database.HashToIndex(id, dbInfo.NumBlocks())
 	log.Printf("id: %s, hashKey: %d", id, hashKey)
 
 	// query given hash key
@@ -178,7 +178,7 @@ func (a *Actor) GetDBInfos() ([]database.Info, error) {
 		}
 	}
 
-	log.Printf("databaseInfo: %#v", dbInfo[0])
+	log.Printf("databaseInfo: %v", dbInfo[0])
 
 	return dbInfo, nil
 }
@@ -253,7 +253,7 @@ func (s server) getDBInfo(ctx context.Context) database.Info {
 		NumRows:    int(answer.GetNumRows()),
 		NumColumns: int(answer.GetNumColumns()),
 		BlockSize:  int(answer.GetBlockLength()),
-		PIRType:    answer.GetPirType(),
+		PIRType:    database.PIRType(answer.GetPirType()),
 		Merkle:     &database.Merkle{Root: answer.GetRoot(), ProofLen: int(answer.GetProofLen())},
 	}
 
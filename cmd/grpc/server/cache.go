@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/si-co/vpir-code/lib/proto"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// answerCache is a least-recently-used cache of the responses to queries,
+// keyed by the request ID chosen by the client, so that a retried query is
+// not computed again. The cache holds at most maxBytes bytes of answers and
+// its entries expire after ttl. An answerCache is safe for concurrent use.
+type answerCache struct {
+	mu       sync.Mutex
+	maxBytes int
+	ttl      time.Duration
+	size     int
+	order    *list.List // front is the most recently used
+	entries  map[string]*list.Element
+	// gen is incremented when the cache is cleared, so that the answers
+	// computed before are not cached
+	gen uint64
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+type cacheEntry struct {
+	id      string
+	digest  [sha256.Size]byte
+	resp    *proto.QueryResponse
+	expires time.Time
+}
+
+func newAnswerCache(maxBytes int, ttl time.Duration) *answerCache {
+	return &answerCache{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		now:      time.Now,
+	}
+}
+
+// get returns the cached response to the query with the given request ID, or
+// nil if there is none. A response cached for a different query under the
+// same ID is not returned.
+func (c *answerCache) get(id string, query []byte) *proto.QueryResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[id]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*cacheEntry)
+	if c.now().After(e.expires) {
+		c.remove(el)
+		return nil
+	}
+	digest := sha256.Sum256(query)
+	if !bytes.Equal(digest[:], e.digest[:]) {
+		return nil
+	}
+	c.order.MoveToFront(el)
+
+	resp := protobuf.Clone(e.resp).(*proto.QueryResponse)
+	resp.Cached = true
+	return resp
+}
+
+// generation returns the current generation of the cache, to be passed to
+// put for an answer computed afterwards
+func (c *answerCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// put caches the response to the query with the given request ID, evicting
+// the least recently used entries to stay within maxBytes. Answers larger
+// than maxBytes, and answers computed before the cache was cleared, i.e.,
+// in an older generation than gen, are not cached.
+func (c *answerCache) put(gen uint64, id string, query []byte, resp *proto.QueryResponse) {
+	if len(resp.Answer) > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+
+	if el, ok := c.entries[id]; ok {
+		c.remove(el)
+	}
+	for c.size+len(resp.Answer) > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+	c.entries[id] = c.order.PushFront(&cacheEntry{
+		id:      id,
+		digest:  sha256.Sum256(query),
+		resp:    resp,
+		expires: c.now().Add(c.ttl),
+	})
+	c.size += len(resp.Answer)
+}
+
+// clear removes all the cached responses, e.g., after the db changed
+func (c *answerCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.size = 0
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+func (c *answerCache) remove(el *list.Element) {
+	e := c.order.Remove(el).(*cacheEntry)
+	delete(c.entries, e.id)
+	c.size -= len(e.resp.Answer)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -14,6 +15,7 @@ import (
 	"runtime"
 	"runtime/pprof"
 	"syscall"
+	"time"
 
 	"github.com/si-co/vpir-code/cmd/grpc/sdnotify"
 	"github.com/si-co/vpir-code/lib/database"
@@ -23,8 +25,13 @@ import (
 	"github.com/si-co/vpir-code/lib/proto"
 	"github.com/si-co/vpir-code/lib/server"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -45,6 +52,16 @@ func main() {
 	logFile := flag.String("log", "", "write log to file instead of stdout/stderr")
 	prof := flag.Bool("prof", false, "Write CPU prof file")
 	mprof := flag.Bool("mprof", false, "Write memory prof file")
+	dbPath := flag.String("db", "", "load a db generated offline instead of building it")
+	macKeyFile := flag.String("macKey", "", "file holding the key of the MAC of the db given with -db, which is checked against it")
+	mmap := flag.Bool("mmap", false, "map in memory the flat pointPIR/pointVPIR db given with -db instead of loading it")
+	noTLS := flag.Bool("insecure", false, "disable TLS, only for local benchmarks")
+	certFile := flag.String("cert", "", "PEM file of the TLS certificate of the server, instead of the built-in one for its id")
+	keyFile := flag.String("key", "", "PEM file of the private key of the certificate given with -cert")
+	cacheBytes := flag.Int("cacheBytes", 64<<20, "bytes of answers cached for retried queries, 0 to disable the cache")
+	cacheTTL := flag.Duration("cacheTTL", time.Minute, "time after which a cached answer expires")
+	warmup := flag.Bool("warmup", false, "read the whole db before reporting SERVING, e.g., a db mapped with -mmap")
+	numa := flag.Bool("numa", false, "answer pointPIR/pointVPIR queries with a thread pinned to every CPU of the NUMA nodes, on copies of the db local to the nodes, see "+server.NUMANodesEnv)
 
 	flag.Parse()
 
@@ -91,24 +108,42 @@ func main() {
 	}
 	addr := config.Addresses[*sid]
 
+	// the key of the MAC of the db file, if any
+	var macKey []byte
+	if *macKeyFile != "" {
+		if *dbPath == "" {
+			log.Fatal("-macKey needs a db file given with -db")
+		}
+		if macKey, err = os.ReadFile(*macKeyFile); err != nil {
+			log.Fatalf("could not read the MAC key: %v", err)
+		}
+	}
+
 	// load the db
 	var db *database.DB
 	var dbBytes *database.Bytes
+	var unmap func() error
 	switch *scheme {
-	case "pointPIR":
-		dbBytes, err = loadPgpBytes(*filesNumber, true)
-		if err != nil {
-			log.Fatalf("impossible to construct real keys bytes db: %v", err)
+	case "pointPIR", "pointVPIR":
+		if *dbPath != "" && *mmap {
+			dbBytes, unmap, err = database.MapBytesWithMAC(*dbPath, macKey)
+		} else if *dbPath != "" {
+			dbBytes, err = database.LoadBytesWithMAC(*dbPath, macKey)
+		} else if *scheme == "pointPIR" {
+			dbBytes, err = loadPgpBytes(*filesNumber, true)
+		} else {
+			dbBytes, err = loadPgpMerkle(*filesNumber, true)
 		}
-		log.Printf("db size in GiB: %f", dbBytes.SizeGiB())
-	case "pointVPIR":
-		dbBytes, err = loadPgpMerkle(*filesNumber, true)
 		if err != nil {
 			log.Fatalf("impossible to construct real keys bytes db: %v", err)
 		}
 		log.Printf("db size in GiB: %f", dbBytes.SizeGiB())
 	case "complexPIR", "complexVPIR":
-		db, err = loadPgpDB(*filesNumber, true)
+		if *dbPath != "" {
+			db, err = database.LoadDBWithMAC(*dbPath, macKey)
+		} else {
+			db, err = loadPgpDB(*filesNumber, true)
+		}
 		if err != nil {
 			log.Fatalf("impossible to load real keys db: %v", err)
 		}
@@ -120,10 +155,21 @@ func main() {
 	// GC after db creation
 	runtime.GC()
 
-	// run server with TLS
-	cfg := &tls.Config{
-		Certificates: []tls.Certificate{utils.ServerCertificates[*sid]},
-		ClientAuth:   tls.NoClientCert,
+	// run server with TLS, unless disabled
+	var creds credentials.TransportCredentials
+	if *noTLS {
+		log.Println("WARNING: TLS is disabled, -insecure must not be used in production")
+		creds = insecure.NewCredentials()
+	} else {
+		cert, err := utils.ServerCertificate(*certFile, *keyFile, *sid)
+		if err != nil {
+			log.Fatalf("could not load the TLS certificate: %v", err)
+		}
+		cfg := &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.NoClientCert,
+		}
+		creds = credentials.NewTLS(cfg)
 	}
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -132,14 +178,25 @@ func main() {
 	rpcServer := grpc.NewServer(
 		grpc.MaxRecvMsgSize(1024*1024*1024),
 		grpc.MaxSendMsgSize(1024*1024*1024),
-		grpc.Creds(credentials.NewTLS(cfg)),
+		grpc.Creds(creds),
 	)
 
 	// select correct server
 	var s server.Server
 	switch *scheme {
 	case "pointPIR", "pointVPIR":
-		if *cores != -1 && *experiment {
+		if *numa {
+			nodes, err := server.NUMANodes()
+			if err != nil {
+				log.Fatalf("failed to get the NUMA nodes: %v", err)
+			}
+			ps, err := server.NewPIRWithNUMA(dbBytes, nodes)
+			if err != nil {
+				log.Fatalf("failed to create the server: %v", err)
+			}
+			defer ps.Close()
+			s = ps
+		} else if *cores != -1 && *experiment {
 			s = server.NewPIR(dbBytes, *cores)
 		} else {
 			s = server.NewPIR(dbBytes)
@@ -159,6 +216,7 @@ func main() {
 	default:
 		log.Fatal("unknow scheme")
 	}
+	warm, _ := s.(server.WarmupServer)
 
 	// start server
 	server := &vpirServer{
@@ -167,7 +225,21 @@ func main() {
 		cores:      *cores,
 		queryChan:  make(chan queryWrapper, 10),
 	}
+	if *cacheBytes > 0 {
+		server.cache = newAnswerCache(*cacheBytes, *cacheTTL)
+	}
+	healthServer := health.NewServer()
+	if dbBytes != nil && *dbPath != "" {
+		server.reloader = &dbReloader{mmap: *mmap, macKey: macKey, warmup: *warmup, unmap: unmap, health: healthServer}
+	} else if unmap != nil {
+		defer unmap()
+	}
 	proto.RegisterVPIRServer(rpcServer, server)
+	healthpb.RegisterHealthServer(rpcServer, healthServer)
+	// the clients checking the health wait for the warmup
+	if *warmup {
+		healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	}
 
 	go server.startWorker()
 
@@ -176,6 +248,21 @@ func main() {
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
 	errCh := make(chan error, 1)
 
+	// reload the db from -db on SIGHUP, e.g., after updating the file
+	if server.reloader != nil {
+		hupCh := make(chan os.Signal, 1)
+		signal.Notify(hupCh, syscall.SIGHUP)
+		go func() {
+			for range hupCh {
+				if err := server.reloadDB(*dbPath); err != nil {
+					log.Printf("db reload failed, still serving the old db: %v", err)
+					continue
+				}
+				log.Printf("db reloaded from %s", *dbPath)
+			}
+		}()
+	}
+
 	go func() {
 		log.Println("gRPC server started at", lis.Addr())
 		if err := rpcServer.Serve(lis); err != nil {
@@ -200,6 +287,15 @@ func main() {
 		}()
 	}
 
+	if *warmup {
+		if warm != nil {
+			log.Printf("db warmed up in %v", warm.Warmup())
+		} else {
+			log.Printf("no warmup for scheme %s", *scheme)
+		}
+		healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	}
+
 	_, err = sdnotify.SdNotify(false, sdnotify.SdNotifyReady)
 	if err != nil {
 		log.Fatalf("failed to sdnotify: %v", err)
@@ -209,9 +305,13 @@ func main() {
 	case err := <-errCh:
 		log.Fatalf("failed to serve: %v", err)
 	case <-sigCh:
+		healthServer.Shutdown()
 		server.stopWorker()
 		rpcServer.GracefulStop()
 		lis.Close()
+		if server.reloader != nil && server.reloader.unmap != nil {
+			server.reloader.unmap()
+		}
 		log.Println("clean shutdown of server done")
 	}
 
@@ -220,7 +320,7 @@ func main() {
 
 type queryWrapper struct {
 	query  *proto.QueryRequest
-	answer chan []byte
+	answer chan *proto.QueryResponse
 	error  chan error
 }
 
@@ -231,6 +331,13 @@ type vpirServer struct {
 
 	queryChan chan queryWrapper
 
+	// cache holds the answers to the queries with a request ID, nil if
+	// caching is disabled
+	cache *answerCache
+
+	// reloader reloads the db on SIGHUP, nil if the db cannot be reloaded
+	reloader *dbReloader
+
 	// only for experiments
 	experiment bool
 	cores      int
@@ -245,9 +352,15 @@ func (s *vpirServer) DatabaseInfo(ctx context.Context, r *proto.DatabaseInfoRequ
 		NumRows:     uint32(dbInfo.NumRows),
 		NumColumns:  uint32(dbInfo.NumColumns),
 		BlockLength: uint32(dbInfo.BlockSize),
-		PirType:     dbInfo.PIRType,
-		Root:        dbInfo.Root,
-		ProofLen:    uint32(dbInfo.ProofLen),
+		PirType:     string(dbInfo.PIRType),
+	}
+	if dbInfo.Merkle != nil {
+		resp.Root = dbInfo.Root
+		resp.ProofLen = uint32(dbInfo.ProofLen)
+	}
+	if dbInfo.BlockHashes != nil {
+		resp.HashKey = dbInfo.HashKey
+		resp.BlockHashes = dbInfo.Hashes
 	}
 
 	return resp, nil
@@ -257,15 +370,25 @@ func (s *vpirServer) Query(ctx context.Context, qr *proto.QueryRequest) (
 	*proto.QueryResponse, error) {
 	log.Print("got query request")
 
-	answerCh := make(chan []byte, 1)
+	if id := qr.GetRequestId(); s.cache != nil && id != "" {
+		if resp := s.cache.get(id, qr.GetQuery()); resp != nil {
+			log.Printf("answer to request %s served from cache", id)
+			return resp, nil
+		}
+	}
+
+	answerCh := make(chan *proto.QueryResponse, 1)
 	errorCh := make(chan error, 1)
 	s.queryChan <- queryWrapper{qr, answerCh, errorCh}
 
 	select {
-	case answer := <-answerCh:
-		return &proto.QueryResponse{Answer: answer}, nil
+	case resp := <-answerCh:
+		return resp, nil
 	case err := <-errorCh:
 		log.Printf("ERROR while processing query: %v", err)
+		if errors.Is(err, server.ErrInvalidQuery) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
 		return nil, err
 	case <-ctx.Done():
 		log.Printf("Context deadline exceeded - canceled?")
@@ -275,22 +398,54 @@ func (s *vpirServer) Query(ctx context.Context, qr *proto.QueryRequest) (
 
 func (s *vpirServer) startWorker() {
 	for wrap := range s.queryChan {
+		var gen uint64
+		if s.cache != nil {
+			gen = s.cache.generation()
+		}
 
-		a, err := s.Server.AnswerBytes(wrap.query.GetQuery())
+		resp, err := s.answer(wrap.query.GetQuery())
 		if err != nil {
 			wrap.error <- err
 			continue
 		}
-		answerLen := len(a)
+		answerLen := len(resp.Answer)
 		log.Printf("answer size in bytes: %d", answerLen)
 		if s.experiment {
 			log.Printf("stats,%d,%d", s.cores, answerLen)
 		}
+		if id := wrap.query.GetRequestId(); s.cache != nil && id != "" {
+			s.cache.put(gen, id, wrap.query.GetQuery(), resp)
+		}
 
-		wrap.answer <- a
+		wrap.answer <- resp
 	}
 }
 
+// answer computes the response to the query, including the answer
+// statistics if the server reports them
+func (s *vpirServer) answer(q []byte) (*proto.QueryResponse, error) {
+	ss, ok := s.Server.(server.StatsServer)
+	if !ok {
+		a, err := s.Server.AnswerBytes(q)
+		if err != nil {
+			return nil, err
+		}
+		return &proto.QueryResponse{Answer: a}, nil
+	}
+
+	a, stats, err := ss.AnswerBytesWithStats(q)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proto.QueryResponse{
+		Answer:      a,
+		AnswerBytes: uint64(stats.AnswerBytes),
+		ComputeMs:   stats.ComputeMs,
+		WorkersUsed: uint32(stats.WorkersUsed),
+	}, nil
+}
+
 func (s *vpirServer) stopWorker() {
 	close(s.queryChan)
 }
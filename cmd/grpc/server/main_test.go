@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/si-co/vpir-code/lib/client"
+	"github.com/si-co/vpir-code/lib/database"
+	"github.com/si-co/vpir-code/lib/proto"
+	"github.com/si-co/vpir-code/lib/server"
+	"github.com/si-co/vpir-code/lib/utils"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// countingServer counts the answers it computes
+type countingServer struct {
+	server.Server
+	answers int32
+}
+
+func (s *countingServer) AnswerBytes(q []byte) ([]byte, error) {
+	atomic.AddInt32(&s.answers, 1)
+	return s.Server.AnswerBytes(q)
+}
+
+func TestRetriedQueryServedFromCache(t *testing.T) {
+	db := randomBytesDB(t)
+	queries, err := client.NewPIR(utils.RandomPRG(), &db.Info).QueryBytes([]byte{0, 0, 0, 42}, 2)
+	require.NoError(t, err)
+
+	cs := &countingServer{Server: server.NewPIR(db)}
+	s := &vpirServer{
+		Server:    cs,
+		queryChan: make(chan queryWrapper, 10),
+		cache:     newAnswerCache(2*db.NumRows*db.BlockSize, time.Minute),
+	}
+	now := time.Now()
+	s.cache.now = func() time.Time { return now }
+	go s.startWorker()
+	defer s.stopWorker()
+
+	ctx := context.Background()
+	query := func(id string, q []byte) *proto.QueryResponse {
+		resp, err := s.Query(ctx, &proto.QueryRequest{Query: q, RequestId: id})
+		require.NoError(t, err)
+		return resp
+	}
+
+	// the retried request is answered from the cache
+	first := query("a", queries[0])
+	require.False(t, first.GetCached())
+	retried := query("a", queries[0])
+	require.True(t, retried.GetCached())
+	require.Equal(t, first.GetAnswer(), retried.GetAnswer())
+	require.Equal(t, int32(1), atomic.LoadInt32(&cs.answers))
+
+	// a different query under the same ID and queries without ID are
+	// computed
+	require.False(t, query("a", queries[1]).GetCached())
+	require.False(t, query("", queries[1]).GetCached())
+	require.False(t, query("", queries[1]).GetCached())
+	require.Equal(t, int32(4), atomic.LoadInt32(&cs.answers))
+
+	// the cache holds two answers, the least recently used is evicted
+	query("b", queries[0])
+	query("c", queries[0])
+	require.True(t, query("b", queries[0]).GetCached())
+	require.False(t, query("a", queries[1]).GetCached())
+
+	// entries expire
+	now = now.Add(2 * time.Minute)
+	require.False(t, query("b", queries[0]).GetCached())
+}
+
+func TestReloadDB(t *testing.T) {
+	dir := t.TempDir()
+	oldDB := randomBytesDB(t)
+	newDB := randomBytesDB(t)
+	newPath := filepath.Join(dir, "new.db")
+	require.NoError(t, database.SaveBytes(newPath, newDB))
+	badPath := filepath.Join(dir, "bad.db")
+	require.NoError(t, os.WriteFile(badPath, []byte("not a db"), 0o600))
+
+	healthServer := health.NewServer()
+	s := &vpirServer{
+		Server:    server.NewPIR(oldDB),
+		queryChan: make(chan queryWrapper, 10),
+		cache:     newAnswerCache(2*oldDB.NumRows*oldDB.BlockSize, time.Minute),
+		reloader:  &dbReloader{health: healthServer},
+	}
+	go s.startWorker()
+	defer s.stopWorker()
+
+	ctx := context.Background()
+	const index = 42
+	in := make([]byte, 4)
+	binary.BigEndian.PutUint32(in, uint32(index))
+	c := client.NewPIR(utils.RandomPRG(), &oldDB.Info)
+	queries, err := c.QueryBytes(in, 2)
+	require.NoError(t, err)
+	retrieve := func(id string) []byte {
+		answers := make([][]byte, len(queries))
+		for k, q := range queries {
+			resp, err := s.Query(ctx, &proto.QueryRequest{Query: q, RequestId: id})
+			require.NoError(t, err)
+			answers[k] = resp.GetAnswer()
+		}
+		res, err := c.Reconstruct(answers)
+		require.NoError(t, err)
+		return res
+	}
+	status := func() healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := healthServer.Check(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		return resp.GetStatus()
+	}
+
+	block := func(db *database.Bytes) []byte {
+		return db.Entries[index*db.BlockSize : (index+1)*db.BlockSize]
+	}
+	require.Equal(t, block(oldDB), retrieve("a"))
+
+	// a failed reload keeps serving the old db
+	require.Error(t, s.reloadDB(badPath))
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, status())
+	require.Equal(t, block(oldDB), retrieve("b"))
+
+	// a reload serves the new db, also to the retried requests answered
+	// from the cache before
+	require.NoError(t, s.reloadDB(newPath))
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, status())
+	require.Equal(t, block(newDB), retrieve("a"))
+}
+
+// randomBytesDB returns a random bytes db of 16 rows of 16 blocks of 64
+// bytes, the db of most of the tests
+func randomBytesDB(t testing.TB) *database.Bytes {
+	t.Helper()
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), 8*16*16*64, 16, 64)
+	require.NoError(t, err)
+	return db
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/si-co/vpir-code/lib/database"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// bytesSwapper is implemented by the servers whose bytes db can be replaced
+// while serving, i.e., server.PIR
+type bytesSwapper interface {
+	SwapDB(db *database.Bytes)
+}
+
+// dbReloader reloads the bytes db of a vpirServer from a file, e.g., on
+// SIGHUP after the file has been updated
+type dbReloader struct {
+	// mu serializes the reloads
+	mu sync.Mutex
+	// mmap maps the db in memory instead of loading it, as -mmap
+	mmap bool
+	// macKey is the key of the MAC of the db file, as -macKey, or nil
+	macKey []byte
+	// warmup reads the whole new db before the swap, as -warmup
+	warmup bool
+	// unmap unmaps the db currently served, if it is mapped
+	unmap func() error
+	// health reports NOT_SERVING during the swap, if not nil
+	health *health.Server
+}
+
+// reloadDB loads the bytes db saved at path and swaps it for the db served
+// by s. The health of the server is NOT_SERVING during the swap and the
+// cached answers to the old db are discarded. If the new db cannot be
+// loaded, the old one keeps being served and the error is returned.
+func (s *vpirServer) reloadDB(path string) error {
+	sw, ok := s.Server.(bytesSwapper)
+	if !ok || s.reloader == nil {
+		return errors.New("the db of this scheme cannot be reloaded")
+	}
+	r := s.reloader
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var db *database.Bytes
+	var unmap func() error
+	var err error
+	if r.mmap {
+		db, unmap, err = database.MapBytesWithMAC(path, r.macKey)
+	} else {
+		db, err = database.LoadBytesWithMAC(path, r.macKey)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load db from %s: %v", path, err)
+	}
+
+	if r.warmup {
+		t := time.Now()
+		db.Touch()
+		log.Printf("new db warmed up in %v", time.Since(t))
+	}
+
+	r.setServingStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	sw.SwapDB(db)
+	if s.cache != nil {
+		s.cache.clear()
+	}
+	r.setServingStatus(healthpb.HealthCheckResponse_SERVING)
+
+	// no answer uses the old db once SwapDB returns
+	old := r.unmap
+	r.unmap = unmap
+	if old != nil {
+		if err := old(); err != nil {
+			return fmt.Errorf("failed to unmap the old db: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *dbReloader) setServingStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	if r.health != nil {
+		r.health.SetServingStatus("", status)
+	}
+}
@@ -3,14 +3,24 @@ package main
 // Test suite for integrated VPIR.
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/nikirill/go-crypto/openpgp/packet"
+	"github.com/si-co/vpir-code/lib/client"
 	"github.com/si-co/vpir-code/lib/database"
+	"github.com/si-co/vpir-code/lib/field"
+	"github.com/si-co/vpir-code/lib/fss"
 	"github.com/si-co/vpir-code/lib/query"
+	"github.com/si-co/vpir-code/lib/server"
 	"github.com/si-co/vpir-code/lib/utils"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -35,6 +45,581 @@ func initRandomDB() {
 	runtime.GC()
 }
 
+func TestConcurrentClientsComplex(t *testing.T) {
+	db, err := database.CreateRandomKeysDB(utils.RandomPRG(), 1000)
+	require.NoError(t, err)
+	// a few emails with multiple matches
+	for i := 0; i < 30; i++ {
+		db.KeysInfo[i].UserId.Email = db.KeysInfo[i%3].UserId.Email
+	}
+
+	// one pair of servers shared by all the clients
+	s0 := server.NewPredicateAPIR(db, 0)
+	s1 := server.NewPredicateAPIR(db, 1)
+
+	numClients := 16
+	errs := make(chan error, numClients)
+	var wg sync.WaitGroup
+	for k := 0; k < numClients; k++ {
+		wg.Add(1)
+		go func(k int) {
+			defer wg.Done()
+			errs <- retrieveComplexConcurrent(db, s0, s1, db.KeysInfo[k].UserId.Email)
+		}(k)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func TestAnswerContextComplex(t *testing.T) {
+	db, err := database.CreateRandomKeysDB(utils.RandomPRG(), 5000)
+	require.NoError(t, err)
+	match := db.KeysInfo[0].UserId.Email
+
+	c := client.NewPredicateAPIR(utils.RandomPRG(), &db.Info)
+	q := (&query.Info{Target: query.UserId}).ToEmailClientFSS(match)
+	in, err := q.Encode()
+	require.NoError(t, err)
+	fssKeys, err := c.QueryBytes(in, 2)
+	require.NoError(t, err)
+
+	// the chunked evaluation gives the same result
+	s0 := server.NewPredicateAPIR(db, 0, 4)
+	s1 := server.NewPredicateAPIR(db, 1, 4)
+	a0, err := s0.AnswerBytesContext(context.Background(), fssKeys[0], nil)
+	require.NoError(t, err)
+	a1, err := s1.AnswerBytesContext(context.Background(), fssKeys[1], nil)
+	require.NoError(t, err)
+	res, err := c.ReconstructBytes([][]byte{a0, a1})
+	require.NoError(t, err)
+	require.Equal(t, localResult(db, q.Info, match), res.(uint32))
+
+	// cancel after the first chunk, with a single goroutine
+	s := server.NewPredicateAPIR(db, 0, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	evaluated := 0
+	_, err = s.AnswerBytesContext(ctx, fssKeys[0], func(done, total int) {
+		evaluated = done
+		cancel()
+	})
+	require.True(t, errors.Is(err, context.Canceled))
+	require.Less(t, evaluated, db.NumColumns)
+}
+
+func TestAnswerStatsComplex(t *testing.T) {
+	db, err := database.CreateRandomKeysDB(utils.RandomPRG(), 5000)
+	require.NoError(t, err)
+	match := db.KeysInfo[0].UserId.Email
+	q := (&query.Info{Target: query.UserId}).ToEmailClientFSS(match)
+	in, err := q.Encode()
+	require.NoError(t, err)
+	c := client.NewPredicateAPIR(utils.RandomPRG(), &db.Info)
+	fssKeys, err := c.QueryBytes(in, 2)
+	require.NoError(t, err)
+
+	// concurrent answers, each timing its concurrent workers, e.g., with
+	// go test -race
+	for _, cores := range []int{1, 3} {
+		s := []server.StatsServer{server.NewPredicateAPIR(db, 0, cores), server.NewPredicateAPIR(db, 1, cores)}
+		// the answers, statistics and errors of every run are checked once
+		// all the runs are done
+		runs := 4
+		answers := make([][][]byte, runs)
+		stats := make([][]server.AnswerStats, runs)
+		errs := make([]error, runs)
+		var wg sync.WaitGroup
+		for r := 0; r < runs; r++ {
+			answers[r] = make([][]byte, len(s))
+			stats[r] = make([]server.AnswerStats, len(s))
+			wg.Add(1)
+			go func(r int) {
+				defer wg.Done()
+				for k := range s {
+					answers[r][k], stats[r][k], errs[r] = s[k].AnswerBytesWithStats(fssKeys[k])
+					if errs[r] != nil {
+						return
+					}
+				}
+			}(r)
+		}
+		wg.Wait()
+
+		for r := 0; r < runs; r++ {
+			require.NoError(t, errs[r])
+			for k := range s {
+				require.Equal(t, len(answers[r][k]), stats[r][k].AnswerBytes)
+				require.Equal(t, cores, stats[r][k].WorkersUsed)
+				require.Equal(t, cores, stats[r][k].Workers.Iterations)
+				require.GreaterOrEqual(t, stats[r][k].Workers.MinMs, float64(0))
+				require.GreaterOrEqual(t, stats[r][k].ComputeMs, float64(0))
+
+				// the same answers as the sequential evaluation
+				expected, err := s[k].AnswerBytes(fssKeys[k])
+				require.NoError(t, err)
+				require.Equal(t, expected, answers[r][k])
+			}
+		}
+	}
+	a0, err := server.NewPredicateAPIR(db, 0, 3).AnswerBytes(fssKeys[0])
+	require.NoError(t, err)
+	a1, err := server.NewPredicateAPIR(db, 1, 3).AnswerBytes(fssKeys[1])
+	require.NoError(t, err)
+	res, err := c.ReconstructBytes([][]byte{a0, a1})
+	require.NoError(t, err)
+	require.Equal(t, localResult(db, q.Info, match), res.(uint32))
+}
+
+func TestAnswerBatchComplex(t *testing.T) {
+	db, err := database.CreateRandomKeysDB(utils.RandomPRG(), 5000)
+	require.NoError(t, err)
+
+	// queries on different targets, including an AVG query with a longer
+	// answer
+	c := client.NewPredicateAPIR(utils.RandomPRG(), &db.Info)
+	infos := []*query.ClientFSS{
+		(&query.Info{Target: query.UserId}).ToEmailClientFSS(db.KeysInfo[0].UserId.Email),
+		(&query.Info{Target: query.PubKeyAlgo}).ToPKAClientFSS("RSA"),
+		(&query.Info{Target: query.UserId, FromEnd: 4}).ToEmailClientFSS(".com"),
+		(&query.Info{And: true, Avg: true, FromEnd: 4}).ToAvgClientFSS(".com"),
+	}
+	batch := make([][]byte, len(infos))
+	for i, q := range infos {
+		in, err := q.Encode()
+		require.NoError(t, err)
+		fssKeys, err := c.QueryBytes(in, 2)
+		require.NoError(t, err)
+		batch[i] = fssKeys[0]
+	}
+
+	var s server.BatchServer = server.NewPredicateAPIR(db, 0, 4)
+	answers, err := s.AnswerBatchBytes(batch)
+	require.NoError(t, err)
+	require.Len(t, answers, len(batch))
+	for i := range batch {
+		expected, err := s.AnswerBytes(batch[i])
+		require.NoError(t, err)
+		require.Equal(t, expected, answers[i])
+	}
+}
+
+func TestLastVerifiedComplex(t *testing.T) {
+	db, err := database.CreateRandomKeysDB(utils.RandomPRG(), 1000)
+	require.NoError(t, err)
+	q := (&query.Info{Target: query.UserId}).ToEmailClientFSS(db.KeysInfo[0].UserId.Email)
+	in, err := q.Encode()
+	require.NoError(t, err)
+
+	// the authenticated scheme verifies the tags, the classical one does
+	// not
+	for _, tc := range []struct {
+		c        client.Client
+		s0, s1   server.Server
+		verified bool
+	}{
+		{client.NewPredicateAPIR(utils.RandomPRG(), &db.Info),
+			server.NewPredicateAPIR(db, 0), server.NewPredicateAPIR(db, 1), true},
+		{client.NewPredicatePIR(utils.RandomPRG(), &db.Info),
+			server.NewPredicatePIR(db, 0), server.NewPredicatePIR(db, 1), false},
+	} {
+		fssKeys, err := tc.c.QueryBytes(in, 2)
+		require.NoError(t, err)
+		a0, err := tc.s0.AnswerBytes(fssKeys[0])
+		require.NoError(t, err)
+		a1, err := tc.s1.AnswerBytes(fssKeys[1])
+		require.NoError(t, err)
+		_, err = tc.c.ReconstructBytes([][]byte{a0, a1})
+		require.NoError(t, err)
+		require.Equal(t, tc.verified, tc.c.(client.Verifier).LastVerified())
+	}
+
+	// the classical scheme reconstructs answers without a previous query
+	c := client.NewPredicatePIR(utils.RandomPRG(), &db.Info)
+	out, err := c.Reconstruct([][]uint32{{1}, {2}})
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), out)
+	require.False(t, c.LastVerified())
+}
+
+func TestLastSizesComplex(t *testing.T) {
+	db, err := database.CreateRandomKeysDB(utils.RandomPRG(), 1000)
+	require.NoError(t, err)
+	q := (&query.Info{Target: query.UserId}).ToEmailClientFSS(db.KeysInfo[0].UserId.Email)
+	in, err := q.Encode()
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		c      client.Client
+		s0, s1 server.Server
+	}{
+		{client.NewPredicateAPIR(utils.RandomPRG(), &db.Info),
+			server.NewPredicateAPIR(db, 0), server.NewPredicateAPIR(db, 1)},
+		{client.NewPredicatePIR(utils.RandomPRG(), &db.Info),
+			server.NewPredicatePIR(db, 0), server.NewPredicatePIR(db, 1)},
+	} {
+		fssKeys, err := tc.c.QueryBytes(in, 2)
+		require.NoError(t, err)
+		require.Equal(t, len(fssKeys[0])+len(fssKeys[1]), tc.c.LastQueryBytes())
+		a0, err := tc.s0.AnswerBytes(fssKeys[0])
+		require.NoError(t, err)
+		a1, err := tc.s1.AnswerBytes(fssKeys[1])
+		require.NoError(t, err)
+		_, err = tc.c.ReconstructBytes([][]byte{a0, a1})
+		require.NoError(t, err)
+		require.Equal(t, len(a0)+len(a1), tc.c.LastAnswerBytes())
+	}
+}
+
+func TestVerifyAnswersComplex(t *testing.T) {
+	db, err := database.CreateRandomKeysDB(utils.RandomPRG(), 1000)
+	require.NoError(t, err)
+	q := (&query.Info{Target: query.UserId}).ToEmailClientFSS(db.KeysInfo[0].UserId.Email)
+
+	c := client.NewPredicateAPIR(utils.RandomPRG(), &db.Info)
+	fssKeys := c.Query(q, 2)
+	answers := [][]uint32{
+		server.NewPredicateAPIR(db, 0).Answer(fssKeys[0]),
+		server.NewPredicateAPIR(db, 1).Answer(fssKeys[1]),
+	}
+	corrupted := func(k int, corrupt func(a []uint32) []uint32) [][]uint32 {
+		out := [][]uint32{append([]uint32{}, answers[0]...), append([]uint32{}, answers[1]...)}
+		out[k] = corrupt(out[k])
+		return out
+	}
+
+	ok, failing, err := c.VerifyAnswers(answers)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Empty(t, failing)
+
+	for _, tc := range []struct {
+		answers [][]uint32
+		failing []int
+	}{
+		// malformed answers are blamed on their server
+		{corrupted(1, func(a []uint32) []uint32 { a[2] = field.ModP; return a }), []int{1}},
+		{corrupted(0, func(a []uint32) []uint32 { return a[1:] }), []int{0}},
+		// a tampered share can only be blamed on both servers
+		{corrupted(1, func(a []uint32) []uint32 { a[0] = (a[0] + 1) % field.ModP; return a }), []int{0, 1}},
+		{corrupted(0, func(a []uint32) []uint32 { a[3] = (a[3] + 1) % field.ModP; return a }), []int{0, 1}},
+	} {
+		ok, failing, err := c.VerifyAnswers(tc.answers)
+		require.NoError(t, err)
+		require.False(t, ok)
+		require.Equal(t, tc.failing, failing)
+	}
+
+	// Reconstruct runs the same checks
+	_, err = c.Reconstruct(corrupted(1, func(a []uint32) []uint32 { a[0]++; return a }))
+	require.ErrorIs(t, err, client.ErrReject)
+	res, err := c.Reconstruct(answers)
+	require.NoError(t, err)
+	require.Equal(t, localResult(db, q.Info, db.KeysInfo[0].UserId.Email), res)
+}
+
+func TestReconstructVerboseComplex(t *testing.T) {
+	db, err := database.CreateRandomKeysDB(utils.RandomPRG(), 1000)
+	require.NoError(t, err)
+	q := (&query.Info{Target: query.UserId}).ToEmailClientFSS(db.KeysInfo[0].UserId.Email)
+	expected := localResult(db, q.Info, db.KeysInfo[0].UserId.Email)
+
+	c := client.NewPredicateAPIR(utils.RandomPRG(), &db.Info)
+	fssKeys := c.Query(q, 2)
+	answers := [][]uint32{
+		server.NewPredicateAPIR(db, 0).Answer(fssKeys[0]),
+		server.NewPredicateAPIR(db, 1).Answer(fssKeys[1]),
+	}
+
+	// correct answers leave no residual
+	res, residuals, err := c.ReconstructVerbose(answers)
+	require.NoError(t, err)
+	require.Equal(t, expected, res)
+	require.Equal(t, make([]uint32, field.ConcurrentExecutions), residuals)
+
+	// a tampered tag leaves the tampering as its residual, and only there
+	tampered := [][]uint32{append([]uint32{}, answers[0]...), answers[1]}
+	tampered[0][3] = (tampered[0][3] + 5) % field.ModP
+	_, residuals, err = c.ReconstructVerbose(tampered)
+	require.ErrorIs(t, err, client.ErrReject)
+	for i, r := range residuals {
+		if i == 2 {
+			require.Equal(t, uint32(5), r)
+		} else {
+			require.Zero(t, r)
+		}
+	}
+
+	// a tampered share of the data breaks all the tags
+	tampered = [][]uint32{append([]uint32{}, answers[0]...), answers[1]}
+	tampered[0][0] = (tampered[0][0] + 1) % field.ModP
+	_, residuals, err = c.ReconstructVerbose(tampered)
+	require.ErrorIs(t, err, client.ErrReject)
+	for _, r := range residuals {
+		require.NotZero(t, r)
+	}
+
+	_, _, err = c.ReconstructVerbose(answers[:1])
+	require.Error(t, err)
+}
+
+func TestTaggedUntaggedComplex(t *testing.T) {
+	db, err := database.CreateRandomKeysDB(utils.RandomPRG(), 1000)
+	require.NoError(t, err)
+	for i := 0; i < 30; i++ {
+		db.KeysInfo[i].UserId.Email = db.KeysInfo[0].UserId.Email
+	}
+	match := db.KeysInfo[0].UserId.Email
+	info := &query.Info{Target: query.UserId}
+	in, err := info.ToEmailClientFSS(match).Encode()
+	require.NoError(t, err)
+	expected := localResult(db, info, match)
+
+	// the untagged scheme answers with the value only, the tagged one
+	// with the value and a tag per execution
+	for _, tc := range []struct {
+		c          client.Client
+		s0, s1     server.Server
+		answerSize int
+	}{
+		{client.NewPredicatePIR(utils.RandomPRG(), &db.Info),
+			server.NewPredicatePIR(db, 0), server.NewPredicatePIR(db, 1), field.Bytes},
+		{client.NewPredicateAPIR(utils.RandomPRG(), &db.Info),
+			server.NewPredicateAPIR(db, 0), server.NewPredicateAPIR(db, 1),
+			(1 + field.ConcurrentExecutions) * field.Bytes},
+	} {
+		fssKeys, err := tc.c.QueryBytes(in, 2)
+		require.NoError(t, err)
+		a0, err := tc.s0.AnswerBytes(fssKeys[0])
+		require.NoError(t, err)
+		a1, err := tc.s1.AnswerBytes(fssKeys[1])
+		require.NoError(t, err)
+		require.Len(t, a0, tc.answerSize)
+		require.Len(t, a1, tc.answerSize)
+
+		res, err := tc.c.ReconstructBytes([][]byte{a0, a1})
+		require.NoError(t, err)
+		require.Equal(t, expected, res)
+	}
+}
+
+func TestKeySizeComplex(t *testing.T) {
+	db, err := database.CreateRandomKeysDB(utils.RandomPRG(), 1000)
+	require.NoError(t, err)
+	match := db.KeysInfo[0].UserId.Email
+	info := &query.Info{Target: query.UserId}
+	in, err := info.ToEmailClientFSS(match).Encode()
+	require.NoError(t, err)
+	expected := localResult(db, info, match)
+
+	for _, keySize := range []int{fss.KeySize128, fss.KeySize192, fss.KeySize256} {
+		cPIR, err := client.NewPredicatePIRWithKeySize(utils.RandomPRG(), &db.Info, keySize)
+		require.NoError(t, err)
+		cAPIR, err := client.NewPredicateAPIRWithKeySize(utils.RandomPRG(), &db.Info, keySize)
+		require.NoError(t, err)
+		sPIR := make([]server.Server, 2)
+		sAPIR := make([]server.Server, 2)
+		for k := range sPIR {
+			sPIR[k], err = server.NewPredicatePIRWithKeySize(db, byte(k), keySize)
+			require.NoError(t, err)
+			sAPIR[k], err = server.NewPredicateAPIRWithKeySize(db, byte(k), keySize)
+			require.NoError(t, err)
+		}
+
+		for _, tc := range []struct {
+			c  client.Client
+			ss []server.Server
+		}{{cPIR, sPIR}, {cAPIR, sAPIR}} {
+			fssKeys, err := tc.c.QueryBytes(in, 2)
+			require.NoError(t, err)
+			a0, err := tc.ss[0].AnswerBytes(fssKeys[0])
+			require.NoError(t, err)
+			a1, err := tc.ss[1].AnswerBytes(fssKeys[1])
+			require.NoError(t, err)
+			res, err := tc.c.ReconstructBytes([][]byte{a0, a1})
+			require.NoError(t, err)
+			require.Equal(t, expected, res)
+		}
+	}
+
+	// a server rejects the queries of another key size
+	c, err := client.NewPredicatePIRWithKeySize(utils.RandomPRG(), &db.Info, fss.KeySize256)
+	require.NoError(t, err)
+	fssKeys, err := c.QueryBytes(in, 2)
+	require.NoError(t, err)
+	_, err = server.NewPredicatePIR(db, 0).AnswerBytes(fssKeys[0])
+	require.ErrorIs(t, err, server.ErrInvalidQuery)
+
+	_, err = client.NewPredicatePIRWithKeySize(utils.RandomPRG(), &db.Info, 20)
+	require.Error(t, err)
+	_, err = server.NewPredicateAPIRWithKeySize(db, 0, 20)
+	require.Error(t, err)
+}
+
+func TestDeterministicKeysComplex(t *testing.T) {
+	db, err := database.CreateRandomKeysDB(utils.RandomPRG(), 1000)
+	require.NoError(t, err)
+	match := db.KeysInfo[0].UserId.Email
+	info := &query.Info{Target: query.UserId}
+	in, err := info.ToEmailClientFSS(match).Encode()
+	require.NoError(t, err)
+
+	key := utils.RandomPRGKey()
+	c0 := client.NewDPFWithPRG(utils.NewPRG(key), &db.Info)
+	c1 := client.NewDPFWithPRG(utils.NewPRG(key), &db.Info)
+	fssKeys0, err := c0.QueryBytes(in, 2)
+	require.NoError(t, err)
+	fssKeys1, err := c1.QueryBytes(in, 2)
+	require.NoError(t, err)
+	require.Equal(t, fssKeys0, fssKeys1)
+
+	// the deterministic keys retrieve the right result
+	s0 := server.NewPredicatePIR(db, 0)
+	s1 := server.NewPredicatePIR(db, 1)
+	a0, err := s0.AnswerBytes(fssKeys0[0])
+	require.NoError(t, err)
+	a1, err := s1.AnswerBytes(fssKeys0[1])
+	require.NoError(t, err)
+	res, err := c0.ReconstructBytes([][]byte{a0, a1})
+	require.NoError(t, err)
+	require.Equal(t, localResult(db, info, match), res)
+
+	// another seed gives other keys
+	c2 := client.NewDPFWithPRG(utils.RandomPRG(), &db.Info)
+	fssKeys2, err := c2.QueryBytes(in, 2)
+	require.NoError(t, err)
+	require.NotEqual(t, fssKeys0, fssKeys2)
+}
+
+func TestPredicateComplex(t *testing.T) {
+	db, err := database.CreateRandomKeysDB(utils.RandomPRG(), 1000)
+	require.NoError(t, err)
+	for i := 0; i < 40; i++ {
+		email := db.KeysInfo[i].UserId.Email
+		db.KeysInfo[i].UserId.Email = "START" + email[5:len(email)-4] + ".edu"
+		db.KeysInfo[i].PubKeyAlgo = packet.PubKeyAlgoECDSA
+		if i%2 == 0 {
+			db.KeysInfo[i].CreationTime = time.Date(2019, time.March, 14, 0, 0, 0, 0, time.UTC)
+		}
+	}
+
+	count := func(selects func(k *database.KeyInfo) bool) uint32 {
+		c := uint32(0)
+		for _, k := range db.KeysInfo {
+			if selects(k) {
+				c++
+			}
+		}
+		return c
+	}
+	for _, tc := range []struct {
+		p       *client.Predicate
+		selects func(k *database.KeyInfo) bool
+	}{
+		{client.Match(query.UserId, db.KeysInfo[0].UserId.Email),
+			func(k *database.KeyInfo) bool { return k.UserId.Email == db.KeysInfo[0].UserId.Email }},
+		{client.MatchPrefix(query.UserId, "START"),
+			func(k *database.KeyInfo) bool { return strings.HasPrefix(k.UserId.Email, "START") }},
+		{client.MatchSuffix(query.UserId, ".edu"),
+			func(k *database.KeyInfo) bool { return strings.HasSuffix(k.UserId.Email, ".edu") }},
+		{client.Match(query.PubKeyAlgo, "ECDSA"),
+			func(k *database.KeyInfo) bool { return k.PubKeyAlgo == packet.PubKeyAlgoECDSA }},
+		{client.Match(query.CreationTime, "2019"),
+			func(k *database.KeyInfo) bool { return k.CreationTime.Year() == 2019 }},
+		{client.And(client.Range(query.CreationTime, 2019, 2019), client.MatchSuffix(query.UserId, ".edu")),
+			func(k *database.KeyInfo) bool {
+				return k.CreationTime.Year() == 2019 && strings.HasSuffix(k.UserId.Email, ".edu")
+			}},
+	} {
+		c := client.NewPredicatePIR(utils.RandomPRG(), &db.Info)
+		fssKeys, err := c.QueryPredicate(tc.p, 2)
+		require.NoError(t, err)
+		a0, err := server.NewPredicatePIR(db, 0).AnswerBytes(fssKeys[0])
+		require.NoError(t, err)
+		a1, err := server.NewPredicatePIR(db, 1).AnswerBytes(fssKeys[1])
+		require.NoError(t, err)
+		res, err := c.ReconstructBytes([][]byte{a0, a1})
+		require.NoError(t, err)
+		require.Equal(t, count(tc.selects), res)
+		require.NotZero(t, res)
+	}
+
+	// predicates that the point functions cannot express
+	for _, p := range []*client.Predicate{
+		client.Range(query.CreationTime, 2019, 2019),
+		client.And(client.Range(query.CreationTime, 2018, 2020), client.MatchSuffix(query.UserId, ".edu")),
+		client.And(client.Match(query.PubKeyAlgo, "RSA"), client.MatchSuffix(query.UserId, ".edu")),
+		client.MatchPrefix(query.PubKeyAlgo, "RS"),
+		client.Match(query.PubKeyAlgo, "unknown"),
+	} {
+		_, err := p.Compile()
+		require.Error(t, err)
+	}
+}
+
+func BenchmarkAnswerComplex(b *testing.B) {
+	db, err := database.CreateRandomKeysDB(utils.RandomPRG(), 10000)
+	require.NoError(b, err)
+	info := &query.Info{Target: query.UserId}
+	in, err := info.ToEmailClientFSS(db.KeysInfo[0].UserId.Email).Encode()
+	require.NoError(b, err)
+
+	for _, bc := range []struct {
+		name string
+		c    client.Client
+		s    server.Server
+	}{
+		{"untagged", client.NewPredicatePIR(utils.RandomPRG(), &db.Info), server.NewPredicatePIR(db, 0)},
+		{"tagged", client.NewPredicateAPIR(utils.RandomPRG(), &db.Info), server.NewPredicateAPIR(db, 0)},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			fssKeys, err := bc.c.QueryBytes(in, 2)
+			require.NoError(b, err)
+
+			b.ResetTimer()
+			stats, err := server.BenchmarkAnswer(bc.s, fssKeys[0], b.N)
+			require.NoError(b, err)
+			b.ReportMetric(stats.MeanMs, "cpu-ms/op")
+		})
+	}
+}
+
+func retrieveComplexConcurrent(db *database.DB, s0, s1 server.Server, match string) error {
+	c := client.NewPredicateAPIR(utils.RandomPRG(), &db.Info)
+	info := &query.Info{Target: query.UserId}
+	q := info.ToEmailClientFSS(match)
+
+	in, err := q.Encode()
+	if err != nil {
+		return err
+	}
+	fssKeys, err := c.QueryBytes(in, 2)
+	if err != nil {
+		return err
+	}
+	a0, err := s0.AnswerBytes(fssKeys[0])
+	if err != nil {
+		return err
+	}
+	a1, err := s1.AnswerBytes(fssKeys[1])
+	if err != nil {
+		return err
+	}
+	res, err := c.ReconstructBytes([][]byte{a0, a1})
+	if err != nil {
+		return err
+	}
+
+	if expected := localResult(db, q.Info, match); res.(uint32) != expected {
+		return fmt.Errorf("count for %s: expected %d, got %d", match, expected, res.(uint32))
+	}
+
+	return nil
+}
+
 func TestCountEntireEmail(t *testing.T) {
 	if randomDB == nil {
 		initRandomDB()
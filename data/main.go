@@ -10,23 +10,28 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/si-co/vpir-code/lib/database"
 	"github.com/si-co/vpir-code/lib/pgp"
 	"golang.org/x/xerrors"
 )
 
 const hundredMb = 104857600
-const usage = `go run main.go {-rabalanced} -cmd genChunks|genDB|parseDump -path PATH -out PATH`
+const usage = `go run main.go {-rabalanced} {-flat} {-scheme pir|merkle|fss} -cmd genChunks|genDB|parseDump -path PATH -out PATH`
 
 func main() {
 	var cmd string
 	var path string
 	var out string
+	var scheme string
 	var rebalanced bool
+	var flat bool
 
 	flag.StringVar(&cmd, "cmd", "", "genChunks|genDB|parseDump")
 	flag.StringVar(&path, "path", "", "input file")
 	flag.StringVar(&out, "out", "", "output file/folder")
+	flag.StringVar(&scheme, "scheme", "pir", "db to generate with genDB: pir|merkle|fss")
 	flag.BoolVar(&rebalanced, "rebalanced", false, "rebalanced db or not")
+	flag.BoolVar(&flat, "flat", false, "save a pir or merkle db in the flat format, for servers mapping it in memory")
 
 	flag.Parse()
 
@@ -43,7 +48,7 @@ func main() {
 			log.Fatalf("failed to split chunks: %v", err)
 		}
 	case "genDB":
-		err := generateDB(path, out, rebalanced)
+		err := generateDB(path, out, scheme, rebalanced, flat)
 		if err != nil {
 			log.Fatalf("failed to generate DB: %v", err)
 		}
@@ -133,31 +138,51 @@ func splitFullDumpIntoChunks(path, out string) error {
 	return nil
 }
 
-func generateDB(root, out string, rebalanced bool) error {
-	//filesInfo, err := ioutil.ReadDir(root)
-	//if err != nil {
-	//	return xerrors.Errorf("failed to read files: %v", err)
-	//}
-	//
-	//var files []string
-	//
-	//for _, info := range filesInfo {
-	//	if info.IsDir() {
-	//		continue
-	//	}
-	//
-	//	files = append(files, filepath.Join(root, info.Name()))
-	//}
-	//
-	//db, err := database.GenerateRealKeyDB(files, constants.ChunkBytesLength, rebalanced)
-	//if err != nil {
-	//	return xerrors.Errorf("failed to generate DB: %v", err)
-	//}
-	//
-	//err = db.SaveDBFileSingle(out)
-	//if err != nil {
-	//	return xerrors.Errorf("failed to save db: %v", err)
-	//}
+// generateDB builds the database for the given scheme from the key files in
+// root and saves it to out, so that servers can load it at boot. If flat is
+// true, bytes databases are saved in the flat format read by
+// database.MapBytes.
+func generateDB(root, out, scheme string, rebalanced, flat bool) error {
+	files, err := pgp.GetAllFiles(root)
+	if err != nil {
+		return xerrors.Errorf("failed to read files: %v", err)
+	}
+
+	var info database.Info
+	switch scheme {
+	case "pir", "merkle":
+		var db *database.Bytes
+		if scheme == "pir" {
+			db, err = database.GenerateRealKeyBytes(files, rebalanced)
+		} else {
+			db, err = database.GenerateRealKeyMerkle(files, rebalanced)
+		}
+		if err != nil {
+			return xerrors.Errorf("failed to generate db: %v", err)
+		}
+		if flat {
+			err = database.SaveBytesFlat(out, db)
+		} else {
+			err = database.SaveBytes(out, db)
+		}
+		info = db.Info
+	case "fss":
+		var db *database.DB
+		db, err = database.GenerateRealKeyDB(files)
+		if err != nil {
+			return xerrors.Errorf("failed to generate db: %v", err)
+		}
+		err = database.SaveDB(out, db)
+		info = db.Info
+	default:
+		return xerrors.Errorf("unknown scheme: %s", scheme)
+	}
+	if err != nil {
+		return xerrors.Errorf("failed to save db: %v", err)
+	}
+
+	log.Printf("db saved to %s: rows %d, columns %d, block size %d, type %s\n",
+		out, info.NumRows, info.NumColumns, info.BlockSize, info.PIRType)
 
 	return nil
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/si-co/vpir-code/lib/database"
+	"github.com/si-co/vpir-code/lib/pgp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateDB(t *testing.T) {
+	root := t.TempDir()
+	f, err := os.Create(filepath.Join(root, "sks-000.pgp"))
+	require.NoError(t, err)
+	enc := gob.NewEncoder(f)
+	for i := 0; i < 100; i++ {
+		packet := make([]byte, 64)
+		_, err := rand.Read(packet)
+		require.NoError(t, err)
+		require.NoError(t, enc.Encode(&pgp.Key{ID: fmt.Sprintf("user%d@example.com", i), Packet: packet}))
+	}
+	require.NoError(t, f.Close())
+
+	out := filepath.Join(t.TempDir(), "db")
+	require.NoError(t, generateDB(root, out, "pir", true, false))
+
+	expected, err := database.GenerateRealKeyBytes([]string{filepath.Join(root, "sks-000.pgp")}, true)
+	require.NoError(t, err)
+	db, err := database.LoadBytes(out)
+	require.NoError(t, err)
+	require.Equal(t, expected.Entries, db.Entries)
+	require.Equal(t, expected.Info, db.Info)
+
+	// the flat db has the same content
+	require.NoError(t, generateDB(root, out, "pir", true, true))
+	mapped, unmap, err := database.MapBytes(out)
+	require.NoError(t, err)
+	defer unmap()
+	require.Equal(t, expected.Entries, mapped.Entries)
+	require.Equal(t, expected.Info, mapped.Info)
+
+	require.Error(t, generateDB(root, out, "unknown", true, false))
+}
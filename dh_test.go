@@ -21,11 +21,184 @@ func TestDH(t *testing.T) {
 	dbLen := 1024 * 1024 // dbLen is specified in bits
 	dbPRG := utils.RandomPRG()
 	ecg := group.P256
-	db := database.CreateRandomEllipticWithDigest(dbPRG, dbLen, ecg, true)
+	db, err := database.CreateRandomEllipticWithDigest(dbPRG, dbLen, ecg, true)
+	require.NoError(t, err)
 	prg := utils.RandomPRG()
 	retrieveBlocksDH(t, prg, db, "Diffie-Hellman")
 }
 
+func TestDHSingleElement(t *testing.T) {
+	for _, rebalanced := range []bool{false, true} {
+		db, err := database.CreateRandomEllipticWithDigest(utils.RandomPRG(), 1, group.P256, rebalanced)
+		require.NoError(t, err)
+		require.Equal(t, 1, db.NumRows)
+		require.Equal(t, 1, db.NumColumns)
+		retrieveBlocksDH(t, utils.RandomPRG(), db, "DH single element")
+	}
+}
+
+func TestDHAsciiVector(t *testing.T) {
+	payload := "Private retrieval, bit by bit"
+	db := database.CreateAsciiVector(payload, group.P256)
+	retrieveAsciiDH(t, utils.RandomPRG(), db, payload)
+}
+
+func TestDHAsciiMatrix(t *testing.T) {
+	payload := "Private retrieval, bit by bit"
+	db := database.CreateAsciiMatrix(payload, group.P256)
+	retrieveAsciiDH(t, utils.RandomPRG(), db, payload)
+}
+
+func TestDHExpectedAnswerLength(t *testing.T) {
+	payload := "Private retrieval, bit by bit"
+	for _, db := range []*database.Elliptic{
+		database.CreateAsciiVector(payload, group.P256),
+		database.CreateAsciiMatrix(payload, group.P256),
+	} {
+		c := client.NewDH(utils.RandomPRG(), &db.Info)
+		query, err := c.QueryBytes(0)
+		require.NoError(t, err)
+		a, err := server.NewDH(db).AnswerBytes(query)
+		require.NoError(t, err)
+
+		// one group element per row
+		require.Equal(t, db.NumRows, client.ExpectedAnswerElements(&db.Info))
+		require.Len(t, a, client.ExpectedAnswerBytes(&db.Info))
+
+		_, err = c.ReconstructBytes(a[1:])
+		require.Error(t, err)
+	}
+}
+
+func TestDHCores(t *testing.T) {
+	for _, dbLen := range []int{100, 1000, 3000} {
+		db, err := database.CreateRandomEllipticWithDigest(utils.RandomPRG(), dbLen, group.P256, true)
+		require.NoError(t, err)
+		c := client.NewDH(utils.RandomPRG(), &db.Info)
+		query, err := c.QueryBytes(rand.Intn(db.NumRows * db.NumColumns))
+		require.NoError(t, err)
+		expected, err := server.NewDH(db).AnswerBytes(query)
+		require.NoError(t, err)
+
+		// more cores than rows and core counts not dividing the rows
+		for _, cores := range []int{2, 3, 4, 7, db.NumRows - 1, db.NumRows + 1, 2 * db.NumRows} {
+			a, err := server.NewDH(db, cores).AnswerBytes(query)
+			require.NoError(t, err)
+			require.Equal(t, expected, a, "%d rows, %d cores", db.NumRows, cores)
+		}
+		_, err = c.ReconstructBytes(expected)
+		require.NoError(t, err)
+	}
+}
+
+func TestDHNUMA(t *testing.T) {
+	db, err := database.CreateRandomEllipticWithDigest(utils.RandomPRG(), 1000, group.P256, true)
+	require.NoError(t, err)
+	c := client.NewDH(utils.RandomPRG(), &db.Info)
+	query, err := c.QueryBytes(rand.Intn(db.NumRows * db.NumColumns))
+	require.NoError(t, err)
+	expected, err := server.NewDH(db).AnswerBytes(query)
+	require.NoError(t, err)
+
+	// simulated sockets of uneven sizes, sharing the CPUs of the machine,
+	// more CPUs than rows and CPUs the threads cannot be pinned to
+	for _, nodes := range [][][]int{
+		{{0}},
+		{{0}, {0}},
+		{{0, 0, 0}, {0}},
+		{make([]int, 2*db.NumRows)},
+		{{0}, {1 << 20}},
+	} {
+		s, err := server.NewDHWithNUMA(db, nodes)
+		require.NoError(t, err)
+		// the threads answer every query
+		for i := 0; i < 2; i++ {
+			a, err := s.AnswerBytes(query)
+			require.NoError(t, err)
+			require.Equal(t, expected, a, "nodes %v", nodes)
+		}
+		s.Close()
+	}
+	_, err = c.ReconstructBytes(expected)
+	require.NoError(t, err)
+
+	_, err = server.NewDHWithNUMA(db, nil)
+	require.Error(t, err)
+	_, err = server.NewDHWithNUMA(db, [][]int{{0}, {}})
+	require.Error(t, err)
+}
+
+func TestParseNUMANodes(t *testing.T) {
+	nodes, err := server.ParseNUMANodes("0-3,8;4-7, 9")
+	require.NoError(t, err)
+	require.Equal(t, [][]int{{0, 1, 2, 3, 8}, {4, 5, 6, 7, 9}}, nodes)
+
+	for _, s := range []string{"", "0;", "a", "3-1", "0--1", "-1"} {
+		_, err := server.ParseNUMANodes(s)
+		require.Error(t, err, s)
+	}
+
+	t.Setenv(server.NUMANodesEnv, "0;1")
+	nodes, err = server.NUMANodes()
+	require.NoError(t, err)
+	require.Equal(t, [][]int{{0}, {1}}, nodes)
+}
+
+// BenchmarkDHNUMA compares the answers computed with the rows split among
+// all the CPUs with and without NUMA-aware partitioning, for the nodes of
+// the machine or those given by server.NUMANodesEnv
+func BenchmarkDHNUMA(b *testing.B) {
+	nodes, err := server.NUMANodes()
+	require.NoError(b, err)
+	numCPUs := 0
+	for _, cpus := range nodes {
+		numCPUs += len(cpus)
+	}
+	db, err := database.CreateRandomEllipticWithDigest(utils.RandomPRG(), 1<<16, group.P256, true)
+	require.NoError(b, err)
+	query, err := client.NewDH(utils.RandomPRG(), &db.Info).QueryBytes(0)
+	require.NoError(b, err)
+
+	numa, err := server.NewDHWithNUMA(db, nodes)
+	require.NoError(b, err)
+	defer numa.Close()
+	for _, bc := range []struct {
+		name string
+		s    *server.DH
+	}{
+		{fmt.Sprintf("cores=%d", numCPUs), server.NewDH(db, numCPUs)},
+		{fmt.Sprintf("numa=%d", len(nodes)), numa},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := bc.s.AnswerBytes(query); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func retrieveAsciiDH(t *testing.T, rnd io.Reader, db *database.Elliptic, payload string) {
+	c := client.NewDH(rnd, &db.Info)
+	s := server.NewDH(db)
+
+	result := make([]byte, len(payload))
+	for i := 0; i < len(payload)*8; i++ {
+		query, err := c.QueryBytes(i)
+		require.NoError(t, err)
+
+		a, err := s.AnswerBytes(query)
+		require.NoError(t, err)
+
+		res, err := c.ReconstructBytes(a)
+		require.NoError(t, err)
+		// bits are stored most significant first
+		result[i/8] |= res.(byte) << (7 - i%8)
+	}
+	require.Equal(t, payload, string(result))
+}
+
 func retrieveBlocksDH(t *testing.T, rnd io.Reader, db *database.Elliptic, testName string) {
 	c := client.NewDH(rnd, &db.Info)
 	s := server.NewDH(db)
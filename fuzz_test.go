@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/si-co/vpir-code/lib/client"
+	"github.com/si-co/vpir-code/lib/database"
+	"github.com/si-co/vpir-code/lib/query"
+	"github.com/si-co/vpir-code/lib/server"
+	"github.com/si-co/vpir-code/lib/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// Fuzz targets for the decoding of query inputs on the client and of
+// queries on the server. Arbitrary bytes must be rejected with an error,
+// never with a panic.
+
+func FuzzDecodeQueryInputs(f *testing.F) {
+	pirDB := randomBytesDB(f)
+	keysDB, err := database.CreateRandomKeysDB(utils.RandomPRG(), 10)
+	require.NoError(f, err)
+
+	info := &query.Info{Target: query.UserId, FromEnd: 4}
+	in, err := info.ToEmailClientFSS(".edu").Encode()
+	require.NoError(f, err)
+	f.Add(in)
+	f.Add([]byte{0, 0, 0, 42})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		pir := client.NewPIR(utils.RandomPRG(), &pirDB.Info)
+		if queries, err := pir.QueryBytes(in, 2); err == nil {
+			require.Len(t, queries, 2)
+		}
+
+		apir := client.NewPredicateAPIR(utils.RandomPRG(), &keysDB.Info)
+		if queries, err := apir.QueryBytes(in, 2); err == nil {
+			require.Len(t, queries, 2)
+		}
+	})
+}
+
+func FuzzAnswerBytes(f *testing.F) {
+	pirDB := randomBytesDB(f)
+	keysDB, err := database.CreateRandomKeysDB(utils.RandomPRG(), 10)
+	require.NoError(f, err)
+
+	pir := server.NewPIR(pirDB)
+	apir := server.NewPredicateAPIR(keysDB, 0, 1)
+
+	// valid queries for both servers
+	in := make([]byte, 4)
+	queries, err := client.NewPIR(utils.RandomPRG(), &pirDB.Info).QueryBytes(in, 2)
+	require.NoError(f, err)
+	f.Add(queries[0])
+	info := &query.Info{Target: query.UserId}
+	in, err = info.ToEmailClientFSS(keysDB.KeysInfo[0].UserId.Email).Encode()
+	require.NoError(f, err)
+	queries, err = client.NewPredicateAPIR(utils.RandomPRG(), &keysDB.Info).QueryBytes(in, 2)
+	require.NoError(f, err)
+	f.Add(queries[0])
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, q []byte) {
+		if a, err := pir.AnswerBytes(q); err == nil {
+			require.Len(t, a, pirDB.NumRows*pirDB.BlockSize)
+		}
+		if a, err := apir.AnswerBytes(q); err == nil {
+			require.NotEmpty(t, a)
+		}
+	})
+}
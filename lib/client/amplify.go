@@ -1,7 +1,6 @@
 package client
 
 import (
-	"errors"
 	"io"
 
 	"github.com/si-co/vpir-code/lib/database"
@@ -51,7 +50,7 @@ func (a *Amplify) Reconstruct(answers []*matrix.Matrix) (uint32, error) {
 	for i := range outputs {
 		outputs[i], err = a.lwes[i].Reconstruct(answers[i])
 		if err != nil {
-			return 0, errors.New("REJECT")
+			return 0, ErrReject
 		}
 	}
 
@@ -2,19 +2,79 @@ package client
 
 import (
 	"errors"
-	"log"
+	"fmt"
 
 	"github.com/cloudflare/circl/group"
 	"github.com/lukechampine/fastxor"
 	"github.com/si-co/vpir-code/lib/database"
+	"github.com/si-co/vpir-code/lib/field"
 	"github.com/si-co/vpir-code/lib/merkle"
 	"github.com/si-co/vpir-code/lib/utils"
 )
 
+// ErrReject is wrapped by the errors returned when the reconstruction
+// detects that the answers have been tampered with. Use errors.Is to
+// distinguish it from other failures.
+var ErrReject = errors.New("REJECT")
+
+// ErrNotPrivate is returned when a multi-server scheme is run with a single
+// server, which would get the query of the index in the clear
+var ErrNotPrivate = errors.New("a single server learns the queried index")
+
 // Client represents the client for all (A)PIR clients implemented in the package
 type Client interface {
 	QueryBytes([]byte, int) ([][]byte, error)
 	ReconstructBytes([][]byte) (interface{}, error)
+	// LastQueryBytes returns the length of the last queries, summed across
+	// the servers
+	LastQueryBytes() int
+	// LastAnswerBytes returns the length of the last answers passed to the
+	// reconstruction, summed across the servers
+	LastAnswerBytes() int
+}
+
+// Verifier is implemented by the clients that can tell whether their last
+// reconstruction checked the integrity of the answers. Some schemes, e.g.,
+// classical PIR, return the data without checking it.
+type Verifier interface {
+	// LastVerified returns true if the last reconstruction succeeded and
+	// the answers were verified
+	LastVerified() bool
+}
+
+// sizes accounts the bytes sent to and received from the servers by the
+// last query and reconstruction of a client
+type sizes struct {
+	queryBytes  int
+	answerBytes int
+}
+
+// LastQueryBytes returns the length of the last queries, summed across the
+// servers
+func (s *sizes) LastQueryBytes() int {
+	return s.queryBytes
+}
+
+// LastAnswerBytes returns the length of the last answers, summed across the
+// servers
+func (s *sizes) LastAnswerBytes() int {
+	return s.answerBytes
+}
+
+func (s *sizes) setQueries(queries [][]byte) {
+	s.queryBytes = totalLen(queries)
+}
+
+func (s *sizes) setAnswers(answers [][]byte) {
+	s.answerBytes = totalLen(answers)
+}
+
+func totalLen(b [][]byte) int {
+	n := 0
+	for i := range b {
+		n += len(b[i])
+	}
+	return n
 }
 
 // state of the client, used for all the schemes.
@@ -22,6 +82,8 @@ type state struct {
 	// only used for Merkle tree-based approach and classic PIR
 	ix int
 	iy int
+	// number of blocks of a range query of classic PIR
+	rangeLen int
 
 	// for multi-server
 	alphas []uint32 // four alphas to meet desired soundness
@@ -30,6 +92,9 @@ type state struct {
 	// for single-server (DH)
 	r  group.Scalar
 	ht group.Element
+
+	// set by the reconstruction if the answers were verified
+	verified bool
 }
 
 // decodeAnswer decodes the gob-encoded answers from the servers and return
@@ -44,30 +109,122 @@ func decodeAnswer(in [][]byte) ([][]uint32, error) {
 	return answer, nil
 }
 
+// CombineAnswers sums the answers of the servers element-wise in the field,
+// which reconstructs the answer of the schemes secret-sharing in the field.
+// The answers must have the same length.
+func CombineAnswers(answers [][]uint32) ([]uint32, error) {
+	if len(answers) == 0 {
+		return nil, errors.New("no answers to combine")
+	}
+	sum := make([]uint32, len(answers[0]))
+	for k, a := range answers {
+		if len(a) != len(sum) {
+			return nil, fmt.Errorf("answer of server %d has length %d, expected %d", k, len(a), len(sum))
+		}
+		for i := range sum {
+			sum[i] = (sum[i] + a[i]) % field.ModP
+		}
+	}
+
+	return sum, nil
+}
+
+// CombineAnswerBytes XORs the answers of the servers, which reconstructs the
+// answer of the schemes secret-sharing in GF(2). The answers must have the
+// same length.
+func CombineAnswerBytes(answers [][]byte) ([]byte, error) {
+	if len(answers) == 0 {
+		return nil, errors.New("no answers to combine")
+	}
+	sum := make([]byte, len(answers[0]))
+	for k, a := range answers {
+		if len(a) != len(sum) {
+			return nil, fmt.Errorf("answer of server %d has length %d, expected %d", k, len(a), len(sum))
+		}
+		fastxor.Bytes(sum, sum, a)
+	}
+
+	return sum, nil
+}
+
 // reconstructPIR returns the database entry for the classical PIR schemes.
 // These schemes are used as a baseline for the evaluation of the VPIR schemes.
 func reconstructPIR(answers [][]byte, dbInfo *database.Info, state *state) ([]byte, error) {
+	block := make([]byte, dbInfo.BlockSize)
+	n, err := reconstructPIRInto(answers, dbInfo, state, block)
+	if err != nil {
+		return nil, err
+	}
+
+	return block[:n], nil
+}
+
+// reconstructPIRInto is like reconstructPIR, but writes the entry at the
+// beginning of dst, which must hold a block, and returns its length. The
+// rest of the block in dst is overwritten as well.
+func reconstructPIRInto(answers [][]byte, dbInfo *database.Info, state *state, dst []byte) (int, error) {
+	state.verified = false
+	if len(dst) < dbInfo.BlockSize {
+		return 0, fmt.Errorf("buffer of %d bytes for a block of %d bytes", len(dst), dbInfo.BlockSize)
+	}
+	block := dst[:dbInfo.BlockSize]
+	if err := reconstructValuePIRInto(answers, dbInfo, state, block); err != nil {
+		return 0, err
+	}
+
+	// the entry is always at the beginning of the block
+	data, err := checkBlockPIR(block, dbInfo, state)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+// checkBlockPIR checks the reconstructed block of the classical PIR schemes
+// against the block hashes or the Merkle proof of the db and returns its
+// data
+func checkBlockPIR(block []byte, dbInfo *database.Info, state *state) ([]byte, error) {
 	switch dbInfo.PIRType {
-	case "classical", "":
-		return reconstructValuePIR(answers, dbInfo, state)
-	case "merkle":
-		block, err := reconstructValuePIR(answers, dbInfo, state)
+	case database.PIRTypeClassical, "":
+		index := state.ix*dbInfo.NumColumns + state.iy
+		ok, err := dbInfo.VerifyBlock(index, block)
 		if err != nil {
-			return block, err
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: block hash mismatch", ErrReject)
 		}
+		// without block hashes the block is not checked
+		state.verified = dbInfo.BlockHashes != nil
+
+		// the zeros after the data are not part of the block
+		if n := dbInfo.PayloadLength(index); n < len(block) {
+			block = block[:n]
+		}
+
+		return block, nil
+	case database.PIRTypeMerkle:
 		block = database.UnPadBlock(block)
+		if len(block) < dbInfo.ProofLen {
+			return nil, fmt.Errorf("%w: block shorter than the Merkle proof", ErrReject)
+		}
 		data := block[:len(block)-dbInfo.ProofLen]
 
 		// check Merkle proof
 		encodedProof := block[len(block)-dbInfo.ProofLen:]
-		proof := merkle.DecodeProof(encodedProof)
+		proof, err := merkle.DecodeProof(encodedProof)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrReject, err)
+		}
 		verified, err := merkle.VerifyProof(data, proof, dbInfo.Root)
 		if err != nil {
-			log.Fatalf("impossible to verify proof: %v", err)
+			return nil, fmt.Errorf("impossible to verify proof: %v", err)
 		}
 		if !verified {
-			return nil, errors.New("REJECT!")
+			return nil, ErrReject
 		}
+		state.verified = true
 
 		return data, nil
 	default:
@@ -75,22 +232,160 @@ func reconstructPIR(answers [][]byte, dbInfo *database.Info, state *state) ([]by
 	}
 }
 
-func reconstructValuePIR(answers [][]byte, dbInfo *database.Info, state *state) ([]byte, error) {
-	// sum answers as vectors in GF(2)
+// reconstructValuePIRInto writes the sum of the rows of the block in the
+// answers into block, as CombineAnswerBytes
+func reconstructValuePIRInto(answers [][]byte, dbInfo *database.Info, state *state, block []byte) error {
+	if len(answers) == 0 {
+		return errors.New("no answers to combine")
+	}
 	bs := dbInfo.BlockSize
-	sum := make([]byte, bs)
+	expectedLen := ExpectedAnswerBytes(dbInfo)
 	for k := range answers {
-		fastxor.Bytes(sum, sum, answers[k][state.ix*bs:bs*(state.ix+1)])
+		if len(answers[k]) != expectedLen {
+			return fmt.Errorf("answer of server %d has length %d, expected %d",
+				k, len(answers[k]), expectedLen)
+		}
 	}
 
-	return sum, nil
+	// sum the rows of the block as vectors in GF(2)
+	for i := range block {
+		block[i] = 0
+	}
+	for k := range answers {
+		fastxor.Bytes(block, block, answers[k][state.ix*bs:bs*(state.ix+1)])
+	}
+
+	return nil
 }
 
-// return true if the query inputs are invalid for IT schemes
-func invalidQueryInputsIT(index, numServers int) bool {
-	return index < 0 && numServers < 2
+// ExpectedAnswerElements returns the number of elements in the answer of a
+// server for the database described by info. For single-bit databases, the
+// answer has one group element per row, otherwise it has one block of bytes
+// per row and the elements are the bytes.
+func ExpectedAnswerElements(info *database.Info) int {
+	if info.BlockSize == database.SingleBitBlockLength {
+		return info.NumRows
+	}
+	return info.NumRows * info.BlockSize
 }
 
-func invalidQueryInputsFSS(numServers int) bool {
-	return numServers != 2
+// ExpectedAnswerBytes returns the length in bytes of the answer of a server
+// for the database described by info
+func ExpectedAnswerBytes(info *database.Info) int {
+	if info.BlockSize == database.SingleBitBlockLength {
+		return ExpectedAnswerElements(info) * info.ElementSize
+	}
+	return ExpectedAnswerElements(info)
+}
+
+// ValidatePIRType returns an error if scheme cannot run on the database
+// described by info, e.g., a pir-merkle client on a classical database,
+// whose blocks hold no Merkle proof. A database without a type, e.g., saved
+// before the types were set, is accepted by every scheme, since it may be
+// of any type. The scheme names are the ones of ValidateServers.
+func ValidatePIRType(scheme string, info *database.Info) error {
+	var expected database.PIRType
+	switch scheme {
+	case "pir-classic", "pointPIR":
+		expected = database.PIRTypeClassical
+	case "pir-merkle", "pointVPIR":
+		expected = database.PIRTypeMerkle
+	case "fss-classic", "fss-auth", "complexPIR", "complexVPIR":
+		expected = database.PIRTypePredicate
+	case "cmp-vpir-dh":
+		expected = database.PIRTypeDH
+	case "cmp-vpir-lwe":
+		expected = database.PIRTypeLWE
+	case "cmp-vpir-lwe-128":
+		expected = database.PIRTypeLWE128
+	default:
+		return fmt.Errorf("unknown scheme %s", scheme)
+	}
+
+	if info.PIRType != "" && info.PIRType != expected {
+		return fmt.Errorf("scheme %s needs a %s db, the servers hold a %s db", scheme, expected, info.PIRType)
+	}
+
+	return nil
+}
+
+// ExpectedQueryBytes returns the length in bytes of the query sent to every
+// server by the IT point schemes for the database described by info, i.e.,
+// a share of the vector selecting one column, one bit per column. With
+// ExpectedAnswerBytes, it gives the communication of a retrieval for a
+// layout of the database.
+func ExpectedQueryBytes(info *database.Info) int {
+	return info.QueryVectorBytes()
+}
+
+// ValidateServers returns an error if scheme cannot run with numServers
+// servers. The IT schemes need at least two servers, the FSS-based schemes
+// exactly two and the computational schemes a single one. The scheme names
+// are the ones of the command-line tools.
+func ValidateServers(scheme string, numServers int) error {
+	var min, max int
+	switch scheme {
+	case "pir-classic", "pir-merkle", "pointPIR", "pointVPIR":
+		min, max = 2, 0
+	case "fss-classic", "fss-auth", "complexPIR", "complexVPIR":
+		min, max = 2, 2
+	case "cmp-vpir-dh", "cmp-vpir-lwe", "cmp-vpir-lwe-128":
+		min, max = 1, 1
+	default:
+		return fmt.Errorf("unknown scheme %s", scheme)
+	}
+
+	switch {
+	case numServers == 1 && min > 1:
+		return fmt.Errorf("scheme %s needs at least %d servers, got 1: %w", scheme, min, ErrNotPrivate)
+	case min == max && numServers != min:
+		return fmt.Errorf("scheme %s needs exactly %d servers, got %d", scheme, min, numServers)
+	case numServers < min:
+		return fmt.Errorf("scheme %s needs at least %d servers, got %d", scheme, min, numServers)
+	}
+
+	return nil
+}
+
+// RequiredServers returns the minimum number of servers to deploy for
+// scheme so that the queries stay private against collusionBound colluding
+// servers and a retrieval succeeds despite faultTolerance crashed servers,
+// over which the clients fail over to the spare servers. The model of each
+// scheme is:
+//
+//   - IT schemes (pir-classic, pir-merkle, pointPIR, pointVPIR): the query
+//     is additively shared among the queried servers, and any set of them
+//     but all learns nothing. They need collusionBound+1 queried servers,
+//     and at least 2, plus faultTolerance spare ones.
+//   - FSS schemes (fss-classic, fss-auth, complexPIR, complexVPIR): the two
+//     keys go to two servers with distinct roles, private against a single
+//     server. The collusion bound is at most 1 and no fault is tolerated.
+//   - computational schemes (cmp-vpir-dh, cmp-vpir-lwe, cmp-vpir-lwe-128):
+//     a single server learns nothing whatever the collusion bound, and
+//     faultTolerance replicas take over the crashed ones.
+//
+// None of the schemes reconstructs from wrong answers: the verifiable ones
+// reject them, so byzantine servers, which would need 2t+1 servers with a
+// robust reconstruction, only count as crashed ones once detected.
+func RequiredServers(collusionBound, faultTolerance int, scheme string) (int, error) {
+	if collusionBound < 0 || faultTolerance < 0 {
+		return 0, fmt.Errorf("invalid collusion bound %d or fault tolerance %d", collusionBound, faultTolerance)
+	}
+	switch scheme {
+	case "pir-classic", "pir-merkle", "pointPIR", "pointVPIR":
+		return utils.Max(collusionBound+1, 2) + faultTolerance, nil
+	case "fss-classic", "fss-auth", "complexPIR", "complexVPIR":
+		if collusionBound > 1 {
+			return 0, fmt.Errorf("scheme %s is private against a single server, not %d colluding servers",
+				scheme, collusionBound)
+		}
+		if faultTolerance > 0 {
+			return 0, fmt.Errorf("scheme %s needs both its servers, it tolerates no fault", scheme)
+		}
+		return 2, nil
+	case "cmp-vpir-dh", "cmp-vpir-lwe", "cmp-vpir-lwe-128":
+		return 1 + faultTolerance, nil
+	default:
+		return 0, fmt.Errorf("unknown scheme %s", scheme)
+	}
 }
@@ -2,7 +2,7 @@ package client
 
 import (
 	"bytes"
-	"errors"
+	"fmt"
 	"io"
 	"log"
 
@@ -50,7 +50,7 @@ func (c *DH) QueryBytes(index int) ([]byte, error) {
 	st.ix, st.iy = utils.VectorToMatrixIndices(index, c.dbInfo.NumColumns)
 	st.r = r
 
-	query := make([]group.Element, 0, c.dbInfo.NumColumns*c.dbInfo.BlockSize)
+	query := make([]group.Element, 0, c.dbInfo.NumColumns)
 	for j := 0; j < c.dbInfo.NumColumns; j++ {
 		query = append(query, database.CommitScalarToIndex(r, uint64(j), c.dbInfo.Group))
 	}
@@ -73,24 +73,26 @@ func (c *DH) ReconstructBytes(a []byte) (interface{}, error) {
 	g := c.dbInfo.Group
 	digSize := c.dbInfo.ElementSize
 	rneg := g.NewScalar().Neg(c.state.r)
+	if expected := ExpectedAnswerBytes(c.dbInfo); len(a) != expected {
+		return nil, fmt.Errorf("answer has length %d, expected %d", len(a), expected)
+	}
 	// get the tags of all the rows
 	answer, err := database.UnmarshalGroupElements(a, c.dbInfo.Group, c.dbInfo.ElementSize)
 	if err != nil {
 		return nil, err
 	}
+	digests, err := database.UnmarshalGroupElements(c.dbInfo.SubDigests[:c.dbInfo.NumRows*digSize], g, digSize)
+	if err != nil {
+		return nil, err
+	}
 	m := g.Identity()
 	var res byte
 	for i := 0; i < c.dbInfo.NumRows; i++ {
-		// get the row digest and raise it to a power r
-		d := g.NewElement()
-		err = d.UnmarshalBinary(c.dbInfo.SubDigests[i*digSize : (i+1)*digSize])
-		if err != nil {
-			return nil, err
-		}
-		d.Mul(d, rneg)
+		// raise the row digest to a power r
+		d := digests[i].Mul(digests[i], rneg)
 		m.Add(d, answer[i])
 		if !m.IsIdentity() && !m.IsEqual(c.state.ht) {
-			return nil, errors.New("reject")
+			return nil, ErrReject
 		}
 		if i == c.state.ix {
 			switch {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/gob"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 
@@ -14,19 +15,39 @@ import (
 )
 
 type clientFSS struct {
+	// scheme name for ValidateServers
+	scheme string
 	rnd    io.Reader
 	dbInfo *database.Info
 	state  *state
 
 	Fss        *fss.Fss
 	executions int
+	sizes
+}
+
+func newClientFSS(scheme string, rnd io.Reader, info *database.Info, f *fss.Fss, executions int) *clientFSS {
+	return &clientFSS{
+		scheme:     scheme,
+		rnd:        rnd,
+		dbInfo:     info,
+		state:      nil,
+		Fss:        f,
+		executions: executions,
+	}
 }
 
 func (c *clientFSS) queryBytes(in []byte, numServers int) ([][]byte, error) {
+	if err := ValidateServers(c.scheme, numServers); err != nil {
+		return nil, err
+	}
 	inQuery, err := query.DecodeClientFSS(in)
 	if err != nil {
 		return nil, err
 	}
+	if inQuery.Info == nil || len(inQuery.Input) == 0 {
+		return nil, errors.New("invalid query inputs")
+	}
 
 	queries := c.query(inQuery, numServers)
 
@@ -40,13 +61,14 @@ func (c *clientFSS) queryBytes(in []byte, numServers int) ([][]byte, error) {
 		}
 		data[i] = buf.Bytes()
 	}
+	c.setQueries(data)
 
 	return data, nil
 }
 
 func (c *clientFSS) query(q *query.ClientFSS, numServers int) []*query.FSS {
-	if invalidQueryInputsFSS(numServers) {
-		log.Fatal("invalid query inputs")
+	if err := ValidateServers(c.scheme, numServers); err != nil {
+		log.Fatal(err)
 	}
 
 	// set client state
@@ -71,7 +93,14 @@ func (c *clientFSS) query(q *query.ClientFSS, numServers int) []*query.FSS {
 	}
 }
 
+// LastVerified returns true if the last reconstruction checked the tags of
+// the answers, i.e., for the authenticated scheme
+func (c *clientFSS) LastVerified() bool {
+	return c.state != nil && c.state.verified
+}
+
 func (c *clientFSS) reconstructBytes(answers [][]byte) (interface{}, error) {
+	c.setAnswers(answers)
 	answer, err := decodeAnswer(answers)
 	if err != nil {
 		return nil, err
@@ -81,55 +110,114 @@ func (c *clientFSS) reconstructBytes(answers [][]byte) (interface{}, error) {
 }
 
 func (c *clientFSS) reconstruct(answers [][]uint32) (uint32, error) {
+	if c.state != nil {
+		c.state.verified = false
+	}
+	// two answers of one value and its tags each, or twice as many for AVG
+	if len(answers) != 2 || len(answers[0]) != len(answers[1]) ||
+		(len(answers[0]) != c.executions && len(answers[0]) != 2*c.executions) {
+		return 0, errors.New("invalid answers length")
+	}
+
+	// check tags, executed only for authenticated
+	if c.executions > 1 {
+		ok, _, err := c.verifyAnswers(answers)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, ErrReject
+		}
+	}
+	if c.state != nil {
+		c.state.verified = c.executions > 1
+	}
+
+	sum, err := CombineAnswers(answers)
+	if err != nil {
+		return 0, err
+	}
+
 	// AVG case
-	if len(answers[0]) == 2*c.executions {
-		countFirst := answers[0][:c.executions]
-		countSecond := answers[1][:c.executions]
-		sumFirst := answers[0][c.executions:]
-		sumSecond := answers[1][c.executions:]
-
-		dataCount := (countFirst[0] + countSecond[0]) % field.ModP
-		dataCountCasted := uint64(dataCount)
-		sumCount := (sumFirst[0] + sumSecond[0]) % field.ModP
-		sumCountCasted := uint64(sumCount)
-
-		// check tags, executed only for authenticated. The -1 is to ignore
-		// the value for the data already initialized
-		for i := 0; i < c.executions-1; i++ {
-			tmpCount := (dataCountCasted * uint64(c.state.alphas[i])) % uint64(field.ModP)
-			tagCount := uint32(tmpCount)
-			reconstructedTagCount := (countFirst[i+1] + countSecond[i+1]) % field.ModP
-			if tagCount != reconstructedTagCount {
-				return 0, errors.New("REJECT count")
-			}
-
-			tmpSum := (sumCountCasted * uint64(c.state.alphas[i])) % uint64(field.ModP)
-			tagSum := uint32(tmpSum)
-			reconstructedTagSum := (sumFirst[i+1] + sumSecond[i+1]) % field.ModP
-			if tagSum != reconstructedTagSum {
-				return 0, errors.New("REJECT sum")
-			}
+	if len(sum) == 2*c.executions {
+		return sum[c.executions] / sum[0], nil
+	}
+
+	return sum[0], nil
+}
+
+// verifyAnswers checks the tags of the answers to the last query without
+// reconstructing the data. It returns whether the answers pass and the
+// indices of the servers whose answers fail. An answer that has the wrong
+// length or is not made of field elements is blamed on its server. Since
+// the data and the tags are secret-shared between the two servers, a tag
+// mismatch can only be blamed on both.
+func (c *clientFSS) verifyAnswers(answers [][]uint32) (bool, []int, error) {
+	if c.state == nil {
+		return false, nil, errors.New("no query to verify the answers of")
+	}
+	if len(answers) != 2 {
+		return false, nil, fmt.Errorf("%d answers, expected 2", len(answers))
+	}
+
+	var failing []int
+	for k, a := range answers {
+		if !c.validAnswer(a) {
+			failing = append(failing, k)
 		}
+	}
+	if len(failing) > 0 {
+		return false, failing, nil
+	}
+	both := []int{0, 1}
+	// one answer to a count query and the other to an AVG query
+	if len(answers[0]) != len(answers[1]) {
+		return false, both, nil
+	}
 
-		return sumCount / dataCount, nil
+	for _, r := range c.residuals(answers) {
+		if r != 0 {
+			return false, both, nil
+		}
+	}
 
-	} else {
-		// compute data
-		data := (answers[0][0] + answers[1][0]) % field.ModP
-		dataCasted := uint64(data)
+	return true, nil, nil
+}
 
-		// check tags, executed only for authenticated. The -1 is to ignore
-		// the value for the data already initialized
+// residuals returns, for every tag of the answers, the difference between
+// the reconstructed tag and the tag of the reconstructed data, i.e., its
+// product with the alpha of the tag. The residuals of correct answers are
+// all zero. The answer to an AVG query is the count and the sum, each with
+// its tags, and the residuals of the count come first. The answers must
+// have the same length, a multiple of the executions.
+func (c *clientFSS) residuals(answers [][]uint32) []uint32 {
+	p := uint64(field.ModP)
+	// the -1 is to ignore the value for the data
+	out := make([]uint32, 0, len(answers[0])/c.executions*(c.executions-1))
+	for begin := 0; begin < len(answers[0]); begin += c.executions {
+		first := answers[0][begin : begin+c.executions]
+		second := answers[1][begin : begin+c.executions]
+		data := (uint64(first[0]) + uint64(second[0])) % p
 		for i := 0; i < c.executions-1; i++ {
-			tmp := (dataCasted * uint64(c.state.alphas[i])) % uint64(field.ModP)
-			tag := uint32(tmp)
-			reconstructedTag := (answers[0][i+1] + answers[1][i+1]) % field.ModP
-			if tag != reconstructedTag {
-				return 0, errors.New("REJECT")
-			}
+			tag := (uint64(first[i+1]) + uint64(second[i+1])) % p
+			expected := data * uint64(c.state.alphas[i]) % p
+			out = append(out, uint32((tag+p-expected)%p))
 		}
-
-		return data, nil
 	}
 
+	return out
+}
+
+// validAnswer returns true if a has the length of an answer to a count or
+// AVG query and is made of field elements
+func (c *clientFSS) validAnswer(a []uint32) bool {
+	if len(a) != c.executions && len(a) != 2*c.executions {
+		return false
+	}
+	for _, e := range a {
+		if e >= field.ModP {
+			return false
+		}
+	}
+	return true
 }
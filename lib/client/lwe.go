@@ -1,7 +1,6 @@
 package client
 
 import (
-	"errors"
 	"io"
 
 	"github.com/si-co/vpir-code/lib/database"
@@ -83,7 +82,7 @@ func (c *LWE) Reconstruct(answers *matrix.Matrix) (uint32, error) {
 		} else if c.inRange(v - c.state.t) {
 			outs[i] = 1
 		} else {
-			return 0, errors.New("REJECT")
+			return 0, ErrReject
 		}
 	}
 
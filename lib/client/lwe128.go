@@ -1,7 +1,6 @@
 package client
 
 import (
-	"errors"
 	"io"
 
 	"github.com/si-co/vpir-code/lib/database"
@@ -84,7 +83,7 @@ func (c *LWE128) Reconstruct(answers *matrix.Matrix128) (uint32, error) {
 		} else if c.inRange(v.SubWrap(c.state.t)) {
 			outs[i] = 1
 		} else {
-			return 0, errors.New("REJECT")
+			return 0, ErrReject
 		}
 	}
 
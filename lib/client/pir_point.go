@@ -2,6 +2,8 @@ package client
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 
@@ -19,6 +21,7 @@ type PIR struct {
 	rnd    io.Reader
 	dbInfo *database.Info
 	state  *state
+	sizes
 }
 
 // NewPIR return a client for the classical PIR multi-bit scheme in
@@ -34,15 +37,29 @@ func NewPIR(rnd io.Reader, info *database.Info) *PIR {
 
 // QueryBytes is wrapper around Query to implement the Client interface
 func (c *PIR) QueryBytes(in []byte, numServers int) ([][]byte, error) {
+	if len(in) != 4 {
+		return nil, fmt.Errorf("query input has length %d, expected 4", len(in))
+	}
+	if err := ValidateServers("pir-classic", numServers); err != nil {
+		return nil, err
+	}
 	index := int(binary.BigEndian.Uint32(in))
+	if index >= c.dbInfo.NumRows*c.dbInfo.NumColumns {
+		return nil, errors.New("invalid query inputs")
+	}
 	return c.Query(index, numServers), nil
 }
 
 // Query performs a client query for the given database index to numServers
-// servers. This function performs both vector and rebalanced query depending
-// on the database representation
+// servers, with numServers >= 2. The first numServers-1 servers get random
+// vectors, the last one their XOR with the basis vector of the index, so that
+// any numServers-1 colluding servers learn nothing. This function performs
+// both vector and rebalanced query depending on the database representation
 func (c *PIR) Query(index int, numServers int) [][]byte {
-	if invalidQueryInputsIT(index, numServers) {
+	if err := ValidateServers("pir-classic", numServers); err != nil {
+		log.Fatal(err)
+	}
+	if index < 0 {
 		log.Fatal("invalid query inputs")
 	}
 	// set the client state. The entries specific to VPIR are not used
@@ -55,10 +72,95 @@ func (c *PIR) Query(index int, numServers int) [][]byte {
 	if err != nil {
 		log.Fatal(err)
 	}
+	c.setQueries(vectors)
 
 	return vectors
 }
 
+// QuerySeeded is like Query, but the first numServers-1 servers get the PRG
+// seed of their random vector instead of the vector itself, and answer it
+// with AnswerSeedBytes. Only the last server gets a full query vector, so
+// that the query sent to all the other servers has constant size.
+func (c *PIR) QuerySeeded(index int, numServers int) ([][]byte, error) {
+	if err := ValidateServers("pir-classic", numServers); err != nil {
+		return nil, err
+	}
+	if index < 0 || index >= c.dbInfo.NumBlocks() {
+		return nil, errors.New("invalid query inputs")
+	}
+	ix, iy := utils.VectorToMatrixIndices(index, c.dbInfo.NumColumns)
+	c.state = &state{
+		ix: ix,
+		iy: iy,
+	}
+
+	// same length as the vectors of secretShare
+	vectorLen := ExpectedQueryBytes(c.dbInfo)
+	queries := make([][]byte, numServers)
+	last := make([]byte, vectorLen)
+	last[iy/8] = byte(1 << (iy % 8))
+	for k := 0; k < numServers-1; k++ {
+		seed := new(utils.PRGKey)
+		if _, err := io.ReadFull(c.rnd, seed[:]); err != nil {
+			return nil, err
+		}
+		fastxor.Bytes(last, last, utils.ExpandSeed(seed, vectorLen))
+		queries[k] = seed[:]
+	}
+	queries[numServers-1] = last
+	c.setQueries(queries)
+
+	return queries, nil
+}
+
+// QueryState is the state of the client for a query precomputed with
+// PrecomputeQuery, needed to reconstruct the answers to it
+type QueryState struct {
+	index int
+	state *state
+}
+
+// Index returns the index of the block retrieved by the query
+func (qs *QueryState) Index() int {
+	return qs.index
+}
+
+// PrecomputeQuery is like Query, but it also returns the state of the
+// client for the query, so that the same query can be answered many times,
+// e.g., to benchmark the servers without the cost of generating it, and
+// every time reconstructed with ReconstructWithState. Later queries of the
+// client do not change the state.
+func (c *PIR) PrecomputeQuery(index, numServers int) ([][]byte, *QueryState, error) {
+	if err := ValidateServers("pir-classic", numServers); err != nil {
+		return nil, nil, err
+	}
+	if index < 0 || index >= c.dbInfo.NumBlocks() {
+		return nil, nil, errors.New("invalid query inputs")
+	}
+	ix, iy := utils.VectorToMatrixIndices(index, c.dbInfo.NumColumns)
+	c.state = &state{
+		ix: ix,
+		iy: iy,
+	}
+	vectors, err := c.secretShare(numServers)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.setQueries(vectors)
+
+	return vectors, &QueryState{index: index, state: c.state}, nil
+}
+
+// ReconstructWithState is like Reconstruct, but reconstructs the answers
+// to the query precomputed with the given state instead of the last query
+func (c *PIR) ReconstructWithState(qs *QueryState, answers [][]byte) ([]byte, error) {
+	if qs == nil || qs.state == nil {
+		return nil, errors.New("no query state to reconstruct the answers with")
+	}
+	c.state = qs.state
+	return c.Reconstruct(answers)
+}
+
 // ReconstructBytes returns []byte
 func (c *PIR) ReconstructBytes(a [][]byte) (interface{}, error) {
 	return c.Reconstruct(a)
@@ -66,13 +168,151 @@ func (c *PIR) ReconstructBytes(a [][]byte) (interface{}, error) {
 
 // Reconstruct reconstruct the entry of the database from answers
 func (c *PIR) Reconstruct(answers [][]byte) ([]byte, error) {
+	c.setAnswers(answers)
 	return reconstructPIR(answers, c.dbInfo, c.state)
 }
 
+// ReconstructBytesInto is like Reconstruct, but writes the entry at the
+// beginning of dst and returns its length, so that no memory is allocated
+// for it, e.g., when retrieving many blocks in a loop. dst must hold a
+// block of the db, whose bytes after the entry are overwritten as well.
+func (c *PIR) ReconstructBytesInto(answers [][]byte, dst []byte) (int, error) {
+	c.setAnswers(answers)
+	return reconstructPIRInto(answers, c.dbInfo, c.state, dst)
+}
+
+// LastVerified returns true if the last reconstruction checked the block
+// against the Merkle root or the block hashes of the db
+func (c *PIR) LastVerified() bool {
+	return c.state != nil && c.state.verified
+}
+
+// Answerer answers the queries of a client, e.g. a local server or a stub
+// sending the queries over the network
+type Answerer interface {
+	AnswerBytes([]byte) ([]byte, error)
+}
+
+// RetrieveRecord retrieves the record spanning numBlocks consecutive blocks
+// from startBlock, querying every block to all the servers and concatenating
+// the reconstructed blocks in order. If unpad is true, the padding added by
+// database.PadBlock at the end of the record is removed.
+func (c *PIR) RetrieveRecord(startBlock, numBlocks int, servers []Answerer, unpad bool) ([]byte, error) {
+	if numBlocks <= 0 || startBlock+numBlocks > c.dbInfo.NumBlocks() {
+		return nil, fmt.Errorf("invalid record of %d blocks from block %d", numBlocks, startBlock)
+	}
+
+	record := make([]byte, 0, numBlocks*c.dbInfo.BlockSize)
+	in := make([]byte, 4)
+	for i := startBlock; i < startBlock+numBlocks; i++ {
+		binary.BigEndian.PutUint32(in, uint32(i))
+		queries, err := c.QueryBytes(in, len(servers))
+		if err != nil {
+			return nil, err
+		}
+		answers := make([][]byte, len(servers))
+		for k := range servers {
+			answers[k], err = servers[k].AnswerBytes(queries[k])
+			if err != nil {
+				return nil, fmt.Errorf("server %d failed to answer block %d: %v", k, i, err)
+			}
+		}
+		block, err := c.Reconstruct(answers)
+		if err != nil {
+			return nil, err
+		}
+		record = append(record, block...)
+	}
+
+	if unpad {
+		return database.UnPadBlock(record), nil
+	}
+
+	return record, nil
+}
+
+// BatchAnswerer answers a batch of queries of a client at once, e.g. a
+// server.BatchServer
+type BatchAnswerer interface {
+	AnswerBatchBytes([][]byte) ([][]byte, error)
+}
+
+// RetrieveByIDs retrieves the blocks of the given ids from a db of keys
+// hashed with database.HashToIndex, sending a single batch of queries to
+// every server. The ids hashed to the same block are queried once. It
+// returns the unpadded block of every id, which holds the keys of all the
+// ids hashed to it, e.g., to be parsed with pgp.RecoverKeyFromBlock.
+func (c *PIR) RetrieveByIDs(ids []string, servers []BatchAnswerer) (map[string][]byte, error) {
+	// the blocks to query, in order of first appearance
+	blocks := make([]int, 0, len(ids))
+	position := make(map[int]int, len(ids))
+	for _, id := range ids {
+		b := int(database.HashToIndex(id, c.dbInfo.NumBlocks()))
+		if _, ok := position[b]; !ok {
+			position[b] = len(blocks)
+			blocks = append(blocks, b)
+		}
+	}
+
+	// batches[k] holds the queries of server k, one per block
+	batches := make([][][]byte, len(servers))
+	states := make([]*state, len(blocks))
+	all := make([][]byte, 0, len(blocks)*len(servers))
+	in := make([]byte, 4)
+	for i, b := range blocks {
+		binary.BigEndian.PutUint32(in, uint32(b))
+		queries, err := c.QueryBytes(in, len(servers))
+		if err != nil {
+			return nil, err
+		}
+		states[i] = c.state
+		for k := range servers {
+			batches[k] = append(batches[k], queries[k])
+		}
+		all = append(all, queries...)
+	}
+
+	answers := make([][][]byte, len(servers))
+	for k := range servers {
+		a, err := servers[k].AnswerBatchBytes(batches[k])
+		if err != nil {
+			return nil, fmt.Errorf("server %d failed to answer the batch: %v", k, err)
+		}
+		if len(a) != len(blocks) {
+			return nil, fmt.Errorf("server %d answered %d queries, expected %d", k, len(a), len(blocks))
+		}
+		answers[k] = a
+	}
+
+	out := make(map[string][]byte, len(ids))
+	results := make([][]byte, len(blocks))
+	allAnswers := make([][]byte, 0, len(all))
+	for i, b := range blocks {
+		blockAnswers := make([][]byte, len(servers))
+		for k := range servers {
+			blockAnswers[k] = answers[k][i]
+		}
+		allAnswers = append(allAnswers, blockAnswers...)
+		c.state = states[i]
+		block, err := reconstructPIR(blockAnswers, c.dbInfo, c.state)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %v", b, err)
+		}
+		results[i] = database.UnPadBlock(block)
+	}
+	for _, id := range ids {
+		out[id] = results[position[int(database.HashToIndex(id, c.dbInfo.NumBlocks()))]]
+	}
+	c.setQueries(all)
+	c.setAnswers(allAnswers)
+
+	return out, nil
+}
+
 func (c *PIR) secretShare(numServers int) ([][]byte, error) {
 	// length of query vector
 	// one query bit per column
-	vectorLen := c.dbInfo.NumColumns/8 + 1
+	vectorLen := ExpectedQueryBytes(c.dbInfo)
 
 	// create query vectors for all the servers
 	vectors := make([][]byte, numServers)
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Range queries for the classical PIR scheme.
+//
+// A range query retrieves numBlocks consecutive blocks with a single query
+// vector per server. The vectors are shared as for a point query of the
+// first block, and every server derives the query for the block at offset k
+// by shifting its vector cyclically by k columns. The shifted shares
+// reconstruct the basis vector of the column of the block at offset k, and
+// since every server only sees a uniformly random vector and the public
+// number of blocks, the range query is as private as a point query.
+//
+// The query has the size of a point query, plus 4 bytes for the number of
+// blocks, instead of numBlocks times the size of a point query. The server
+// reads the db once, but XORs every block into up to numBlocks answers, and
+// its answer is numBlocks times as large as the answer to a point query,
+// since every offset gets one block per row.
+
+// RangeQueryPrefix is the length of the number of blocks that precedes the
+// query vector of a range query
+const RangeQueryPrefix = 4
+
+// QueryRange returns the queries for the numBlocks consecutive blocks from
+// startBlock, one per server. The range must not span more blocks than the
+// columns of the db, so that no two blocks are in the same column.
+func (c *PIR) QueryRange(startBlock, numBlocks, numServers int) ([][]byte, error) {
+	if err := ValidateServers("pir-classic", numServers); err != nil {
+		return nil, err
+	}
+	if startBlock < 0 || numBlocks <= 0 || startBlock+numBlocks > c.dbInfo.NumBlocks() {
+		return nil, fmt.Errorf("invalid range of %d blocks from block %d", numBlocks, startBlock)
+	}
+	if numBlocks > c.dbInfo.NumColumns {
+		return nil, fmt.Errorf("range of %d blocks in a db with %d columns", numBlocks, c.dbInfo.NumColumns)
+	}
+
+	ix, iy := startBlock/c.dbInfo.NumColumns, startBlock%c.dbInfo.NumColumns
+	c.state = &state{ix: ix, iy: iy, rangeLen: numBlocks}
+	vectors, err := c.secretShare(numServers)
+	if err != nil {
+		return nil, err
+	}
+
+	queries := make([][]byte, numServers)
+	for k, v := range vectors {
+		queries[k] = make([]byte, RangeQueryPrefix+len(v))
+		binary.BigEndian.PutUint32(queries[k], uint32(numBlocks))
+		copy(queries[k][RangeQueryPrefix:], v)
+	}
+	c.setQueries(queries)
+
+	return queries, nil
+}
+
+// ReconstructRange reconstructs the blocks of the last range query from the
+// answers of the servers, in order
+func (c *PIR) ReconstructRange(answers [][]byte) ([][]byte, error) {
+	if c.state == nil || c.state.rangeLen == 0 {
+		return nil, fmt.Errorf("no range query to reconstruct")
+	}
+	c.setAnswers(answers)
+	n := c.state.rangeLen
+	pointLen := ExpectedAnswerBytes(c.dbInfo)
+	for k := range answers {
+		if len(answers[k]) != n*pointLen {
+			return nil, fmt.Errorf("answer of server %d has length %d, expected %d",
+				k, len(answers[k]), n*pointLen)
+		}
+	}
+
+	start := c.state.ix*c.dbInfo.NumColumns + c.state.iy
+	blocks := make([][]byte, n)
+	point := make([][]byte, len(answers))
+	verified := true
+	for i := range blocks {
+		for k := range answers {
+			point[k] = answers[k][i*pointLen : (i+1)*pointLen]
+		}
+		st := &state{ix: (start + i) / c.dbInfo.NumColumns, iy: (start + i) % c.dbInfo.NumColumns}
+		block, err := reconstructPIR(point, c.dbInfo, st)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", start+i, err)
+		}
+		blocks[i] = block
+		verified = verified && st.verified
+	}
+	c.state.verified = verified
+
+	return blocks, nil
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/lukechampine/fastxor"
+	"github.com/si-co/vpir-code/lib/utils"
+)
+
+// AnswerChunk is a part of the answer of a server to a classical PIR query.
+// The answer is split in chunks of the same length, except the last one,
+// and the chunk of index Index holds the answer from byte Index*chunkLen.
+type AnswerChunk struct {
+	Server int
+	Index  int
+	Data   []byte
+}
+
+// SplitAnswer splits the answer of the given server in chunks of chunkLen
+// bytes, the last one being possibly shorter. The chunks share the memory of
+// answer.
+func SplitAnswer(server int, answer []byte, chunkLen int) []AnswerChunk {
+	chunks := make([]AnswerChunk, 0, (len(answer)+chunkLen-1)/chunkLen)
+	for off := 0; off < len(answer); off += chunkLen {
+		end := off + chunkLen
+		if end > len(answer) {
+			end = len(answer)
+		}
+		chunks = append(chunks, AnswerChunk{Server: server, Index: off / chunkLen, Data: answer[off:end]})
+	}
+	return chunks
+}
+
+// ReconstructStream reconstructs the block of the last query from the
+// chunks of the answers of numServers servers, split as by SplitAnswer. The
+// chunks can arrive in any order and interleaved across the servers. Only
+// the bytes of the row of the block are kept, so that the client needs a
+// single block besides the chunk being processed, instead of the answers of
+// all the servers. It returns once all the chunks have arrived, or an error
+// for an invalid or duplicated chunk or if chunks is closed before all the
+// chunks have arrived.
+func (c *PIR) ReconstructStream(chunks <-chan AnswerChunk, numServers, chunkLen int) ([]byte, error) {
+	if c.state == nil {
+		return nil, fmt.Errorf("no query to reconstruct")
+	}
+	if err := ValidateServers("pir-classic", numServers); err != nil {
+		return nil, err
+	}
+	if chunkLen <= 0 {
+		return nil, fmt.Errorf("invalid chunk length %d", chunkLen)
+	}
+	c.state.verified = false
+	c.answerBytes = 0
+
+	answerLen := ExpectedAnswerBytes(c.dbInfo)
+	numChunks := (answerLen + chunkLen - 1) / chunkLen
+	received := make([]bool, numServers*numChunks)
+	bs := c.dbInfo.BlockSize
+	blockStart := c.state.ix * bs
+
+	sum := make([]byte, bs)
+	for missing := len(received); missing > 0; missing-- {
+		ch, ok := <-chunks
+		if !ok {
+			return nil, fmt.Errorf("%d chunks missing", missing)
+		}
+		if ch.Server < 0 || ch.Server >= numServers || ch.Index < 0 || ch.Index >= numChunks {
+			return nil, fmt.Errorf("unexpected chunk %d of server %d", ch.Index, ch.Server)
+		}
+		off := ch.Index * chunkLen
+		if expected := utils.Min(chunkLen, answerLen-off); len(ch.Data) != expected {
+			return nil, fmt.Errorf("chunk %d of server %d has length %d, expected %d",
+				ch.Index, ch.Server, len(ch.Data), expected)
+		}
+		if received[ch.Server*numChunks+ch.Index] {
+			return nil, fmt.Errorf("duplicated chunk %d of server %d", ch.Index, ch.Server)
+		}
+		received[ch.Server*numChunks+ch.Index] = true
+		c.answerBytes += len(ch.Data)
+
+		// XOR the part of the chunk in the row of the block
+		lo, hi := utils.Max(off, blockStart), utils.Min(off+len(ch.Data), blockStart+bs)
+		if lo < hi {
+			dst := sum[lo-blockStart : hi-blockStart]
+			fastxor.Bytes(dst, dst, ch.Data[lo-off:hi-off])
+		}
+	}
+
+	return checkBlockPIR(sum, c.dbInfo, c.state)
+}
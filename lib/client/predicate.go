@@ -0,0 +1,188 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/si-co/vpir-code/lib/query"
+)
+
+type predicateKind uint8
+
+const (
+	matchPredicate predicateKind = iota
+	rangePredicate
+	andPredicate
+)
+
+// Predicate is a condition on the keys of the database, whose matching keys
+// are counted by the FSS-based complex queries. A predicate is built with
+// Match, MatchPrefix, MatchSuffix, Range and And, and compiled into the
+// query of the PredicatePIR and PredicateAPIR clients by Compile.
+//
+// The FSS scheme only evaluates point functions, so a predicate must select
+// a single value of its target: ranges are limited to a single year and a
+// conjunction must combine the year of creation with a match on the email,
+// the only conjunction evaluated by the servers.
+type Predicate struct {
+	kind   predicateKind
+	target query.Target
+
+	// match
+	value              string
+	fromStart, fromEnd int
+
+	// range
+	lo, hi int
+
+	// conjunction
+	operands []*Predicate
+}
+
+// Match selects the keys whose target is equal to value. The email is
+// matched as a whole, the public key algorithm by name (e.g., RSA) and the
+// creation time by its year, e.g., 2019.
+func Match(target query.Target, value string) *Predicate {
+	return &Predicate{kind: matchPredicate, target: target, value: value}
+}
+
+// MatchPrefix selects the keys whose email starts with prefix
+func MatchPrefix(target query.Target, prefix string) *Predicate {
+	return &Predicate{kind: matchPredicate, target: target, value: prefix, fromStart: len(prefix)}
+}
+
+// MatchSuffix selects the keys whose email ends with suffix, e.g., the
+// organization .edu
+func MatchSuffix(target query.Target, suffix string) *Predicate {
+	return &Predicate{kind: matchPredicate, target: target, value: suffix, fromEnd: len(suffix)}
+}
+
+// Range selects the keys created from year lo to year hi included. Only
+// single-year ranges, i.e., lo equal to hi, can be compiled, and only in a
+// conjunction with And.
+func Range(target query.Target, lo, hi int) *Predicate {
+	return &Predicate{kind: rangePredicate, target: target, lo: lo, hi: hi}
+}
+
+// And selects the keys satisfying all the given predicates
+func And(ps ...*Predicate) *Predicate {
+	return &Predicate{kind: andPredicate, operands: ps}
+}
+
+// Compile returns the query selecting the keys that satisfy the predicate
+func (p *Predicate) Compile() (*query.ClientFSS, error) {
+	switch p.kind {
+	case matchPredicate:
+		return p.compileMatch()
+	case rangePredicate:
+		if err := p.checkRange(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("a range is only supported in a conjunction with a match on the email")
+	case andPredicate:
+		return p.compileAnd()
+	default:
+		return nil, fmt.Errorf("unknown predicate kind %d", p.kind)
+	}
+}
+
+func (p *Predicate) compileMatch() (*query.ClientFSS, error) {
+	if p.value == "" {
+		return nil, errors.New("empty match value")
+	}
+	if p.target != query.UserId && (p.fromStart != 0 || p.fromEnd != 0) {
+		return nil, fmt.Errorf("substring match on target %d, only supported on the email", p.target)
+	}
+
+	info := &query.Info{Target: p.target, FromStart: p.fromStart, FromEnd: p.fromEnd}
+	switch p.target {
+	case query.UserId:
+		id, _ := info.IdForEmail(p.value)
+		return &query.ClientFSS{Info: info, Input: id}, nil
+	case query.PubKeyAlgo:
+		pka, err := query.ParsePubKeyAlgo(p.value)
+		if err != nil {
+			return nil, err
+		}
+		return &query.ClientFSS{Info: info, Input: info.IdForPubKeyAlgo(pka)}, nil
+	case query.CreationTime:
+		year, err := strconv.Atoi(p.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid creation year %q: %v", p.value, err)
+		}
+		id, err := info.IdForYearCreationTime(time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC))
+		if err != nil {
+			return nil, err
+		}
+		return &query.ClientFSS{Info: info, Input: id}, nil
+	default:
+		return nil, fmt.Errorf("unknown target %d", p.target)
+	}
+}
+
+func (p *Predicate) checkRange() error {
+	if p.target != query.CreationTime {
+		return fmt.Errorf("range on target %d, only supported on the creation time", p.target)
+	}
+	if p.lo > p.hi {
+		return fmt.Errorf("empty range from %d to %d", p.lo, p.hi)
+	}
+	if p.lo != p.hi {
+		return fmt.Errorf("range from %d to %d spans more than one year", p.lo, p.hi)
+	}
+	return nil
+}
+
+// compileAnd compiles the conjunction of a year and a match on the email,
+// whose input is the year followed by the email identifier as evaluated by
+// the servers
+func (p *Predicate) compileAnd() (*query.ClientFSS, error) {
+	if len(p.operands) != 2 {
+		return nil, fmt.Errorf("conjunction of %d predicates, only conjunctions of 2 are supported",
+			len(p.operands))
+	}
+	var year, email *Predicate
+	for _, o := range p.operands {
+		switch {
+		case o.kind == rangePredicate:
+			year = o
+		case o.kind == matchPredicate && o.target == query.UserId:
+			email = o
+		}
+	}
+	if year == nil || email == nil {
+		return nil, errors.New("a conjunction must combine a range on the creation time and a match on the email")
+	}
+	if err := year.checkRange(); err != nil {
+		return nil, err
+	}
+	if email.value == "" {
+		return nil, errors.New("empty match value")
+	}
+
+	info := &query.Info{And: true, FromStart: email.fromStart, FromEnd: email.fromEnd}
+	idYear, err := info.IdForYearCreationTime(time.Date(year.lo, time.January, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		return nil, err
+	}
+	idEmail, _ := info.IdForEmail(email.value)
+
+	return &query.ClientFSS{Info: info, Input: append(idYear, idEmail...)}, nil
+}
+
+// queryPredicate compiles the predicate and returns the encoded queries for
+// the servers
+func (c *clientFSS) queryPredicate(p *Predicate, numServers int) ([][]byte, error) {
+	q, err := p.Compile()
+	if err != nil {
+		return nil, err
+	}
+	in, err := q.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.queryBytes(in, numServers)
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"errors"
 	"io"
 
 	"github.com/si-co/vpir-code/lib/database"
@@ -18,19 +19,25 @@ type PredicateAPIR struct {
 
 // NewFSS returns a new client for the FSS-based single- and multi-bit schemes
 func NewPredicateAPIR(rnd io.Reader, info *database.Info) *PredicateAPIR {
+	// one value for the data, four values for the info-theoretic MAC
 	executions := 1 + field.ConcurrentExecutions
 	return &PredicateAPIR{
-		&clientFSS{
-			rnd:    rnd,
-			dbInfo: info,
-			state:  nil,
-			// one value for the data, four values for the info-theoretic MAC
-			Fss:        fss.ClientInitialize(executions),
-			executions: executions,
-		},
+		newClientFSS("fss-auth", rnd, info, fss.ClientInitialize(executions), executions),
 	}
 }
 
+// NewPredicateAPIRWithKeySize is like NewPredicateAPIR, but the FSS uses
+// PRF keys of keySize bytes, see fss.KeySize128. The servers must use the
+// same key size.
+func NewPredicateAPIRWithKeySize(rnd io.Reader, info *database.Info, keySize int) (*PredicateAPIR, error) {
+	executions := 1 + field.ConcurrentExecutions
+	f, err := fss.ClientInitializeWithKeySize(executions, keySize)
+	if err != nil {
+		return nil, err
+	}
+	return &PredicateAPIR{newClientFSS("fss-auth", rnd, info, f, executions)}, nil
+}
+
 // QueryBytes executes Query and encodes the result a byte array for each
 // server
 func (c *PredicateAPIR) QueryBytes(in []byte, numServers int) ([][]byte, error) {
@@ -54,3 +61,36 @@ func (c *PredicateAPIR) ReconstructBytes(a [][]byte) (interface{}, error) {
 func (c *PredicateAPIR) Reconstruct(answers [][]uint32) (uint32, error) {
 	return c.reconstruct(answers)
 }
+
+// ReconstructVerbose is like Reconstruct, but also returns the residuals of
+// the tags of the answers, which are all zero for correct answers, also if
+// the reconstruction fails the integrity check. It is meant to inspect how
+// and where the verification fails, e.g., to gather statistics, and costs a
+// second pass over the tags.
+func (c *PredicateAPIR) ReconstructVerbose(answers [][]uint32) (uint32, []uint32, error) {
+	if c.state == nil {
+		return 0, nil, errors.New("no query to reconstruct")
+	}
+	if len(answers) != 2 || len(answers[0]) != len(answers[1]) ||
+		(len(answers[0]) != c.executions && len(answers[0]) != 2*c.executions) {
+		return 0, nil, errors.New("invalid answers length")
+	}
+	residuals := c.residuals(answers)
+	res, err := c.reconstruct(answers)
+
+	return res, residuals, err
+}
+
+// VerifyAnswers checks the tags of the answers to the last query without
+// reconstructing the entry, e.g., to decide whether to query again. It
+// returns whether the answers pass and the indices of the servers whose
+// answers fail. Reconstruct runs the same checks.
+func (c *PredicateAPIR) VerifyAnswers(answers [][]uint32) (bool, []int, error) {
+	return c.verifyAnswers(answers)
+}
+
+// QueryPredicate compiles the predicate and returns the encoded queries for
+// the servers, as QueryBytes
+func (c *PredicateAPIR) QueryPredicate(p *Predicate, numServers int) ([][]byte, error) {
+	return c.queryPredicate(p, numServers)
+}
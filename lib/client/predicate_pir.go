@@ -6,6 +6,7 @@ import (
 	"github.com/si-co/vpir-code/lib/database"
 	"github.com/si-co/vpir-code/lib/fss"
 	"github.com/si-co/vpir-code/lib/query"
+	"github.com/si-co/vpir-code/lib/utils"
 )
 
 // PredicatePIR represent the client for the FSS-based complex-queries non-verifiable PIR
@@ -16,16 +17,32 @@ type PredicatePIR struct {
 // NewPredicatePIR returns a new client for the DPF-base multi-bit classical PIR
 // scheme
 func NewPredicatePIR(rnd io.Reader, info *database.Info) *PredicatePIR {
-	executions := 1
+	executions := 1 // only one value
 	return &PredicatePIR{
-		&clientFSS{
-			rnd:        rnd,
-			dbInfo:     info,
-			state:      nil,
-			Fss:        fss.ClientInitialize(executions), // only one value
-			executions: executions,
-		},
+		newClientFSS("fss-classic", rnd, info, fss.ClientInitialize(executions), executions),
+	}
+}
+
+// NewPredicatePIRWithKeySize is like NewPredicatePIR, but the FSS uses PRF
+// keys of keySize bytes, see fss.KeySize128. The servers must use the same
+// key size.
+func NewPredicatePIRWithKeySize(rnd io.Reader, info *database.Info, keySize int) (*PredicatePIR, error) {
+	executions := 1
+	f, err := fss.ClientInitializeWithKeySize(executions, keySize)
+	if err != nil {
+		return nil, err
 	}
+	return &PredicatePIR{newClientFSS("fss-classic", rnd, info, f, executions)}, nil
+}
+
+// NewDPFWithPRG is like NewPredicatePIR, but the FSS keys are generated
+// from prg instead of crypto/rand, so that the same seed yields the same
+// keys for the same queries. It is meant for reproducible tests and
+// benchmarks only: deterministic keys must not be used in production.
+func NewDPFWithPRG(prg *utils.PRGReader, info *database.Info) *PredicatePIR {
+	c := NewPredicatePIR(prg, info)
+	c.Fss.Rand = prg
+	return c
 }
 
 // QueryBytes executes Query and encodes the result a byte array for each
@@ -49,3 +66,9 @@ func (c *PredicatePIR) ReconstructBytes(answers [][]byte) (interface{}, error) {
 func (c *PredicatePIR) Reconstruct(answers [][]uint32) (uint32, error) {
 	return c.reconstruct(answers)
 }
+
+// QueryPredicate compiles the predicate and returns the encoded queries for
+// the servers, as QueryBytes
+func (c *PredicatePIR) QueryPredicate(p *Predicate, numServers int) ([][]byte, error) {
+	return c.queryPredicate(p, numServers)
+}
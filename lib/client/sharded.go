@@ -0,0 +1,103 @@
+package client
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/si-co/vpir-code/lib/database"
+)
+
+// ShardedPIR is the client for the classical PIR multi-bit scheme over a
+// logical database split by columns across shards, each served by its own
+// server process. The queries and the answers are laid out by server role,
+// i.e., the query for shard s of server k is at index k*len(shards)+s.
+type ShardedPIR struct {
+	pir    *PIR
+	shards []database.ShardRange
+	sizes
+}
+
+// NewShardedPIR returns a client for the logical database described by
+// info, split in the given shards. The shards must cover the columns of the
+// database in order, as returned by database.SplitColumns.
+func NewShardedPIR(rnd io.Reader, info *database.Info, shards []database.ShardRange) (*ShardedPIR, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("no shards")
+	}
+	next := 0
+	for i, s := range shards {
+		if s.Start != next || s.End <= s.Start {
+			return nil, fmt.Errorf("shard %d covers columns from %d to %d, expected to start at %d",
+				i, s.Start, s.End, next)
+		}
+		next = s.End
+	}
+	if next != info.NumColumns {
+		return nil, fmt.Errorf("shards cover %d columns of a db with %d columns", next, info.NumColumns)
+	}
+
+	return &ShardedPIR{pir: NewPIR(rnd, info), shards: shards}, nil
+}
+
+// QueryBytes returns the queries for the block index encoded in in, one for
+// every shard of every one of the numServers server roles. The query of a
+// shard is the query of the logical database restricted to its columns.
+func (c *ShardedPIR) QueryBytes(in []byte, numServers int) ([][]byte, error) {
+	queries, err := c.pir.QueryBytes(in, numServers)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([][]byte, 0, numServers*len(c.shards))
+	for _, q := range queries {
+		for _, s := range c.shards {
+			out = append(out, columnBits(q, s))
+		}
+	}
+	c.setQueries(out)
+
+	return out, nil
+}
+
+// ReconstructBytes returns []byte
+func (c *ShardedPIR) ReconstructBytes(a [][]byte) (interface{}, error) {
+	return c.Reconstruct(a)
+}
+
+// Reconstruct XORs the answers of the shards of every server role and
+// reconstructs the block from the combined answers
+func (c *ShardedPIR) Reconstruct(answers [][]byte) ([]byte, error) {
+	if len(answers) == 0 || len(answers)%len(c.shards) != 0 {
+		return nil, fmt.Errorf("%d answers for %d shards", len(answers), len(c.shards))
+	}
+	c.setAnswers(answers)
+
+	combined := make([][]byte, len(answers)/len(c.shards))
+	for k := range combined {
+		var err error
+		combined[k], err = CombineAnswerBytes(answers[k*len(c.shards) : (k+1)*len(c.shards)])
+		if err != nil {
+			return nil, fmt.Errorf("shards of server %d: %v", k, err)
+		}
+	}
+
+	return c.pir.Reconstruct(combined)
+}
+
+// LastVerified returns true if the last reconstruction checked the block
+func (c *ShardedPIR) LastVerified() bool {
+	return c.pir.LastVerified()
+}
+
+// columnBits returns the bits of the query vector q for the columns of s,
+// with the same layout as a query vector for a database of s.Len() columns
+func columnBits(q []byte, s database.ShardRange) []byte {
+	out := make([]byte, s.Len()/8+1)
+	for j := s.Start; j < s.End; j++ {
+		if (q[j/8]>>(j%8))&1 == 1 {
+			k := j - s.Start
+			out[k/8] |= 1 << (k % 8)
+		}
+	}
+	return out
+}
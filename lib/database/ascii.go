@@ -0,0 +1,43 @@
+package database
+
+import (
+	"math"
+
+	"github.com/cloudflare/circl/group"
+	"github.com/si-co/vpir-code/lib/utils"
+)
+
+// CreateAsciiVector returns a single-bit database in the vector
+// representation holding the bits of the given payload, most significant bit
+// of every character first.
+func CreateAsciiVector(payload string, g group.Group) *Elliptic {
+	numRows, numColumns := CalculateNumRowsAndColumns(8*len(payload), false)
+	return createAscii(payload, g, numRows, numColumns)
+}
+
+// CreateAsciiMatrix returns a single-bit database in the rebalanced
+// representation holding the bits of the given payload, most significant bit
+// of every character first. Every row holds whole characters, so that no row
+// is made only of padding: the digest of an all-zero row is the identity,
+// whose encoding is shorter than the one of the other group elements. The
+// bits that do not fill up the last row are set to zero.
+func CreateAsciiMatrix(payload string, g group.Group) *Elliptic {
+	// about sqrt(n) columns, rounded up to whole characters
+	charsPerRow := int(math.Ceil(math.Sqrt(float64(8*len(payload))) / 8))
+	numRows := (len(payload) + charsPerRow - 1) / charsPerRow
+	return createAscii(payload, g, numRows, 8*charsPerRow)
+}
+
+func createAscii(payload string, g group.Group, numRows, numColumns int) *Elliptic {
+	bits := utils.ByteToBits([]byte(payload))
+
+	// as for the random database, we use the whole byte to store 0 or 1
+	data := make([]byte, numRows*numColumns)
+	for i, b := range bits {
+		if b {
+			data[i] = 1
+		}
+	}
+
+	return CreateEllipticWithDigest(data, numRows, numColumns, g)
+}
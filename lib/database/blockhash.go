@@ -0,0 +1,82 @@
+package database
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/xerrors"
+)
+
+// BlockHashes is the optional integrity information of the classical PIR
+// databases: one keyed hash per block, that the client checks after
+// reconstruction. Since the key is public, it catches corruption and naive
+// tampering, but it does not make the scheme verifiable.
+type BlockHashes struct {
+	HashKey []byte
+	// Hashes contains blake2b.Size256 bytes per block
+	Hashes []byte
+}
+
+// AddBlockHashes computes the keyed hash of every block of db and stores
+// them in the db info. The hash is computed over the block padded with zeros
+// to the block size, as reconstructed by the client.
+func AddBlockHashes(db *Bytes, key []byte) error {
+	numBlocks := db.NumBlocks()
+	hashes := make([]byte, 0, numBlocks*blake2b.Size256)
+	block := make([]byte, db.BlockSize)
+	pos := 0
+	for i := 0; i < numBlocks; i++ {
+		bl := db.BlockSize
+		if db.BlockLengths != nil {
+			bl = db.BlockLengths[i]
+		}
+		copy(block, db.Entries[pos:pos+bl])
+		for j := bl; j < db.BlockSize; j++ {
+			block[j] = 0
+		}
+		pos += bl
+
+		h, err := blockHash(key, i, block)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, h...)
+	}
+	db.BlockHashes = &BlockHashes{HashKey: key, Hashes: hashes}
+
+	return nil
+}
+
+// VerifyBlock returns true if block is the block at the given index of the
+// database described by info. It always returns true if the database has no
+// block hashes, and an error if it has no hash for the index.
+func (i *Info) VerifyBlock(index int, block []byte) (bool, error) {
+	if i.BlockHashes == nil {
+		return true, nil
+	}
+	if index < 0 || index >= len(i.Hashes)/blake2b.Size256 {
+		return false, xerrors.Errorf("no hash for block %d, %d block hashes",
+			index, len(i.Hashes)/blake2b.Size256)
+	}
+	h, err := blockHash(i.HashKey, index, block)
+	if err != nil {
+		return false, err
+	}
+
+	return hmac.Equal(h, i.Hashes[index*blake2b.Size256:(index+1)*blake2b.Size256]), nil
+}
+
+// blockHash binds the block to its index
+func blockHash(key []byte, index int, block []byte) ([]byte, error) {
+	h, err := blake2b.New256(key)
+	if err != nil {
+		return nil, err
+	}
+	var ib [8]byte
+	binary.BigEndian.PutUint64(ib[:], uint64(index))
+	h.Write(ib[:])
+	h.Write(block)
+
+	return h.Sum(nil), nil
+}
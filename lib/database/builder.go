@@ -0,0 +1,106 @@
+package database
+
+import (
+	"encoding/gob"
+	"io"
+	"os"
+
+	"golang.org/x/xerrors"
+)
+
+// BytesBuilder writes a bytes database to disk while its entries are
+// generated, so that databases larger than the memory can be built. At most
+// one chunk of entries is kept in memory. The entries are first written to
+// a temporary file and moved after the header once the builder is
+// finalized, so that the produced file can be read with LoadBytes.
+type BytesBuilder struct {
+	path string
+	tmp  *os.File
+	enc  *gob.Encoder
+
+	chunk     []byte
+	length    int
+	numChunks int
+}
+
+// NewBytesBuilder returns a builder writing the database to path
+func NewBytesBuilder(path string) (*BytesBuilder, error) {
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create temporary file: %v", err)
+	}
+
+	return &BytesBuilder{
+		path:  path,
+		tmp:   tmp,
+		enc:   gob.NewEncoder(tmp),
+		chunk: make([]byte, 0, chunkLength),
+	}, nil
+}
+
+// Write appends p to the entries of the database
+func (b *BytesBuilder) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		free := chunkLength - len(b.chunk)
+		if free > len(p) {
+			free = len(p)
+		}
+		b.chunk = append(b.chunk, p[:free]...)
+		p = p[free:]
+		if len(b.chunk) == chunkLength {
+			if err := b.flush(); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// Finalize writes the database file with the given info and removes the
+// temporary file of the entries. As for SaveBytes, the file at path is
+// only replaced once complete. The builder cannot be used afterwards.
+func (b *BytesBuilder) Finalize(info Info) error {
+	defer os.Remove(b.tmp.Name())
+	defer b.tmp.Close()
+
+	if len(b.chunk) > 0 {
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+	if _, err := b.tmp.Seek(0, io.SeekStart); err != nil {
+		return xerrors.Errorf("failed to rewind temporary file: %v", err)
+	}
+
+	return saveFile(b.path, nil, func(w io.Writer) error {
+		si := newSaveInfo(info)
+		si.EntriesLength = b.length
+		si.NumChunks = b.numChunks
+		if err := gob.NewEncoder(w).Encode(si); err != nil {
+			return xerrors.Errorf("failed to encode db info: %v", err)
+		}
+		// the chunks are gob-encoded byte slices, which do not need any type
+		// definition, so they can follow the header of another encoder
+		if _, err := io.Copy(w, b.tmp); err != nil {
+			return xerrors.Errorf("failed to copy chunks: %v", err)
+		}
+		return nil
+	})
+}
+
+func (b *BytesBuilder) flush() error {
+	data, err := encodeChunk(b.chunk)
+	if err != nil {
+		return xerrors.Errorf("failed to encode chunk %d: %v", b.numChunks, err)
+	}
+	if err := b.enc.Encode(data); err != nil {
+		return xerrors.Errorf("failed to write chunk %d: %v", b.numChunks, err)
+	}
+	b.length += len(b.chunk)
+	b.numChunks++
+	b.chunk = b.chunk[:0]
+
+	return nil
+}
@@ -1,8 +1,13 @@
 package database
 
 import (
+	"encoding/binary"
 	"io"
-	"log"
+	"math"
+	"math/bits"
+
+	"github.com/si-co/vpir-code/lib/utils"
+	"golang.org/x/xerrors"
 )
 
 type Bytes struct {
@@ -21,11 +26,45 @@ func CreateZeroBytes(numRows, numColumns, blockLen int) *Bytes {
 			NumColumns: numColumns,
 			NumRows:    numRows,
 			BlockSize:  blockLen,
+			PIRType:    PIRTypeClassical,
 			Merkle:     &Merkle{ProofLen: 0}, // only for tests compatibility
 		},
 	}
 }
 
+// IdentityIndexLength is the number of bytes of the index stored at the
+// start of every block of an identity database
+const IdentityIndexLength = 4
+
+// CreateIdentityBytes returns a database whose block i starts with its index
+// i, encoded as a big-endian uint32 as the index of a query, followed by
+// zeros. It is meant for debugging the index arithmetic of the schemes and
+// the layouts, since a block retrieved for index i must decode to i. It
+// returns an error if the dimensions are not valid or if the blocks are
+// shorter than IdentityIndexLength bytes.
+func CreateIdentityBytes(numRows, numColumns, blockLen int) (*Bytes, error) {
+	info := Info{NumRows: numRows, NumColumns: numColumns, BlockSize: blockLen}
+	if err := info.ValidateDimensions(); err != nil {
+		return nil, err
+	}
+	if blockLen < IdentityIndexLength || uint64(info.NumBlocks()) > math.MaxUint32+1 {
+		return nil, xerrors.Errorf("%d blocks of %d bytes, the blocks must hold the index in %d bytes",
+			info.NumBlocks(), blockLen, IdentityIndexLength)
+	}
+
+	entries := make([]byte, info.SizeBytes())
+	blockLens := make([]int, info.NumBlocks())
+	for i := range blockLens {
+		binary.BigEndian.PutUint32(entries[i*blockLen:], uint32(i))
+		blockLens[i] = blockLen
+	}
+	info.BlockLengths = blockLens
+	info.PIRType = PIRTypeClassical
+	info.Merkle = &Merkle{ProofLen: 0} // only for tests compatibility
+
+	return &Bytes{Entries: entries, Info: info}, nil
+}
+
 // InitBytes return an empty database with a initial zero capacity, to
 // be used when embedding keys into a bytes database.
 // blockLen must be the number of bytes in a block, as a byte is the element
@@ -39,21 +78,95 @@ func InitBytes(numRows, numColumns, blockLen int) *Bytes {
 			NumRows:      numRows,
 			BlockSize:    blockLen,
 			BlockLengths: make([]int, numRows*numColumns),
+			PIRType:      PIRTypeClassical,
 			Merkle:       &Merkle{ProofLen: 0}, // only for tests compatibility
 		},
 	}
 }
 
+// BytesFromBlocks returns a bytes database holding the given blocks, in
+// order, each with its own length. The block size is the length of the
+// longest block, so that no block is too long for the database. As for
+// GenerateRealKeyBytes, the entries only hold the bytes of the blocks, one
+// after the other, and the block lengths record where each block ends:
+// the servers pad the blocks with zeros when answering, and the client
+// cuts the reconstructed block at its length, so that a block is retrieved
+// exactly, even if it ends with zeros. The blocks after the given ones are
+// empty. The layout is rebalanced as by CalculateNumRowsAndColumns.
+func BytesFromBlocks(blocks [][]byte, rebalanced bool) (*Bytes, error) {
+	if len(blocks) == 0 {
+		return nil, xerrors.Errorf("no blocks: %w", ErrEmptyDB)
+	}
+	blockLen := 0
+	for _, b := range blocks {
+		blockLen = utils.Max(blockLen, len(b))
+	}
+	if blockLen == 0 {
+		return nil, xerrors.Errorf("%d empty blocks: %w", len(blocks), ErrEmptyDB)
+	}
+
+	numRows, numColumns := CalculateNumRowsAndColumns(len(blocks), rebalanced)
+	return packBlocks(blocks, numRows, numColumns, blockLen), nil
+}
+
+// packBlocks returns the bytes database of the given dimensions holding
+// the blocks, of at most blockLen bytes, packed by their lengths
+func packBlocks(blocks [][]byte, numRows, numColumns, blockLen int) *Bytes {
+	db := InitBytes(numRows, numColumns, blockLen)
+	for k, b := range blocks {
+		db.BlockLengths[k] = len(b)
+		db.Entries = append(db.Entries, b...)
+	}
+
+	return db
+}
+
+// BlockOffsets returns the index of the blocks of a database packed by its
+// block lengths, i.e., the position in the entries where every block
+// starts, followed by the length of the entries. Block k spans
+// Entries[offsets[k]:offsets[k+1]]. Without block lengths, every block is
+// BlockSize long.
+func (i *Info) BlockOffsets() []int {
+	offsets := make([]int, i.NumBlocks()+1)
+	for k := 0; k < i.NumBlocks(); k++ {
+		offsets[k+1] = offsets[k] + i.PayloadLength(k)
+	}
+
+	return offsets
+}
+
 // CreateRandomBytes return a random bytes database.
-// blockLen must be the number of bytes in a block, as a byte is the element
-func CreateRandomBytes(rnd io.Reader, dbLen, numRows, blockLen int) *Bytes {
+// blockLen must be the number of bytes in a block, as a byte is the element.
+// An error is returned if dbLen, specified in bits, does not fit exactly
+// numRows rows of blocks of blockLen bytes, since otherwise part of the
+// requested data would not be representable in the database. Use
+// RealizableBytesLength to align dbLen to the database geometry.
+//
+// The entries are the first dbLen/8 bytes read from rnd, in row-major order,
+// so that two calls with readers producing the same stream, e.g., PRGs with
+// the same key, return equal databases. The returned database has
+// NumRows*NumColumns*BlockSize == dbLen/8 and every block of BlockSize bytes.
+func CreateRandomBytes(rnd io.Reader, dbLen, numRows, blockLen int) (*Bytes, error) {
+	if numRows < 1 || blockLen < 1 || dbLen < 0 {
+		return nil, xerrors.Errorf("db of %d bits in %d rows of %d-byte blocks, "+
+			"the rows and the blocks must be positive and the length non-negative", dbLen, numRows, blockLen)
+	}
+	if dbLen == 0 {
+		return nil, xerrors.Errorf("db of 0 bits: %w", ErrEmptyDB)
+	}
+	if realizable := RealizableBytesLength(dbLen, numRows, blockLen); realizable != dbLen {
+		return nil, xerrors.Errorf("db length of %d bits does not fit %d rows of %d-byte blocks, "+
+			"closest realizable length is %d bits", dbLen, numRows, blockLen, realizable)
+	}
+
+	numColumns := dbLen / (8 * numRows * blockLen)
+
 	// sample random entries
 	entries := make([]byte, dbLen/8)
 	if _, err := rnd.Read(entries); err != nil {
-		log.Fatal(err)
+		return nil, xerrors.Errorf("failed to read random entries: %v", err)
 	}
 
-	numColumns := dbLen / (8 * numRows * blockLen)
 	blockLens := make([]int, numRows*numColumns)
 	for i := 0; i < numRows*numColumns; i++ {
 		blockLens[i] = blockLen
@@ -64,9 +177,39 @@ func CreateRandomBytes(rnd io.Reader, dbLen, numRows, blockLen int) *Bytes {
 			NumRows:      numRows,
 			BlockSize:    blockLen,
 			BlockLengths: blockLens,
+			PIRType:      PIRTypeClassical,
 			Merkle:       &Merkle{ProofLen: 0}, // only for tests compatibility
 		},
+	}, nil
+}
+
+// RealizableBytesLength returns the largest length in bits, not greater than
+// dbLen, that fits exactly numRows rows of blocks of blockLen bytes. It
+// returns 0 if no length fits, including when the bit length of a row
+// overflows an int.
+func RealizableBytesLength(dbLen, numRows, blockLen int) int {
+	rowBits, ok := mulInt(8, numRows, blockLen)
+	if !ok || rowBits == 0 {
+		return 0
+	}
+	return (dbLen / rowBits) * rowBits
+}
+
+// mulInt returns the product of the given non-negative factors and false if
+// the product overflows an int
+func mulInt(factors ...int) (int, bool) {
+	out := uint(1)
+	for _, f := range factors {
+		if f < 0 {
+			return 0, false
+		}
+		hi, lo := bits.Mul(out, uint(f))
+		if hi != 0 || lo > math.MaxInt {
+			return 0, false
+		}
+		out = lo
 	}
+	return int(out), true
 }
 
 func (b *Bytes) SizeGiB() float64 {
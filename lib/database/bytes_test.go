@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"math"
+	"math/bits"
+	"testing"
+
+	"github.com/si-co/vpir-code/lib/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateRandomBytesNonDivisibleLength(t *testing.T) {
+	numRows := 4
+	blockLen := 16
+	// one full row of blocks is 8*4*16 = 512 bits
+	dbLen := 10*512 + 100
+
+	_, err := CreateRandomBytes(utils.RandomPRG(), dbLen, numRows, blockLen)
+	require.Error(t, err)
+
+	realizable := RealizableBytesLength(dbLen, numRows, blockLen)
+	require.Equal(t, 10*512, realizable)
+
+	db, err := CreateRandomBytes(utils.RandomPRG(), realizable, numRows, blockLen)
+	require.NoError(t, err)
+	require.Equal(t, realizable/8, len(db.Entries))
+	require.Equal(t, realizable/8, db.NumRows*db.NumColumns*db.BlockSize)
+}
+
+func TestCreateRandomBytesDeterministic(t *testing.T) {
+	numRows, blockLen := 8, 16
+	dbLen := 8 * numRows * blockLen * 32
+	key := utils.RandomPRGKey()
+
+	a, err := CreateRandomBytes(utils.NewPRG(key), dbLen, numRows, blockLen)
+	require.NoError(t, err)
+	b, err := CreateRandomBytes(utils.NewPRG(key), dbLen, numRows, blockLen)
+	require.NoError(t, err)
+	require.True(t, EqualBytes(a, b))
+
+	require.Equal(t, numRows, a.NumRows)
+	require.Equal(t, 32, a.NumColumns)
+	require.Equal(t, blockLen, a.BlockSize)
+	require.Equal(t, dbLen/8, a.NumRows*a.NumColumns*a.BlockSize)
+	require.Len(t, a.BlockLengths, a.NumRows*a.NumColumns)
+	for _, l := range a.BlockLengths {
+		require.Equal(t, blockLen, l)
+	}
+
+	// the entries are the stream of the reader
+	stream := make([]byte, dbLen/8)
+	_, err = utils.NewPRG(key).Read(stream)
+	require.NoError(t, err)
+	require.Equal(t, stream, a.Entries)
+
+	c, err := CreateRandomBytes(utils.RandomPRG(), dbLen, numRows, blockLen)
+	require.NoError(t, err)
+	require.False(t, EqualBytes(a, c))
+}
+
+func TestRealizableBytesLengthOverflow(t *testing.T) {
+	// 2^16 rows of 2^16-byte blocks, i.e., 2^35 bits per row, overflow
+	// 32-bit arithmetic but fit 64-bit ints
+	if bits.UintSize == 64 {
+		numRows, blockLen := 1<<16, 1<<16
+		// shifted at run time, since the constant does not fit 32-bit ints
+		dbLen := 3
+		dbLen <<= 35
+		require.Equal(t, dbLen, RealizableBytesLength(dbLen, numRows, blockLen))
+	}
+
+	// the bit length of a row overflows any int
+	numRows, blockLen := math.MaxInt/4, 8
+	require.Equal(t, 0, RealizableBytesLength(math.MaxInt, numRows, blockLen))
+	_, err := CreateRandomBytes(utils.RandomPRG(), math.MaxInt, numRows, blockLen)
+	require.Error(t, err)
+}
+
+func TestEmbedRecords(t *testing.T) {
+	blockLen := 16
+	// empty, shorter than a block, exactly one and two blocks with the
+	// prefix, and spanning several blocks
+	lengths := []int{0, 5, blockLen - RecordLengthPrefix, 2*blockLen - RecordLengthPrefix, 77}
+	records := make([][]byte, len(lengths))
+	for i, l := range lengths {
+		records[i] = make([]byte, l)
+		_, err := utils.RandomPRG().Read(records[i])
+		require.NoError(t, err)
+	}
+
+	db, spans, err := EmbedRecords(records, blockLen, true)
+	require.NoError(t, err)
+	require.Len(t, spans, len(records))
+	for i, s := range spans {
+		require.Equal(t, (RecordLengthPrefix+lengths[i]+blockLen-1)/blockLen, s.NumBlocks)
+		start, end := 0, 0
+		for b := 0; b < s.StartBlock+s.NumBlocks; b++ {
+			if b == s.StartBlock {
+				start = end
+			}
+			end += db.BlockLengths[b]
+		}
+		r, err := ExtractRecord(db.Entries[start:end])
+		require.NoError(t, err)
+		require.Equal(t, records[i], r)
+	}
+
+	_, err = ExtractRecord([]byte{0, 0, 1, 0, 1, 2})
+	require.Error(t, err)
+	_, err = ExtractRecord([]byte{0, 0})
+	require.Error(t, err)
+	_, _, err = EmbedRecords(records, 0, true)
+	require.Error(t, err)
+}
+
+func TestBuildFromRecords(t *testing.T) {
+	records := [][]byte{{}, []byte("a record"), make([]byte, 100)}
+	ch := make(chan []byte, len(records))
+	for _, r := range records {
+		ch <- r
+	}
+	close(ch)
+
+	db, spans, err := BuildFromRecords(context.Background(), ch, 16, true)
+	require.NoError(t, err)
+	expected, expectedSpans, err := EmbedRecords(records, 16, true)
+	require.NoError(t, err)
+	require.Equal(t, expected.Entries, db.Entries)
+	require.Equal(t, expected.Info, db.Info)
+	require.Equal(t, expectedSpans, spans)
+
+	empty := make(chan []byte)
+	close(empty)
+	_, _, err = BuildFromRecords(context.Background(), empty, 16, true)
+	require.Error(t, err)
+}
+
+func TestEmptyDB(t *testing.T) {
+	_, err := CreateRandomBytes(utils.RandomPRG(), 0, 1, 16)
+	require.ErrorIs(t, err, ErrEmptyDB)
+	_, err = CreateRandomBitsDB(utils.RandomPRG(), 0, 1, 16)
+	require.ErrorIs(t, err, ErrEmptyDB)
+	_, err = CreateRandomBinaryLWE(utils.RandomPRG(), 0, 0)
+	require.ErrorIs(t, err, ErrEmptyDB)
+	_, err = CreateRandomBinaryLWEWithLength128(utils.RandomPRG(), 0)
+	require.ErrorIs(t, err, ErrEmptyDB)
+
+	// a single element is a 1x1 db in both layouts
+	for _, rebalanced := range []bool{false, true} {
+		numRows, numColumns := CalculateNumRowsAndColumns(1, rebalanced)
+		require.Equal(t, 1, numRows)
+		require.Equal(t, 1, numColumns)
+	}
+	db, err := CreateRandomBitsDB(utils.RandomPRG(), 1, 1, 16)
+	require.NoError(t, err)
+	require.Equal(t, 1, db.NumBlocks())
+}
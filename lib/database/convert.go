@@ -0,0 +1,66 @@
+package database
+
+import (
+	"encoding/binary"
+
+	"github.com/si-co/vpir-code/lib/field"
+	"github.com/si-co/vpir-code/lib/utils"
+	"golang.org/x/xerrors"
+)
+
+// BytesFromDB returns the bytes database holding the entries of d, each
+// element encoded in field.Bytes big-endian bytes as by
+// field.Element.Bytes. The dimensions are the same, and the block size and
+// the block lengths, in elements in d, are converted to bytes. The keys
+// information of d is not kept, so that a keys db becomes a classical one.
+func BytesFromDB(d *DB) *Bytes {
+	info := d.Info
+	if info.PIRType == PIRTypePredicate {
+		info.PIRType = PIRTypeClassical
+	}
+	info.BlockSize = d.BlockSize * field.Bytes
+	if d.BlockLengths != nil {
+		info.BlockLengths = make([]int, len(d.BlockLengths))
+		for i, l := range d.BlockLengths {
+			info.BlockLengths[i] = l * field.Bytes
+		}
+	}
+
+	return &Bytes{Entries: utils.Uint32SliceToByteSlice(d.Entries), Info: info}
+}
+
+// DBFromBytes returns the database of field elements whose encoding, as by
+// BytesFromDB, is the entries of b. It returns an error if the entries, the
+// block size or the block lengths of b are not a whole number of elements,
+// or if an element is not smaller than field.ModP, since the values of the
+// entries are never changed.
+func DBFromBytes(b *Bytes) (*DB, error) {
+	if len(b.Entries)%field.Bytes != 0 || b.BlockSize%field.Bytes != 0 {
+		return nil, xerrors.Errorf("entries of %d bytes in blocks of %d bytes are not a whole number of %d-byte elements",
+			len(b.Entries), b.BlockSize, field.Bytes)
+	}
+
+	info := b.Info
+	info.BlockSize = b.BlockSize / field.Bytes
+	if b.BlockLengths != nil {
+		info.BlockLengths = make([]int, len(b.BlockLengths))
+		for i, l := range b.BlockLengths {
+			if l%field.Bytes != 0 {
+				return nil, xerrors.Errorf("block %d of %d bytes is not a whole number of %d-byte elements",
+					i, l, field.Bytes)
+			}
+			info.BlockLengths[i] = l / field.Bytes
+		}
+	}
+
+	entries := make([]uint32, len(b.Entries)/field.Bytes)
+	for i := range entries {
+		entries[i] = binary.BigEndian.Uint32(b.Entries[i*field.Bytes:])
+		if entries[i] >= field.ModP {
+			return nil, xerrors.Errorf("entry %d of value %d is not smaller than the modulus %d",
+				i, entries[i], field.ModP)
+		}
+	}
+
+	return &DB{Entries: entries, Info: info}, nil
+}
@@ -1,7 +1,9 @@
 package database
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/aes"
 	"encoding/binary"
 	"io"
 	"math"
@@ -32,17 +34,171 @@ type KeyInfo struct {
 	BitLength    uint16
 }
 
+// ErrEmptyDB is wrapped by the errors returned for databases of length
+// zero. A database of a single element, i.e., a block or a bit, is valid
+// and has a single row and a single column in both layouts.
+var ErrEmptyDB = xerrors.New("empty db")
+
+// SingleBitBlockLength is the block size of the databases of the
+// single-server schemes, where every entry is a single bit. The block size
+// of all the other databases is a length, so that a block size of 1 denotes
+// a one-byte (multi-bit) block.
+const SingleBitBlockLength = 0
+
+// PIRType is the scheme a database is built for, reported by the servers so
+// that the clients can check that they run the same scheme
+type PIRType string
+
+const (
+	// PIRTypeClassical is the type of the bytes databases of the classical
+	// IT scheme. The databases saved without a type are classical.
+	PIRTypeClassical PIRType = "classical"
+	// PIRTypeMerkle is the type of the bytes databases whose blocks embed a
+	// Merkle proof
+	PIRTypeMerkle PIRType = "merkle"
+	// PIRTypePredicate is the type of the keys databases of the FSS-based
+	// predicate schemes
+	PIRTypePredicate PIRType = "predicate"
+	// PIRTypeDH, PIRTypeLWE and PIRTypeLWE128 are the types of the
+	// single-bit databases of the single-server schemes
+	PIRTypeDH     PIRType = "dh"
+	PIRTypeLWE    PIRType = "lwe"
+	PIRTypeLWE128 PIRType = "lwe128"
+)
+
 type Info struct {
 	NumRows      int
 	NumColumns   int
 	BlockSize    int
 	BlockLengths []int // length of data in blocks defined in number of elements
 
-	// PIR type: classical, merkle
-	PIRType string
+	// PIRType is the scheme the database is built for
+	PIRType PIRType
 
 	*Auth
 	*Merkle
+	*BlockHashes
+
+	// ShardRange is set if the database is a shard of a larger logical
+	// database
+	ShardRange *ShardRange
+}
+
+// IsSingleBit returns true if every entry of the database is a single bit.
+// It is only meaningful for the point-query databases, since the FSS-based
+// databases do not define a block size.
+func (i *Info) IsSingleBit() bool {
+	return i.BlockSize == SingleBitBlockLength
+}
+
+// ValidateDimensions returns an error if i does not describe a database of
+// at least one row and one column, if its block size is negative or if the
+// size of its entries overflows an int. A block size of zero is valid, since
+// it is the SingleBitBlockLength of single-bit databases. BlockLengths, if
+// set, must have a length for every block, at most the block size.
+func (i *Info) ValidateDimensions() error {
+	switch {
+	case i.NumRows < 1 || i.NumColumns < 1:
+		return xerrors.Errorf("db of %d rows and %d columns, both must be positive",
+			i.NumRows, i.NumColumns)
+	case i.BlockSize < 0:
+		return xerrors.Errorf("negative block size %d", i.BlockSize)
+	}
+	if _, ok := mulInt(i.NumRows, i.NumColumns, i.BlockSize); !ok {
+		return xerrors.Errorf("db of %d rows, %d columns and blocks of size %d overflows",
+			i.NumRows, i.NumColumns, i.BlockSize)
+	}
+	if i.BlockLengths == nil {
+		return nil
+	}
+	if len(i.BlockLengths) != i.NumBlocks() {
+		return xerrors.Errorf("%d block lengths for a db of %d blocks",
+			len(i.BlockLengths), i.NumBlocks())
+	}
+	for j, l := range i.BlockLengths {
+		if l < 0 || l > i.BlockSize {
+			return xerrors.Errorf("length %d of block %d, expected between 0 and the block size %d",
+				l, j, i.BlockSize)
+		}
+	}
+
+	return nil
+}
+
+// NumBlocks returns the number of blocks in the database, i.e., the number
+// of entries of a single-bit database
+func (i *Info) NumBlocks() int {
+	return i.NumRows * i.NumColumns
+}
+
+// QueryVectorBytes returns the length in bytes of the query vector of the
+// classical PIR schemes for the database, one bit per column. It only
+// depends on the dimensions of the database, in either layout, so that the
+// clients and the servers of a database of any size agree on it.
+func (i *Info) QueryVectorBytes() int {
+	return i.NumColumns/8 + 1
+}
+
+// PayloadLength returns the length of the data stored in the given block,
+// which can be shorter than the block size, e.g., for the blocks of the
+// keys embedded by GenerateRealKeyBytes. It is the length recorded in
+// BlockLengths, or the block size if the lengths are not known.
+func (i *Info) PayloadLength(block int) int {
+	if len(i.BlockLengths) != i.NumBlocks() || block < 0 || block >= len(i.BlockLengths) {
+		return i.BlockSize
+	}
+	return i.BlockLengths[block]
+}
+
+// SizeBytes returns the size in bytes of the entries of a bytes database,
+// whose blocks are BlockSize bytes long. The bits of a single-bit database
+// are counted as packed into bytes.
+func (i *Info) SizeBytes() int {
+	if i.IsSingleBit() {
+		return (i.NumBlocks() + 7) / 8
+	}
+	return i.NumBlocks() * i.BlockSize
+}
+
+// Occupancy returns the number of occupied blocks of d, i.e., holding at
+// least a non-zero element, and the number of blocks, e.g., to choose the
+// dimensions of a db that is partially filled. The blocks of zeros count
+// as empty, as the empty slots of a hash table do. Block k starts at
+// k*BlockSize, and only its payload of PayloadLength(k) elements is read.
+func Occupancy(d *DB) (occupied, total int) {
+	for k := 0; k < d.NumBlocks(); k++ {
+		pos := k * d.BlockSize
+		for _, e := range d.Entries[pos : pos+d.PayloadLength(k)] {
+			if e != 0 {
+				occupied++
+				break
+			}
+		}
+	}
+
+	return occupied, d.NumBlocks()
+}
+
+// OccupancyBytes is Occupancy for a bytes database, whose blocks are packed
+// by their lengths if it has block lengths, e.g., the empty slots of the
+// hash table of GenerateRealKeyBytes have no bytes at all
+func OccupancyBytes(b *Bytes) (occupied, total int) {
+	pos := 0
+	for k := 0; k < b.NumBlocks(); k++ {
+		l := b.BlockSize
+		if b.BlockLengths != nil {
+			l = b.BlockLengths[k]
+		}
+		for _, e := range b.Entries[pos : pos+l] {
+			if e != 0 {
+				occupied++
+				break
+			}
+		}
+		pos += l
+	}
+
+	return occupied, b.NumBlocks()
 }
 
 // Auth is authentication information for the single-server setting
@@ -72,6 +228,27 @@ type Merkle struct {
 	ProofLen int
 }
 
+// CheckMerkleRoots returns an error if the dbs described by info, held by
+// the servers of the given names, have different Merkle roots. The servers
+// then hold inconsistent dbs, which would otherwise only be detected when
+// the proof of a block fails. An info without Merkle info has no root.
+func CheckMerkleRoots(info []*Info, servers []string) error {
+	root := func(i *Info) []byte {
+		if i.Merkle == nil {
+			return nil
+		}
+		return i.Root
+	}
+	for i := range info {
+		if !bytes.Equal(root(info[0]), root(info[i])) {
+			return xerrors.Errorf("servers %s and %s have different Merkle roots %x and %x, they hold inconsistent dbs",
+				servers[0], servers[i], root(info[0]), root(info[i]))
+		}
+	}
+
+	return nil
+}
+
 func NewKeysDB(info Info) *DB {
 	return &DB{
 		Info:     info,
@@ -80,14 +257,86 @@ func NewKeysDB(info Info) *DB {
 	}
 }
 
-func NewBitsDB(info Info) *DB {
+// NewBitsDB returns a db of zero entries with the given info. It returns an
+// error if the dimensions in info are not valid.
+func NewBitsDB(info Info) (*DB, error) {
+	if err := info.ValidateDimensions(); err != nil {
+		return nil, err
+	}
 	return &DB{
 		Info:    info,
-		Entries: make([]uint32, info.NumRows*info.NumColumns*info.BlockSize),
-	}
+		Entries: make([]uint32, info.NumBlocks()*info.BlockSize),
+	}, nil
 }
 
 func CreateRandomBitsDB(rnd io.Reader, dbLen, numRows, blockLen int) (*DB, error) {
+	db, err := newRandomBitsDB(dbLen, numRows, blockLen)
+	if err != nil {
+		return nil, err
+	}
+
+	numBytesToRead := len(db.Entries) * field.Bytes
+	randBytes := make([]byte, numBytesToRead)
+	if _, err := io.ReadFull(rnd, randBytes[:]); err != nil {
+		return nil, xerrors.Errorf("failed to read random randBytes: %v", err)
+	}
+	field.BytesToElements(db.Entries, randBytes)
+
+	return db, nil
+}
+
+// CreateRandomBitsDBChunked returns the same db as CreateRandomBitsDB for
+// the same reader, but reads the random bytes in chunks of at most
+// chunkLength bytes and converts them to elements as they are read. The
+// memory needed besides the db is a single chunk, instead of as many bytes
+// as the whole db.
+func CreateRandomBitsDBChunked(rnd io.Reader, dbLen, numRows, blockLen int) (*DB, error) {
+	db, err := newRandomBitsDB(dbLen, numRows, blockLen)
+	if err != nil {
+		return nil, err
+	}
+
+	// whole elements in every chunk, and at least an AES block: the PRG
+	// discards the rest of the block on shorter reads, which would give
+	// different bytes than a single read
+	chunkElements := chunkLength / field.Bytes
+	if chunkElements < aes.BlockSize/field.Bytes {
+		chunkElements = aes.BlockSize / field.Bytes
+	}
+	if chunkElements > len(db.Entries) {
+		chunkElements = len(db.Entries)
+	}
+	randBytes := make([]byte, chunkElements*field.Bytes)
+	for start := 0; start < len(db.Entries); start += chunkElements {
+		end := start + chunkElements
+		if end > len(db.Entries) {
+			end = len(db.Entries)
+		}
+		// the PRG XORs its stream into the buffer, which must be zero as
+		// in a single read
+		chunk := randBytes[:(end-start)*field.Bytes]
+		for i := range chunk {
+			chunk[i] = 0
+		}
+		if _, err := io.ReadFull(rnd, chunk); err != nil {
+			return nil, xerrors.Errorf("failed to read random randBytes: %v", err)
+		}
+		field.BytesToElements(db.Entries[start:end], chunk)
+	}
+
+	return db, nil
+}
+
+// newRandomBitsDB returns the zero db of the size of the random dbs of length
+// dbLen bits, with numRows rows and blocks of blockLen elements
+func newRandomBitsDB(dbLen, numRows, blockLen int) (*DB, error) {
+	if numRows < 1 || blockLen < 1 {
+		return nil, xerrors.Errorf("db of %d rows of %d-element blocks, both must be positive",
+			numRows, blockLen)
+	}
+	if dbLen < 1 {
+		return nil, xerrors.Errorf("db of %d bits: %w", dbLen, ErrEmptyDB)
+	}
 	numColumns := dbLen / (8 * field.Bytes * numRows * blockLen)
 	// handle very small db
 	if numColumns == 0 {
@@ -99,27 +348,27 @@ func CreateRandomBitsDB(rnd io.Reader, dbLen, numRows, blockLen int) (*DB, error
 		NumRows:    numRows,
 		BlockSize:  blockLen,
 	}
-
-	n := numRows * numColumns * blockLen
-
-	numBytesToRead := n*field.Bytes + 1
-	randBytes := make([]byte, numBytesToRead)
-	if _, err := io.ReadFull(rnd, randBytes[:]); err != nil {
-		return nil, xerrors.Errorf("failed to read random randBytes: %v", err)
+	db, err := NewBitsDB(info)
+	if err != nil {
+		return nil, err
 	}
 
-	db := NewBitsDB(info)
-	field.BytesToElements(db.Entries, randBytes)
-
 	// add block lengths also in this case for compatibility
-	db.BlockLengths = make([]int, numRows*numColumns)
-	for i := 0; i < n; i++ {
-		db.BlockLengths[i/blockLen] = blockLen
+	db.BlockLengths = make([]int, info.NumBlocks())
+	for i := range db.BlockLengths {
+		db.BlockLengths[i] = blockLen
 	}
 
 	return db, nil
 }
 
+// CreateDeterministic returns a db like CreateRandomBitsDB, with entries read
+// from the PRG seeded with key. The same key and parameters always give the
+// same db, e.g., to compare benchmarks run on different machines.
+func CreateDeterministic(key *utils.PRGKey, dbLen, numRows, blockLen int) (*DB, error) {
+	return CreateRandomBitsDB(utils.NewPRG(key), dbLen, numRows, blockLen)
+}
+
 func CreateRandomKeysDB(rnd io.Reader, numIdentifiers int) (*DB, error) {
 	// only used for eval, so fine to init the seed for
 	// non-crypto PRG with fixed number
@@ -149,7 +398,7 @@ func CreateRandomKeysDB(rnd io.Reader, numIdentifiers int) (*DB, error) {
 	}
 
 	// only information needed for FSS-based schemes
-	info := Info{NumColumns: numIdentifiers}
+	info := Info{NumColumns: numIdentifiers, PIRType: PIRTypePredicate}
 
 	return &DB{
 		KeysInfo: keysInfo,
@@ -158,12 +407,48 @@ func CreateRandomKeysDB(rnd io.Reader, numIdentifiers int) (*DB, error) {
 }
 
 // HashToIndex hashes the given id to an index for a database of the given
-// length
+// length. Different ids can be hashed to the same index: the key databases
+// resolve these collisions by storing all the colliding keys in the same
+// block, see makeHashTable.
 func HashToIndex(id string, length int) uint32 {
 	hash := blake2b.Sum256([]byte(id))
 	return binary.BigEndian.Uint32(hash[:4]) % uint32(length)
 }
 
+// Layouts of the point-query databases. In the vector layout all the blocks
+// are in a single row, while in the matrix (rebalanced) layout they are
+// arranged in a square. Both layouts are supported by the classical and
+// Merkle-based PIR schemes; the FSS-based schemes do not have a layout.
+const (
+	LayoutVector = "vector"
+	LayoutMatrix = "matrix"
+)
+
+// ParseLayout returns whether the given layout is rebalanced, i.e., the
+// matrix one
+func ParseLayout(layout string) (rebalanced bool, err error) {
+	switch layout {
+	case LayoutVector:
+		return false, nil
+	case LayoutMatrix:
+		return true, nil
+	default:
+		return false, xerrors.Errorf("unknown layout: %s", layout)
+	}
+}
+
+// Layout returns the layout of the database described by i
+func (i *Info) Layout() string {
+	if i.NumRows == 1 {
+		return LayoutVector
+	}
+	return LayoutMatrix
+}
+
+// CalculateNumRowsAndColumns returns the dimensions of a database of
+// numBlocks blocks, in the matrix layout if matrix is true and in the
+// vector layout otherwise. A single block gives a 1x1 database in both
+// layouts, and no block gives no rows nor columns.
 func CalculateNumRowsAndColumns(numBlocks int, matrix bool) (numRows, numColumns int) {
 	if matrix {
 		utils.IncreaseToNextSquare(&numBlocks)
@@ -177,5 +462,5 @@ func CalculateNumRowsAndColumns(numBlocks int, matrix bool) (numRows, numColumns
 }
 
 func (d *DB) SizeGiB() float64 {
-	return float64(len(d.Entries)*16) * 9.313e-10
+	return float64(len(d.Entries)*field.Bytes) * 9.313e-10
 }
@@ -0,0 +1,357 @@
+package database
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"testing/iotest"
+
+	"github.com/cloudflare/circl/group"
+	"github.com/si-co/vpir-code/lib/field"
+	"github.com/si-co/vpir-code/lib/pgp"
+	"github.com/si-co/vpir-code/lib/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsSingleBit(t *testing.T) {
+	elliptic, err := CreateRandomEllipticWithDigest(utils.RandomPRG(), 64, group.P256, true)
+	require.NoError(t, err)
+	require.True(t, elliptic.IsSingleBit())
+
+	lwe, err := CreateRandomBinaryLWE(utils.RandomPRG(), 8, 8)
+	require.NoError(t, err)
+	require.True(t, lwe.IsSingleBit())
+
+	lwe128, err := CreateRandomBinaryLWE128(utils.RandomPRG(), 8, 8)
+	require.NoError(t, err)
+	require.True(t, lwe128.IsSingleBit())
+
+	// a block of one byte is a multi-bit block
+	b, err := CreateRandomBytes(utils.RandomPRG(), 8*4*4, 4, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, b.BlockSize)
+	require.False(t, b.IsSingleBit())
+}
+
+func TestInfoSize(t *testing.T) {
+	// single-bit, the 36 bits are packed into 5 bytes
+	singleBit := &Info{NumRows: 6, NumColumns: 6, BlockSize: SingleBitBlockLength}
+	require.True(t, singleBit.IsSingleBit())
+	require.Equal(t, 36, singleBit.NumBlocks())
+	require.Equal(t, 5, singleBit.SizeBytes())
+
+	// vector
+	vector, err := CreateRandomBytes(utils.RandomPRG(), 8*64*16, 1, 16)
+	require.NoError(t, err)
+	require.False(t, vector.IsSingleBit())
+	require.Equal(t, 64, vector.NumBlocks())
+	require.Equal(t, len(vector.Entries), vector.SizeBytes())
+
+	// matrix
+	matrix, err := CreateRandomBytes(utils.RandomPRG(), 8*64*16, 8, 16)
+	require.NoError(t, err)
+	require.Equal(t, 64, matrix.NumBlocks())
+	require.Equal(t, len(matrix.Entries), matrix.SizeBytes())
+}
+
+func TestHashToIndexCollisions(t *testing.T) {
+	// 20 keys in a hash table of 2 blocks, so that some of them collide
+	keys := make([]*pgp.Key, 20)
+	path := filepath.Join(t.TempDir(), "sks-000.pgp")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	enc := gob.NewEncoder(f)
+	for i := range keys {
+		keys[i] = &pgp.Key{ID: fmt.Sprintf("user%d@example.com", i), Packet: []byte(fmt.Sprintf("key%03d", i))}
+		require.NoError(t, enc.Encode(keys[i]))
+	}
+	require.NoError(t, f.Close())
+
+	db, err := GenerateRealKeyBytes([]string{path}, false)
+	require.NoError(t, err)
+	numBlocks := db.NumRows * db.NumColumns
+	require.Equal(t, 2, numBlocks)
+
+	// the colliding keys are stored in the same block, so every key is
+	// retrieved with the block of its id
+	for _, key := range keys {
+		k := int(HashToIndex(key.ID, numBlocks))
+		pos := 0
+		for _, bl := range db.BlockLengths[:k] {
+			pos += bl
+		}
+		block := UnPadBlock(db.Entries[pos : pos+db.BlockLengths[k]])
+		require.True(t, bytes.Contains(block, key.Packet), key.ID)
+	}
+}
+
+func TestCreateDeterministic(t *testing.T) {
+	key := new(utils.PRGKey)
+	copy(key[:], "deterministic db")
+
+	a, err := CreateDeterministic(key, 8*4*16*16, 4, 16)
+	require.NoError(t, err)
+	b, err := CreateDeterministic(key, 8*4*16*16, 4, 16)
+	require.NoError(t, err)
+	require.True(t, Equal(a, b), "%v", Diff(a, b))
+
+	other := new(utils.PRGKey)
+	copy(other[:], "another db seed!")
+	c, err := CreateDeterministic(other, 8*4*16*16, 4, 16)
+	require.NoError(t, err)
+	require.False(t, Equal(a, c))
+}
+
+func TestCreateRandomBitsDBChunked(t *testing.T) {
+	defer func(l int) { chunkLength = l }(chunkLength)
+	key := new(utils.PRGKey)
+	copy(key[:], "deterministic db")
+
+	expected, err := CreateRandomBitsDB(utils.NewPRG(key), 8*4*16*16, 4, 16)
+	require.NoError(t, err)
+
+	// chunks that do not divide the db, shorter than an AES block and the
+	// whole db
+	for _, l := range []int{20, 21, 1, 1 << 24} {
+		chunkLength = l
+		db, err := CreateRandomBitsDBChunked(utils.NewPRG(key), 8*4*16*16, 4, 16)
+		require.NoError(t, err)
+		require.True(t, Equal(expected, db), "chunk length %d: %v", l, Diff(expected, db))
+	}
+
+	// a reader that runs out of bytes is an error
+	_, err = CreateRandomBitsDBChunked(bytes.NewReader(make([]byte, 10)), 8*4*16*16, 4, 16)
+	require.Error(t, err)
+}
+
+func TestBytesFromDB(t *testing.T) {
+	d, err := CreateRandomBitsDB(utils.RandomPRG(), 8*4*16*16, 4, 16)
+	require.NoError(t, err)
+
+	b := BytesFromDB(d)
+	require.Equal(t, d.NumRows, b.NumRows)
+	require.Equal(t, d.NumColumns, b.NumColumns)
+	require.Equal(t, d.BlockSize*field.Bytes, b.BlockSize)
+	require.Len(t, b.Entries, len(d.Entries)*field.Bytes)
+	e := field.Element(d.Entries[5])
+	require.Equal(t, e.Bytes(), b.Entries[5*field.Bytes:6*field.Bytes])
+
+	back, err := DBFromBytes(b)
+	require.NoError(t, err)
+	require.Nil(t, Diff(d, back))
+
+	// only whole canonical elements are converted
+	b.Entries[0] = 0xff
+	_, err = DBFromBytes(b)
+	require.Error(t, err)
+	odd, err := CreateRandomBytes(utils.RandomPRG(), 8*4*16*6, 4, 6)
+	require.NoError(t, err)
+	_, err = DBFromBytes(odd)
+	require.Error(t, err)
+
+	// the bytes of a keys db are not a keys db
+	keys, err := CreateRandomKeysDB(utils.RandomPRG(), 10)
+	require.NoError(t, err)
+	require.Equal(t, PIRTypeClassical, BytesFromDB(keys).PIRType)
+}
+
+func BenchmarkCreateRandomBitsDB(b *testing.B) {
+	benchmarkCreateRandomBitsDB(b, CreateRandomBitsDB)
+}
+
+func BenchmarkCreateRandomBitsDBChunked(b *testing.B) {
+	defer func(l int) { chunkLength = l }(chunkLength)
+	chunkLength = 1 << 16
+	benchmarkCreateRandomBitsDB(b, CreateRandomBitsDBChunked)
+}
+
+func benchmarkCreateRandomBitsDB(b *testing.B, create func(io.Reader, int, int, int) (*DB, error)) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := create(utils.RandomPRG(), 1<<26, 64, 16); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestValidateDimensions(t *testing.T) {
+	valid := []Info{
+		{NumRows: 4, NumColumns: 8, BlockSize: 16},
+		{NumRows: 1, NumColumns: 8, BlockSize: SingleBitBlockLength},
+		{NumRows: 2, NumColumns: 2, BlockSize: 4, BlockLengths: []int{4, 4, 0, 2}},
+	}
+	for _, info := range valid {
+		require.NoError(t, info.ValidateDimensions(), "%+v", info)
+	}
+
+	invalid := []Info{
+		{NumRows: -1, NumColumns: 8, BlockSize: 16},
+		{NumRows: 4, NumColumns: 0, BlockSize: 16},
+		{NumRows: 4, NumColumns: 8, BlockSize: -16},
+		{NumRows: math.MaxInt/2 + 1, NumColumns: 2, BlockSize: 1},
+		{NumRows: 2, NumColumns: 2, BlockSize: 4, BlockLengths: []int{4, 4, 4}},
+		{NumRows: 2, NumColumns: 2, BlockSize: 4, BlockLengths: []int{4, 4, -1, 4}},
+	}
+	for _, info := range invalid {
+		require.Error(t, info.ValidateDimensions(), "%+v", info)
+		_, err := NewBitsDB(info)
+		require.Error(t, err, "%+v", info)
+	}
+
+	// the creation functions reject the dimensions before using them
+	_, err := CreateRandomBitsDB(utils.RandomPRG(), 1024, 0, 16)
+	require.Error(t, err)
+	_, err = CreateRandomBitsDBChunked(utils.RandomPRG(), 1024, 4, -16)
+	require.Error(t, err)
+	_, err = CreateRandomBytes(utils.RandomPRG(), 8*4*16, -4, -16)
+	require.Error(t, err)
+	_, err = CreateRandomBytes(utils.RandomPRG(), -8*4*16, 4, 16)
+	require.Error(t, err)
+}
+
+func TestComputeAuthDigest(t *testing.T) {
+	db, err := CreateRandomEllipticWithDigest(utils.RandomPRG(), 64*64, group.P256, true)
+	require.NoError(t, err)
+
+	// the digest is reproducible from the entries
+	e := &Elliptic{Entries: append([]byte{}, db.Entries...), Info: Info{NumRows: db.NumRows, NumColumns: db.NumColumns}}
+	digest, err := ComputeAuthDigest(e, group.P256, crypto.BLAKE2b_256)
+	require.NoError(t, err)
+	require.Equal(t, db.Digest, digest)
+	require.Equal(t, db.SubDigests, e.SubDigests)
+	require.Equal(t, db.ElementSize, e.ElementSize)
+
+	// flipping a bit changes the digest
+	e.Entries[len(e.Entries)/2] ^= 1
+	tampered, err := ComputeAuthDigest(e, group.P256, crypto.BLAKE2b_256)
+	require.NoError(t, err)
+	require.NotEqual(t, digest, tampered)
+
+	e.Entries = e.Entries[1:]
+	_, err = ComputeAuthDigest(e, group.P256, crypto.BLAKE2b_256)
+	require.Error(t, err)
+
+	// a db without rows has no digest
+	_, err = ComputeAuthDigest(&Elliptic{Info: Info{NumColumns: 8}}, group.P256, crypto.BLAKE2b_256)
+	require.ErrorIs(t, err, ErrEmptyDB)
+}
+
+func TestComputeAuthDigestCores(t *testing.T) {
+	db, err := CreateRandomEllipticWithDigest(utils.RandomPRG(), 37*29, group.P256, false)
+	require.NoError(t, err)
+	e := &Elliptic{Entries: db.Entries, Info: Info{NumRows: 37, NumColumns: 29}}
+
+	serial, err := ComputeAuthDigest(e, group.P256, crypto.BLAKE2b_256, 1)
+	require.NoError(t, err)
+	subDigests := e.SubDigests
+
+	// the digest of a row is the sum of the hashes of its set columns
+	row := 5
+	expected := group.P256.Identity()
+	for j := 0; j < e.NumColumns; j++ {
+		if e.Entries[row*e.NumColumns+j] == 1 {
+			expected.Add(expected, HashIndexToGroup(uint64(j), group.P256))
+		}
+	}
+	encoded, err := expected.MarshalBinaryCompress()
+	require.NoError(t, err)
+	require.Equal(t, encoded, subDigests[row*e.ElementSize:(row+1)*e.ElementSize])
+
+	// the digests do not depend on the number of cores, also when there are
+	// more cores than rows or columns
+	for _, cores := range []int{2, 3, 8, 64} {
+		digest, err := ComputeAuthDigest(e, group.P256, crypto.BLAKE2b_256, cores)
+		require.NoError(t, err)
+		require.Equal(t, serial, digest, "%d cores", cores)
+		require.Equal(t, subDigests, e.SubDigests, "%d cores", cores)
+	}
+}
+
+func BenchmarkComputeAuthDigest(b *testing.B) {
+	// 10k rows of 16 columns
+	numRows, numColumns := 10000, 16
+	data := make([]byte, numRows*numColumns)
+	_, err := io.ReadFull(utils.RandomPRG(), data)
+	require.NoError(b, err)
+	for i := range data {
+		data[i] &= 1
+	}
+	e := &Elliptic{Entries: data, Info: Info{NumRows: numRows, NumColumns: numColumns}}
+
+	for name, cores := range map[string]int{"serial": 1, "all-cpus": runtime.NumCPU()} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := ComputeAuthDigest(e, group.P256, crypto.BLAKE2b_256, cores)
+				require.NoError(b, err)
+			}
+		})
+	}
+}
+
+func TestInfoString(t *testing.T) {
+	merkle := CreateRandomMerkle(utils.RandomPRG(), 16*16*8, 16, 16)
+	s := fmt.Sprint(merkle.Info)
+	require.Contains(t, s, fmt.Sprintf("%dx%d blocks", merkle.NumRows, merkle.NumColumns))
+	require.Contains(t, s, fmt.Sprintf("block size %d", merkle.BlockSize))
+	require.Contains(t, s, merkle.PIRType)
+	require.Contains(t, s, "merkle proofs")
+	require.NotContains(t, s, "auth")
+	require.NotContains(t, s, "0x")
+
+	lwe, err := CreateRandomBinaryLWE(utils.RandomPRG(), 8, 8)
+	require.NoError(t, err)
+	s = fmt.Sprint(lwe.Info)
+	require.Contains(t, s, "8x8 blocks, single bit")
+	require.Contains(t, s, "auth (LWE digest)")
+	require.NotContains(t, s, "0x")
+
+	// the verbose description has all the details
+	v := merkle.Verbose()
+	require.Contains(t, v, fmt.Sprintf("Merkle.Root: %x", merkle.Root))
+	require.Contains(t, v, fmt.Sprintf("BlockLengths: %v", merkle.BlockLengths))
+}
+
+func TestCreateRandomSingleBitReadError(t *testing.T) {
+	errRead := errors.New("read failed")
+	// the reader fails after 5 bytes, fewer than any db below needs
+	failing := func() io.Reader {
+		return io.MultiReader(io.LimitReader(utils.RandomPRG(), 5), iotest.ErrReader(errRead))
+	}
+
+	_, err := CreateRandomBinaryLWE(failing(), 8, 8)
+	require.ErrorContains(t, err, errRead.Error())
+	_, err = CreateRandomBinaryLWEWithoutDigest(failing(), 8, 8)
+	require.ErrorContains(t, err, errRead.Error())
+	_, err = CreateRandomBinaryLWE128(failing(), 8, 8)
+	require.ErrorContains(t, err, errRead.Error())
+	_, err = CreateRandomEllipticWithDigest(failing(), 64, group.P256, true)
+	require.ErrorContains(t, err, errRead.Error())
+
+	// a reader ending early is an error as well
+	_, err = CreateRandomBinaryLWE(io.LimitReader(utils.RandomPRG(), 5), 8, 8)
+	require.ErrorContains(t, err, io.ErrUnexpectedEOF.Error())
+}
+
+func TestCheckMerkleRoots(t *testing.T) {
+	info := func(root []byte) *Info {
+		return &Info{Merkle: &Merkle{Root: root}}
+	}
+	servers := []string{"a", "b", "c"}
+
+	require.NoError(t, CheckMerkleRoots([]*Info{info([]byte{1}), info([]byte{1}), info([]byte{1})}, servers))
+	err := CheckMerkleRoots([]*Info{info([]byte{1}), info([]byte{1}), info([]byte{2})}, servers)
+	require.EqualError(t, err, "servers a and c have different Merkle roots 01 and 02, they hold inconsistent dbs")
+
+	// an info without Merkle info has no root
+	require.NoError(t, CheckMerkleRoots([]*Info{{}, {}}, servers))
+	err = CheckMerkleRoots([]*Info{info([]byte{1}), {}}, servers)
+	require.EqualError(t, err, "servers a and b have different Merkle roots 01 and , they hold inconsistent dbs")
+}
@@ -5,11 +5,12 @@ import (
 	"encoding/binary"
 	"io"
 	"log"
-	"math"
 	"runtime"
+	"sync"
 
 	"github.com/cloudflare/circl/group"
 	"github.com/si-co/vpir-code/lib/utils"
+	"golang.org/x/xerrors"
 )
 
 type Elliptic struct {
@@ -17,77 +18,171 @@ type Elliptic struct {
 	Info
 }
 
-func CreateRandomEllipticWithDigest(rnd io.Reader, dbLen int, g group.Group, rebalanced bool) *Elliptic {
+// CreateRandomEllipticWithDigest returns a random single-bit database and
+// its digests. It returns an error if the random bytes cannot be read from
+// rnd.
+func CreateRandomEllipticWithDigest(rnd io.Reader, dbLen int, g group.Group, rebalanced bool) (*Elliptic, error) {
+	if dbLen < 1 {
+		return nil, xerrors.Errorf("db of %d bits: %w", dbLen, ErrEmptyDB)
+	}
 	numRows, numColumns := CalculateNumRowsAndColumns(dbLen, rebalanced)
 	// read random bytes for filling out the entries
 	// For simplicity, we use the whole byte to store 0 or 1
 	data := make([]byte, numRows*numColumns)
-	if _, err := rnd.Read(data); err != nil {
-		log.Fatal(err)
+	if _, err := io.ReadFull(rnd, data); err != nil {
+		return nil, xerrors.Errorf("failed to read random bytes: %v", err)
 	}
 	for i := 0; i < len(data); i++ {
 		data[i] = data[i] & 1
 	}
+
+	return CreateEllipticWithDigest(data, numRows, numColumns, g), nil
+}
+
+// CreateEllipticWithDigest returns a single-bit database holding the given
+// bits, one per byte, and computes the row digests and the global digest.
+// The length of data must be equal to numRows*numColumns.
+func CreateEllipticWithDigest(data []byte, numRows, numColumns int, g group.Group) *Elliptic {
+	e := &Elliptic{Entries: data,
+		Info: Info{NumColumns: numColumns,
+			NumRows:   numRows,
+			BlockSize: SingleBitBlockLength,
+			PIRType:   PIRTypeDH,
+		},
+	}
+	if _, err := ComputeAuthDigest(e, g, crypto.BLAKE2b_256); err != nil {
+		log.Fatal(err)
+	}
+
+	return e
+}
+
+// ComputeAuthDigest computes the digests authenticating the single-bit
+// database d for the single-server scheme, sets them in the Auth of d and
+// returns the global digest. The construction is the following:
+//   - the digest of row i is the sum over the group g of the elements
+//     HashIndexToGroup(j, g) for all the columns j where the bit of row i is
+//     set, i.e., the identity for a row of zeros;
+//   - the row digests, encoded with MarshalGroupElements, are concatenated
+//     in row order into SubDigests;
+//   - the global digest is the hash of SubDigests.
+//
+// d must not be empty, its entries must be 0 or 1, one bit per byte, and
+// hash must be available.
+//
+// The rows are split in ranges among the given number of cores, by default
+// all the CPUs, and the digests do not depend on the number of cores. The
+// hashes of the column indices to the group are the same for all the rows,
+// so that they are computed once, in parallel as well.
+func ComputeAuthDigest(d *Elliptic, g group.Group, hash crypto.Hash, cores ...int) ([]byte, error) {
+	if d.NumBlocks() == 0 {
+		return nil, xerrors.Errorf("db of %d rows and %d columns: %w", d.NumRows, d.NumColumns, ErrEmptyDB)
+	}
+	if len(d.Entries) != d.NumRows*d.NumColumns {
+		return nil, xerrors.Errorf("%d entries for a db of %d rows and %d columns",
+			len(d.Entries), d.NumRows, d.NumColumns)
+	}
+	if !hash.Available() {
+		return nil, xerrors.Errorf("hash function %v not available", hash)
+	}
+
 	NGoRoutines := runtime.NumCPU()
-	if dbLen <= 1024*1024 { // dirty hack for small databases
+	if len(cores) > 0 && cores[0] > 0 {
+		NGoRoutines = cores[0]
+	} else if d.NumRows*d.NumColumns <= 1024*1024 { // dirty hack for small databases
 		NGoRoutines = 8
 	}
-	h := crypto.BLAKE2b_256
-	rowsPerRoutine := int(math.Ceil(float64(numRows) / float64(NGoRoutines)))
-	replies := make([]chan []byte, NGoRoutines)
+	columns := hashColumnsToGroup(d.NumColumns, g, NGoRoutines)
+
+	// make sure that we do not need up with routines processing 0 rows
+	if NGoRoutines > d.NumRows {
+		NGoRoutines = d.NumRows
+	}
+	rowsPerRoutine := (d.NumRows + NGoRoutines - 1) / NGoRoutines
+	replies := make([]chan chunkResult, NGoRoutines)
 	var begin, end int
 	for i := 0; i < NGoRoutines; i++ {
 		begin, end = i*rowsPerRoutine, (i+1)*rowsPerRoutine
 		// make the last routine take all the left-over (from division) rows
-		if end > numRows {
-			end = numRows
+		if end > d.NumRows {
+			end = d.NumRows
 		}
-		replyChan := make(chan []byte, 1)
+		if begin > end {
+			begin = end
+		}
+		replyChan := make(chan chunkResult, 1)
 		replies[i] = replyChan
-		go computeDigests(begin, end, data, numColumns, g, h, replyChan)
+		go computeDigests(begin, end, d.Entries, columns, g, replyChan)
 	}
-	digests := make([]byte, 0, numRows*h.Size())
-	for i, reply := range replies {
-		chunk := <-reply
-		digests = append(digests, chunk...)
-		close(replies[i])
+	elementSize := getGroupElementSize(g)
+	digests := make([]byte, 0, d.NumRows*elementSize)
+	for _, reply := range replies {
+		r := <-reply
+		if r.err != nil {
+			return nil, xerrors.Errorf("failed to compute row digests: %v", r.err)
+		}
+		digests = append(digests, r.data...)
 	}
 
 	// global digest
-	hasher := h.New()
+	hasher := hash.New()
 	hasher.Write(digests)
 
-	return &Elliptic{Entries: data,
-		Info: Info{NumColumns: numColumns,
-			NumRows:   numRows,
-			BlockSize: 1,
-			Auth: &Auth{
-				Digest:      hasher.Sum(nil),
-				SubDigests:  digests,
-				Group:       g,
-				Hash:        h,
-				ElementSize: getGroupElementSize(g),
-			},
-		},
+	d.Auth = &Auth{
+		Digest:      hasher.Sum(nil),
+		SubDigests:  digests,
+		Group:       g,
+		Hash:        hash,
+		ElementSize: elementSize,
 	}
+
+	return d.Digest, nil
 }
 
-func computeDigests(begin, end int, data []byte, rowLen int, g group.Group, h crypto.Hash, replyTo chan<- []byte) {
-	digs := make([]byte, 0, (end-begin)*h.Size())
+// computeDigests computes the digests of the rows from begin to end, given
+// the hashes to the group of the indices of the columns
+func computeDigests(begin, end int, data []byte, columns []group.Element, g group.Group, replyTo chan<- chunkResult) {
+	rowLen := len(columns)
+	digs := make([]byte, 0, (end-begin)*getGroupElementSize(g))
 	for i := begin; i < end; i++ {
 		d := g.Identity()
 		for j := 0; j < rowLen; j++ {
 			if data[i*rowLen+j] == 1 {
-				d.Add(d, HashIndexToGroup(uint64(j), g))
+				d.Add(d, columns[j])
 			}
 		}
-		tmp, err := d.MarshalBinaryCompress()
+		tmp, err := MarshalGroupElements([]group.Element{d}, getGroupElementSize(g))
 		if err != nil {
-			log.Fatal(err)
+			replyTo <- chunkResult{err: err}
+			return
 		}
 		digs = append(digs, tmp...)
 	}
-	replyTo <- digs
+	replyTo <- chunkResult{data: digs}
+}
+
+// hashColumnsToGroup returns HashIndexToGroup(j, g) for all the numColumns
+// columns j, computed by at most numRoutines goroutines
+func hashColumnsToGroup(numColumns int, g group.Group, numRoutines int) []group.Element {
+	if numRoutines > numColumns {
+		numRoutines = utils.Max(numColumns, 1)
+	}
+	columns := make([]group.Element, numColumns)
+	perRoutine := (numColumns + numRoutines - 1) / numRoutines
+	var wg sync.WaitGroup
+	for begin := 0; begin < numColumns; begin += perRoutine {
+		end := utils.Min(begin+perRoutine, numColumns)
+		wg.Add(1)
+		go func(begin, end int) {
+			defer wg.Done()
+			for j := begin; j < end; j++ {
+				columns[j] = HashIndexToGroup(uint64(j), g)
+			}
+		}(begin, end)
+	}
+	wg.Wait()
+
+	return columns
 }
 
 // Take the indices (j, l) and hash them to get a group element
@@ -109,6 +204,12 @@ func CommitScalarToIndex(x group.Scalar, j uint64, g group.Group) group.Element
 func MarshalGroupElements(q []group.Element, marshalledLen int) ([]byte, error) {
 	encoded := make([]byte, 0, marshalledLen*len(q))
 	for _, el := range q {
+		// the identity compresses to a single byte, e.g., the answer to a
+		// row of zeros, and is encoded with zeros to keep a fixed length
+		if el.IsIdentity() {
+			encoded = append(encoded, make([]byte, marshalledLen)...)
+			continue
+		}
 		tmp, err := el.MarshalBinaryCompress()
 		if err != nil {
 			return nil, err
@@ -120,9 +221,16 @@ func MarshalGroupElements(q []group.Element, marshalledLen int) ([]byte, error)
 
 // Unmarshal a slice of group elements
 func UnmarshalGroupElements(q []byte, g group.Group, elemSize int) ([]group.Element, error) {
+	if len(q)%elemSize != 0 {
+		return nil, xerrors.Errorf("%d bytes are not a multiple of the element size %d", len(q), elemSize)
+	}
 	var err error
 	decoded := make([]group.Element, 0, len(q)/elemSize)
 	for i := 0; i < len(q); i += elemSize {
+		if isZero(q[i : i+elemSize]) {
+			decoded = append(decoded, g.Identity())
+			continue
+		}
 		elem := g.NewElement()
 		err = elem.UnmarshalBinary(q[i : i+elemSize])
 		if err != nil {
@@ -133,6 +241,15 @@ func UnmarshalGroupElements(q []byte, g group.Group, elemSize int) ([]group.Elem
 	return decoded, nil
 }
 
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 func getGroupElementSize(g group.Group) int {
 	// Obtaining the scalar and element sizes for the group
 	rnd := utils.RandomPRG()
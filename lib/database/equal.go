@@ -0,0 +1,113 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Difference is the first difference found between two databases. Index is
+// the index of the differing entry, or -1 if the difference is not in an
+// entry.
+type Difference struct {
+	Field string
+	Index int
+	A, B  interface{}
+}
+
+func (d *Difference) String() string {
+	if d.Index < 0 {
+		return fmt.Sprintf("%s: %v != %v", d.Field, d.A, d.B)
+	}
+	return fmt.Sprintf("%s[%d]: %v != %v", d.Field, d.Index, d.A, d.B)
+}
+
+// Equal returns true if a and b have the same info, keys info and entries
+func Equal(a, b *DB) bool {
+	return Diff(a, b) == nil
+}
+
+// EqualBytes returns true if a and b have the same info and entries
+func EqualBytes(a, b *Bytes) bool {
+	return DiffBytes(a, b) == nil
+}
+
+// Diff returns the first difference between a and b, or nil if they are
+// equal. The info is compared first, then the keys info and the entries.
+func Diff(a, b *DB) *Difference {
+	if d := diffInfo(&a.Info, &b.Info); d != nil {
+		return d
+	}
+	if d := diffKeysInfo(a.KeysInfo, b.KeysInfo); d != nil {
+		return d
+	}
+	if len(a.Entries) != len(b.Entries) {
+		return &Difference{Field: "len(Entries)", Index: -1, A: len(a.Entries), B: len(b.Entries)}
+	}
+	for i := range a.Entries {
+		if a.Entries[i] != b.Entries[i] {
+			return &Difference{Field: "Entries", Index: i, A: a.Entries[i], B: b.Entries[i]}
+		}
+	}
+
+	return nil
+}
+
+// DiffBytes returns the first difference between a and b, or nil if they
+// are equal. The info is compared before the entries.
+func DiffBytes(a, b *Bytes) *Difference {
+	if d := diffInfo(&a.Info, &b.Info); d != nil {
+		return d
+	}
+	if len(a.Entries) != len(b.Entries) {
+		return &Difference{Field: "len(Entries)", Index: -1, A: len(a.Entries), B: len(b.Entries)}
+	}
+	for i := range a.Entries {
+		if a.Entries[i] != b.Entries[i] {
+			return &Difference{Field: "Entries", Index: i, A: a.Entries[i], B: b.Entries[i]}
+		}
+	}
+
+	return nil
+}
+
+func diffInfo(a, b *Info) *Difference {
+	fields := []struct {
+		name string
+		a, b interface{}
+	}{
+		{"NumRows", a.NumRows, b.NumRows},
+		{"NumColumns", a.NumColumns, b.NumColumns},
+		{"BlockSize", a.BlockSize, b.BlockSize},
+		{"BlockLengths", a.BlockLengths, b.BlockLengths},
+		{"PIRType", a.PIRType, b.PIRType},
+		{"Auth", a.Auth, b.Auth},
+		{"Merkle", a.Merkle, b.Merkle},
+		{"BlockHashes", a.BlockHashes, b.BlockHashes},
+		{"ShardRange", a.ShardRange, b.ShardRange},
+	}
+	for _, f := range fields {
+		if !reflect.DeepEqual(f.a, f.b) {
+			return &Difference{Field: "Info." + f.name, Index: -1, A: f.a, B: f.b}
+		}
+	}
+
+	return nil
+}
+
+func diffKeysInfo(a, b []*KeyInfo) *Difference {
+	if len(a) != len(b) {
+		return &Difference{Field: "len(KeysInfo)", Index: -1, A: len(a), B: len(b)}
+	}
+	for i := range a {
+		// the creation times are compared as instants, since their
+		// location is not preserved by the encoding
+		if !reflect.DeepEqual(a[i].UserId, b[i].UserId) ||
+			!a[i].CreationTime.Equal(b[i].CreationTime) ||
+			a[i].PubKeyAlgo != b[i].PubKeyAlgo ||
+			a[i].BitLength != b[i].BitLength {
+			return &Difference{Field: "KeysInfo", Index: i, A: *a[i], B: *b[i]}
+		}
+	}
+
+	return nil
+}
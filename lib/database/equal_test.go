@@ -0,0 +1,46 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/si-co/vpir-code/lib/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffBytes(t *testing.T) {
+	a, err := CreateRandomBytes(utils.RandomPRG(), 8*4*16*10, 4, 16)
+	require.NoError(t, err)
+	b := &Bytes{Entries: append([]byte{}, a.Entries...), Info: a.Info}
+	require.True(t, EqualBytes(a, b))
+	require.Nil(t, DiffBytes(a, b))
+
+	// a single different entry
+	b.Entries[123] ^= 0xff
+	require.False(t, EqualBytes(a, b))
+	d := DiffBytes(a, b)
+	require.NotNil(t, d)
+	require.Equal(t, "Entries", d.Field)
+	require.Equal(t, 123, d.Index)
+	require.Equal(t, a.Entries[123], d.A)
+	require.Equal(t, b.Entries[123], d.B)
+
+	// the info is compared first
+	b.NumRows = 2
+	d = DiffBytes(a, b)
+	require.Equal(t, "Info.NumRows", d.Field)
+	require.Equal(t, -1, d.Index)
+}
+
+func TestDiffDB(t *testing.T) {
+	a, err := CreateRandomBitsDB(utils.RandomPRG(), 8*4*4*16*10, 4, 16)
+	require.NoError(t, err)
+	b := &DB{Entries: append([]uint32{}, a.Entries...), Info: a.Info}
+	require.True(t, Equal(a, b))
+
+	b.Entries[len(b.Entries)-1]++
+	require.False(t, Equal(a, b))
+	d := Diff(a, b)
+	require.Equal(t, "Entries", d.Field)
+	require.Equal(t, len(b.Entries)-1, d.Index)
+	require.Equal(t, "Entries[639]: ", d.String()[:len("Entries[639]: ")])
+}
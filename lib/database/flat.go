@@ -0,0 +1,125 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"golang.org/x/xerrors"
+)
+
+// headerLenSize is the size of the length of the header that starts a flat
+// database file
+const headerLenSize = 8
+
+// SaveBytesFlat writes the given bytes database to the file at path,
+// replacing the file if it already exists, as SaveBytes. Unlike SaveBytes, the entries
+// are written as they are in memory, after the length of the gob-encoded
+// header and the header itself, so that the file can be mapped in memory
+// by MapBytes.
+func SaveBytesFlat(path string, b *Bytes) error {
+	return saveFile(path, nil, func(w io.Writer) error {
+		return writeBytesFlat(w, b)
+	})
+}
+
+func writeBytesFlat(w io.Writer, b *Bytes) error {
+	si := newSaveInfo(b.Info)
+	si.EntriesLength = len(b.Entries)
+	var header bytes.Buffer
+	if err := gob.NewEncoder(&header).Encode(si); err != nil {
+		return xerrors.Errorf("failed to encode db info: %v", err)
+	}
+
+	headerLen := make([]byte, headerLenSize)
+	binary.BigEndian.PutUint64(headerLen, uint64(header.Len()))
+	for _, data := range [][]byte{headerLen, header.Bytes(), b.Entries} {
+		if _, err := w.Write(data); err != nil {
+			return xerrors.Errorf("failed to write db file: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// MapBytes maps in memory the bytes database written at path by
+// SaveBytesFlat. The entries of the returned database are backed by the
+// file and are not loaded in memory, so that a server can answer queries on
+// a database larger than its memory. The entries are read-only and must not
+// be used after calling unmap.
+func MapBytes(path string) (db *Bytes, unmap func() error, err error) {
+	db, unmap, _, err = mapBytes(path)
+	return db, unmap, err
+}
+
+// mapBytes is MapBytes, also returning the whole mapped file
+func mapBytes(path string) (*Bytes, func() error, []byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, xerrors.Errorf("failed to open db file: %v", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, nil, xerrors.Errorf("failed to stat db file: %v", err)
+	}
+	if fi.Size() < headerLenSize {
+		return nil, nil, nil, xerrors.New("db file too short")
+	}
+
+	data, err := mmapFile(f, int(fi.Size()))
+	if err != nil {
+		return nil, nil, nil, xerrors.Errorf("failed to map db file: %v", err)
+	}
+	unmap := func() error { return munmapFile(data) }
+
+	si, err := decodeFlatHeader(data)
+	if err != nil {
+		unmap()
+		return nil, nil, nil, err
+	}
+
+	return &Bytes{Entries: data[len(data)-si.EntriesLength:], Info: si.info()}, unmap, data, nil
+}
+
+// touchSink accumulates the bytes read by Touch, so that the reads cannot
+// be optimized away. It is updated atomically since dbs can be touched
+// concurrently, e.g., while reloading.
+var touchSink uint32
+
+// Touch reads a byte of every memory page of the entries of b. On a db
+// mapped by MapBytes, this loads the whole file in memory, so that the
+// first queries do not pay the page faults.
+func (b *Bytes) Touch() {
+	page := os.Getpagesize()
+	var x byte
+	for i := 0; i < len(b.Entries); i += page {
+		x ^= b.Entries[i]
+	}
+	atomic.AddUint32(&touchSink, uint32(x))
+}
+
+// decodeFlatHeader decodes the header of the flat database file in data and
+// checks that it is followed by the entries
+func decodeFlatHeader(data []byte) (*saveInfo, error) {
+	headerLen := binary.BigEndian.Uint64(data[:headerLenSize])
+	if headerLen > uint64(len(data)-headerLenSize) {
+		return nil, xerrors.Errorf("header of %d bytes in a db file of %d bytes", headerLen, len(data))
+	}
+
+	si := new(saveInfo)
+	header := data[headerLenSize : headerLenSize+int(headerLen)]
+	if err := gob.NewDecoder(bytes.NewReader(header)).Decode(si); err != nil {
+		return nil, xerrors.Errorf("failed to decode db info: %v", err)
+	}
+	if entriesLen := len(data) - headerLenSize - int(headerLen); entriesLen != si.EntriesLength {
+		return nil, xerrors.Errorf("wrong entries length: expected %d, got %d",
+			si.EntriesLength, entriesLen)
+	}
+
+	return si, nil
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/si-co/vpir-code/lib/utils"
+	"golang.org/x/crypto/blake2b"
+)
+
+// String returns a compact description of the info for logging, with the
+// dimensions, the block size, the PIR type and which of the Merkle, Auth,
+// block hashes and shard info are set. Use Verbose for all the details.
+func (i Info) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%dx%d blocks", i.NumRows, i.NumColumns)
+	if i.IsSingleBit() {
+		b.WriteString(", single bit")
+	} else {
+		fmt.Fprintf(&b, ", block size %d", i.BlockSize)
+	}
+	if i.PIRType != "" {
+		fmt.Fprintf(&b, ", %s", i.PIRType)
+	}
+	if i.Merkle != nil {
+		fmt.Fprintf(&b, ", merkle proofs of %d bytes", i.ProofLen)
+	}
+	if i.Auth != nil {
+		fmt.Fprintf(&b, ", auth %s", i.Auth.summary())
+	}
+	if i.BlockHashes != nil {
+		fmt.Fprintf(&b, ", %d block hashes", len(i.Hashes)/blake2b.Size256)
+	}
+	if i.ShardRange != nil {
+		fmt.Fprintf(&b, ", shard of columns [%d, %d)", i.ShardRange.Start, i.ShardRange.End)
+	}
+
+	return b.String()
+}
+
+// Verbose returns a multi-line description of all the fields of the info,
+// including the digests and the Merkle root in hex
+func (i Info) Verbose() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "NumRows: %d\n", i.NumRows)
+	fmt.Fprintf(&b, "NumColumns: %d\n", i.NumColumns)
+	fmt.Fprintf(&b, "BlockSize: %d\n", i.BlockSize)
+	fmt.Fprintf(&b, "BlockLengths: %v\n", i.BlockLengths)
+	fmt.Fprintf(&b, "PIRType: %q\n", i.PIRType)
+	if i.Auth != nil {
+		a := i.Auth
+		if a.DigestLWE != nil {
+			fmt.Fprintf(&b, "Auth.DigestLWE: %dx%d matrix\n", a.DigestLWE.Rows(), a.DigestLWE.Cols())
+		}
+		if a.DigestLWE128 != nil {
+			fmt.Fprintf(&b, "Auth.DigestLWE128: %dx%d matrix\n", a.DigestLWE128.Rows(), a.DigestLWE128.Cols())
+		}
+		fmt.Fprintf(&b, "Auth.Digest: %s\n", hex.EncodeToString(a.Digest))
+		fmt.Fprintf(&b, "Auth.SubDigests: %d bytes\n", len(a.SubDigests))
+		fmt.Fprintf(&b, "Auth.SubDigestLength: %d\n", a.SubDigestLength)
+		if a.Group != nil {
+			fmt.Fprintf(&b, "Auth.Group: %T\n", a.Group)
+		}
+		fmt.Fprintf(&b, "Auth.Hash: %s\n", a.Hash)
+		fmt.Fprintf(&b, "Auth.ElementSize: %d\n", a.ElementSize)
+		fmt.Fprintf(&b, "Auth.ScalarSize: %d\n", a.ScalarSize)
+	}
+	if i.Merkle != nil {
+		fmt.Fprintf(&b, "Merkle.Root: %s\n", hex.EncodeToString(i.Root))
+		fmt.Fprintf(&b, "Merkle.ProofLen: %d\n", i.ProofLen)
+	}
+	if i.BlockHashes != nil {
+		fmt.Fprintf(&b, "BlockHashes.HashKey: %s\n", hex.EncodeToString(i.HashKey))
+		fmt.Fprintf(&b, "BlockHashes.Hashes: %d bytes\n", len(i.Hashes))
+	}
+	if i.ShardRange != nil {
+		fmt.Fprintf(&b, "ShardRange: [%d, %d)\n", i.ShardRange.Start, i.ShardRange.End)
+	}
+
+	return b.String()
+}
+
+// summary returns which digests the auth info holds
+func (a *Auth) summary() string {
+	var parts []string
+	if a.DigestLWE != nil {
+		parts = append(parts, "LWE digest")
+	}
+	if a.DigestLWE128 != nil {
+		parts = append(parts, "LWE128 digest")
+	}
+	if a.Digest != nil {
+		parts = append(parts, fmt.Sprintf("digest of %d bytes", len(a.Digest)))
+	}
+	if a.SubDigests != nil {
+		parts = append(parts, fmt.Sprintf("%d sub-digests", len(a.SubDigests)/utils.Max(a.SubDigestLength, 1)))
+	}
+	if a.Group != nil {
+		parts = append(parts, "ECC group")
+	}
+	if len(parts) == 0 {
+		return "(empty)"
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
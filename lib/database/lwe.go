@@ -1,10 +1,15 @@
 package database
 
 import (
+	"bytes"
+	"encoding/binary"
 	"io"
+	"os"
 
 	"github.com/si-co/vpir-code/lib/matrix"
 	"github.com/si-co/vpir-code/lib/utils"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/xerrors"
 )
 
 type LWE struct {
@@ -13,7 +18,6 @@ type LWE struct {
 }
 
 const plaintextModulus = 2
-const blockSizeLWE = 1 // for backward compatibility
 
 func Digest(db *LWE, rows int) *matrix.Matrix {
 	return matrix.BinaryMul(
@@ -24,17 +28,39 @@ func Digest(db *LWE, rows int) *matrix.Matrix {
 		), db.Matrix)
 }
 
-func CreateRandomBinaryLWEWithLength(rnd io.Reader, dbLen int) *LWE {
+func CreateRandomBinaryLWEWithLength(rnd io.Reader, dbLen int) (*LWE, error) {
+	if dbLen < 1 {
+		return nil, xerrors.Errorf("db of %d bits: %w", dbLen, ErrEmptyDB)
+	}
 	numRows, numColumns := CalculateNumRowsAndColumns(dbLen, true)
 	return CreateRandomBinaryLWE(rnd, numRows, numColumns)
 }
 
-func CreateRandomBinaryLWE(rnd io.Reader, numRows, numColumns int) *LWE {
+// CreateRandomBinaryLWE returns a random LWE database and its digest. It
+// returns an error if the random bytes cannot be read from rnd.
+func CreateRandomBinaryLWE(rnd io.Reader, numRows, numColumns int) (*LWE, error) {
+	db, err := CreateRandomBinaryLWEWithoutDigest(rnd, numRows, numColumns)
+	if err != nil {
+		return nil, err
+	}
+	db.Auth = &Auth{
+		DigestLWE: Digest(db, numRows),
+	}
+
+	return db, nil
+}
+
+// CreateRandomBinaryLWEWithoutDigest returns a random LWE database without
+// computing its digest, e.g., to load it with LoadOrComputeDigestLWE.
+func CreateRandomBinaryLWEWithoutDigest(rnd io.Reader, numRows, numColumns int) (*LWE, error) {
+	if numRows < 1 || numColumns < 1 {
+		return nil, xerrors.Errorf("db of %d rows and %d columns: %w", numRows, numColumns, ErrEmptyDB)
+	}
 	m := matrix.NewBytes(numRows, numColumns)
 	// read random bytes for filling out the entries
 	data := make([]byte, (numRows*numColumns)/8+1)
-	if _, err := rnd.Read(data); err != nil {
-		panic(err)
+	if _, err := io.ReadFull(rnd, data); err != nil {
+		return nil, xerrors.Errorf("failed to read random bytes: %v", err)
 	}
 
 	for i := 0; i < m.Len(); i++ {
@@ -45,18 +71,107 @@ func CreateRandomBinaryLWE(rnd io.Reader, numRows, numColumns int) *LWE {
 		m.SetData(i, val)
 	}
 
-	db := &LWE{
+	return &LWE{
 		Matrix: m,
 		Info: Info{
 			NumRows:    numRows,
 			NumColumns: numColumns,
-			BlockSize:  blockSizeLWE,
+			BlockSize:  SingleBitBlockLength,
+			PIRType:    PIRTypeLWE,
 		},
+	}, nil
+}
+
+// SaveDigestLWE writes the digest of db to the file at path, encoded with
+// matrix.MatrixToBytes and preceded by a hash of the entries of db, so that
+// the digest is only loaded back for the same database. The digest only
+// depends on the database, so that it can be computed once for a static
+// database. It returns an error if the digest of db is not set.
+func SaveDigestLWE(path string, db *LWE) error {
+	if db.Auth == nil || db.DigestLWE == nil {
+		return xerrors.New("db without LWE digest")
 	}
+	hash := hashLWE(db)
+	data := append(hash[:], matrix.MatrixToBytes(db.DigestLWE)...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return xerrors.Errorf("failed to write digest file: %v", err)
+	}
+	return nil
+}
 
-	db.Auth = &Auth{
-		DigestLWE: Digest(db, numRows),
+// LoadDigestLWE reads the digest of db written at path by SaveDigestLWE and
+// sets it in the info of db. It returns an error if the digest was saved
+// for another database or does not have the dimensions of the digest of db.
+func LoadDigestLWE(path string, db *LWE) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return xerrors.Errorf("failed to read digest file: %v", err)
+	}
+	hash := hashLWE(db)
+	if len(data) < len(hash) || !bytes.Equal(data[:len(hash)], hash[:]) {
+		return xerrors.New("digest file of another db")
+	}
+	digest, err := decodeDigestLWE(data[len(hash):], db.NumColumns)
+	if err != nil {
+		return err
+	}
+	db.Auth = &Auth{DigestLWE: digest}
+
+	return nil
+}
+
+// decodeDigestLWE decodes the digest encoded with matrix.MatrixToBytes of
+// an LWE database with numColumns columns, checking its dimensions
+func decodeDigestLWE(data []byte, numColumns int) (*matrix.Matrix, error) {
+	rows, cols := utils.ParamsDefault().N, numColumns
+	if expected := 8 + 4*rows*cols; len(data) != expected {
+		return nil, xerrors.Errorf("digest of %d bytes, expected %d bytes", len(data), expected)
+	}
+	digest := matrix.BytesToMatrix(data)
+	if digest.Rows() != rows || digest.Cols() != cols {
+		return nil, xerrors.Errorf("digest of %dx%d elements, expected %dx%d",
+			digest.Rows(), digest.Cols(), rows, cols)
+	}
+
+	return digest, nil
+}
+
+// hashLWE returns the blake2b hash of the dimensions and of the entries of
+// db, packed a bit per entry
+func hashLWE(db *LWE) [blake2b.Size256]byte {
+	h, err := blake2b.New256(nil)
+	if err != nil {
+		panic(err)
+	}
+	var dims [16]byte
+	binary.BigEndian.PutUint64(dims[:8], uint64(db.NumRows))
+	binary.BigEndian.PutUint64(dims[8:], uint64(db.NumColumns))
+	h.Write(dims[:])
+	row := make([]byte, (db.NumColumns+7)/8)
+	for r := 0; r < db.NumRows; r++ {
+		for i := range row {
+			row[i] = 0
+		}
+		for c := 0; c < db.NumColumns; c++ {
+			row[c/8] |= db.Matrix.Get(r, c) << (c % 8)
+		}
+		h.Write(row)
+	}
+
+	var out [blake2b.Size256]byte
+	copy(out[:], h.Sum(nil))
+	return out
+}
+
+// LoadOrComputeDigestLWE loads the digest of db from the file at path if it
+// exists, or computes it and saves it to path otherwise.
+func LoadOrComputeDigestLWE(path string, db *LWE) error {
+	if _, err := os.Stat(path); err == nil {
+		return LoadDigestLWE(path, db)
+	} else if !os.IsNotExist(err) {
+		return xerrors.Errorf("failed to stat digest file: %v", err)
 	}
 
-	return db
+	db.Auth = &Auth{DigestLWE: Digest(db, db.NumRows)}
+	return SaveDigestLWE(path, db)
 }
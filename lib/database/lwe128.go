@@ -5,6 +5,7 @@ import (
 
 	"github.com/si-co/vpir-code/lib/matrix"
 	"github.com/si-co/vpir-code/lib/utils"
+	"golang.org/x/xerrors"
 )
 
 type LWE128 struct {
@@ -21,18 +22,26 @@ func Digest128(db *LWE128, rows int) *matrix.Matrix128 {
 		), db.Matrix)
 }
 
-func CreateRandomBinaryLWEWithLength128(rnd io.Reader, dbLen int) *LWE128 {
+func CreateRandomBinaryLWEWithLength128(rnd io.Reader, dbLen int) (*LWE128, error) {
+	if dbLen < 1 {
+		return nil, xerrors.Errorf("db of %d bits: %w", dbLen, ErrEmptyDB)
+	}
 	numRows, numColumns := CalculateNumRowsAndColumns(dbLen, true)
 	return CreateRandomBinaryLWE128(rnd, numRows, numColumns)
 }
 
-func CreateRandomBinaryLWE128(rnd io.Reader, numRows, numColumns int) *LWE128 {
+// CreateRandomBinaryLWE128 returns a random LWE128 database and its
+// digest. It returns an error if the random bytes cannot be read from rnd.
+func CreateRandomBinaryLWE128(rnd io.Reader, numRows, numColumns int) (*LWE128, error) {
+	if numRows < 1 || numColumns < 1 {
+		return nil, xerrors.Errorf("db of %d rows and %d columns: %w", numRows, numColumns, ErrEmptyDB)
+	}
 	m := matrix.NewBytes(numRows, numColumns)
 	// read random bytes for filling out the entries
 	// the +1 takes into account a float division by 8
 	data := make([]byte, numRows*numColumns/8+1)
-	if _, err := rnd.Read(data); err != nil {
-		panic(err)
+	if _, err := io.ReadFull(rnd, data); err != nil {
+		return nil, xerrors.Errorf("failed to read random bytes: %v", err)
 	}
 
 	for i := 0; i < m.Len(); i++ {
@@ -48,7 +57,8 @@ func CreateRandomBinaryLWE128(rnd io.Reader, numRows, numColumns int) *LWE128 {
 		Info: Info{
 			NumRows:    numRows,
 			NumColumns: numColumns,
-			BlockSize:  blockSizeLWE,
+			BlockSize:  SingleBitBlockLength,
+			PIRType:    PIRTypeLWE128,
 		},
 	}
 
@@ -56,5 +66,5 @@ func CreateRandomBinaryLWE128(rnd io.Reader, numRows, numColumns int) *LWE128 {
 		DigestLWE128: Digest128(db, numRows),
 	}
 
-	return db
+	return db, nil
 }
@@ -36,7 +36,7 @@ func TestLWE(t *testing.T) {
 		Info: Info{
 			NumRows:    rows,
 			NumColumns: columns,
-			BlockSize:  blockSizeLWE,
+			BlockSize:  SingleBitBlockLength,
 		},
 	}
 
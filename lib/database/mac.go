@@ -0,0 +1,259 @@
+package database
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/xerrors"
+)
+
+// MACSuffix is appended to the path of a database file to get the path of
+// the file holding its MAC
+const MACSuffix = ".mac"
+
+// MACPath returns the path of the file holding the MAC of the database file
+// at path
+func MACPath(path string) string {
+	return path + MACSuffix
+}
+
+// SaveBytesWithMAC is as SaveBytes, but if key is not nil it also writes the
+// keyed hash of the whole database file, header and chunks, to
+// MACPath(path). Unlike the gob encoding, the MAC cannot be recomputed
+// without the key, so that LoadBytesWithMAC detects any change to the file.
+// A file extended with AppendBytes needs a new MAC.
+func SaveBytesWithMAC(path string, b *Bytes, key []byte) error {
+	return saveFile(path, key, func(w io.Writer) error {
+		return writeBytes(w, b)
+	})
+}
+
+// LoadBytesWithMAC is as LoadBytes, but if key is not nil it checks the
+// file against the MAC written by SaveBytesWithMAC and returns an error if
+// they do not match
+func LoadBytesWithMAC(path string, key []byte) (*Bytes, error) {
+	var b *Bytes
+	err := loadFile(path, key, func(r io.Reader) (err error) {
+		b, err = readBytes(r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// SaveDBWithMAC is as SaveDB, but if key is not nil it also writes the keyed
+// hash of the whole database file to MACPath(path)
+func SaveDBWithMAC(path string, d *DB, key []byte) error {
+	return saveFile(path, key, func(w io.Writer) error {
+		return writeDB(w, d)
+	})
+}
+
+// LoadDBWithMAC is as LoadDB, but if key is not nil it checks the file
+// against the MAC written by SaveDBWithMAC and returns an error if they do
+// not match
+func LoadDBWithMAC(path string, key []byte) (*DB, error) {
+	var d *DB
+	err := loadFile(path, key, func(r io.Reader) (err error) {
+		d, err = readDB(r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// SaveBytesFlatWithMAC is as SaveBytesFlat, but if key is not nil it also
+// writes the keyed hash of the whole database file to MACPath(path)
+func SaveBytesFlatWithMAC(path string, b *Bytes, key []byte) error {
+	return saveFile(path, key, func(w io.Writer) error {
+		return writeBytesFlat(w, b)
+	})
+}
+
+// MapBytesWithMAC is as MapBytes, but if key is not nil it checks the
+// mapped file against the MAC written by SaveBytesFlatWithMAC, reading the
+// whole file, and returns an error if they do not match. The entries served
+// are the mapped bytes that were checked, but, as they are backed by the
+// file, a later change of the file in place is not detected: the file must
+// only be replaced, as the save functions do.
+func MapBytesWithMAC(path string, key []byte) (*Bytes, func() error, error) {
+	db, unmap, data, err := mapBytes(path)
+	if err != nil || key == nil {
+		return db, unmap, err
+	}
+	mac, err := newFileMAC(key)
+	if err != nil {
+		unmap()
+		return nil, nil, err
+	}
+	if err := checkMAC(path, mac, bytes.NewReader(data)); err != nil {
+		unmap()
+		return nil, nil, err
+	}
+
+	return db, unmap, nil
+}
+
+// saveFile writes the file at path with write. If key is not nil, the MAC
+// of what is written is saved to MACPath(path), and any previous MAC file
+// is removed otherwise. The file and its MAC are first written to temporary
+// files in the same directory, synced and renamed only once complete: a
+// save failing midway removes the temporary files and leaves any previous
+// file at path untouched, so that it can be retried. The previous MAC is
+// removed before the new file is renamed, so that a failure between the
+// two renames leaves the new file without MAC rather than with a stale one.
+func saveFile(path string, key []byte, write func(io.Writer) error) error {
+	var mac hash.Hash
+	if key != nil {
+		var err error
+		if mac, err = newFileMAC(key); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := writeTemp(path, func(f io.Writer) error {
+		if mac == nil {
+			return write(f)
+		}
+		return write(io.MultiWriter(f, mac))
+	})
+	if err != nil {
+		return err
+	}
+	tmpMAC := ""
+	if mac != nil {
+		tmpMAC, err = writeTemp(MACPath(path), func(f io.Writer) error {
+			_, err := f.Write(mac.Sum(nil))
+			return err
+		})
+		if err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	if err := os.Remove(MACPath(path)); err != nil && !os.IsNotExist(err) {
+		os.Remove(tmp)
+		if tmpMAC != "" {
+			os.Remove(tmpMAC)
+		}
+		return xerrors.Errorf("failed to remove previous db MAC file: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		if tmpMAC != "" {
+			os.Remove(tmpMAC)
+		}
+		return xerrors.Errorf("failed to rename db file: %v", err)
+	}
+	if tmpMAC == "" {
+		return nil
+	}
+	if err := os.Rename(tmpMAC, MACPath(path)); err != nil {
+		os.Remove(tmpMAC)
+		return xerrors.Errorf("failed to rename db MAC file: %v", err)
+	}
+
+	return nil
+}
+
+// writeTemp writes a temporary file next to path with write, syncs it and
+// returns its name. The file is removed if it cannot be written.
+func writeTemp(path string, write func(io.Writer) error) (name string, err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", xerrors.Errorf("failed to create db file: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	// as a file created by os.Create, and not only readable by its owner
+	// as a temporary file
+	if err := f.Chmod(0644); err != nil {
+		return "", xerrors.Errorf("failed to set mode of db file: %v", err)
+	}
+
+	if err := write(f); err != nil {
+		return "", err
+	}
+	// the data must be on disk before the rename makes it the db file
+	if err := f.Sync(); err != nil {
+		return "", xerrors.Errorf("failed to sync db file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", xerrors.Errorf("failed to close db file: %v", err)
+	}
+
+	return f.Name(), nil
+}
+
+// loadFile opens the file at path and decodes it with read. If key is not
+// nil, the file is hashed as read consumes it and the rest of the file is
+// hashed afterwards, so that the whole file is checked against the MAC at
+// MACPath(path) before loadFile returns. The file is read only once, so
+// that what is decoded is exactly what is checked, even if the file is
+// changed meanwhile. A file not matching its MAC is reported as such, even
+// if it could not be decoded.
+func loadFile(path string, key []byte, read func(io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return xerrors.Errorf("failed to open db file: %v", err)
+	}
+	defer f.Close()
+	if key == nil {
+		return read(f)
+	}
+
+	mac, err := newFileMAC(key)
+	if err != nil {
+		return err
+	}
+	errRead := read(io.TeeReader(f, mac))
+	// read may not consume the whole file, e.g., when the decoder fails
+	if err := checkMAC(path, mac, f); err != nil {
+		return err
+	}
+
+	return errRead
+}
+
+// checkMAC hashes what r reads with mac and returns an error if the MAC
+// does not match the one at MACPath(path)
+func checkMAC(path string, mac hash.Hash, r io.Reader) error {
+	if _, err := io.Copy(mac, r); err != nil {
+		return xerrors.Errorf("failed to read db file: %v", err)
+	}
+	expected, err := os.ReadFile(MACPath(path))
+	if err != nil {
+		return xerrors.Errorf("failed to read db MAC file: %v", err)
+	}
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return xerrors.New("db file does not match its MAC")
+	}
+
+	return nil
+}
+
+// newFileMAC returns the keyed hash of the database files, as the keyed hash
+// of the blocks
+func newFileMAC(key []byte) (hash.Hash, error) {
+	mac, err := blake2b.New256(key)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid MAC key: %v", err)
+	}
+	return mac, nil
+}
@@ -57,7 +57,7 @@ func CreateRandomMerkle(rnd io.Reader, dbLen, numRows, blockLen int) *Bytes {
 			NumColumns:   numColumns,
 			BlockSize:    blockLen,
 			BlockLengths: blockLens,
-			PIRType:      "merkle",
+			PIRType:      PIRTypeMerkle,
 			Merkle:       &Merkle{Root: tree.Root(), ProofLen: proofLen},
 		},
 	}
@@ -77,7 +77,8 @@ func TestMerkleTree(t *testing.T) {
 			entireBlock := entries[i][j*(blockLen+proofLen) : (j+1)*(blockLen+proofLen)]
 			data := entireBlock[:blockLen]
 			encodedProof := entireBlock[blockLen:]
-			proof := merkle.DecodeProof(encodedProof)
+			proof, err := merkle.DecodeProof(encodedProof)
+			require.NoError(t, err)
 			verified, err := merkle.VerifyProof(data, proof, root)
 			require.NoError(t, err)
 			require.True(t, verified)
@@ -0,0 +1,17 @@
+//go:build !(aix || android || darwin || dragonfly || freebsd || illumos || ios || linux || netbsd || openbsd || solaris)
+
+package database
+
+import (
+	"os"
+
+	"golang.org/x/xerrors"
+)
+
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	return nil, xerrors.New("memory-mapped databases are not supported on this system")
+}
+
+func munmapFile(data []byte) error {
+	return nil
+}
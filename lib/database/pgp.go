@@ -10,6 +10,7 @@ import (
 	"github.com/si-co/vpir-code/lib/merkle"
 	"github.com/si-co/vpir-code/lib/pgp"
 	"github.com/si-co/vpir-code/lib/utils"
+	"golang.org/x/xerrors"
 )
 
 const numKeysToDBLengthRatio float32 = 0.1
@@ -28,7 +29,8 @@ func GenerateRealKeyDB(dataPaths []string) (*DB, error) {
 
 	// only information needed for FSS-based schemes
 	info := Info{NumColumns: len(keys),
-		Merkle: &Merkle{ProofLen: 0, Root: []byte{0}}, // only for tests compatibility}
+		PIRType: PIRTypePredicate,
+		Merkle:  &Merkle{ProofLen: 0, Root: []byte{0}}, // only for tests compatibility}
 	}
 	// create empty database
 	db := NewKeysDB(info)
@@ -66,13 +68,18 @@ func GenerateRealKeyBytes(dataPaths []string, rebalanced bool) (*Bytes, error) {
 	numRows, numColumns := CalculateNumRowsAndColumns(preSquareNumBlocks, rebalanced)
 
 	ht := makeHashTable(keys, numRows*numColumns)
+
+	return embedHashTable(ht, numRows, numColumns), nil
+}
+
+// embedHashTable returns the bytes database holding the values of ht, padded
+// with PadWithSignalByte, in the blocks of their keys. The blocks without a
+// value are empty.
+func embedHashTable(ht map[int][]byte, numRows, numColumns int) *Bytes {
 	// get the maximum byte length of the values in the hashTable
 	// +1 takes into account the padding 0x80 that is always added.
 	blockLen := utils.MaxBytesLength(ht) + 1
 
-	// create all zeros db
-	db := InitBytes(numRows, numColumns, blockLen)
-
 	// order blocks because of map
 	blocks := make([][]byte, numRows*numColumns)
 	for k, v := range ht {
@@ -80,13 +87,7 @@ func GenerateRealKeyBytes(dataPaths []string, rebalanced bool) (*Bytes, error) {
 		blocks[k] = PadWithSignalByte(v)
 	}
 
-	// add blocks to the db with the according padding and store the length
-	for k, block := range blocks {
-		db.BlockLengths[k] = len(block)
-		db.Entries = append(db.Entries, block...)
-	}
-
-	return db, nil
+	return packBlocks(blocks, numRows, numColumns, blockLen)
 }
 
 func GenerateRealKeyMerkle(dataPaths []string, rebalanced bool) (*Bytes, error) {
@@ -138,7 +139,7 @@ func GenerateRealKeyMerkle(dataPaths []string, rebalanced bool) (*Bytes, error)
 			NumColumns:   numColumns,
 			BlockSize:    maxBlockLen,
 			BlockLengths: blockLens,
-			PIRType:      "merkle",
+			PIRType:      PIRTypeMerkle,
 			Merkle:       &Merkle{Root: tree.Root(), ProofLen: proofLen},
 		},
 	}
@@ -146,6 +147,40 @@ func GenerateRealKeyMerkle(dataPaths []string, rebalanced bool) (*Bytes, error)
 	return m, nil
 }
 
+// ListIDs returns the ids of all the keys embedded in a db generated by
+// GenerateRealKeyBytes or GenerateRealKeyMerkle, in the order of the blocks.
+// It reads the db in the clear, for administrative purposes only.
+func ListIDs(db *Bytes) ([]string, error) {
+	ids := make([]string, 0)
+	pos := 0
+	for i, bl := range db.BlockLengths {
+		block := db.Entries[pos : pos+bl]
+		pos += bl
+		// empty bucket of the hash table
+		if len(block) == 0 {
+			continue
+		}
+		block = UnPadBlock(block)
+		if db.PIRType == PIRTypeMerkle {
+			block = block[:len(block)-db.ProofLen]
+			if len(block) == 0 {
+				continue
+			}
+			block = UnPadBlock(block)
+		}
+
+		el, err := openpgp.ReadKeyRing(bytes.NewReader(block))
+		if err != nil {
+			return nil, xerrors.Errorf("failed to parse the keys of block %d: %v", i, err)
+		}
+		for _, e := range el {
+			ids = append(ids, pgp.PrimaryEmail(e))
+		}
+	}
+
+	return ids, nil
+}
+
 func makeHashTable(keys []*pgp.Key, tableLen int) map[int][]byte {
 	// prepare db
 	db := make(map[int][]byte)
@@ -159,16 +194,30 @@ func makeHashTable(keys []*pgp.Key, tableLen int) map[int][]byte {
 	return db
 }
 
+// DefaultPadByte is the byte that marks the end of the data of a block
+// padded by PadBlock and PadWithSignalByte
+const DefaultPadByte = byte(0x80)
+
 // Simple ISO/IEC 7816-4 padding where 0x80 is appended to the block, then
 // zeros to make up to blockLen
 func PadBlock(block []byte, blockLen int) []byte {
-	block = append(block, byte(0x80))
+	return PadBlockWith(block, blockLen, DefaultPadByte)
+}
+
+// PadBlockWith pads block as PadBlock, but marks the end of the data with
+// pad instead of 0x80. Since the marker is followed by zeros, pad must not
+// be zero.
+func PadBlockWith(block []byte, blockLen int, pad byte) []byte {
+	if pad == 0 {
+		panic("zero pad byte")
+	}
+	block = append(block, pad)
 	zeros := make([]byte, blockLen-(len(block)%blockLen))
 	return append(block, zeros...)
 }
 
 func PadWithSignalByte(block []byte) []byte {
-	return append(block, byte(0x80))
+	return append(block, DefaultPadByte)
 }
 
 func UnPadBlock(block []byte) []byte {
@@ -176,10 +225,33 @@ func UnPadBlock(block []byte) []byte {
 	block = bytes.TrimRightFunc(block, func(b rune) bool {
 		return b == 0
 	})
+	// a block of zeros has no padding to remove
+	if len(block) == 0 {
+		return block
+	}
 	// remove 0x80 preceding zeros
 	return block[:len(block)-1]
 }
 
+// UnPadBlockWith removes the padding added by PadBlockWith with pad. Unlike
+// UnPadBlock, it returns an error if the block does not end with pad
+// followed by zeros, since the end of its data is then unknown. The zeros
+// at the end of the data are kept, as they precede the marker.
+func UnPadBlockWith(block []byte, pad byte) ([]byte, error) {
+	if pad == 0 {
+		return nil, errors.New("zero pad byte")
+	}
+	end := len(block)
+	for end > 0 && block[end-1] == 0 {
+		end--
+	}
+	if end == 0 || block[end-1] != pad {
+		return nil, xerrors.Errorf("block of %d bytes without the %#x pad byte", len(block), pad)
+	}
+
+	return block[:end-1], nil
+}
+
 func sortById(keys []*pgp.Key) {
 	sort.Slice(keys, func(i, j int) bool {
 		return keys[i].ID > keys[j].ID
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nikirill/go-crypto/openpgp"
+	"github.com/nikirill/go-crypto/openpgp/packet"
+	"github.com/si-co/vpir-code/lib/field"
+	"github.com/si-co/vpir-code/lib/pgp"
+	"github.com/si-co/vpir-code/lib/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListIDs(t *testing.T) {
+	// enough keys for a hash table of a few blocks
+	ids := make([]string, 30)
+	path := filepath.Join(t.TempDir(), "sks-000.pgp")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	enc := gob.NewEncoder(f)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("user%d@example.com", i)
+		e, err := openpgp.NewEntity("user", "", ids[i], &packet.Config{RSABits: 1024})
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		require.NoError(t, e.Serialize(&buf))
+		require.NoError(t, enc.Encode(&pgp.Key{ID: ids[i], Packet: buf.Bytes()}))
+	}
+	require.NoError(t, f.Close())
+
+	db, err := GenerateRealKeyBytes([]string{path}, true)
+	require.NoError(t, err)
+	listed, err := ListIDs(db)
+	require.NoError(t, err)
+	require.ElementsMatch(t, ids, listed)
+
+	mdb, err := GenerateRealKeyMerkle([]string{path}, true)
+	require.NoError(t, err)
+	listed, err = ListIDs(mdb)
+	require.NoError(t, err)
+	require.ElementsMatch(t, ids, listed)
+}
+
+func TestPadBlockWith(t *testing.T) {
+	// data ending in zeros, as the last block of a key can
+	data := []byte{1, 2, 3, 0, 0}
+	for _, pad := range []byte{DefaultPadByte, 0x01, 0xff} {
+		padded := PadBlockWith(append([]byte{}, data...), 16, pad)
+		require.Len(t, padded, 16)
+		unpadded, err := UnPadBlockWith(padded, pad)
+		require.NoError(t, err)
+		require.Equal(t, data, unpadded)
+	}
+	require.Equal(t, PadBlock(append([]byte{}, data...), 16), PadBlockWith(append([]byte{}, data...), 16, DefaultPadByte))
+
+	// the end of the data is unknown without the marker
+	_, err := UnPadBlockWith([]byte{1, 2, 0, 0}, DefaultPadByte)
+	require.Error(t, err)
+	_, err = UnPadBlockWith(make([]byte, 4), DefaultPadByte)
+	require.Error(t, err)
+	_, err = UnPadBlockWith(PadBlock(data, 16), 0)
+	require.Error(t, err)
+}
+
+func TestOccupancy(t *testing.T) {
+	// a keyring filling part of the hash table, where the padding marks a
+	// record of zeros as occupied
+	ht := map[int][]byte{0: []byte("first"), 3: []byte("second key"), 7: {0}, 8: []byte("k"), 12: []byte("last")}
+	db := embedHashTable(ht, 4, 4)
+	occupied, total := OccupancyBytes(db)
+	require.Equal(t, len(ht), occupied)
+	require.Equal(t, 16, total)
+
+	// field elements, with two blocks of zeros
+	fdb, err := DBFromBytes(BytesFromDB(&DB{Entries: []uint32{0, 0, 5, 0, 0, 0, 0, 7},
+		Info: Info{NumRows: 2, NumColumns: 2, BlockSize: 2}}))
+	require.NoError(t, err)
+	occupied, total = Occupancy(fdb)
+	require.Equal(t, 2, occupied)
+	require.Equal(t, 4, total)
+
+	// blocks shorter than the block size, each starting at a multiple of
+	// it, where the element past the payload of the third block does not
+	// count
+	sdb := &DB{Entries: []uint32{0, 0, 0, 0, 0, 4, 7, 0},
+		Info: Info{NumRows: 2, NumColumns: 2, BlockSize: 2, BlockLengths: []int{2, 1, 1, 1}}}
+	occupied, total = Occupancy(sdb)
+	require.Equal(t, 1, occupied)
+	require.Equal(t, 4, total)
+
+	// random blocks are all occupied, unless zeroed
+	rdb, err := CreateRandomBitsDB(utils.RandomPRG(), 8*field.Bytes*4*16*4, 4, 4)
+	require.NoError(t, err)
+	occupied, total = Occupancy(rdb)
+	require.Equal(t, total, occupied)
+	for i := 0; i < 4*rdb.BlockSize; i++ {
+		rdb.Entries[i] = 0
+	}
+	occupied, _ = Occupancy(rdb)
+	require.Equal(t, total-4, occupied)
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+
+	"github.com/si-co/vpir-code/lib/utils"
+	"golang.org/x/xerrors"
+)
+
+// RecordLengthPrefix is the length in bytes of the prefix holding the
+// length of a record embedded by EmbedRecords
+const RecordLengthPrefix = 4
+
+// RecordSpan locates a record embedded by EmbedRecords, e.g., to retrieve
+// it with client.PIR.RetrieveRecord
+type RecordSpan struct {
+	StartBlock int
+	NumBlocks  int
+}
+
+// EmbedRecords returns a bytes database with blocks of blockLen bytes
+// holding the records, in order, and where each record starts. Every record
+// starts at a new block and is framed as follows:
+//
+//	bytes [0, 4)      length n of the record, big-endian uint32
+//	bytes [4, 4+n)    the record
+//	bytes [4+n, end)  zeros up to the end of the last block of the record
+//
+// so that a record of n bytes spans ceil((4+n)/blockLen) blocks. The
+// blocks after the last record are empty. As for GenerateRealKeyBytes, the
+// block lengths of the info count the bytes of the frame in each block and
+// the entries only hold these bytes, i.e., the frames one after the other.
+// The zeros are added by the servers when answering, and the blocks
+// reconstructed by the client stop at the end of the record. The layout is
+// rebalanced as by CalculateNumRowsAndColumns. Use ExtractRecord to get a
+// record back from its blocks.
+func EmbedRecords(records [][]byte, blockLen int, rebalanced bool) (*Bytes, []RecordSpan, error) {
+	if blockLen <= 0 {
+		return nil, nil, xerrors.Errorf("invalid block length %d", blockLen)
+	}
+	if len(records) == 0 {
+		return nil, nil, xerrors.New("no records to embed")
+	}
+
+	b := newRecordBuilder(blockLen)
+	for i, r := range records {
+		if err := b.add(r); err != nil {
+			return nil, nil, xerrors.Errorf("record %d: %v", i, err)
+		}
+	}
+	db, spans := b.build(rebalanced)
+
+	return db, spans, nil
+}
+
+// ExtractRecord returns the record framed as by EmbedRecords at the
+// beginning of data, i.e., the concatenation of the blocks of the record.
+// The bytes after the record are ignored. It returns an error if data is
+// shorter than the length in its prefix.
+func ExtractRecord(data []byte) ([]byte, error) {
+	if len(data) < RecordLengthPrefix {
+		return nil, xerrors.Errorf("record of %d bytes without the length prefix", len(data))
+	}
+	n := binary.BigEndian.Uint32(data)
+	if uint64(n) > uint64(len(data)-RecordLengthPrefix) {
+		return nil, xerrors.Errorf("record of %d bytes, the prefix gives %d bytes", len(data)-RecordLengthPrefix, n)
+	}
+
+	return data[RecordLengthPrefix : RecordLengthPrefix+int(n)], nil
+}
+
+// BuildFromRecords is as EmbedRecords, with the records read from a channel
+// until it is closed, e.g., by a goroutine reading them from a file. A
+// record is read only once the previous one is packed, so that a producer
+// sending on an unbuffered channel is slowed down to the pace of the
+// packing. The records are not buffered: the memory used is the one of the
+// returned database, i.e., the frames of the records one after the other,
+// plus a block length per block and a span per record. The geometry of the
+// database is computed once the channel is closed. It returns ctx.Err() if
+// ctx is done before the channel is closed, leaving the remaining records
+// unread.
+func BuildFromRecords(ctx context.Context, records <-chan []byte, blockLen int, rebalanced bool) (*Bytes, []RecordSpan, error) {
+	if blockLen <= 0 {
+		return nil, nil, xerrors.Errorf("invalid block length %d", blockLen)
+	}
+
+	b := newRecordBuilder(blockLen)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case r, ok := <-records:
+			if !ok {
+				if len(b.spans) == 0 {
+					return nil, nil, xerrors.New("no records to embed")
+				}
+				db, spans := b.build(rebalanced)
+				return db, spans, nil
+			}
+			if err := b.add(r); err != nil {
+				return nil, nil, xerrors.Errorf("record %d: %v", len(b.spans), err)
+			}
+		}
+	}
+}
+
+// recordBuilder packs the frames of the records as they come, before the
+// number of blocks, and hence the geometry of the database, is known
+type recordBuilder struct {
+	blockLen     int
+	entries      []byte
+	blockLengths []int
+	spans        []RecordSpan
+}
+
+func newRecordBuilder(blockLen int) *recordBuilder {
+	return &recordBuilder{blockLen: blockLen}
+}
+
+func (b *recordBuilder) add(r []byte) error {
+	if uint64(len(r)) > math.MaxUint32 {
+		return xerrors.Errorf("record of %d bytes is too long", len(r))
+	}
+
+	frameLen := RecordLengthPrefix + len(r)
+	n := (frameLen + b.blockLen - 1) / b.blockLen
+	b.spans = append(b.spans, RecordSpan{StartBlock: len(b.blockLengths), NumBlocks: n})
+	var prefix [RecordLengthPrefix]byte
+	binary.BigEndian.PutUint32(prefix[:], uint32(len(r)))
+	b.entries = append(b.entries, prefix[:]...)
+	b.entries = append(b.entries, r...)
+	for i := 0; i < n; i++ {
+		b.blockLengths = append(b.blockLengths, utils.Min(b.blockLen, frameLen-i*b.blockLen))
+	}
+
+	return nil
+}
+
+func (b *recordBuilder) build(rebalanced bool) (*Bytes, []RecordSpan) {
+	numRows, numColumns := CalculateNumRowsAndColumns(len(b.blockLengths), rebalanced)
+	db := InitBytes(numRows, numColumns, b.blockLen)
+	db.Entries = b.entries
+	copy(db.BlockLengths, b.blockLengths)
+
+	return db, b.spans
+}
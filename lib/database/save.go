@@ -0,0 +1,333 @@
+package database
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"os"
+	"runtime"
+
+	"golang.org/x/xerrors"
+)
+
+// chunkLength is the maximal number of entries bytes encoded together when
+// saving a bytes database to disk. It is a variable only for tests.
+var chunkLength = 1 << 24
+
+// chunkResult is the outcome of encoding or decoding a single chunk
+type chunkResult struct {
+	data []byte
+	err  error
+}
+
+// saveInfo is the header of a database file. It is followed by NumChunks
+// chunks, that concatenated in order give the entries of the database. The
+// single-server authentication info is not saved, since the schemes using it
+// build their databases in memory.
+//
+// A bytes database extended with AppendBytes has further segments after the
+// chunks, each made of an updated header, encoded as a byte slice, and of
+// the NumChunks chunks it appends. EntriesLength is always the total length
+// of the entries up to the end of the segment.
+type saveInfo struct {
+	NumRows      int
+	NumColumns   int
+	BlockSize    int
+	BlockLengths []int
+	PIRType      PIRType
+	Merkle       *Merkle
+	BlockHashes  *BlockHashes
+	ShardRange   *ShardRange
+
+	EntriesLength int
+	NumChunks     int
+}
+
+func newSaveInfo(info Info) *saveInfo {
+	return &saveInfo{
+		NumRows:      info.NumRows,
+		NumColumns:   info.NumColumns,
+		BlockSize:    info.BlockSize,
+		BlockLengths: info.BlockLengths,
+		PIRType:      info.PIRType,
+		Merkle:       info.Merkle,
+		BlockHashes:  info.BlockHashes,
+		ShardRange:   info.ShardRange,
+	}
+}
+
+func (si *saveInfo) info() Info {
+	return Info{
+		NumRows:      si.NumRows,
+		NumColumns:   si.NumColumns,
+		BlockSize:    si.BlockSize,
+		BlockLengths: si.BlockLengths,
+		PIRType:      si.PIRType,
+		Merkle:       si.Merkle,
+		BlockHashes:  si.BlockHashes,
+		ShardRange:   si.ShardRange,
+	}
+}
+
+// SaveBytes writes the given bytes database to the file at path, overwriting
+// the file if it already exists. The entries are gob-encoded in chunks of at
+// most chunkLength bytes, so that a database never needs to be encoded as a
+// single message.
+func SaveBytes(path string, b *Bytes) error {
+	return SaveBytesWithMAC(path, b, nil)
+}
+
+func writeBytes(w io.Writer, b *Bytes) error {
+	numChunks := (len(b.Entries) + chunkLength - 1) / chunkLength
+	enc := gob.NewEncoder(w)
+	si := newSaveInfo(b.Info)
+	si.EntriesLength = len(b.Entries)
+	si.NumChunks = numChunks
+	if err := enc.Encode(si); err != nil {
+		return xerrors.Errorf("failed to encode db info: %v", err)
+	}
+
+	// chunks are encoded in parallel, but written in order
+	replies := encodeChunks(b.Entries, numChunks)
+	for i := 0; i < numChunks; i++ {
+		r := <-replies[i]
+		if r.err != nil {
+			return xerrors.Errorf("failed to encode chunk %d: %v", i, r.err)
+		}
+		if err := enc.Encode(r.data); err != nil {
+			return xerrors.Errorf("failed to write chunk %d: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+// LoadBytes reads a bytes database previously written with SaveBytes and
+// possibly extended with AppendBytes
+func LoadBytes(path string) (*Bytes, error) {
+	return LoadBytesWithMAC(path, nil)
+}
+
+func readBytes(r io.Reader) (*Bytes, error) {
+	// the encoded chunks are read in order and decoded in parallel
+	si, encoded, err := readSegments(gob.NewDecoder(r))
+	if err != nil {
+		return nil, err
+	}
+	replies := decodeChunks(encoded)
+
+	entries := make([]byte, 0, si.EntriesLength)
+	for i := range encoded {
+		r := <-replies[i]
+		if r.err != nil {
+			return nil, xerrors.Errorf("failed to decode chunk %d: %v", i, r.err)
+		}
+		entries = append(entries, r.data...)
+	}
+	if len(entries) != si.EntriesLength {
+		return nil, xerrors.Errorf("wrong entries length: expected %d, got %d",
+			si.EntriesLength, len(entries))
+	}
+
+	return &Bytes{Entries: entries, Info: si.info()}, nil
+}
+
+// AppendBytes appends the given entries, a whole number of blocks, to the
+// bytes database saved at path. The entries already saved are not
+// rewritten: the new chunks are written at the end of the file, after the
+// updated header. Only databases in vector layout can be extended, since
+// in matrix layout the new blocks would change the rows, and databases
+// authenticated with a Merkle tree or block hashes cannot be extended.
+func AppendBytes(path string, entries []byte) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return xerrors.Errorf("failed to open db file: %v", err)
+	}
+	defer f.Close()
+
+	si, _, err := readSegments(gob.NewDecoder(f))
+	if err != nil {
+		return err
+	}
+	switch {
+	case si.NumRows != 1:
+		return xerrors.Errorf("cannot append to a db with %d rows, only vector layout is supported", si.NumRows)
+	case si.PIRType == PIRTypeMerkle || si.BlockHashes != nil:
+		return xerrors.New("cannot append to an authenticated db")
+	case si.BlockSize <= 0 || len(entries)%si.BlockSize != 0:
+		return xerrors.Errorf("entries of length %d are not a whole number of %d-byte blocks",
+			len(entries), si.BlockSize)
+	}
+
+	numBlocks := len(entries) / si.BlockSize
+	si.NumColumns += numBlocks
+	if si.BlockLengths != nil {
+		for i := 0; i < numBlocks; i++ {
+			si.BlockLengths = append(si.BlockLengths, si.BlockSize)
+		}
+	}
+	si.EntriesLength += len(entries)
+	si.NumChunks = (len(entries) + chunkLength - 1) / chunkLength
+
+	var header bytes.Buffer
+	if err := gob.NewEncoder(&header).Encode(si); err != nil {
+		return xerrors.Errorf("failed to encode db info: %v", err)
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return xerrors.Errorf("failed to seek end of db file: %v", err)
+	}
+	// the header is written as a byte slice, which, as the chunks, does not
+	// need any type definition and can follow the data of another encoder
+	enc := gob.NewEncoder(f)
+	if err := enc.Encode(header.Bytes()); err != nil {
+		return xerrors.Errorf("failed to write db info: %v", err)
+	}
+	replies := encodeChunks(entries, si.NumChunks)
+	for i := 0; i < si.NumChunks; i++ {
+		r := <-replies[i]
+		if r.err != nil {
+			return xerrors.Errorf("failed to encode chunk %d: %v", i, r.err)
+		}
+		if err := enc.Encode(r.data); err != nil {
+			return xerrors.Errorf("failed to write chunk %d: %v", i, err)
+		}
+	}
+
+	return f.Close()
+}
+
+// readSegments reads the header and the chunks of a bytes database file and
+// of all the segments appended to it. It returns the last header and the
+// encoded chunks in order.
+func readSegments(dec *gob.Decoder) (*saveInfo, [][]byte, error) {
+	si := new(saveInfo)
+	if err := dec.Decode(si); err != nil {
+		return nil, nil, xerrors.Errorf("failed to decode db info: %v", err)
+	}
+
+	var encoded [][]byte
+	for segment := 0; ; segment++ {
+		for i := 0; i < si.NumChunks; i++ {
+			var chunk []byte
+			if err := dec.Decode(&chunk); err != nil {
+				return nil, nil, xerrors.Errorf("failed to read chunk %d: %v", len(encoded), err)
+			}
+			encoded = append(encoded, chunk)
+		}
+
+		var header []byte
+		err := dec.Decode(&header)
+		if err == io.EOF {
+			return si, encoded, nil
+		}
+		if err != nil {
+			return nil, nil, xerrors.Errorf("failed to read header of segment %d: %v", segment+1, err)
+		}
+		si = new(saveInfo)
+		if err := gob.NewDecoder(bytes.NewReader(header)).Decode(si); err != nil {
+			return nil, nil, xerrors.Errorf("failed to decode header of segment %d: %v", segment+1, err)
+		}
+	}
+}
+
+// SaveDB writes the given database, including the keys information used by
+// the FSS-based schemes, to the file at path
+func SaveDB(path string, d *DB) error {
+	return SaveDBWithMAC(path, d, nil)
+}
+
+func writeDB(w io.Writer, d *DB) error {
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(newSaveInfo(d.Info)); err != nil {
+		return xerrors.Errorf("failed to encode db info: %v", err)
+	}
+	if err := enc.Encode(d.KeysInfo); err != nil {
+		return xerrors.Errorf("failed to encode keys info: %v", err)
+	}
+	if err := enc.Encode(d.Entries); err != nil {
+		return xerrors.Errorf("failed to encode entries: %v", err)
+	}
+
+	return nil
+}
+
+// LoadDB reads a database previously written with SaveDB
+func LoadDB(path string) (*DB, error) {
+	return LoadDBWithMAC(path, nil)
+}
+
+func readDB(r io.Reader) (*DB, error) {
+	dec := gob.NewDecoder(r)
+	si := new(saveInfo)
+	if err := dec.Decode(si); err != nil {
+		return nil, xerrors.Errorf("failed to decode db info: %v", err)
+	}
+	d := NewKeysDB(si.info())
+	if err := dec.Decode(&d.KeysInfo); err != nil {
+		return nil, xerrors.Errorf("failed to decode keys info: %v", err)
+	}
+	if err := dec.Decode(&d.Entries); err != nil {
+		return nil, xerrors.Errorf("failed to decode entries: %v", err)
+	}
+
+	return d, nil
+}
+
+// encodeChunks gob-encodes the entries in chunks of chunkLength bytes using
+// a pool of workers. The i-th channel returns the encoding of the i-th chunk.
+func encodeChunks(entries []byte, numChunks int) []chan chunkResult {
+	return processChunks(numChunks, func(i int) chunkResult {
+		end := (i + 1) * chunkLength
+		if end > len(entries) {
+			end = len(entries)
+		}
+		data, err := encodeChunk(entries[i*chunkLength : end])
+		return chunkResult{data: data, err: err}
+	})
+}
+
+// encodeChunk gob-encodes a single chunk of entries
+func encodeChunk(chunk []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(chunk)
+	return buf.Bytes(), err
+}
+
+// decodeChunks decodes the chunks produced by encodeChunks using a pool of
+// workers. The i-th channel returns the entries of the i-th chunk.
+func decodeChunks(encoded [][]byte) []chan chunkResult {
+	return processChunks(len(encoded), func(i int) chunkResult {
+		var chunk []byte
+		err := gob.NewDecoder(bytes.NewReader(encoded[i])).Decode(&chunk)
+		return chunkResult{data: chunk, err: err}
+	})
+}
+
+// processChunks runs f on every chunk index with at most runtime.NumCPU()
+// goroutines, and returns one buffered reply channel per chunk
+func processChunks(numChunks int, f func(int) chunkResult) []chan chunkResult {
+	replies := make([]chan chunkResult, numChunks)
+	for i := range replies {
+		replies[i] = make(chan chunkResult, 1)
+	}
+
+	jobs := make(chan int, numChunks)
+	for i := 0; i < numChunks; i++ {
+		jobs <- i
+	}
+	close(jobs)
+
+	NGoRoutines := runtime.NumCPU()
+	if NGoRoutines > numChunks {
+		NGoRoutines = numChunks
+	}
+	for j := 0; j < NGoRoutines; j++ {
+		go func() {
+			for i := range jobs {
+				replies[i] <- f(i)
+			}
+		}()
+	}
+
+	return replies
+}
@@ -0,0 +1,298 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/si-co/vpir-code/lib/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveLoadBytes(t *testing.T) {
+	db, err := CreateRandomBytes(utils.RandomPRG(), 8*4*16*10, 4, 16)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "db")
+	require.NoError(t, SaveBytes(path, db))
+
+	loaded, err := LoadBytes(path)
+	require.NoError(t, err)
+	require.Nil(t, DiffBytes(db, loaded))
+}
+
+func TestSaveLoadDB(t *testing.T) {
+	db, err := CreateRandomKeysDB(utils.RandomPRG(), 100)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "db")
+	require.NoError(t, SaveDB(path, db))
+
+	loaded, err := LoadDB(path)
+	require.NoError(t, err)
+	require.Nil(t, Diff(db, loaded))
+}
+
+func TestSaveLoadBytesMultiChunk(t *testing.T) {
+	defer func(l int) { chunkLength = l }(chunkLength)
+	chunkLength = 100
+
+	// 8*4*16*10/8 = 640 entries bytes, i.e., 7 chunks with a partial one
+	db, err := CreateRandomBytes(utils.RandomPRG(), 8*4*16*10, 4, 16)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "db")
+	require.NoError(t, SaveBytes(path, db))
+
+	loaded, err := LoadBytes(path)
+	require.NoError(t, err)
+	require.Nil(t, DiffBytes(db, loaded))
+}
+
+func TestSaveFailingMidway(t *testing.T) {
+	db, err := CreateRandomBytes(utils.RandomPRG(), 8*4*16*10, 4, 16)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db")
+	require.NoError(t, SaveBytes(path, db))
+
+	// a save failing after a partial write leaves the previous file
+	errWrite := errors.New("write failed")
+	err = saveFile(path, nil, func(w io.Writer) error {
+		if _, err := w.Write([]byte("partial")); err != nil {
+			return err
+		}
+		return errWrite
+	})
+	require.ErrorIs(t, err, errWrite)
+	loaded, err := LoadBytes(path)
+	require.NoError(t, err)
+	require.Nil(t, DiffBytes(db, loaded))
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	// and the save can be retried
+	other, err := CreateRandomBytes(utils.RandomPRG(), 8*4*16*10, 4, 16)
+	require.NoError(t, err)
+	require.NoError(t, SaveBytes(path, other))
+	loaded, err = LoadBytes(path)
+	require.NoError(t, err)
+	require.Nil(t, DiffBytes(other, loaded))
+}
+
+func TestSaveLoadBytesWithMAC(t *testing.T) {
+	defer func(l int) { chunkLength = l }(chunkLength)
+	chunkLength = 100
+
+	db, err := CreateRandomBytes(utils.RandomPRG(), 8*4*16*10, 4, 16)
+	require.NoError(t, err)
+	key := []byte("db file key")
+	path := filepath.Join(t.TempDir(), "db")
+	require.NoError(t, SaveBytesWithMAC(path, db, key))
+
+	loaded, err := LoadBytesWithMAC(path, key)
+	require.NoError(t, err)
+	require.Nil(t, DiffBytes(db, loaded))
+	_, err = LoadBytesWithMAC(path, []byte("other key"))
+	require.EqualError(t, err, "db file does not match its MAC")
+
+	// changing a byte of any chunk goes unnoticed without the key, but not
+	// with it
+	saved, err := os.ReadFile(path)
+	require.NoError(t, err)
+	for off := 0; off < len(db.Entries); off += chunkLength {
+		pos := bytes.Index(saved, db.Entries[off:off+10])
+		require.Positive(t, pos)
+		tampered := append([]byte(nil), saved...)
+		tampered[pos] ^= 1
+		require.NoError(t, os.WriteFile(path, tampered, 0644))
+
+		_, err := LoadBytes(path)
+		require.NoError(t, err)
+		_, err = LoadBytesWithMAC(path, key)
+		require.EqualError(t, err, "db file does not match its MAC", "chunk at %d", off)
+	}
+
+	// saving without key removes the MAC, now stale, and a missing MAC is
+	// an error under a key
+	require.NoError(t, SaveBytes(path, db))
+	require.NoFileExists(t, MACPath(path))
+	_, err = LoadBytesWithMAC(path, key)
+	require.Error(t, err)
+}
+
+func TestSaveLoadDBWithMAC(t *testing.T) {
+	db, err := CreateRandomKeysDB(utils.RandomPRG(), 100)
+	require.NoError(t, err)
+	key := []byte("db file key")
+	path := filepath.Join(t.TempDir(), "db")
+	require.NoError(t, SaveDBWithMAC(path, db, key))
+
+	loaded, err := LoadDBWithMAC(path, key)
+	require.NoError(t, err)
+	require.Nil(t, Diff(db, loaded))
+
+	saved, err := os.ReadFile(path)
+	require.NoError(t, err)
+	saved[len(saved)-1] ^= 1
+	require.NoError(t, os.WriteFile(path, saved, 0644))
+	_, err = LoadDBWithMAC(path, key)
+	require.EqualError(t, err, "db file does not match its MAC")
+}
+
+func TestMapBytesWithMAC(t *testing.T) {
+	db := randomBytesDB(t)
+	key := []byte("db file key")
+	path := filepath.Join(t.TempDir(), "db")
+	require.NoError(t, SaveBytesFlatWithMAC(path, db, key))
+
+	mapped, unmap, err := MapBytesWithMAC(path, key)
+	require.NoError(t, err)
+	require.Nil(t, DiffBytes(db, mapped))
+	require.NoError(t, unmap())
+	_, _, err = MapBytesWithMAC(path, []byte("other key"))
+	require.EqualError(t, err, "db file does not match its MAC")
+
+	saved, err := os.ReadFile(path)
+	require.NoError(t, err)
+	saved[len(saved)-1] ^= 1
+	require.NoError(t, os.WriteFile(path, saved, 0644))
+	_, _, err = MapBytesWithMAC(path, key)
+	require.EqualError(t, err, "db file does not match its MAC")
+}
+
+func BenchmarkSaveLoadBytes(b *testing.B) {
+	// 64 MiB of entries, i.e., four chunks
+	db, err := CreateRandomBytes(utils.RandomPRG(), 8*1024*1024*64, 1024, 1024)
+	require.NoError(b, err)
+	path := filepath.Join(b.TempDir(), "db")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		require.NoError(b, SaveBytes(path, db))
+		_, err := LoadBytes(path)
+		require.NoError(b, err)
+	}
+}
+
+func TestBytesBuilder(t *testing.T) {
+	defer func(l int) { chunkLength = l }(chunkLength)
+	// memory cap of the builder
+	chunkLength = 64
+
+	db, err := CreateRandomBytes(utils.RandomPRG(), 8*4*16*10, 4, 16)
+	require.NoError(t, err)
+	require.Greater(t, len(db.Entries), 5*chunkLength)
+
+	path := filepath.Join(t.TempDir(), "db")
+	b, err := NewBytesBuilder(path)
+	require.NoError(t, err)
+	// write the entries as a stream of small pieces
+	for i := 0; i < len(db.Entries); i += 10 {
+		end := i + 10
+		if end > len(db.Entries) {
+			end = len(db.Entries)
+		}
+		_, err := b.Write(db.Entries[i:end])
+		require.NoError(t, err)
+		require.LessOrEqual(t, cap(b.chunk), chunkLength)
+	}
+	require.NoError(t, b.Finalize(db.Info))
+
+	loaded, err := LoadBytes(path)
+	require.NoError(t, err)
+	require.Nil(t, DiffBytes(db, loaded))
+}
+
+func TestAppendBytes(t *testing.T) {
+	defer func(l int) { chunkLength = l }(chunkLength)
+	chunkLength = 64
+
+	db, err := CreateRandomBytes(utils.RandomPRG(), 8*16*20, 1, 16)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "db")
+	require.NoError(t, SaveBytes(path, db))
+
+	// append twice, the second time a single block
+	for _, numBlocks := range []int{7, 1} {
+		newBlocks := make([]byte, numBlocks*db.BlockSize)
+		_, err := utils.RandomPRG().Read(newBlocks)
+		require.NoError(t, err)
+		require.NoError(t, AppendBytes(path, newBlocks))
+
+		db.Entries = append(db.Entries, newBlocks...)
+		db.NumColumns += numBlocks
+		for i := 0; i < numBlocks; i++ {
+			db.BlockLengths = append(db.BlockLengths, db.BlockSize)
+		}
+
+		loaded, err := LoadBytes(path)
+		require.NoError(t, err)
+		require.Nil(t, DiffBytes(db, loaded))
+
+		// the last block is the last appended one
+		last := loaded.NumColumns - 1
+		require.Equal(t, newBlocks[len(newBlocks)-db.BlockSize:],
+			loaded.Entries[last*loaded.BlockSize:(last+1)*loaded.BlockSize])
+	}
+
+	// partial blocks and matrix layout are rejected
+	require.Error(t, AppendBytes(path, make([]byte, db.BlockSize+1)))
+	matrix, err := CreateRandomBytes(utils.RandomPRG(), 8*4*16*4, 4, 16)
+	require.NoError(t, err)
+	matrixPath := filepath.Join(t.TempDir(), "matrix")
+	require.NoError(t, SaveBytes(matrixPath, matrix))
+	require.Error(t, AppendBytes(matrixPath, make([]byte, matrix.BlockSize)))
+}
+
+func TestMapBytes(t *testing.T) {
+	db := randomBytesDB(t)
+	path := filepath.Join(t.TempDir(), "db")
+	require.NoError(t, SaveBytesFlat(path, db))
+
+	mapped, unmap, err := MapBytes(path)
+	require.NoError(t, err)
+	require.Nil(t, DiffBytes(db, mapped))
+	require.NoError(t, unmap())
+
+	// a truncated file is rejected
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data[:len(data)-1], 0o644))
+	_, _, err = MapBytes(path)
+	require.Error(t, err)
+}
+
+func TestSaveBytesFlatWhileMapped(t *testing.T) {
+	db := randomBytesDB(t)
+	path := filepath.Join(t.TempDir(), "db")
+	require.NoError(t, SaveBytesFlat(path, db))
+	mapped, unmap, err := MapBytes(path)
+	require.NoError(t, err)
+	defer unmap()
+
+	// saving a smaller db replaces the file instead of truncating the one
+	// still mapped
+	other, err := CreateRandomBytes(utils.RandomPRG(), 8*16*16*32, 16, 32)
+	require.NoError(t, err)
+	require.NoError(t, SaveBytesFlat(path, other))
+	require.Nil(t, DiffBytes(db, mapped))
+
+	remapped, unmapOther, err := MapBytes(path)
+	require.NoError(t, err)
+	defer unmapOther()
+	require.Nil(t, DiffBytes(other, remapped))
+}
+
+// randomBytesDB returns a random bytes db of 16 rows of 16 blocks of 64
+// bytes, the db of most of the tests
+func randomBytesDB(t testing.TB) *Bytes {
+	t.Helper()
+	db, err := CreateRandomBytes(utils.RandomPRG(), 8*16*16*64, 16, 64)
+	require.NoError(t, err)
+	return db
+}
@@ -0,0 +1,82 @@
+package database
+
+import "golang.org/x/xerrors"
+
+// ShardRange is the range of columns, from Start included to End excluded,
+// of a logical database held by a shard. A shard holds these columns for all
+// the rows, so that the answer of a classical PIR server over the logical
+// database is the XOR of the answers of its shards.
+type ShardRange struct {
+	Start, End int
+}
+
+// Len returns the number of columns of the shard
+func (r ShardRange) Len() int {
+	return r.End - r.Start
+}
+
+// ShardBytes returns the shard of b holding the columns in r. The entries
+// are copied, so that b can be discarded once all its shards are created.
+// The block hashes of b are not kept, since the client checks the blocks
+// against the info of the logical database.
+func ShardBytes(b *Bytes, r ShardRange) (*Bytes, error) {
+	if r.Start < 0 || r.End > b.NumColumns || r.Start >= r.End {
+		return nil, xerrors.Errorf("shard of columns from %d to %d of a db with %d columns",
+			r.Start, r.End, b.NumColumns)
+	}
+
+	nCols := r.Len()
+	// a db without block lengths keeps all its blocks of the block size
+	var blockLens []int
+	if b.BlockLengths != nil {
+		blockLens = make([]int, 0, b.NumRows*nCols)
+	}
+	entries := make([]byte, 0, b.NumRows*nCols*b.BlockSize)
+	pos := 0
+	for i := 0; i < b.NumRows; i++ {
+		for j := 0; j < b.NumColumns; j++ {
+			l := b.PayloadLength(i*b.NumColumns + j)
+			if j >= r.Start && j < r.End {
+				if blockLens != nil {
+					blockLens = append(blockLens, l)
+				}
+				entries = append(entries, b.Entries[pos:pos+l]...)
+			}
+			pos += l
+		}
+	}
+
+	return &Bytes{
+		Entries: entries,
+		Info: Info{
+			NumRows:      b.NumRows,
+			NumColumns:   nCols,
+			BlockSize:    b.BlockSize,
+			BlockLengths: blockLens,
+			PIRType:      b.PIRType,
+			Merkle:       b.Merkle,
+			ShardRange:   &ShardRange{Start: r.Start, End: r.End},
+		},
+	}, nil
+}
+
+// SplitColumns returns numShards ranges of consecutive columns covering the
+// numColumns columns of a database, whose lengths differ by at most one.
+func SplitColumns(numColumns, numShards int) ([]ShardRange, error) {
+	if numShards < 1 || numShards > numColumns {
+		return nil, xerrors.Errorf("%d shards for a db with %d columns", numShards, numColumns)
+	}
+
+	shards := make([]ShardRange, numShards)
+	start := 0
+	for i := range shards {
+		end := start + numColumns/numShards
+		if i < numColumns%numShards {
+			end++
+		}
+		shards[i] = ShardRange{Start: start, End: end}
+		start = end
+	}
+
+	return shards, nil
+}
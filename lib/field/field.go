@@ -1,14 +1,133 @@
 package field
 
 import (
+	"bytes"
+	"crypto/rand"
 	"encoding/binary"
+	"encoding/hex"
 	"io"
+	"strconv"
+	"unsafe"
 
 	"github.com/si-co/vpir-code/lib/utils"
+	"golang.org/x/xerrors"
 )
 
+// Element is an element of the prime field of order ModP. It is a plain
+// value without any precomputed state, e.g., no multiplication table: every
+// operation reads only the current values of its operands, so an element
+// can be mutated by any operation and then used in any other one. The
+// operations set their receiver after reading their operands, so the
+// receiver may alias them.
 type Element uint32
 
+// Bytes returns the big-endian byte representation of the element, i.e.,
+// the Bytes bytes of its uint32 value with the most significant byte first.
+// This is the byte order of all the encodings of elements, e.g., of
+// utils.Uint32SliceToByteSlice used for the answers and the saved databases.
+func (e *Element) Bytes() []byte {
+	out := make([]byte, Bytes)
+	binary.BigEndian.PutUint32(out, uint32(*e))
+	return out
+}
+
+// SetBytes sets e to the element with the big-endian byte representation in,
+// as returned by Bytes, and returns e. It returns an error if in is not Bytes
+// bytes long or does not represent an element smaller than ModP. Unlike
+// BytesToElements, which maps arbitrary random bytes to elements, it never
+// changes the value represented by in.
+func (e *Element) SetBytes(in []byte) (*Element, error) {
+	if len(in) != Bytes {
+		return nil, xerrors.Errorf("element of %d bytes, expected %d bytes", len(in), Bytes)
+	}
+	v := binary.BigEndian.Uint32(in)
+	if v >= ModP {
+		return nil, xerrors.Errorf("value %d is not smaller than the modulus %d", v, ModP)
+	}
+	*e = Element(v)
+	return e, nil
+}
+
+// Equal returns true if e and x are the same element
+func (e *Element) Equal(x *Element) bool {
+	return *e == *x
+}
+
+// Add sets e to x + y mod ModP and returns e
+func (e *Element) Add(x, y *Element) *Element {
+	*e = Element((uint64(*x) + uint64(*y)) % uint64(ModP))
+	return e
+}
+
+// Sub sets e to x - y mod ModP and returns e. Unlike in GF(2^n), in this
+// prime field subtraction differs from addition.
+func (e *Element) Sub(x, y *Element) *Element {
+	*e = Element((uint64(*x) + uint64(ModP) - uint64(*y)) % uint64(ModP))
+	return e
+}
+
+// Mul sets e to x * y mod ModP and returns e
+func (e *Element) Mul(x, y *Element) *Element {
+	*e = Element((uint64(*x) * uint64(*y)) % uint64(ModP))
+	return e
+}
+
+// Neg sets e to -x mod ModP and returns e
+func (e *Element) Neg(x *Element) *Element {
+	*e = Element((uint64(ModP) - uint64(*x)) % uint64(ModP))
+	return e
+}
+
+// Cmp compares the big-endian byte representations of e and x and returns
+// -1 if e < x, 0 if e == x and +1 if e > x. This is a lexicographic order
+// usable for sorting and indexing, not an order on the field.
+func (e *Element) Cmp(x *Element) int {
+	return bytes.Compare(e.Bytes(), x.Bytes())
+}
+
+// String returns the decimal representation of the element, without sign
+// nor leading zeros, as parsed by FromString
+func (e *Element) String() string {
+	return strconv.FormatUint(uint64(*e), 10)
+}
+
+// HexString returns the hexadecimal representation of the big-endian bytes
+// of the element, as returned by Bytes, i.e., always 2*Bytes lowercase
+// digits. It is parsed by FromHexString.
+func (e *Element) HexString() string {
+	return hex.EncodeToString(e.Bytes())
+}
+
+// FromString returns the element with the decimal representation s, as
+// returned by String. It returns an error if s is not a decimal number
+// smaller than ModP.
+func FromString(s string) (*Element, error) {
+	v, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid element %q: %v", s, err)
+	}
+	if v >= uint64(ModP) {
+		return nil, xerrors.Errorf("value %d is not smaller than the modulus %d", v, ModP)
+	}
+	e := Element(v)
+	return &e, nil
+}
+
+// FromHexString returns the element with the hexadecimal representation s,
+// as returned by HexString. Upper-case digits are accepted. It returns an
+// error if s is not 2*Bytes hexadecimal digits representing an element
+// smaller than ModP.
+func FromHexString(s string) (*Element, error) {
+	if len(s) != 2*Bytes {
+		return nil, xerrors.Errorf("element of %d hex digits, expected %d digits", len(s), 2*Bytes)
+	}
+	in, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid element %q: %v", s, err)
+	}
+	return new(Element).SetBytes(in)
+}
+
 const (
 	ModP                 = uint32(2147483647) // 2^31 - 1
 	Bytes                = 4
@@ -17,6 +136,9 @@ const (
 	ConcurrentExecutions = 4
 )
 
+// Bytes must be the size of an Element, this fails to compile otherwise
+var _ [Bytes]byte = [unsafe.Sizeof(Element(0))]byte{}
+
 func NegateVector(in []uint32) []uint32 {
 	for i := range in {
 		in[i] = ModP - in[i]
@@ -90,6 +212,49 @@ func RandVector(length int) []uint32 {
 	return RandVectorWithPRG(length, utils.RandomPRG())
 }
 
+// RandomFrom returns an element sampled uniformly with the bytes read from
+// rnd, e.g., a deterministic reader in tests. It returns an error if rnd
+// fails before an element is sampled.
+func RandomFrom(rnd io.Reader) (*Element, error) {
+	var buf [Bytes]byte
+	for {
+		if _, err := io.ReadFull(rnd, buf[:]); err != nil {
+			return nil, xerrors.Errorf("error in randomness: %v", err)
+		}
+		// Clearing the top most bit of uint32
+		buf[0] &= Mask
+		// Make sure that the element is not equal 2^31 - 1
+		if v := binary.BigEndian.Uint32(buf[:]); v != ModP {
+			e := Element(v)
+			return &e, nil
+		}
+	}
+}
+
+// Random returns an element sampled with crypto/rand, see RandomFrom
+func Random() *Element {
+	e, err := RandomFrom(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// RandomPRG returns an element sampled from prg. Since prg is deterministic,
+// the same key always yields the same sequence of elements.
+func RandomPRG(prg *utils.PRGReader) *Element {
+	e := Element(RandElementWithPRG(prg))
+	return &e
+}
+
+// RandomVectorPRGInto fills out with elements sampled from prg, in order. It
+// yields the same elements as len(out) successive calls to RandomPRG.
+func RandomVectorPRGInto(out []Element, prg *utils.PRGReader) {
+	for i := range out {
+		out[i] = Element(RandElementWithPRG(prg))
+	}
+}
+
 func BytesToElements(out []uint32, in []byte) {
 	//if len(in) % Bytes != 0 {
 	//	padding := make([]byte, Bytes-len(in) % Bytes)
@@ -0,0 +1,201 @@
+package field
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"testing"
+	"testing/iotest"
+
+	"github.com/si-co/vpir-code/lib/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCmpSort(t *testing.T) {
+	elements := make([]Element, 1000)
+	for i, e := range RandVector(len(elements)) {
+		elements[i] = Element(e)
+	}
+	// add duplicates
+	elements = append(elements, elements[:10]...)
+
+	sort.Slice(elements, func(i, j int) bool {
+		return elements[i].Cmp(&elements[j]) < 0
+	})
+
+	for i := 1; i < len(elements); i++ {
+		c := elements[i-1].Cmp(&elements[i])
+		require.True(t, c <= 0)
+		require.Equal(t, c == 0, elements[i-1].Equal(&elements[i]))
+		require.Equal(t, -c, elements[i].Cmp(&elements[i-1]))
+	}
+}
+
+func TestCmpZero(t *testing.T) {
+	var zero, other Element
+	require.True(t, zero.Equal(&other))
+	require.Equal(t, 0, zero.Cmp(&other))
+
+	other = 1
+	require.False(t, zero.Equal(&other))
+	require.Equal(t, -1, zero.Cmp(&other))
+	require.Equal(t, 1, other.Cmp(&zero))
+}
+
+func TestAddSubNeg(t *testing.T) {
+	var zero, sum, diff, neg Element
+	for i := 0; i < 1000; i++ {
+		x, y := Element(RandElement()), Element(RandElement())
+
+		sum.Add(&x, &y)
+		require.Equal(t, x, *diff.Sub(&sum, &y))
+		require.Equal(t, y, *diff.Sub(&sum, &x))
+
+		// x - y == x + (-y)
+		neg.Neg(&y)
+		require.Equal(t, *diff.Sub(&x, &y), *sum.Add(&x, &neg))
+		require.Equal(t, zero, *sum.Add(&y, &neg))
+	}
+
+	require.Equal(t, zero, *neg.Neg(&zero))
+	one := Element(1)
+	require.Equal(t, Element(ModP-1), *neg.Neg(&one))
+}
+
+func TestMulAfterMutation(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		x, y := Element(RandElement()), Element(RandElement())
+
+		// mutate e with every operation, in place, and multiply it
+		e := x
+		e.Add(&e, &y)
+		e.Mul(&e, &y)
+		e.Sub(&e, &x)
+		e.Neg(&e)
+		_, err := e.SetBytes(y.Bytes())
+		require.NoError(t, err)
+		e.Add(&e, &x)
+		got := e
+		got.Mul(&got, &y)
+
+		// the same product computed from fresh elements
+		var sum, expected Element
+		sum.Add(&y, &x)
+		expected.Mul(&sum, &y)
+		require.Equal(t, expected, got)
+
+		// squaring in place
+		sq := x
+		sq.Mul(&sq, &sq)
+		require.Equal(t, *expected.Mul(&x, &x), sq)
+	}
+}
+
+func TestRandomPRGDeterministic(t *testing.T) {
+	key := utils.RandomPRGKey()
+	prg1 := utils.NewPRG(key)
+	prg2 := utils.NewPRG(key)
+
+	for i := 0; i < 100; i++ {
+		e1, e2 := RandomPRG(prg1), RandomPRG(prg2)
+		require.True(t, e1.Equal(e2))
+		require.Less(t, uint32(*e1), ModP)
+	}
+
+	v1 := make([]Element, 100)
+	v2 := make([]Element, 100)
+	RandomVectorPRGInto(v1, prg1)
+	RandomVectorPRGInto(v2, prg2)
+	require.Equal(t, v1, v2)
+
+	// the vector is the same as successive single elements
+	prg3 := utils.NewPRG(key)
+	for i := 0; i < 100; i++ {
+		RandomPRG(prg3)
+	}
+	for i := range v1 {
+		require.Equal(t, v1[i], *RandomPRG(prg3))
+	}
+}
+
+func TestRandomFrom(t *testing.T) {
+	// the top bit is cleared and ModP is rejected
+	e, err := RandomFrom(bytes.NewReader([]byte{0x80, 0, 0, 5}))
+	require.NoError(t, err)
+	require.Equal(t, Element(5), *e)
+	e, err = RandomFrom(bytes.NewReader([]byte{0x7f, 0xff, 0xff, 0xff, 0, 0, 0, 7}))
+	require.NoError(t, err)
+	require.Equal(t, Element(7), *e)
+
+	_, err = RandomFrom(iotest.ErrReader(errors.New("no randomness")))
+	require.ErrorContains(t, err, "no randomness")
+	_, err = RandomFrom(bytes.NewReader([]byte{1, 2}))
+	require.Error(t, err)
+
+	require.Less(t, uint32(*Random()), ModP)
+}
+
+func TestElementBytesLength(t *testing.T) {
+	for _, e := range RandVector(10) {
+		el := Element(e)
+		require.Len(t, el.Bytes(), Bytes)
+	}
+}
+
+func TestSetBytes(t *testing.T) {
+	var e, x Element
+	for _, v := range append(RandVector(1000), 0, 1, ModP-1) {
+		e = Element(v)
+		b := e.Bytes()
+		// most significant byte first
+		require.Equal(t, []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}, b)
+
+		_, err := x.SetBytes(b)
+		require.NoError(t, err)
+		require.Equal(t, e, x)
+
+		// the slice encodings and the decoding of random bytes agree
+		require.Equal(t, b, utils.Uint32SliceToByteSlice([]uint32{v}))
+		require.Equal(t, []uint32{v}, utils.ByteSliceToUint32Slice(b))
+		out := make([]uint32, 1)
+		BytesToElements(out, b)
+		require.Equal(t, v, out[0])
+	}
+
+	_, err := x.SetBytes([]byte{0x7f, 0xff, 0xff, 0xff})
+	require.Error(t, err)
+	_, err = x.SetBytes([]byte{1, 2, 3})
+	require.Error(t, err)
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	for _, v := range append(RandVector(1000), 0, 1, ModP-1) {
+		e := Element(v)
+
+		x, err := FromHexString(e.HexString())
+		require.NoError(t, err)
+		require.Equal(t, e, *x)
+		require.Len(t, e.HexString(), 2*Bytes)
+
+		x, err = FromString(e.String())
+		require.NoError(t, err)
+		require.Equal(t, e, *x)
+	}
+
+	e := Element(0xabcdef)
+	require.Equal(t, "00abcdef", e.HexString())
+	require.Equal(t, "11259375", e.String())
+	x, err := FromHexString("00ABCDEF")
+	require.NoError(t, err)
+	require.Equal(t, e, *x)
+
+	// wrong length, not hex, ModP and above
+	for _, s := range []string{"", "abcdef", "0000000001", "0000000g", "7fffffff", "ffffffff"} {
+		_, err := FromHexString(s)
+		require.Error(t, err, s)
+	}
+	for _, s := range []string{"", "-1", "+1", "0x10", "1.0", "2147483647", "4294967296"} {
+		_, err := FromString(s)
+		require.Error(t, err, s)
+	}
+}
@@ -4,9 +4,8 @@ package fss
 // Source: https://github.com/frankw2/libfss/blob/master/go/libfss/client.go
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/rand"
+	"io"
 
 	"github.com/si-co/vpir-code/lib/field"
 )
@@ -15,45 +14,50 @@ import (
 // numBits represents the input domain for the function, i.e. the number
 // of bits to check
 func ClientInitialize(blockLength int) *Fss {
-	f := new(Fss)
-	f.BlockLength = blockLength
-	initPRFLen := 4
-	// Create fixed AES blocks
-	f.FixedBlocks = make([]cipher.Block, initPRFLen)
-	for i := uint(0); i < uint(initPRFLen); i++ {
-		block, err := aes.NewCipher(PrfKeys[i])
-		if err != nil {
-			panic(err.Error())
-		}
-		f.FixedBlocks[i] = block
+	f, err := ClientInitializeWithKeySize(blockLength, DefaultKeySize)
+	if err != nil {
+		panic(err.Error())
 	}
-	f.N = 256 // maximum number of bits supported by FSS
-	f.Temp = make([]byte, aes.BlockSize)
-	f.Out = make([]byte, aes.BlockSize*initPRFLen)
-	f.OutConvertBlock = make([]byte, blockLength*field.Bytes)
 
 	return f
 }
 
+// ClientInitializeWithKeySize is like ClientInitialize, but with PRF keys,
+// i.e., seeds, of keySize bytes, see KeySize128
+func ClientInitializeWithKeySize(blockLength, keySize int) (*Fss, error) {
+	if err := CheckKeySize(keySize); err != nil {
+		return nil, err
+	}
+	// Create fixed AES blocks
+	return initialize(FixedBlocks(), blockLength, keySize), nil
+}
+
 // Generate Keys for 2-party point functions It creates keys for a function
 // that evaluates to vector b when input x = a.
 func (f Fss) GenerateTreePF(a []bool, b []uint32) []FssKeyEq2P {
 	// reinitialize f.NumBits because we have different input lengths
 	f.NumBits = uint(len(a))
 
+	// length of the seeds
+	lambda := f.KeySize
+
 	fssKeys := make([]FssKeyEq2P, 2)
 	// Set up initial values
-	tempRand1 := make([]byte, aes.BlockSize+1)
-	rand.Read(tempRand1)
-	fssKeys[0].SInit = tempRand1[:aes.BlockSize]
-	fssKeys[0].TInit = tempRand1[aes.BlockSize] % 2
-	fssKeys[1].SInit = make([]byte, aes.BlockSize)
-	rand.Read(fssKeys[1].SInit)
+	tempRand1 := make([]byte, lambda+1)
+	rnd := f.Rand
+	if rnd == nil {
+		rnd = rand.Reader
+	}
+	io.ReadFull(rnd, tempRand1)
+	fssKeys[0].SInit = tempRand1[:lambda]
+	fssKeys[0].TInit = tempRand1[lambda] % 2
+	fssKeys[1].SInit = make([]byte, lambda)
+	io.ReadFull(rnd, fssKeys[1].SInit)
 	fssKeys[1].TInit = fssKeys[0].TInit ^ 1
 
 	// Set current seed being used
-	sCurr0 := make([]byte, aes.BlockSize)
-	sCurr1 := make([]byte, aes.BlockSize)
+	sCurr0 := make([]byte, lambda)
+	sCurr1 := make([]byte, lambda)
 	copy(sCurr0, fssKeys[0].SInit)
 	copy(sCurr1, fssKeys[1].SInit)
 	tCurr0 := fssKeys[0].TInit
@@ -63,27 +67,27 @@ func (f Fss) GenerateTreePF(a []bool, b []uint32) []FssKeyEq2P {
 	fssKeys[0].CW = make([][]byte, f.NumBits)
 	fssKeys[1].CW = make([][]byte, f.NumBits)
 	for i := uint(0); i < f.NumBits; i++ {
-		// make AES block size + 2 bytes
-		fssKeys[0].CW[i] = make([]byte, aes.BlockSize+2)
-		fssKeys[1].CW[i] = make([]byte, aes.BlockSize+2)
+		// make seed length + 2 bytes
+		fssKeys[0].CW[i] = make([]byte, lambda+2)
+		fssKeys[1].CW[i] = make([]byte, lambda+2)
 	}
 
 	leftStart := 0
-	rightStart := aes.BlockSize + 1
+	rightStart := lambda + 1
 	for i := uint(0); i < f.NumBits; i++ {
 		// "expand" seed into two seeds + 2 bits
-		prf(sCurr0, f.FixedBlocks, 3, f.Temp, f.Out)
-		prfOut0 := make([]byte, aes.BlockSize*3)
-		copy(prfOut0, f.Out[:aes.BlockSize*3])
-		prf(sCurr1, f.FixedBlocks, 3, f.Temp, f.Out)
-		prfOut1 := make([]byte, aes.BlockSize*3)
-		copy(prfOut1, f.Out[:aes.BlockSize*3])
+		f.expand(sCurr0)
+		prfOut0 := make([]byte, lambda*2+2)
+		copy(prfOut0, f.Out)
+		f.expand(sCurr1)
+		prfOut1 := make([]byte, lambda*2+2)
+		copy(prfOut1, f.Out)
 
 		// Parse out "t" bits
-		t0Left := prfOut0[aes.BlockSize] % 2
-		t0Right := prfOut0[(aes.BlockSize*2)+1] % 2
-		t1Left := prfOut1[aes.BlockSize] % 2
-		t1Right := prfOut1[(aes.BlockSize*2)+1] % 2
+		t0Left := prfOut0[lambda] % 2
+		t0Right := prfOut0[(lambda*2)+1] % 2
+		t1Left := prfOut1[lambda] % 2
+		t1Right := prfOut1[(lambda*2)+1] % 2
 		// Find bit in a
 		// original: aBit := getBit(a, (f.N - f.NumBits + i + 1), f.N)
 		aBit := byte(0)
@@ -100,26 +104,26 @@ func (f Fss) GenerateTreePF(a []bool, b []uint32) []FssKeyEq2P {
 		}
 
 		// Set correction words for both keys. Note: they are the same
-		for j := 0; j < aes.BlockSize; j++ {
+		for j := 0; j < lambda; j++ {
 			fssKeys[0].CW[i][j] = prfOut0[lose+j] ^ prfOut1[lose+j]
 			fssKeys[1].CW[i][j] = fssKeys[0].CW[i][j]
 		}
-		fssKeys[0].CW[i][aes.BlockSize] = t0Left ^ t1Left ^ aBit ^ 1
-		fssKeys[1].CW[i][aes.BlockSize] = fssKeys[0].CW[i][aes.BlockSize]
-		fssKeys[0].CW[i][aes.BlockSize+1] = t0Right ^ t1Right ^ aBit
-		fssKeys[1].CW[i][aes.BlockSize+1] = fssKeys[0].CW[i][aes.BlockSize+1]
+		fssKeys[0].CW[i][lambda] = t0Left ^ t1Left ^ aBit ^ 1
+		fssKeys[1].CW[i][lambda] = fssKeys[0].CW[i][lambda]
+		fssKeys[0].CW[i][lambda+1] = t0Right ^ t1Right ^ aBit
+		fssKeys[1].CW[i][lambda+1] = fssKeys[0].CW[i][lambda+1]
 
-		for j := 0; j < aes.BlockSize; j++ {
+		for j := 0; j < lambda; j++ {
 			sCurr0[j] = prfOut0[keep+j] ^ (tCurr0 * fssKeys[0].CW[i][j])
 			sCurr1[j] = prfOut1[keep+j] ^ (tCurr1 * fssKeys[0].CW[i][j])
 		}
 
-		tCWKeep := fssKeys[0].CW[i][aes.BlockSize]
+		tCWKeep := fssKeys[0].CW[i][lambda]
 		if keep == rightStart {
-			tCWKeep = fssKeys[0].CW[i][aes.BlockSize+1]
+			tCWKeep = fssKeys[0].CW[i][lambda+1]
 		}
-		tCurr0 = (prfOut0[keep+aes.BlockSize] % 2) ^ tCWKeep*tCurr0
-		tCurr1 = (prfOut1[keep+aes.BlockSize] % 2) ^ tCWKeep*tCurr1
+		tCurr0 = (prfOut0[keep+lambda] % 2) ^ tCWKeep*tCurr0
+		tCurr1 = (prfOut1[keep+lambda] % 2) ^ tCWKeep*tCurr1
 	}
 
 	bLen := uint(len(b))
@@ -5,15 +5,37 @@ package fss
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"encoding/binary"
+	"io"
 
 	"github.com/lukechampine/fastxor"
 	"github.com/si-co/vpir-code/lib/field"
+	"golang.org/x/xerrors"
 )
 
+// PrfKeys are the fixed public AES-128 keys of the PRF
 var PrfKeys [][]byte
 
+// Sizes in bytes of the keys of the PRF, i.e., of the seeds of the FSS
+// keys, which bound the security of the FSS. The 16-byte seeds are expanded
+// with the fixed-key PRF over PrfKeys, whose security is bounded by its
+// 16-byte inputs. The longer seeds are instead the keys of AES-192 or
+// AES-256 in counter mode, which pays a key schedule for every node of the
+// tree, both to generate and to evaluate the FSS keys, on top of the 12 or
+// 14 rounds instead of 10. The correction words grow with the seeds, from
+// 18 to 26 or 34 bytes per input bit, and so do the FSS keys sent to the
+// servers. The client and the servers must use the same key size.
+const (
+	KeySize128 = 16
+	KeySize192 = 24
+	KeySize256 = 32
+
+	DefaultKeySize = KeySize128
+)
+
 type Fss struct {
 	FixedBlocks []cipher.Block
+	KeySize     int // size in bytes of the seeds, see KeySize128
 	N           uint
 	NumBits     uint   // number of bits in domain
 	Temp        []byte // temporary slices so that we only need to allocate memory at the beginning
@@ -21,6 +43,12 @@ type Fss struct {
 
 	BlockLength     int    // block length in number of elements
 	OutConvertBlock []byte // to gather random bytes in convertBlock, allocate once for performance
+
+	// Rand is the source of the seeds of the keys, crypto/rand if nil. A
+	// seeded source makes the keys deterministic, which is only meant for
+	// tests and benchmarks: deterministic keys must not be used in
+	// production.
+	Rand io.Reader
 }
 
 // Structs for keys
@@ -52,6 +80,36 @@ func init() {
 		{49, 194, 90, 224, 41, 253, 48, 252, 55, 167, 51, 93, 246, 176, 38, 220}}
 }
 
+// CheckKeySize returns an error if the PRF keys cannot have keySize bytes
+func CheckKeySize(keySize int) error {
+	switch keySize {
+	case KeySize128, KeySize192, KeySize256:
+		return nil
+	default:
+		return xerrors.Errorf("unsupported PRF key size of %d bytes, expected %d, %d or %d",
+			keySize, KeySize128, KeySize192, KeySize256)
+	}
+}
+
+// initialize returns an Fss with the given ciphers, see FixedBlocks, for
+// seeds of keySize bytes and blocks of blockLength elements
+func initialize(blocks []cipher.Block, blockLength, keySize int) *Fss {
+	f := new(Fss)
+	f.BlockLength = blockLength
+	f.FixedBlocks = blocks
+	f.KeySize = keySize
+	f.N = 256 // maximum number of bits supported by FSS
+	f.Temp = make([]byte, aes.BlockSize)
+	// the expansion of a seed holds two seeds and two bits
+	f.Out = make([]byte, aes.BlockSize*len(blocks))
+	if n := 2*keySize + 2; n > len(f.Out) {
+		f.Out = make([]byte, n)
+	}
+	f.OutConvertBlock = make([]byte, blockLength*field.Bytes)
+
+	return f
+}
+
 // Helper functions
 
 // fixed key PRF (Matyas–Meyer–Oseas one way compression function)
@@ -65,7 +123,41 @@ func prf(x []byte, aesBlocks []cipher.Block, numBlocks uint, temp, out []byte) {
 	}
 }
 
+// expand writes the expansion of seed into f.Out, i.e., the seeds and the
+// bits of its two children in the tree: the left seed, the left bit, the
+// right seed and the right bit
+func (f Fss) expand(seed []byte) {
+	if len(seed) == KeySize128 {
+		prf(seed, f.FixedBlocks, 3, f.Temp, f.Out)
+		return
+	}
+	prgWithSeed(seed, f.Temp, f.Out[:2*len(seed)+2])
+}
+
+// prgWithSeed fills out with the output of AES in counter mode keyed by
+// seed, of KeySize192 or KeySize256 bytes. temp is the buffer of one AES
+// block.
+func prgWithSeed(seed, temp, out []byte) {
+	block, err := aes.NewCipher(seed)
+	if err != nil {
+		panic(err.Error())
+	}
+	for i := 0; i*aes.BlockSize < len(out); i++ {
+		for j := range temp {
+			temp[j] = 0
+		}
+		binary.BigEndian.PutUint32(temp[aes.BlockSize-4:], uint32(i))
+		block.Encrypt(temp, temp)
+		copy(out[i*aes.BlockSize:], temp)
+	}
+}
+
 func convertBlock(f Fss, x []byte, out []uint32) {
+	if len(x) != KeySize128 {
+		prgWithSeed(x, f.Temp, f.OutConvertBlock[:len(out)*field.Bytes])
+		field.BytesToElements(out, f.OutConvertBlock)
+		return
+	}
 	// we can generate four uint32 numbers with a 16-bytes AES block
 	prf(x, f.FixedBlocks, uint(len(out)/4), f.Temp, f.OutConvertBlock)
 	field.BytesToElements(out, f.OutConvertBlock)
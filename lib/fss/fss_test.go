@@ -61,6 +61,45 @@ func TestPoint(t *testing.T) {
 	}
 }
 
+func TestPointKeySizes(t *testing.T) {
+	b := make([]uint32, testBlockLength)
+	for i := range b {
+		b[i] = field.RandElement()
+	}
+	for _, keySize := range []int{KeySize128, KeySize192, KeySize256} {
+		fClient, err := ClientInitializeWithKeySize(testBlockLength, keySize)
+		require.NoError(t, err)
+		fServer, err := ServerInitializeWithKeySize(FixedBlocks(), testBlockLength, keySize)
+		require.NoError(t, err)
+
+		index := randomIndex(numBits)
+		fssKeys := fClient.GenerateTreePF(index, b)
+		// the seeds have the size of the keys
+		require.Len(t, fssKeys[0].SInit, keySize)
+		require.Len(t, fssKeys[0].CW[0], keySize+2)
+		for _, x := range [][]bool{index, randomIndex(numBits)} {
+			out0 := make([]uint32, testBlockLength)
+			out1 := make([]uint32, testBlockLength)
+			fServer.EvaluatePF(0, fssKeys[0], x, out0)
+			fServer.EvaluatePF(1, fssKeys[1], x, out1)
+			sum := make([]uint32, testBlockLength)
+			for i := range sum {
+				sum[i] = (out0[i] + out1[i]) % field.ModP
+			}
+			if equalIndices(index, x) {
+				require.Equal(t, b, sum)
+			} else {
+				require.Equal(t, make([]uint32, testBlockLength), sum)
+			}
+		}
+	}
+
+	_, err := ClientInitializeWithKeySize(testBlockLength, 20)
+	require.Error(t, err)
+	_, err = ServerInitializeWithKeySize(FixedBlocks(), testBlockLength, 8)
+	require.Error(t, err)
+}
+
 func TestPointWithAlphaVector(t *testing.T) {
 	// Generate fss Keys on client
 	fClient := ClientInitialize(testBlockLength)
@@ -110,6 +149,66 @@ func TestPointWithAlphaVector(t *testing.T) {
 	}
 }
 
+func TestPointSharedBlocks(t *testing.T) {
+	fClient := ClientInitialize(testBlockLength)
+	index := randomIndex(numBits)
+	b := make([]uint32, testBlockLength)
+	for i := range b {
+		b[i] = field.RandElement()
+	}
+	fssKeys := fClient.GenerateTreePF(index, b)
+
+	// two servers sharing the expanded keys evaluate like fresh ones
+	blocks := FixedBlocks()
+	cached := []*Fss{
+		ServerInitializeWithBlocks(blocks, testBlockLength),
+		ServerInitializeWithBlocks(blocks, testBlockLength),
+	}
+	fresh := ServerInitialize(testBlockLength)
+	for j := 0; j <= 100; j++ {
+		indexToTest := randomIndex(numBits)
+		if j == 0 {
+			indexToTest = index
+		}
+		for k := range cached {
+			expected := make([]uint32, testBlockLength)
+			out := make([]uint32, testBlockLength)
+			fresh.EvaluatePF(byte(k), fssKeys[k], indexToTest, expected)
+			cached[k].EvaluatePF(byte(k), fssKeys[k], indexToTest, out)
+			require.Equal(t, expected, out)
+		}
+	}
+}
+
+func BenchmarkAnswerFixedBlocks(b *testing.B) {
+	blocks := FixedBlocks()
+	benchmarkAnswer(b, func() *Fss {
+		return ServerInitializeWithBlocks(blocks, testBlockLength)
+	})
+}
+
+func BenchmarkAnswerExpandKeys(b *testing.B) {
+	benchmarkAnswer(b, func() *Fss {
+		return ServerInitialize(testBlockLength)
+	})
+}
+
+// benchmarkAnswer measures the setup and evaluation of 1000 queries, each on
+// a new Fss returned by newFss
+func benchmarkAnswer(b *testing.B, newFss func() *Fss) {
+	fClient := ClientInitialize(testBlockLength)
+	index := randomIndex(numBits)
+	fssKeys := fClient.GenerateTreePF(index, make([]uint32, testBlockLength))
+	out := make([]uint32, testBlockLength)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for q := 0; q < 1000; q++ {
+			newFss().EvaluatePF(0, fssKeys[0], index, out)
+		}
+	}
+}
+
 // return random index, biased but fine for this test
 func randomIndex(bits int) []bool {
 	index := make([]bool, bits)
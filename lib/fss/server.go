@@ -14,28 +14,48 @@ import (
 // this function. The server, unlike the client
 // receives prfKeys, so it doesn't need to pick random ones
 func ServerInitialize(blockLength int) *Fss {
-	f := new(Fss)
-	f.FixedBlocks = make([]cipher.Block, len(PrfKeys))
+	return ServerInitializeWithBlocks(FixedBlocks(), blockLength)
+}
+
+// FixedBlocks returns the AES ciphers keyed with PrfKeys. They do not depend
+// on the queries and are safe for concurrent use, so a server can expand
+// the keys once and share the ciphers among all its Fss.
+func FixedBlocks() []cipher.Block {
+	blocks := make([]cipher.Block, len(PrfKeys))
 	for i := range PrfKeys {
 		block, err := aes.NewCipher(PrfKeys[i])
 		if err != nil {
 			panic(err.Error())
 		}
-		f.FixedBlocks[i] = block
+		blocks[i] = block
 	}
-	f.N = 256 // maximum number of bits supported by FSS
-	f.Temp = make([]byte, aes.BlockSize)
-	f.Out = make([]byte, aes.BlockSize*len(PrfKeys))
-	f.OutConvertBlock = make([]byte, blockLength*field.Bytes)
 
-	return f
+	return blocks
+}
+
+// ServerInitializeWithBlocks is like ServerInitialize, but uses the given
+// ciphers returned by FixedBlocks instead of expanding PrfKeys again
+func ServerInitializeWithBlocks(blocks []cipher.Block, blockLength int) *Fss {
+	return initialize(blocks, blockLength, DefaultKeySize)
+}
+
+// ServerInitializeWithKeySize is like ServerInitializeWithBlocks, but for
+// FSS keys with PRF keys, i.e., seeds, of keySize bytes, see KeySize128
+func ServerInitializeWithKeySize(blocks []cipher.Block, blockLength, keySize int) (*Fss, error) {
+	if err := CheckKeySize(keySize); err != nil {
+		return nil, err
+	}
+	return initialize(blocks, blockLength, keySize), nil
 }
 
 func (f Fss) EvaluatePF(serverNum byte, k FssKeyEq2P, x []bool, out []uint32) {
 	// reinitialize f.NumBits because we have different input lengths
 	f.NumBits = uint(len(x))
 
-	sCurr := make([]byte, aes.BlockSize)
+	// length of the seeds
+	lambda := f.KeySize
+
+	sCurr := make([]byte, lambda)
 	copy(sCurr, k.SInit)
 	tCurr := k.TInit
 	tmp := make([]uint32, len(out))
@@ -48,16 +68,16 @@ func (f Fss) EvaluatePF(serverNum byte, k FssKeyEq2P, x []bool, out []uint32) {
 			}
 		}
 
-		prf(sCurr, f.FixedBlocks, 3, f.Temp, f.Out)
+		f.expand(sCurr)
 
 		// Keep counter to ensure we are accessing CW correctly
 		count := 0
-		for j := 0; j < aes.BlockSize*2+2; j++ {
+		for j := 0; j < lambda*2+2; j++ {
 			// Make sure we are doing G(s) ^ (t*sCW||tLCW||sCW||tRCW)
-			if j == aes.BlockSize+1 {
+			if j == lambda+1 {
 				count = 0
-			} else if j == aes.BlockSize*2+1 {
-				count = aes.BlockSize + 1
+			} else if j == lambda*2+1 {
+				count = lambda + 1
 			}
 			f.Out[j] = f.Out[j] ^ (tCurr * k.CW[i][count])
 			count++
@@ -65,11 +85,11 @@ func (f Fss) EvaluatePF(serverNum byte, k FssKeyEq2P, x []bool, out []uint32) {
 
 		// Pick right seed expansion based on
 		if xBit == 0 {
-			copy(sCurr, f.Out[:aes.BlockSize])
-			tCurr = f.Out[aes.BlockSize] % 2
+			copy(sCurr, f.Out[:lambda])
+			tCurr = f.Out[lambda] % 2
 		} else {
-			copy(sCurr, f.Out[(aes.BlockSize+1):(aes.BlockSize*2+1)])
-			tCurr = f.Out[aes.BlockSize*2+1] % 2
+			copy(sCurr, f.Out[(lambda+1):(lambda*2+1)])
+			tCurr = f.Out[lambda*2+1] % 2
 		}
 	}
 
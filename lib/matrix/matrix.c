@@ -42,4 +42,70 @@ void binary_multiply128(int aRows, int aCols, int bCols, __uint128_t *a, uint8_t
 			}
 		}
 	}
-}
\ No newline at end of file
+}
+
+// number of rows of a packed binary matrix unpacked at once by
+// binary_multiply_bits, so that the masks are reused for all the rows of a,
+// the query
+#define BITS_BLOCK_ROWS 32
+
+// unpack_bits writes the masks, all zeros or all ones, of the entries of the
+// rows [k0, k1) of the packed binary matrix b
+static void unpack_bits(int k0, int k1, int bCols, int bWords, uint64_t *b, uint32_t *masks) {
+	int k, j;
+	for (k = k0; k < k1; k++) {
+		uint64_t *row = b + bWords*k;
+		uint32_t *m = masks + bCols*(k-k0);
+		for (j = 0; j < bCols; j++) {
+			m[j] = -(uint32_t)((row[j/64] >> (j%64)) & 1);
+		}
+	}
+}
+
+int binary_multiply_bits(int aRows, int aCols, int bCols, int bWords, uint32_t *a, uint64_t *b, uint32_t *out) {
+	int i, j, k, k0, k1;
+	uint32_t *masks = malloc(sizeof(uint32_t) * BITS_BLOCK_ROWS * bCols);
+	if (masks == NULL) {
+		return -1;
+	}
+	for (k0 = 0; k0 < aCols; k0 += BITS_BLOCK_ROWS) {
+		k1 = k0 + BITS_BLOCK_ROWS < aCols ? k0 + BITS_BLOCK_ROWS : aCols;
+		unpack_bits(k0, k1, bCols, bWords, b, masks);
+		for (i = 0; i < aRows; i++) {
+			uint32_t *o = out + bCols*i;
+			for (k = k0; k < k1; k++) {
+				uint32_t v = a[aCols*i+k];
+				uint32_t *m = masks + bCols*(k-k0);
+				for (j = 0; j < bCols; j++) {
+					o[j] += v & m[j];
+				}
+			}
+		}
+	}
+	free(masks);
+	return 0;
+}
+
+int binary_multiply_bits128(int aRows, int aCols, int bCols, int bWords, __uint128_t *a, uint64_t *b, __uint128_t *out) {
+	int i, j, k, k0, k1;
+	uint32_t *masks = malloc(sizeof(uint32_t) * BITS_BLOCK_ROWS * bCols);
+	if (masks == NULL) {
+		return -1;
+	}
+	for (k0 = 0; k0 < aCols; k0 += BITS_BLOCK_ROWS) {
+		k1 = k0 + BITS_BLOCK_ROWS < aCols ? k0 + BITS_BLOCK_ROWS : aCols;
+		unpack_bits(k0, k1, bCols, bWords, b, masks);
+		for (i = 0; i < aRows; i++) {
+			__uint128_t *o = out + bCols*i;
+			for (k = k0; k < k1; k++) {
+				__uint128_t v = a[aCols*i+k];
+				uint32_t *m = masks + bCols*(k-k0);
+				for (j = 0; j < bCols; j++) {
+					o[j] += v & (__uint128_t)(__int128_t)(int32_t)m[j];
+				}
+			}
+		}
+	}
+	free(masks);
+	return 0;
+}
@@ -9,3 +9,9 @@ void binary_multiply(int aRows, int aCols, int bCols, uint32_t *a, uint8_t *b, u
 void multiply128(int aRows, int aCols, int bCols, __uint128_t *a, __uint128_t *b, __uint128_t *out);
 
 void binary_multiply128(int aRows, int aCols, int bCols, __uint128_t *a, uint8_t *b, __uint128_t *out);
+
+// binary_multiply_bits and binary_multiply_bits128 return -1 if they cannot
+// allocate their buffer, 0 otherwise
+int binary_multiply_bits(int aRows, int aCols, int bCols, int bWords, uint32_t *a, uint64_t *b, uint32_t *out);
+
+int binary_multiply_bits128(int aRows, int aCols, int bCols, int bWords, __uint128_t *a, uint64_t *b, __uint128_t *out);
\ No newline at end of file
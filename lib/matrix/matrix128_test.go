@@ -125,6 +125,27 @@ func BenchmarkBinaryMul128(b *testing.B) {
 
 }
 
+func BenchmarkBinaryMulBits128(b *testing.B) {
+	rows, columns := 2048, 2048
+	m := randomBinary(rows, columns)
+	rm := NewRandom128(
+		utils.NewPRG(utils.ParamsDefault128().SeedA),
+		utils.ParamsDefault128().N,
+		rows)
+
+	b.Run("bytes", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			rows = BinaryMul128(rm, m).Rows()
+		}
+	})
+	packed := BytesToBits(m)
+	b.Run("bits", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			rows = BinaryMulBits128(rm, packed).Rows()
+		}
+	})
+}
+
 func BenchmarkMul128(b *testing.B) {
 	rows, columns := 2048, 2048
 	rnd := utils.RandomPRG()
@@ -36,6 +36,23 @@ func TestEncoding(t *testing.T) {
 	}
 }
 
+func TestBinaryMulBits(t *testing.T) {
+	// columns not a multiple of 64 to cover the last word of the rows
+	rows, columns := 100, 130
+	m := randomBinary(rows, columns)
+	packed := BytesToBits(m)
+	for r := 0; r < rows; r++ {
+		for c := 0; c < columns; c++ {
+			require.Equal(t, m.Get(r, c), packed.Get(r, c))
+		}
+	}
+
+	a := NewRandom(utils.RandomPRG(), 3, rows)
+	require.Equal(t, BinaryMul(a, m), BinaryMulBits(a, packed))
+	a128 := NewRandom128(utils.RandomPRG(), 3, rows)
+	require.Equal(t, BinaryMul128(a128, m), BinaryMulBits128(a128, packed))
+}
+
 func BenchmarkBinaryMul32(b *testing.B) {
 	rows, columns := 1024, 1024
 	buff := make([]byte, rows*columns/8+1)
@@ -89,3 +106,37 @@ func BenchmarkMul(b *testing.B) {
 
 	rows = r
 }
+
+func BenchmarkBinaryMulBits32(b *testing.B) {
+	rows, columns := 1024, 1024
+	m := randomBinary(rows, columns)
+	rm := NewRandom(
+		utils.NewPRG(utils.ParamsDefault().SeedA),
+		utils.ParamsDefault().N,
+		rows)
+
+	b.Run("bytes", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			rows = BinaryMul(rm, m).Rows()
+		}
+	})
+	packed := BytesToBits(m)
+	b.Run("bits", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			rows = BinaryMulBits(rm, packed).Rows()
+		}
+	})
+}
+
+func randomBinary(rows, columns int) *MatrixBytes {
+	buff := make([]byte, rows*columns/8+1)
+	if _, err := utils.RandomPRG().Read(buff); err != nil {
+		panic("insufficient randomness")
+	}
+	m := NewBytes(rows, columns)
+	for i := 0; i < m.Len(); i++ {
+		m.SetData(i, (buff[i/8]>>(i%8))&1)
+	}
+
+	return m
+}
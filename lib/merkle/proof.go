@@ -15,6 +15,7 @@ package merkle
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 )
 
 const (
@@ -77,12 +78,22 @@ func generateProofHash(data []byte, proof *Proof, hashType HashType) []byte {
 	return proofHash
 }
 
-func DecodeProof(p []byte) *Proof {
+// DecodeProof decodes a proof encoded with EncodeProof. An error is returned
+// if the length of p does not match the number of hashes it announces.
+func DecodeProof(p []byte) (*Proof, error) {
+	if len(p) < numHashesByteSize+indexByteSize {
+		return nil, errors.New("proof too short")
+	}
+
 	// number of hashes
 	numHashes := binary.LittleEndian.Uint32(p[:numHashesByteSize])
 
 	// hashes
 	hashLength := uint32(32) // blake3
+	expectedLen := uint64(numHashesByteSize) + uint64(numHashes)*uint64(hashLength) + indexByteSize
+	if uint64(len(p)) != expectedLen {
+		return nil, errors.New("proof length does not match the number of hashes")
+	}
 	hashes := make([][]byte, numHashes)
 	for i := uint32(0); i < numHashes; i++ {
 		hashes[i] = p[4+hashLength*i : 4+hashLength*(i+1)]
@@ -94,7 +105,7 @@ func DecodeProof(p []byte) *Proof {
 	return &Proof{
 		Hashes: hashes,
 		Index:  index,
-	}
+	}, nil
 }
 
 func EncodeProof(p *Proof) []byte {
@@ -60,7 +60,8 @@ func TestProofVerification(t *testing.T) {
 		// encode the proof
 		b := EncodeProof(proof)
 		// decode proof
-		p := DecodeProof(b)
+		p, err := DecodeProof(b)
+		require.NoError(t, err)
 		require.Equal(t, *proof, *p)
 
 		// check if proof verifies
@@ -71,3 +72,26 @@ func TestProofVerification(t *testing.T) {
 		}
 	}
 }
+
+func FuzzDecodeProof(f *testing.F) {
+	data := make([][]byte, 16)
+	for i := range data {
+		data[i] = []byte{byte(i)}
+	}
+	tree, err := New(data)
+	require.NoError(f, err)
+	proof, err := tree.GenerateProof(data[3])
+	require.NoError(f, err)
+
+	f.Add(EncodeProof(proof))
+	f.Add([]byte{})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		p, err := DecodeProof(b)
+		if err != nil {
+			return
+		}
+		// a decoded proof encodes back to the same bytes
+		require.Equal(t, b, EncodeProof(p))
+	})
+}
@@ -2,31 +2,47 @@ package monitor
 
 import (
 	"log"
+	"sync"
 	"syscall"
 )
 
-// Helpers for measurement of CPU cost of operations
+// Helpers for measurement of CPU cost of operations. A Monitor is not safe
+// for concurrent use: goroutines timing their own work need a Monitor each,
+// whose times can be collected in an Aggregate.
 type Monitor struct {
 	cpuTime float64
+	who     int
 }
 
+// NewMonitor returns a monitor of the CPU time of the whole process, i.e.,
+// of all its goroutines
 func NewMonitor() *Monitor {
-	var m Monitor
-	m.cpuTime = getCPUTime()
+	m := Monitor{who: syscall.RUSAGE_SELF}
+	m.cpuTime = getCPUTime(m.who)
+	return &m
+}
+
+// NewThreadMonitor returns a monitor of the CPU time of the calling thread
+// only, e.g., to time a worker among concurrent ones. The calling goroutine
+// must be locked to its thread with runtime.LockOSThread until the last
+// record. On systems without per-thread times, it monitors the process.
+func NewThreadMonitor() *Monitor {
+	m := Monitor{who: rusageThread}
+	m.cpuTime = getCPUTime(m.who)
 	return &m
 }
 
 func (m *Monitor) Reset() {
-	m.cpuTime = getCPUTime()
+	m.cpuTime = getCPUTime(m.who)
 }
 
 func (m *Monitor) Record() float64 {
-	return getCPUTime() - m.cpuTime
+	return getCPUTime(m.who) - m.cpuTime
 }
 
 func (m *Monitor) RecordAndReset() float64 {
 	old := m.cpuTime
-	m.cpuTime = getCPUTime()
+	m.cpuTime = getCPUTime(m.who)
 	return m.cpuTime - old
 }
 
@@ -34,10 +50,56 @@ func (m *Monitor) GetCpuTime() float64 {
 	return m.cpuTime
 }
 
-// Returns the sum of the system and the user CPU time used by the current process so far.
-func getCPUTime() float64 {
+// Stats summarizes the CPU time, in milliseconds, of repeated executions of
+// the same operation
+type Stats struct {
+	Iterations int
+	TotalMs    float64
+	MeanMs     float64
+	MinMs      float64
+	MaxMs      float64
+}
+
+// Add records the CPU time of one more execution
+func (s *Stats) Add(ms float64) {
+	if s.Iterations == 0 || ms < s.MinMs {
+		s.MinMs = ms
+	}
+	if ms > s.MaxMs {
+		s.MaxMs = ms
+	}
+	s.Iterations++
+	s.TotalMs += ms
+	s.MeanMs = s.TotalMs / float64(s.Iterations)
+}
+
+// Aggregate collects the CPU times, in milliseconds, recorded by concurrent
+// workers, each with its own Monitor. It is safe for concurrent use.
+type Aggregate struct {
+	mu    sync.Mutex
+	stats Stats
+}
+
+// Add records the CPU time of one more worker
+func (a *Aggregate) Add(ms float64) {
+	a.mu.Lock()
+	a.stats.Add(ms)
+	a.mu.Unlock()
+}
+
+// Stats returns the statistics of the CPU times recorded so far, one
+// iteration per worker
+func (a *Aggregate) Stats() Stats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.stats
+}
+
+// Returns the sum of the system and the user CPU time used so far by the
+// current process, or thread for rusageThread.
+func getCPUTime(who int) float64 {
 	rusage := &syscall.Rusage{}
-	if err := syscall.Getrusage(syscall.RUSAGE_SELF, rusage); err != nil {
+	if err := syscall.Getrusage(who, rusage); err != nil {
 		log.Fatalln("Couldn't get rusage time:", err)
 		return -1
 	}
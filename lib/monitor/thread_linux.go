@@ -0,0 +1,7 @@
+//go:build linux
+
+package monitor
+
+import "syscall"
+
+const rusageThread = syscall.RUSAGE_THREAD
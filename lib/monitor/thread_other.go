@@ -0,0 +1,8 @@
+//go:build !linux
+
+package monitor
+
+import "syscall"
+
+// no per-thread CPU time, fall back to the process
+const rusageThread = syscall.RUSAGE_SELF
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/gob"
+	"fmt"
 	"log"
 	"strconv"
 	"time"
@@ -88,19 +89,7 @@ func (i *Info) ToEmailClientFSS(in string) *ClientFSS {
 }
 
 func (i *Info) ToPKAClientFSS(in string) *ClientFSS {
-	var pka packet.PublicKeyAlgorithm
-	switch in {
-	case "RSA":
-		pka = packet.PubKeyAlgoRSA
-	case "ElGamal":
-		pka = packet.PubKeyAlgoElGamal
-	case "DSA":
-		pka = packet.PubKeyAlgoDSA
-	case "ECDH":
-		pka = packet.PubKeyAlgoECDH
-	case "ECDSA":
-		pka = packet.PubKeyAlgoECDSA
-	}
+	pka, _ := ParsePubKeyAlgo(in)
 	id := i.IdForPubKeyAlgo(pka)
 	return &ClientFSS{
 		Info:  i,
@@ -124,6 +113,25 @@ func (i *Info) ToCreationTimeClientFSS(in string) *ClientFSS {
 	}
 }
 
+// ParsePubKeyAlgo returns the public key algorithm with the given name, one
+// of RSA, ElGamal, DSA, ECDH and ECDSA
+func ParsePubKeyAlgo(in string) (packet.PublicKeyAlgorithm, error) {
+	switch in {
+	case "RSA":
+		return packet.PubKeyAlgoRSA, nil
+	case "ElGamal":
+		return packet.PubKeyAlgoElGamal, nil
+	case "DSA":
+		return packet.PubKeyAlgoDSA, nil
+	case "ECDH":
+		return packet.PubKeyAlgoECDH, nil
+	case "ECDSA":
+		return packet.PubKeyAlgoECDSA, nil
+	default:
+		return 0, fmt.Errorf("unknown public key algorithm %q", in)
+	}
+}
+
 // TODO: hardcoded for the moment, FIX
 func (i *Info) ToAndClientFSS(in string) *ClientFSS {
 	idYear, err := i.IdForYearCreationTime(time.Date(2019, 0, 0, 0, 0, 0, 0, time.UTC))
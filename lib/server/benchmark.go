@@ -0,0 +1,42 @@
+package server
+
+import (
+	"github.com/si-co/vpir-code/lib/monitor"
+)
+
+// BenchmarkAnswer answers the given query iterations times and returns the
+// per-iteration CPU time, to profile the server computation without the
+// network
+func BenchmarkAnswer(s Server, q []byte, iterations int) (monitor.Stats, error) {
+	var stats monitor.Stats
+	m := monitor.NewMonitor()
+	for i := 0; i < iterations; i++ {
+		m.Reset()
+		if _, err := s.AnswerBytes(q); err != nil {
+			return stats, err
+		}
+		stats.Add(m.Record())
+	}
+
+	return stats, nil
+}
+
+// BenchmarkAnswerInto is as BenchmarkAnswer, but the answers are computed
+// into a single buffer reused across the iterations, to profile the server
+// computation without the allocation of the answers. It returns the buffer
+// holding the last answer.
+func BenchmarkAnswerInto(s *PIR, q []byte, iterations int) ([]byte, monitor.Stats, error) {
+	var stats monitor.Stats
+	info := s.DBInfo()
+	dst := make([]byte, info.NumRows*info.BlockSize)
+	m := monitor.NewMonitor()
+	for i := 0; i < iterations; i++ {
+		m.Reset()
+		if err := s.answerInto(q, dst); err != nil {
+			return nil, stats, err
+		}
+		stats.Add(m.Record())
+	}
+
+	return dst, stats, nil
+}
@@ -1,44 +1,93 @@
 package server
 
 import (
-	"math"
-
 	"github.com/cloudflare/circl/group"
 	"github.com/si-co/vpir-code/lib/database"
 )
 
 // A DH server for the single-server DL-based tag retrieval
 type DH struct {
-	db *database.Elliptic
+	db    *database.Elliptic
+	cores int
+	// parts are the rows of the db on each NUMA node, if not nil, answered
+	// by the workers of pool
+	parts []dhPart
+	pool  *numaPool
+}
+
+// dhPart holds the rows of the db from begin, copied to the memory of a
+// NUMA node, and the workers of the node answering on them
+type dhPart struct {
+	begin   int
+	entries []byte
+	workers []numaWorker
+}
+
+// NewDH returns a server for the single-server DL-based scheme. The rows of
+// the db are split among the given number of cores, one by default.
+func NewDH(db *database.Elliptic, cores ...int) *DH {
+	if len(cores) == 0 || cores[0] < 1 {
+		return &DH{db: db, cores: 1}
+	}
+	return &DH{db: db, cores: cores[0]}
+}
+
+// NewDHWithNUMA is like NewDH, but the rows of the db are split among the
+// NUMA nodes in proportion to their CPUs, given as by NUMANodes, and the rows
+// of every node among its CPUs. The server keeps a thread pinned to each
+// CPU, see numaPool. Each node gets a copy of its rows written by one of its
+// threads, which Linux allocates in the memory of the node, and the answers
+// are computed by the threads of the nodes, so that they mostly read memory
+// local to their socket. The answers are the same as with NewDH. The db is
+// held twice, once in db and once in the copies. The server must be closed
+// to stop its threads.
+func NewDHWithNUMA(db *database.Elliptic, nodes [][]int) (*DH, error) {
+	if err := checkNUMANodes(nodes); err != nil {
+		return nil, err
+	}
+
+	partition := numaPartition(db.NumRows, nodes)
+	s := &DH{db: db, cores: 1, pool: newNUMAPool(nodes)}
+	starts := make([]int, len(partition))
+	ends := make([]int, len(partition))
+	for n, workers := range partition {
+		if len(workers) > 0 {
+			starts[n] = workers[0].begin * db.NumColumns
+			ends[n] = workers[len(workers)-1].end * db.NumColumns
+		}
+	}
+	copies := s.pool.copyToNodes(partition, db.Entries, starts, ends)
+	for n, workers := range partition {
+		if len(workers) > 0 {
+			s.parts = append(s.parts, dhPart{begin: workers[0].begin, entries: copies[n], workers: workers})
+		}
+	}
+
+	return s, nil
 }
 
-func NewDH(db *database.Elliptic) *DH {
-	return &DH{db: db}
+// Close stops the threads of a server returned by NewDHWithNUMA. It does
+// nothing for other servers.
+func (s *DH) Close() {
+	if s.pool != nil {
+		s.pool.close()
+	}
 }
 
 func (s *DH) AnswerBytes(q []byte) ([]byte, error) {
+	if err := checkNotEmpty(&s.db.Info); err != nil {
+		return nil, err
+	}
 	query, err := database.UnmarshalGroupElements(q, s.db.Group, s.db.ElementSize)
 	if err != nil {
 		return nil, err
 	}
 
-	NGoRoutines := 1
-	// make sure that we do not need up with routines processing 0 elements
-	if NGoRoutines > s.db.NumRows {
-		NGoRoutines = s.db.NumRows
-	}
-	rowsPerRoutine := int(math.Ceil(float64(s.db.NumRows) / float64(NGoRoutines)))
-	replies := make([]chan []group.Element, NGoRoutines)
-	var begin, end int
-	for i := 0; i < NGoRoutines; i++ {
-		begin, end = i*rowsPerRoutine, (i+1)*rowsPerRoutine
-		// make the last routine take all the left-over (from division) rows
-		if end > s.db.NumRows {
-			end = s.db.NumRows
-		}
-		replyChan := make(chan []group.Element, 1)
-		replies[i] = replyChan
-		go s.processRows(begin, end, query, replyChan)
+	var replies []chan []group.Element
+	if s.parts != nil {
+		replies = s.answerNUMA(query)
+	} else {
+		replies = s.answerCores(query)
 	}
 
 	answer := make([]group.Element, 0, s.db.NumRows)
@@ -57,13 +106,57 @@ func (s *DH) AnswerBytes(q []byte) ([]byte, error) {
 	return encoded, nil
 }
 
-func (s *DH) processRows(begin, end int, input []group.Element, replyTo chan<- []group.Element) {
+// answerCores starts the routines computing the rows of the answer, split
+// among s.cores routines, and returns their replies in row order
+func (s *DH) answerCores(query []group.Element) []chan []group.Element {
+	// only start the routines that get at least one row, since the rows
+	// per routine are rounded up
+	rowsPerRoutine := (s.db.NumRows + s.cores - 1) / s.cores
+	numWorkers := (s.db.NumRows + rowsPerRoutine - 1) / rowsPerRoutine
+	replies := make([]chan []group.Element, numWorkers)
+	var begin, end int
+	for i := 0; i < numWorkers; i++ {
+		begin, end = i*rowsPerRoutine, (i+1)*rowsPerRoutine
+		// the last routine takes the left-over (from division) rows
+		if end > s.db.NumRows {
+			end = s.db.NumRows
+		}
+		replyChan := make(chan []group.Element, 1)
+		replies[i] = replyChan
+		go s.processRows(s.db.Entries[begin*s.db.NumColumns:], begin, end, query, replyChan)
+	}
+
+	return replies
+}
+
+// answerNUMA has each worker of the NUMA nodes compute its rows on the
+// copy local to its node, and returns their replies in row order
+func (s *DH) answerNUMA(query []group.Element) []chan []group.Element {
+	var replies []chan []group.Element
+	for _, p := range s.parts {
+		for _, w := range p.workers {
+			replyChan := make(chan []group.Element, 1)
+			replies = append(replies, replyChan)
+			entries, begin, end := p.entries[(w.begin-p.begin)*s.db.NumColumns:], w.begin, w.end
+			s.pool.run(w.id, func() {
+				s.processRows(entries, begin, end, query, replyChan)
+			})
+		}
+	}
+
+	return replies
+}
+
+// processRows computes the rows from begin to end of the answer, with
+// entries holding the rows of the db from begin
+func (s *DH) processRows(entries []byte, begin, end int, input []group.Element, replyTo chan<- []group.Element) {
 	// one product per row
 	prods := make([]group.Element, end-begin)
 	for i := begin; i < end; i++ {
 		prods[i-begin] = s.db.Group.Identity()
+		row := entries[(i-begin)*s.db.NumColumns:]
 		for j := 0; j < s.db.NumColumns; j++ {
-			if s.db.Entries[i*s.db.NumColumns+j] == 1 {
+			if row[j] == 1 {
 				// add query element to the product if
 				// the corresponding database bit is 1
 				prods[i-begin].Add(prods[i-begin], input[j])
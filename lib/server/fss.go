@@ -2,22 +2,48 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
+	"runtime"
+	"sync"
 	"time"
 
 	"github.com/si-co/vpir-code/lib/database"
 	"github.com/si-co/vpir-code/lib/field"
 	"github.com/si-co/vpir-code/lib/fss"
+	"github.com/si-co/vpir-code/lib/monitor"
 	"github.com/si-co/vpir-code/lib/query"
 	"github.com/si-co/vpir-code/lib/utils"
+	"golang.org/x/xerrors"
 )
 
+// fssChunkLength is the number of identifiers evaluated between two checks
+// of the context in answerContext
+const fssChunkLength = 1000
+
+// serverFSS is safe for concurrent use: the db is only read and every
+// answer evaluates the FSS keys with its own fss.Fss, whose buffers cannot
+// be shared.
 type serverFSS struct {
 	db    *database.DB
 	cores int
 
 	serverNum byte
-	fss       *fss.Fss
+	keySize   int
+	fssPool   *sync.Pool
+}
+
+// newFssPool returns a pool of FSS servers for the given block length and
+// key size, see fss.KeySize128, which must be valid. The PRF keys are
+// expanded once and shared by all the FSS servers of the pool.
+func newFssPool(blockLength, keySize int) *sync.Pool {
+	blocks := fss.FixedBlocks()
+	return &sync.Pool{
+		New: func() interface{} {
+			f, _ := fss.ServerInitializeWithKeySize(blocks, blockLength, keySize)
+			return f
+		},
+	}
 }
 
 func (s *serverFSS) dbInfo() *database.Info {
@@ -25,11 +51,8 @@ func (s *serverFSS) dbInfo() *database.Info {
 }
 
 func (s *serverFSS) answerBytes(q []byte, out, tmp []uint32) ([]byte, error) {
-	// decode query
-	buf := bytes.NewBuffer(q)
-	dec := gob.NewDecoder(buf)
-	var query *query.FSS
-	if err := dec.Decode(&query); err != nil {
+	query, err := decodeFSSQuery(q, len(out), s.keySize)
+	if err != nil {
 		return nil, err
 	}
 
@@ -39,40 +62,316 @@ func (s *serverFSS) answerBytes(q []byte, out, tmp []uint32) ([]byte, error) {
 	return utils.Uint32SliceToByteSlice(a), nil
 }
 
+func (s *serverFSS) answerBytesContext(ctx context.Context, q []byte, outLen int,
+	progress func(done, total int)) ([]byte, error) {
+	fssQuery, err := decodeFSSQuery(q, outLen, s.keySize)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := s.answerContext(ctx, []*query.FSS{fssQuery}, outLen, progress, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.Uint32SliceToByteSlice(a[0]), nil
+}
+
+// answerBytesWithStats computes the answer as answerBytesContext, with
+// s.cores goroutines, and measures the CPU time of the whole answer and of
+// each goroutine
+func (s *serverFSS) answerBytesWithStats(q []byte, outLen int) ([]byte, AnswerStats, error) {
+	fssQuery, err := decodeFSSQuery(q, outLen, s.keySize)
+	if err != nil {
+		return nil, AnswerStats{}, err
+	}
+
+	m := monitor.NewMonitor()
+	var workers monitor.Aggregate
+	a, err := s.answerContext(context.Background(), []*query.FSS{fssQuery}, outLen, nil, &workers)
+	if err != nil {
+		return nil, AnswerStats{}, err
+	}
+	computeMs := m.Record()
+	answer := utils.Uint32SliceToByteSlice(a[0])
+	ws := workers.Stats()
+
+	return answer, AnswerStats{
+		AnswerBytes: len(answer),
+		ComputeMs:   computeMs,
+		WorkersUsed: ws.Iterations,
+		Workers:     ws,
+	}, nil
+}
+
+// answerBatchBytes answers the queries with a single pass over the db, see
+// answerContext
+func (s *serverFSS) answerBatchBytes(qs [][]byte, outLen int) ([][]byte, error) {
+	queries := make([]*query.FSS, len(qs))
+	for i := range qs {
+		var err error
+		queries[i], err = decodeFSSQuery(qs[i], outLen, s.keySize)
+		if err != nil {
+			return nil, xerrors.Errorf("query %d: %w", i, err)
+		}
+	}
+
+	a, err := s.answerContext(context.Background(), queries, outLen, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	answers := make([][]byte, len(a))
+	for i := range a {
+		answers[i] = utils.Uint32SliceToByteSlice(a[i])
+	}
+
+	return answers, nil
+}
+
+// decodeFSSQuery decodes a gob-encoded FSS query and checks that it can be
+// evaluated into outLen values with seeds of keySize bytes. The returned
+// errors wrap ErrInvalidQuery.
+func decodeFSSQuery(q []byte, outLen, keySize int) (*query.FSS, error) {
+	buf := bytes.NewBuffer(q)
+	dec := gob.NewDecoder(buf)
+	var query *query.FSS
+	if err := dec.Decode(&query); err != nil {
+		return nil, xerrors.Errorf("%v: %w", err, ErrInvalidQuery)
+	}
+	if err := validateFSSQuery(query, outLen, keySize); err != nil {
+		return nil, xerrors.Errorf("%v: %w", err, ErrInvalidQuery)
+	}
+
+	return query, nil
+}
+
+// validateFSSQuery checks that q is a query supported by answerChunk and
+// that its FSS key has a seed of keySize bytes, one correction word per
+// input bit and outLen final correction values, so that evaluating it
+// cannot go out of bounds
+func validateFSSQuery(q *query.FSS, outLen, keySize int) error {
+	if q == nil || q.Info == nil {
+		return xerrors.New("missing query info")
+	}
+	if q.FromStart < 0 || q.FromEnd < 0 {
+		return xerrors.Errorf("negative substring bounds %d, %d", q.FromStart, q.FromEnd)
+	}
+	if len(q.FssKey.SInit) != keySize {
+		return xerrors.Errorf("seed has length %d, expected %d", len(q.FssKey.SInit), keySize)
+	}
+	for i, cw := range q.FssKey.CW {
+		if len(cw) != keySize+2 {
+			return xerrors.Errorf("correction word %d has length %d, expected %d",
+				i, len(cw), keySize+2)
+		}
+	}
+	if len(q.FssKey.FinalCW) != outLen {
+		return xerrors.Errorf("final correction word has length %d, expected %d",
+			len(q.FssKey.FinalCW), outLen)
+	}
+
+	numBits := len(q.FssKey.CW)
+	switch {
+	case !q.And && !q.Avg && !q.Sum:
+		switch q.Target {
+		case query.UserId:
+			return checkInputBits(numBits, emailInputBits(q.Info))
+		case query.PubKeyAlgo:
+			return checkInputBits(numBits, 8)
+		case query.CreationTime:
+			// a key of yearInputBits matches the year of creation, any
+			// other the encoded creation time, whose length depends on its
+			// location, so that identifiers of another length are skipped
+			if numBits == 0 {
+				return xerrors.New("empty FSS key")
+			}
+			return nil
+		default:
+			return xerrors.Errorf("unknown target %d", q.Target)
+		}
+	case q.And && !q.Avg && !q.Sum:
+		// year of creation followed by the email
+		return checkInputBits(numBits, yearInputBits+emailInputBits(q.Info))
+	case q.And && q.Avg && !q.Sum:
+		return checkInputBits(numBits, emailInputBits(q.Info))
+	default:
+		return xerrors.New("query not supported")
+	}
+}
+
+// yearInputBits is the length in bits of the identifiers returned by
+// IdForYearCreationTime
+const yearInputBits = 32
+
+// emailInputBits returns the length in bits of the identifiers returned by
+// IdForEmail for info
+func emailInputBits(info *query.Info) int {
+	switch {
+	case info.FromStart != 0:
+		return 8 * info.FromStart
+	case info.FromEnd != 0:
+		return 8 * info.FromEnd
+	default:
+		return 8 * 16
+	}
+}
+
+func checkInputBits(numBits, expected int) error {
+	if numBits != expected {
+		return xerrors.Errorf("FSS key for %d input bits, expected %d", numBits, expected)
+	}
+	return nil
+}
+
 func (s *serverFSS) answer(q *query.FSS, out, tmp []uint32) []uint32 {
+	f := s.fssPool.Get().(*fss.Fss)
+	defer s.fssPool.Put(f)
+
+	return s.answerChunk(f, q, 0, s.db.NumColumns, out, tmp)
+}
+
+// answerContext evaluates the FSS keys of the queries over the identifiers
+// in chunks of fssChunkLength, distributed among s.cores goroutines. All the
+// queries are evaluated on a chunk before moving to the next one, so that
+// the db is read once for the whole batch. The goroutines check ctx between
+// chunks and stop as soon as it is done, in which case ctx.Err() is
+// returned. The number of evaluated identifiers is reported to progress, if
+// not nil. If workers is not nil, every goroutine measures its own CPU time,
+// locked to its thread, and adds it to workers. The answers are returned in
+// the order of the queries.
+func (s *serverFSS) answerContext(ctx context.Context, qs []*query.FSS, outLen int,
+	progress func(done, total int), workers *monitor.Aggregate) ([][]uint32, error) {
 	numIdentifiers := s.db.NumColumns
+	numChunks := (numIdentifiers + fssChunkLength - 1) / fssChunkLength
+
+	jobs := make(chan int, numChunks)
+	for i := 0; i < numChunks; i++ {
+		jobs <- i
+	}
+	close(jobs)
+
+	NGoRoutines := s.cores
+	if NGoRoutines > numChunks {
+		NGoRoutines = numChunks
+	}
+	if NGoRoutines < 1 {
+		NGoRoutines = 1
+	}
+
+	var mu sync.Mutex
+	done := 0
+	replies := make(chan [][]uint32, NGoRoutines)
+	for j := 0; j < NGoRoutines; j++ {
+		go func() {
+			if workers != nil {
+				runtime.LockOSThread()
+				defer runtime.UnlockOSThread()
+				m := monitor.NewThreadMonitor()
+				defer func() { workers.Add(m.Record()) }()
+			}
+			f := s.fssPool.Get().(*fss.Fss)
+			defer s.fssPool.Put(f)
+
+			partial := make([][]uint32, len(qs))
+			for i := range jobs {
+				if ctx.Err() != nil {
+					break
+				}
+				begin, end := i*fssChunkLength, (i+1)*fssChunkLength
+				if end > numIdentifiers {
+					end = numIdentifiers
+				}
+				for k, q := range qs {
+					out := s.answerChunk(f, q, begin, end, make([]uint32, outLen), make([]uint32, outLen))
+					partial[k] = addVectors(partial[k], out)
+				}
+
+				mu.Lock()
+				done += end - begin
+				if progress != nil {
+					progress(done, numIdentifiers)
+				}
+				mu.Unlock()
+			}
+			replies <- partial
+		}()
+	}
+
+	answers := make([][]uint32, len(qs))
+	for j := 0; j < NGoRoutines; j++ {
+		partial := <-replies
+		for k := range answers {
+			answers[k] = addVectors(answers[k], partial[k])
+		}
+	}
+	// the evaluation stopped before the last chunk
+	if done < numIdentifiers {
+		return nil, ctx.Err()
+	}
+	// empty db
+	for k, q := range qs {
+		if answers[k] == nil {
+			answers[k] = s.answerChunk(nil, q, 0, 0, make([]uint32, outLen), make([]uint32, outLen))
+		}
+	}
+
+	return answers, nil
+}
+
+// addVectors adds b to a element-wise mod field.ModP and returns a. A nil a
+// is the zero vector.
+func addVectors(a, b []uint32) []uint32 {
+	if a == nil {
+		return b
+	}
+	for i := range b {
+		a[i] = (a[i] + b[i]) % field.ModP
+	}
+	return a
+}
 
+// answerChunk evaluates the FSS keys over the identifiers from begin to end
+// with f, and adds the results to out
+func (s *serverFSS) answerChunk(f *fss.Fss, q *query.FSS, begin, end int, out, tmp []uint32) []uint32 {
 	if !q.And && !q.Avg && !q.Sum {
 		switch q.Target {
 		case query.UserId:
-			for i := 0; i < numIdentifiers; i++ {
+			for i := begin; i < end; i++ {
 				email := s.db.KeysInfo[i].UserId.Email
 				id, valid := q.IdForEmail(email)
 				if !valid {
 					continue
 				}
-				s.fss.EvaluatePF(s.serverNum, q.FssKey, id, tmp)
+				f.EvaluatePF(s.serverNum, q.FssKey, id, tmp)
 				for j := range out {
 					out[j] = (out[j] + tmp[j]) % field.ModP
 				}
 			}
 			return out
 		case query.PubKeyAlgo:
-			for i := 0; i < numIdentifiers; i++ {
+			for i := begin; i < end; i++ {
 				id := q.IdForPubKeyAlgo(s.db.KeysInfo[i].PubKeyAlgo)
-				s.fss.EvaluatePF(s.serverNum, q.FssKey, id, tmp)
+				f.EvaluatePF(s.serverNum, q.FssKey, id, tmp)
 				for j := range out {
 					out[j] = (out[j] + tmp[j]) % field.ModP
 				}
 			}
 			return out
 		case query.CreationTime:
-			for i := 0; i < numIdentifiers; i++ {
-				id, err := q.IdForCreationTime(s.db.KeysInfo[i].CreationTime)
+			idForCreationTime := q.IdForCreationTime
+			if len(q.FssKey.CW) == yearInputBits {
+				idForCreationTime = q.IdForYearCreationTime
+			}
+			for i := begin; i < end; i++ {
+				id, err := idForCreationTime(s.db.KeysInfo[i].CreationTime)
 				if err != nil {
 					panic("impossible to marshal creation date")
 				}
-				s.fss.EvaluatePF(s.serverNum, q.FssKey, id, tmp)
+				// an identifier of another length cannot match the target
+				if len(id) != len(q.FssKey.CW) {
+					continue
+				}
+				f.EvaluatePF(s.serverNum, q.FssKey, id, tmp)
 				for j := range out {
 					out[j] = (out[j] + tmp[j]) % field.ModP
 				}
@@ -82,7 +381,7 @@ func (s *serverFSS) answer(q *query.FSS, out, tmp []uint32) []uint32 {
 			panic("not yet implemented")
 		}
 	} else if q.And && !q.Avg && !q.Sum { // conjunction
-		for i := 0; i < numIdentifiers; i++ {
+		for i := begin; i < end; i++ {
 			// year
 			yearMatch, err := q.IdForYearCreationTime(s.db.KeysInfo[i].CreationTime)
 			if err != nil {
@@ -95,7 +394,7 @@ func (s *serverFSS) answer(q *query.FSS, out, tmp []uint32) []uint32 {
 				continue
 			}
 			in := append(yearMatch, id...)
-			s.fss.EvaluatePF(s.serverNum, q.FssKey, in, tmp)
+			f.EvaluatePF(s.serverNum, q.FssKey, in, tmp)
 			for j := range out {
 				out[j] = (out[j] + tmp[j]) % field.ModP
 			}
@@ -106,14 +405,14 @@ func (s *serverFSS) answer(q *query.FSS, out, tmp []uint32) []uint32 {
 		panic("sum not implemented")
 	} else if q.And && q.Avg && !q.Sum { // avg
 		sum := make([]uint32, len(out))
-		for i := 0; i < numIdentifiers; i++ {
+		for i := begin; i < end; i++ {
 			// year
 			in, valid := q.IdForEmail(s.db.KeysInfo[i].UserId.Email)
 			if !valid {
 				continue
 			}
 
-			s.fss.EvaluatePF(s.serverNum, q.FssKey, in, tmp)
+			f.EvaluatePF(s.serverNum, q.FssKey, in, tmp)
 
 			// compute difference in years between now and creation time
 			diffYears := time.Now().Year() - s.db.KeysInfo[i].CreationTime.Year()
@@ -7,17 +7,34 @@ import (
 
 type LWE struct {
 	db *database.LWE
+	// bits is the db packed a bit per entry, if not nil, in which case the
+	// db only holds the info
+	bits *matrix.MatrixBits
 }
 
 func NewLWE(db *database.LWE) *LWE {
 	return &LWE{db: db}
 }
 
+// NewLWEBitPacked is like NewLWE, but packs the single-bit db a bit per
+// entry, i.e., in eight times less memory. The server does not keep the
+// byte matrix of db, which the caller can release. The answers are the
+// same.
+func NewLWEBitPacked(db *database.LWE) *LWE {
+	return &LWE{
+		db:   &database.LWE{Info: db.Info},
+		bits: matrix.BytesToBits(db.Matrix),
+	}
+}
+
 func (s *LWE) DBInfo() *database.Info {
 	return &s.db.Info
 }
 
 func (s *LWE) AnswerBytes(q []byte) ([]byte, error) {
+	if err := checkNotEmpty(&s.db.Info); err != nil {
+		return nil, err
+	}
 	a := s.Answer(matrix.BytesToMatrix(q))
 	return matrix.MatrixToBytes(a), nil
 }
@@ -25,5 +42,8 @@ func (s *LWE) AnswerBytes(q []byte) ([]byte, error) {
 // Answer function for the LWE-based scheme. The query is represented as a
 // vector
 func (s *LWE) Answer(q *matrix.Matrix) *matrix.Matrix {
+	if s.bits != nil {
+		return matrix.BinaryMulBits(q, s.bits)
+	}
 	return matrix.BinaryMul(q, s.db.Matrix)
 }
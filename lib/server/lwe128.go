@@ -7,17 +7,34 @@ import (
 
 type LWE128 struct {
 	db *database.LWE128
+	// bits is the db packed a bit per entry, if not nil, in which case the
+	// db only holds the info
+	bits *matrix.MatrixBits
 }
 
 func NewLWE128(db *database.LWE128) *LWE128 {
 	return &LWE128{db: db}
 }
 
+// NewLWE128BitPacked is like NewLWE128, but packs the single-bit db a bit per
+// entry, i.e., in eight times less memory. The server does not keep the
+// byte matrix of db, which the caller can release. The answers are the
+// same.
+func NewLWE128BitPacked(db *database.LWE128) *LWE128 {
+	return &LWE128{
+		db:   &database.LWE128{Info: db.Info},
+		bits: matrix.BytesToBits(db.Matrix),
+	}
+}
+
 func (s *LWE128) DBInfo() *database.Info {
 	return &s.db.Info
 }
 
 func (s *LWE128) AnswerBytes(q []byte) ([]byte, error) {
+	if err := checkNotEmpty(&s.db.Info); err != nil {
+		return nil, err
+	}
 	a := s.Answer(matrix.BytesToMatrix128(q))
 	return matrix.Matrix128ToBytes(a), nil
 }
@@ -25,5 +42,8 @@ func (s *LWE128) AnswerBytes(q []byte) ([]byte, error) {
 // Answer function for the LWE-based scheme. The query is represented as a
 // vector
 func (s *LWE128) Answer(q *matrix.Matrix128) *matrix.Matrix128 {
+	if s.bits != nil {
+		return matrix.BinaryMulBits128(q, s.bits)
+	}
 	return matrix.BinaryMul128(q, s.db.Matrix)
 }
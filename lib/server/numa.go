@@ -0,0 +1,223 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// NUMANodesEnv is the environment variable mapping the NUMA nodes of the
+// machine to their CPUs, as the CPU lists of the nodes separated by
+// semicolons, each in the cpulist format of Linux, e.g.,
+// "0-7,16-23;8-15,24-31" for two sockets of eight cores with two threads
+// each. If it is not set, NUMANodes reads the nodes from sysfs.
+const NUMANodesEnv = "VPIR_NUMA_NODES"
+
+// sysNodes is where Linux describes the NUMA nodes
+const sysNodes = "/sys/devices/system/node"
+
+// NUMANodes returns the CPUs of every NUMA node of the machine, as given by
+// NUMANodesEnv or, if it is not set, as read from sysfs. The nodes without
+// CPUs, e.g., memory-only nodes, are left out.
+func NUMANodes() ([][]int, error) {
+	if s := os.Getenv(NUMANodesEnv); s != "" {
+		return ParseNUMANodes(s)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(sysNodes, "node*", "cpulist"))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to list the NUMA nodes: %v", err)
+	}
+	if len(paths) == 0 {
+		return nil, xerrors.Errorf("no NUMA node in %s, set %s", sysNodes, NUMANodesEnv)
+	}
+	// node10 comes after node9
+	nodeNum := func(path string) int {
+		n, _ := strconv.Atoi(strings.TrimPrefix(filepath.Base(filepath.Dir(path)), "node"))
+		return n
+	}
+	sort.Slice(paths, func(i, j int) bool { return nodeNum(paths[i]) < nodeNum(paths[j]) })
+
+	nodes := make([][]int, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read the CPUs of a NUMA node: %v", err)
+		}
+		cpus, err := parseCPUList(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, xerrors.Errorf("%s: %v", path, err)
+		}
+		if len(cpus) > 0 {
+			nodes = append(nodes, cpus)
+		}
+	}
+
+	return nodes, nil
+}
+
+// ParseNUMANodes parses the CPUs of the NUMA nodes given in the format of
+// NUMANodesEnv
+func ParseNUMANodes(s string) ([][]int, error) {
+	var nodes [][]int
+	for i, list := range strings.Split(s, ";") {
+		cpus, err := parseCPUList(strings.TrimSpace(list))
+		if err != nil {
+			return nil, xerrors.Errorf("node %d: %v", i, err)
+		}
+		if len(cpus) == 0 {
+			return nil, xerrors.Errorf("node %d without CPUs", i)
+		}
+		nodes = append(nodes, cpus)
+	}
+
+	return nodes, nil
+}
+
+// parseCPUList parses a list of CPUs in the cpulist format of Linux, i.e.,
+// CPU numbers and ranges separated by commas, e.g., "0-3,8"
+func parseCPUList(s string) ([]int, error) {
+	var cpus []int
+	if s == "" {
+		return cpus, nil
+	}
+	for _, r := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(r), "-")
+		lo, err := strconv.Atoi(first)
+		if err != nil || lo < 0 {
+			return nil, xerrors.Errorf("invalid CPU %q", first)
+		}
+		hi := lo
+		if isRange {
+			if hi, err = strconv.Atoi(last); err != nil || hi < lo {
+				return nil, xerrors.Errorf("invalid CPU range %q", r)
+			}
+		}
+		for c := lo; c <= hi; c++ {
+			cpus = append(cpus, c)
+		}
+	}
+
+	return cpus, nil
+}
+
+// numaWorker computes the rows from begin to end of an answer on the
+// thread of a numaPool pinned to cpu, of the given id
+type numaWorker struct {
+	id         int
+	cpu        int
+	begin, end int
+}
+
+// numaPartition splits numRows rows among the CPUs of the nodes in
+// proportion, in order, so that every node gets contiguous rows. It returns
+// the workers of every node, leaving out the CPUs without rows. The id of
+// a worker is the index of its CPU among all the CPUs of the nodes, as in
+// newNUMAPool.
+func numaPartition(numRows int, nodes [][]int) [][]numaWorker {
+	numCPUs := 0
+	for _, cpus := range nodes {
+		numCPUs += len(cpus)
+	}
+
+	out := make([][]numaWorker, len(nodes))
+	k := 0
+	for n, cpus := range nodes {
+		for _, cpu := range cpus {
+			begin, end := k*numRows/numCPUs, (k+1)*numRows/numCPUs
+			if begin < end {
+				out[n] = append(out[n], numaWorker{id: k, cpu: cpu, begin: begin, end: end})
+			}
+			k++
+		}
+	}
+
+	return out
+}
+
+// checkNUMANodes returns an error if there is no node or a node without
+// CPUs
+func checkNUMANodes(nodes [][]int) error {
+	if len(nodes) == 0 {
+		return xerrors.New("no NUMA node")
+	}
+	for i, cpus := range nodes {
+		if len(cpus) == 0 {
+			return xerrors.Errorf("node %d without CPUs", i)
+		}
+	}
+	return nil
+}
+
+// numaPool runs jobs on persistent goroutines, one per CPU, each locked to
+// its thread and pinned to its CPU once, when the pool starts, so that no
+// thread is created or pinned while answering. Pinning is best effort: a
+// thread that cannot be pinned, e.g., to a CPU outside of the CPU set of
+// the process, runs unpinned. The goroutines return with their thread
+// locked when the pool is closed, so that the pinned threads exit then.
+type numaPool struct {
+	jobs []chan func()
+}
+
+// newNUMAPool starts a pool with a thread pinned to each CPU of the nodes,
+// in order
+func newNUMAPool(nodes [][]int) *numaPool {
+	p := new(numaPool)
+	for _, cpus := range nodes {
+		for _, cpu := range cpus {
+			jobs := make(chan func())
+			p.jobs = append(p.jobs, jobs)
+			go func(cpu int) {
+				_ = pinThread(cpu)
+				for job := range jobs {
+					job()
+				}
+			}(cpu)
+		}
+	}
+
+	return p
+}
+
+// run runs job on the worker of the given id. It returns once the worker
+// has taken the job, which may wait for its previous job to complete.
+func (p *numaPool) run(id int, job func()) {
+	p.jobs[id] <- job
+}
+
+// close stops the workers once they complete their jobs. The pool cannot
+// be used afterwards.
+func (p *numaPool) close() {
+	for _, jobs := range p.jobs {
+		close(jobs)
+	}
+}
+
+// copyToNodes returns, for every node, a copy of entries[starts[n]:ends[n]]
+// written by the first worker of the node, which Linux allocates in the
+// memory of the node as it first touches it. A node without workers gets
+// no copy.
+func (p *numaPool) copyToNodes(nodes [][]numaWorker, entries []byte, starts, ends []int) [][]byte {
+	copies := make([][]byte, len(nodes))
+	var wg sync.WaitGroup
+	for n, workers := range nodes {
+		if len(workers) == 0 {
+			continue
+		}
+		n := n
+		wg.Add(1)
+		p.run(workers[0].id, func() {
+			defer wg.Done()
+			copies[n] = make([]byte, ends[n]-starts[n])
+			copy(copies[n], entries[starts[n]:ends[n]])
+		})
+	}
+	wg.Wait()
+
+	return copies
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package server
+
+import (
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// pinThread locks the calling goroutine to its thread and restricts the
+// thread to cpu. The goroutine must return without unlocking the thread,
+// so that the thread exits with it instead of running other goroutines on
+// cpu only.
+func pinThread(cpu int) error {
+	runtime.LockOSThread()
+	var set unix.CPUSet
+	set.Set(cpu)
+	return unix.SchedSetaffinity(0, &set)
+}
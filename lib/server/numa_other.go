@@ -0,0 +1,9 @@
+//go:build !linux
+
+package server
+
+import "golang.org/x/xerrors"
+
+func pinThread(cpu int) error {
+	return xerrors.New("pinning threads to CPUs is not supported on this system")
+}
@@ -1,10 +1,15 @@
 package server
 
 import (
+	"encoding/binary"
 	"runtime"
+	"sync"
+	"time"
 
 	"github.com/lukechampine/fastxor"
 	"github.com/si-co/vpir-code/lib/database"
+	"github.com/si-co/vpir-code/lib/utils"
+	"golang.org/x/xerrors"
 )
 
 // PIR is the server for the information theoretic classical PIR scheme
@@ -13,9 +18,32 @@ import (
 // unauthenticated setting. The former adds Merkle-tree based authentication
 // information to the database entries, but this is trasparent from the server
 // perspective and only changes the database creation.
+// A PIR server is safe for concurrent use by multiple clients.
 type PIR struct {
+	// mu guards db, so that it can be swapped while answering queries
+	mu    sync.RWMutex
 	db    *database.Bytes
 	cores int
+	// numa holds the copies of db on the NUMA nodes, if not nil
+	numa *pirNUMA
+}
+
+// pirNUMA holds the rows of the db of a PIR server on each NUMA node,
+// answered by the threads of pool
+type pirNUMA struct {
+	nodes [][]int
+	pool  *numaPool
+	// parts are guarded by the mutex of the server, as its db
+	parts []pirPart
+}
+
+// pirPart holds a copy of rows of a db in the memory of a NUMA node, as a db
+// with the info of the whole db, and the workers of the node answering on
+// them. The entries of the rows of worker i start at pos[i] in the copy.
+type pirPart struct {
+	db      *database.Bytes
+	workers []numaWorker
+	pos     []int
 }
 
 // NewPIR return a server for the information theoretic single-bit
@@ -28,36 +56,380 @@ func NewPIR(db *database.Bytes, cores ...int) *PIR {
 	return &PIR{db: db, cores: cores[0]}
 }
 
+// NewPIRWithNUMA is like NewPIR, but the answers of AnswerBytes are
+// computed by a thread pinned to each CPU of the NUMA nodes, given as by
+// NUMANodes, on a copy of their rows in the memory of their node, as in
+// NewDHWithNUMA. The db is held twice, and so is every db given to SwapDB,
+// which copies it to the nodes before swapping it. The other answers are
+// computed on the db as with NewPIR. The server must be closed to stop its
+// threads.
+func NewPIRWithNUMA(db *database.Bytes, nodes [][]int) (*PIR, error) {
+	if err := checkNUMANodes(nodes); err != nil {
+		return nil, err
+	}
+
+	numCPUs := 0
+	for _, cpus := range nodes {
+		numCPUs += len(cpus)
+	}
+	numa := &pirNUMA{nodes: nodes, pool: newNUMAPool(nodes)}
+	numa.parts = numa.split(db)
+
+	return &PIR{db: db, cores: numCPUs, numa: numa}, nil
+}
+
+// Close stops the threads of a server returned by NewPIRWithNUMA. It does
+// nothing for other servers.
+func (s *PIR) Close() {
+	if s.numa != nil {
+		s.numa.pool.close()
+	}
+}
+
 // DBInfo returns database info
 func (s *PIR) DBInfo() *database.Info {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return &s.db.Info
 }
 
-// AnswerBytes computes the answer for the given query encoded in bytes
+// SwapDB atomically replaces the database served by s. Answers computed
+// concurrently complete against the database they started with, while
+// answers starting after SwapDB returns use the new one.
+func (s *PIR) SwapDB(db *database.Bytes) {
+	var parts []pirPart
+	if s.numa != nil {
+		parts = s.numa.split(db)
+	}
+
+	s.mu.Lock()
+	s.db = db
+	if s.numa != nil {
+		s.numa.parts = parts
+	}
+	s.mu.Unlock()
+}
+
+// Warmup reads every memory page of the database, so that the first
+// answers on a database mapped by database.MapBytes do not pay the page
+// faults of loading it. It returns the time it took.
+func (s *PIR) Warmup() time.Duration {
+	t := time.Now()
+	s.mu.RLock()
+	s.db.Touch()
+	s.mu.RUnlock()
+
+	return time.Since(t)
+}
+
+// AnswerBytes computes the answer for the given query encoded in bytes. It
+// returns an error wrapping ErrInvalidQuery if the query is too short for
+// the database.
 func (s *PIR) AnswerBytes(q []byte) ([]byte, error) {
-	return s.Answer(q), nil
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := checkQueryVector(&s.db.Info, q); err != nil {
+		return nil, err
+	}
+
+	if s.numa != nil {
+		return s.numa.answer(s.db, q), nil
+	}
+	return answerPIR(s.db, q), nil
+}
+
+// answerInto computes the answer for the given query encoded in bytes into
+// dst, which must be NumRows*BlockSize bytes long. It does not allocate, so
+// that the cost of the computation can be measured on its own.
+func (s *PIR) answerInto(q, dst []byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := checkQueryVector(&s.db.Info, q); err != nil {
+		return err
+	}
+	if len(dst) != s.db.NumRows*s.db.BlockSize {
+		return xerrors.Errorf("answer buffer of %d bytes, expected %d bytes",
+			len(dst), s.db.NumRows*s.db.BlockSize)
+	}
+	answerPIRInto(s.db, q, dst)
+
+	return nil
+}
+
+// AnswerBytesInBands computes the same answer as AnswerBytes in bands of
+// bandRows rows, the last one being possibly shorter, and passes the bands
+// in order to emit, with their index. The answer is never held whole: a
+// single band is allocated and reused, so that emit must copy it to retain
+// it, e.g., to send it as the AnswerChunk of the given index, of
+// bandRows*BlockSize bytes, of a reply streamed to
+// client.PIR.ReconstructStream. The db is the same for all the bands. It
+// stops at the first error of emit and returns it, and returns an error
+// wrapping ErrInvalidQuery if the query is too short for the db.
+func (s *PIR) AnswerBytesInBands(q []byte, bandRows int, emit func(index int, band []byte) error) error {
+	if bandRows < 1 {
+		return xerrors.Errorf("invalid band of %d rows", bandRows)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := checkQueryVector(&s.db.Info, q); err != nil {
+		return err
+	}
+
+	band := make([]byte, utils.Min(bandRows, s.db.NumRows)*s.db.BlockSize)
+	pos := 0
+	for begin := 0; begin < s.db.NumRows; begin += bandRows {
+		end := utils.Min(begin+bandRows, s.db.NumRows)
+		out := band[:(end-begin)*s.db.BlockSize]
+		pos = answerPIRRowsInto(s.db, q, begin, end, pos, out)
+		if err := emit(begin/bandRows, out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AnswerBatchBytes computes the answers for the given queries encoded in
+// bytes, positionally, on the same version of the db. It returns an error
+// wrapping ErrInvalidQuery if any query is too short for the db.
+func (s *PIR) AnswerBatchBytes(queries [][]byte) ([][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i, q := range queries {
+		if err := checkQueryVector(&s.db.Info, q); err != nil {
+			return nil, xerrors.Errorf("query %d: %w", i, err)
+		}
+	}
+
+	answers := make([][]byte, len(queries))
+	for i, q := range queries {
+		answers[i] = answerPIR(s.db, q)
+	}
+
+	return answers, nil
+}
+
+// AnswerSeedBytes computes the answer for a query sent as the PRG seed of
+// the query vector by client.PIR.QuerySeeded. It returns an error wrapping
+// ErrInvalidQuery if seed is not a PRG key.
+func (s *PIR) AnswerSeedBytes(seed []byte) ([]byte, error) {
+	var key utils.PRGKey
+	if len(seed) != len(key) {
+		return nil, xerrors.Errorf("seed of %d bytes, expected %d bytes: %w",
+			len(seed), len(key), ErrInvalidQuery)
+	}
+	copy(key[:], seed)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := checkNotEmpty(&s.db.Info); err != nil {
+		return nil, err
+	}
+	// the client expands the seed to the same length
+	q := utils.ExpandSeed(&key, s.db.QueryVectorBytes())
+
+	return answerPIR(s.db, q), nil
+}
+
+// AnswerRangeBytes computes the answer to a range query of
+// client.PIR.QueryRange, i.e., the number of blocks followed by a query
+// vector. The answer is the concatenation of the answers to the query vector
+// shifted cyclically by every offset in the range, computed reading the db
+// once. It returns an error wrapping ErrInvalidQuery if the query is too
+// short or the range spans more blocks than the columns of the db.
+func (s *PIR) AnswerRangeBytes(q []byte) ([]byte, error) {
+	// the number of blocks is encoded in 4 bytes
+	if len(q) < 4 {
+		return nil, xerrors.Errorf("range query of %d bytes: %w", len(q), ErrInvalidQuery)
+	}
+	numBlocks := int(binary.BigEndian.Uint32(q))
+	q = q[4:]
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := checkQueryVector(&s.db.Info, q); err != nil {
+		return nil, err
+	}
+	if numBlocks < 1 || numBlocks > s.db.NumColumns {
+		return nil, xerrors.Errorf("range of %d blocks for a db with %d columns: %w",
+			numBlocks, s.db.NumColumns, ErrInvalidQuery)
+	}
+
+	return answerPIRRange(s.db, q, numBlocks), nil
+}
+
+// checkQueryVector returns an error wrapping database.ErrEmptyDB if info
+// describes an empty db, and an error wrapping ErrInvalidQuery if q is
+// shorter than the query vector of the db, one bit per column, as sent by
+// the client
+func checkQueryVector(info *database.Info, q []byte) error {
+	if err := checkNotEmpty(info); err != nil {
+		return err
+	}
+	if expected := info.QueryVectorBytes(); len(q) < expected {
+		return xerrors.Errorf("query of %d bytes for a db with %d columns, expected at least %d bytes: %w",
+			len(q), info.NumColumns, expected, ErrInvalidQuery)
+	}
+	return nil
+}
+
+// AnswerBytesWithStats computes the answer for the given query encoded in
+// bytes and returns statistics about its computation. The answer is
+// computed by a single goroutine, or by the workers of the NUMA nodes
+// holding rows of the db for a server created with NewPIRWithNUMA.
+func (s *PIR) AnswerBytesWithStats(q []byte) ([]byte, AnswerStats, error) {
+	return answerBytesWithStats(s.AnswerBytes, q, s.workersUsed())
+}
+
+// workersUsed returns the number of goroutines computing the answers of
+// AnswerBytes
+func (s *PIR) workersUsed() int {
+	if s.numa == nil {
+		return 1
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	workers := 0
+	for _, p := range s.numa.parts {
+		workers += len(p.workers)
+	}
+	return workers
 }
 
 // Answer computes the answer for the given query
 func (s *PIR) Answer(q []byte) []byte {
-	nRows := s.db.NumRows
-	nCols := s.db.NumColumns
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return answerPIR(s.db, q)
+}
 
-	var prevPos, nextPos int
-	out := make([]byte, nRows*s.db.BlockSize)
+func answerPIR(db *database.Bytes, q []byte) []byte {
+	out := make([]byte, db.NumRows*db.BlockSize)
+	answerPIRInto(db, q, out)
+	return out
+}
+
+// answerPIRInto writes the answer to q into out, overwriting its content
+func answerPIRInto(db *database.Bytes, q []byte, out []byte) {
+	answerPIRRowsInto(db, q, 0, db.NumRows, 0, out)
+}
 
-	for i := 0; i < nRows; i++ {
+// answerPIRRowsInto writes the rows from begin to end of the answer to q
+// into out, overwriting its content. The entries of row begin start at byte
+// pos of the entries of db, and the position of the entries of row end is
+// returned.
+func answerPIRRowsInto(db *database.Bytes, q []byte, begin, end, pos int, out []byte) int {
+	nCols := db.NumColumns
+
+	prevPos, nextPos := pos, pos
+	for i := range out {
+		out[i] = 0
+	}
+
+	for i := begin; i < end; i++ {
 		for j := 0; j < nCols; j++ {
-			nextPos += s.db.BlockLengths[i*nCols+j]
+			nextPos += db.BlockLengths[i*nCols+j]
 		}
+		k := i - begin
 		xorValues(
-			s.db.Entries[prevPos:nextPos],
-			s.db.BlockLengths[i*nCols:(i+1)*nCols],
+			db.Entries[prevPos:nextPos],
+			db.BlockLengths[i*nCols:(i+1)*nCols],
 			q,
-			s.db.BlockSize,
-			out[i*s.db.BlockSize:(i+1)*s.db.BlockSize])
+			db.BlockSize,
+			out[k*db.BlockSize:(k+1)*db.BlockSize])
 		prevPos = nextPos
 	}
+
+	return nextPos
+}
+
+// split copies the rows of db to the NUMA nodes, split as by numaPartition
+func (n *pirNUMA) split(db *database.Bytes) []pirPart {
+	partition := numaPartition(db.NumRows, n.nodes)
+	offsets := db.BlockOffsets()
+	// the entries of row i start at offsets[i*NumColumns]
+	starts := make([]int, len(partition))
+	ends := make([]int, len(partition))
+	for i, workers := range partition {
+		if len(workers) > 0 {
+			starts[i] = offsets[workers[0].begin*db.NumColumns]
+			ends[i] = offsets[workers[len(workers)-1].end*db.NumColumns]
+		}
+	}
+	copies := n.pool.copyToNodes(partition, db.Entries, starts, ends)
+
+	var parts []pirPart
+	for i, workers := range partition {
+		if len(workers) == 0 {
+			continue
+		}
+		p := pirPart{db: &database.Bytes{Entries: copies[i], Info: db.Info}, workers: workers}
+		for _, w := range workers {
+			p.pos = append(p.pos, offsets[w.begin*db.NumColumns]-starts[i])
+		}
+		parts = append(parts, p)
+	}
+
+	return parts
+}
+
+// answer computes the answer to q on the copies of the rows of db, which
+// must be those of parts
+func (n *pirNUMA) answer(db *database.Bytes, q []byte) []byte {
+	out := make([]byte, db.NumRows*db.BlockSize)
+	var wg sync.WaitGroup
+	for _, p := range n.parts {
+		for i, w := range p.workers {
+			partDB, pos, begin, end := p.db, p.pos[i], w.begin, w.end
+			wg.Add(1)
+			n.pool.run(w.id, func() {
+				defer wg.Done()
+				answerPIRRowsInto(partDB, q, begin, end, pos, out[begin*db.BlockSize:end*db.BlockSize])
+			})
+		}
+	}
+	wg.Wait()
+
+	return out
+}
+
+// answerPIRRange returns the answers to q shifted cyclically by the offsets
+// from 0 to numBlocks-1, concatenated. Every block is XORed into the answer
+// of each offset whose shifted query selects its column.
+func answerPIRRange(db *database.Bytes, q []byte, numBlocks int) []byte {
+	nCols := db.NumColumns
+	pointLen := db.NumRows * db.BlockSize
+	out := make([]byte, numBlocks*pointLen)
+
+	pos := 0
+	for i := 0; i < db.NumRows; i++ {
+		for j := 0; j < nCols; j++ {
+			l := db.BlockLengths[i*nCols+j]
+			block := db.Entries[pos : pos+l]
+			for k := 0; k < numBlocks; k++ {
+				// column of q shifted to column j by offset k
+				src := j - k
+				if src < 0 {
+					src += nCols
+				}
+				if (q[src/8]>>(src%8))&1 == byte(1) {
+					begin := k*pointLen + i*db.BlockSize
+					fastxor.Bytes(out[begin:begin+db.BlockSize], out[begin:begin+db.BlockSize], block)
+				}
+			}
+			pos += l
+		}
+	}
+
 	return out
 }
 
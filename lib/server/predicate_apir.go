@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"runtime"
 
 	"github.com/si-co/vpir-code/lib/database"
@@ -15,6 +16,20 @@ type PredicateAPIR struct {
 }
 
 func NewPredicateAPIR(db *database.DB, serverNum byte, cores ...int) *PredicateAPIR {
+	return newPredicateAPIR(db, serverNum, fss.DefaultKeySize, cores...)
+}
+
+// NewPredicateAPIRWithKeySize is like NewPredicateAPIR, but the FSS uses
+// PRF keys of keySize bytes, see fss.KeySize128. The client must use the
+// same key size.
+func NewPredicateAPIRWithKeySize(db *database.DB, serverNum byte, keySize int, cores ...int) (*PredicateAPIR, error) {
+	if err := fss.CheckKeySize(keySize); err != nil {
+		return nil, err
+	}
+	return newPredicateAPIR(db, serverNum, keySize, cores...), nil
+}
+
+func newPredicateAPIR(db *database.DB, serverNum byte, keySize int, cores ...int) *PredicateAPIR {
 	// use variadic argument for cores to achieve backward compatibility
 	numCores := runtime.NumCPU()
 	if len(cores) > 0 {
@@ -26,8 +41,9 @@ func NewPredicateAPIR(db *database.DB, serverNum byte, cores ...int) *PredicateA
 			db:        db,
 			cores:     numCores,
 			serverNum: serverNum,
+			keySize:   keySize,
 			// one value for the data, four values for the info-theoretic MAC
-			fss: fss.ServerInitialize(1 + field.ConcurrentExecutions),
+			fssPool: newFssPool(1+field.ConcurrentExecutions, keySize),
 		},
 	}
 }
@@ -43,6 +59,25 @@ func (s *PredicateAPIR) AnswerBytes(q []byte) ([]byte, error) {
 	return s.serverFSS.answerBytes(q, out, tmp)
 }
 
+// AnswerBytesContext is like AnswerBytes, but stops early if ctx is done,
+// see PredicatePIR.AnswerBytesContext
+func (s *PredicateAPIR) AnswerBytesContext(ctx context.Context, q []byte,
+	progress func(done, total int)) ([]byte, error) {
+	return s.serverFSS.answerBytesContext(ctx, q, 1+field.ConcurrentExecutions, progress)
+}
+
+// AnswerBatchBytes answers the queries positionally, evaluating all of them
+// in a single pass over the db
+func (s *PredicateAPIR) AnswerBatchBytes(queries [][]byte) ([][]byte, error) {
+	return s.serverFSS.answerBatchBytes(queries, 1+field.ConcurrentExecutions)
+}
+
+// AnswerBytesWithStats is like AnswerBytes, but also returns statistics
+// about the computation, see PredicatePIR.AnswerBytesWithStats
+func (s *PredicateAPIR) AnswerBytesWithStats(q []byte) ([]byte, AnswerStats, error) {
+	return s.serverFSS.answerBytesWithStats(q, 1+field.ConcurrentExecutions)
+}
+
 func (s *PredicateAPIR) Answer(q *query.FSS) []uint32 {
 	out := make([]uint32, 1+field.ConcurrentExecutions)
 	tmp := make([]uint32, 1+field.ConcurrentExecutions)
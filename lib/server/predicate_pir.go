@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"runtime"
 
 	"github.com/si-co/vpir-code/lib/database"
@@ -15,6 +16,20 @@ type PredicatePIR struct {
 
 // NewPredicatePIR initializes and returns a new server for FSS-based classical PIR
 func NewPredicatePIR(db *database.DB, serverNum byte, cores ...int) *PredicatePIR {
+	return newPredicatePIR(db, serverNum, fss.DefaultKeySize, cores...)
+}
+
+// NewPredicatePIRWithKeySize is like NewPredicatePIR, but the FSS uses PRF
+// keys of keySize bytes, see fss.KeySize128. The client must use the same
+// key size.
+func NewPredicatePIRWithKeySize(db *database.DB, serverNum byte, keySize int, cores ...int) (*PredicatePIR, error) {
+	if err := fss.CheckKeySize(keySize); err != nil {
+		return nil, err
+	}
+	return newPredicatePIR(db, serverNum, keySize, cores...), nil
+}
+
+func newPredicatePIR(db *database.DB, serverNum byte, keySize int, cores ...int) *PredicatePIR {
 	numCores := runtime.NumCPU()
 	if len(cores) > 0 {
 		numCores = cores[0]
@@ -25,7 +40,8 @@ func NewPredicatePIR(db *database.DB, serverNum byte, cores ...int) *PredicatePI
 			db:        db,
 			cores:     numCores,
 			serverNum: serverNum,
-			fss:       fss.ServerInitialize(1), // only one value for data
+			keySize:   keySize,
+			fssPool:   newFssPool(1, keySize), // only one value for data
 		},
 	}
 }
@@ -43,6 +59,28 @@ func (s *PredicatePIR) AnswerBytes(q []byte) ([]byte, error) {
 	return s.serverFSS.answerBytes(q, out, tmp)
 }
 
+// AnswerBytesContext computes the answer for the given query encoded in
+// bytes like AnswerBytes, but in parallel and in chunks. It stops early and
+// returns ctx.Err() if ctx is done, and reports the progress of the
+// evaluation to progress, if not nil.
+func (s *PredicatePIR) AnswerBytesContext(ctx context.Context, q []byte,
+	progress func(done, total int)) ([]byte, error) {
+	return s.serverFSS.answerBytesContext(ctx, q, 1, progress)
+}
+
+// AnswerBatchBytes answers the queries positionally, evaluating all of them
+// in a single pass over the db
+func (s *PredicatePIR) AnswerBatchBytes(queries [][]byte) ([][]byte, error) {
+	return s.serverFSS.answerBatchBytes(queries, 1)
+}
+
+// AnswerBytesWithStats computes the answer for the given query encoded in
+// bytes as AnswerBytesContext, in parallel, and returns statistics about its
+// computation, including the CPU time of every goroutine
+func (s *PredicatePIR) AnswerBytesWithStats(q []byte) ([]byte, AnswerStats, error) {
+	return s.serverFSS.answerBytesWithStats(q, 1)
+}
+
 // Answer computes the answer for the given query
 func (s *PredicatePIR) Answer(q *query.FSS) []uint32 {
 	out := []uint32{0}
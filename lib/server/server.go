@@ -1,12 +1,80 @@
 package server
 
 import (
+	"errors"
+	"time"
+
 	"github.com/si-co/vpir-code/lib/database"
+	"github.com/si-co/vpir-code/lib/monitor"
+	"golang.org/x/xerrors"
 )
 
+// ErrInvalidQuery is wrapped by the errors returned for queries that do not
+// match the database, e.g., when client and server disagree on its
+// dimensions
+var ErrInvalidQuery = errors.New("invalid query")
+
 // Server is a scheme-agnostic VPIR server interface, implemented by both IT
 // and DPF-based schemes
 type Server interface {
 	AnswerBytes([]byte) ([]byte, error)
 	DBInfo() *database.Info
 }
+
+// StatsServer is a Server that also reports statistics about the cost of
+// its answers
+type StatsServer interface {
+	Server
+	AnswerBytesWithStats([]byte) ([]byte, AnswerStats, error)
+}
+
+// BatchServer is a Server that also answers a batch of queries at once,
+// sharing the work common to the queries
+type BatchServer interface {
+	Server
+	AnswerBatchBytes([][]byte) ([][]byte, error)
+}
+
+// WarmupServer is a Server whose db can be loaded in memory before the
+// first query, e.g., when it is mapped from a file, so that the first
+// answers are not slowed down by loading it
+type WarmupServer interface {
+	Server
+	Warmup() time.Duration
+}
+
+// AnswerStats holds statistics about the computation of a single answer
+type AnswerStats struct {
+	// AnswerBytes is the length of the answer in bytes
+	AnswerBytes int
+	// ComputeMs is the CPU time spent computing the answer, in milliseconds
+	ComputeMs float64
+	// WorkersUsed is the number of goroutines used to compute the answer
+	WorkersUsed int
+	// Workers summarizes the CPU time of each goroutine used to compute the
+	// answer, if measured separately, one iteration per goroutine
+	Workers monitor.Stats
+}
+
+// answerBytesWithStats runs answer on q and measures its CPU time
+func answerBytesWithStats(answer func([]byte) ([]byte, error), q []byte, workers int) (
+	[]byte, AnswerStats, error) {
+	m := monitor.NewMonitor()
+	a, err := answer(q)
+	if err != nil {
+		return nil, AnswerStats{}, err
+	}
+
+	return a, AnswerStats{AnswerBytes: len(a), ComputeMs: m.Record(), WorkersUsed: workers}, nil
+}
+
+// checkNotEmpty returns an error wrapping database.ErrEmptyDB if info
+// describes a database without blocks, which the builders reject but a
+// hand-made or corrupted info could still describe
+func checkNotEmpty(info *database.Info) error {
+	if info.NumBlocks() == 0 {
+		return xerrors.Errorf("db of %d rows and %d columns: %w",
+			info.NumRows, info.NumColumns, database.ErrEmptyDB)
+	}
+	return nil
+}
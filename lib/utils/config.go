@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"path/filepath"
 	"strconv"
 
 	"github.com/BurntSushi/toml"
@@ -10,10 +11,50 @@ import (
 
 type Config struct {
 	Servers map[string]Server
+	// DB optionally defines the database served in the simulations
+	DB *DBConfig
+	// TLS optionally defines the credentials of every server, keyed by
+	// its address. If it is empty, the shared ServerPublicKeys are used.
+	TLS map[string]TLSConfig
 
 	Addresses []string
 }
 
+// DBConfig defines the database built by the simulation servers, so that
+// benchmark scripts can set it externally. It is read from the [db] section
+// of the config file:
+//
+//	[db]
+//	scheme = "pir-classic" # pir-classic, pir-merkle, fss-classic or fss-auth
+//	dbLen = 8388608        # length in bits
+//	elemBitSize = 8        # bit size of an element
+//	nRows = 1              # number of rows, 1 for the vector layout
+//	blockLen = 64          # block length in elements
+//	layout = "matrix"      # optional, vector|matrix, overrides nRows
+type DBConfig struct {
+	Scheme      string `toml:"scheme"`
+	DBLen       int    `toml:"dbLen"`
+	ElemBitSize int    `toml:"elemBitSize"`
+	NRows       int    `toml:"nRows"`
+	BlockLen    int    `toml:"blockLen"`
+	Layout      string `toml:"layout"`
+}
+
+// TLSConfig defines the credentials used by the clients to connect to a
+// server with its own certificate. It is read from the [tls] section of the
+// config file, keyed by the address of the server:
+//
+//	[tls."10.0.0.1:50051"]
+//	ca = "server0-ca.pem"              # PEM file of the CA of the server
+//	serverName = "server0.example.org" # optional, name in the certificate
+//
+// A relative CA path is relative to the directory of the config file. If
+// serverName is empty, the host of the address must be in the certificate.
+type TLSConfig struct {
+	CA         string `toml:"ca"`
+	ServerName string `toml:"serverName"`
+}
+
 type Server struct {
 	Index int
 	IP    string
@@ -39,5 +80,47 @@ func LoadConfig(configFile string) (*Config, error) {
 	}
 	c.Addresses = addresses
 
+	// the CAs are relative to the config file
+	dir := filepath.Dir(configFile)
+	for addr, t := range c.TLS {
+		if t.CA != "" && !filepath.IsAbs(t.CA) {
+			t.CA = filepath.Join(dir, t.CA)
+			c.TLS[addr] = t
+		}
+	}
+	if err := c.validateTLS(); err != nil {
+		return nil, err
+	}
+
 	return c, nil
 }
+
+// validateTLS checks that, if the TLS section is set, it has an entry with
+// a CA for every server address and no entry for other addresses
+func (c *Config) validateTLS() error {
+	if len(c.TLS) == 0 {
+		return nil
+	}
+	for _, addr := range c.Addresses {
+		t, ok := c.TLS[addr]
+		if !ok {
+			return xerrors.Errorf("no TLS entry for server %s", addr)
+		}
+		if t.CA == "" {
+			return xerrors.Errorf("no CA in the TLS entry for server %s", addr)
+		}
+	}
+	if len(c.TLS) != len(c.Addresses) {
+		servers := make(map[string]bool, len(c.Addresses))
+		for _, addr := range c.Addresses {
+			servers[addr] = true
+		}
+		for addr := range c.TLS {
+			if !servers[addr] {
+				return xerrors.Errorf("TLS entry for %s, which is not a server address", addr)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// Logger writes structured records through a standard logger, one JSON
+// object per line. A record holds the time, the level and the message,
+// followed by the fields of the logger and by the key-value pairs given
+// with the record, in order. Errors are written as their message and
+// durations as nanoseconds.
+type Logger struct {
+	out    *log.Logger
+	fields []interface{}
+}
+
+// NewLogger returns a logger writing to w, whose records all carry the
+// given key-value pairs
+func NewLogger(w io.Writer, fields ...interface{}) *Logger {
+	return &Logger{out: log.New(w, "", 0), fields: fields}
+}
+
+// Info writes a record of level INFO
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	l.write("INFO", msg, kv)
+}
+
+// Warn writes a record of level WARN
+func (l *Logger) Warn(msg string, kv ...interface{}) {
+	l.write("WARN", msg, kv)
+}
+
+// Error writes a record of level ERROR
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	l.write("ERROR", msg, kv)
+}
+
+func (l *Logger) write(level, msg string, kv []interface{}) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	writeField(&buf, "time", time.Now().Format(time.RFC3339Nano))
+	buf.WriteByte(',')
+	writeField(&buf, "level", level)
+	buf.WriteByte(',')
+	writeField(&buf, "msg", msg)
+	for _, pairs := range [][]interface{}{l.fields, kv} {
+		for i := 0; i < len(pairs); i += 2 {
+			var value interface{} = "!MISSING"
+			if i+1 < len(pairs) {
+				value = pairs[i+1]
+			}
+			buf.WriteByte(',')
+			writeField(&buf, fmt.Sprint(pairs[i]), value)
+		}
+	}
+	buf.WriteByte('}')
+	l.out.Print(buf.String())
+}
+
+// writeField writes the JSON encoding of "key":value to buf
+func writeField(buf *bytes.Buffer, key string, value interface{}) {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case time.Duration:
+		value = int64(v)
+	}
+	k, _ := json.Marshal(key)
+	buf.Write(k)
+	buf.WriteByte(':')
+	if v, err := json.Marshal(value); err == nil {
+		buf.Write(v)
+	} else {
+		v, _ := json.Marshal(fmt.Sprint(value))
+		buf.Write(v)
+	}
+}
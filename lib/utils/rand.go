@@ -109,6 +109,14 @@ func (s *PRGReader) Read(p []byte) (int, error) {
 	return len(p), nil
 }
 
+// ExpandSeed returns length pseudo-random bytes generated by the PRG keyed
+// with seed. The same seed always expands to the same bytes.
+func ExpandSeed(seed *PRGKey, length int) []byte {
+	out := make([]byte, length)
+	NewPRG(seed).Read(out)
+	return out
+}
+
 func NewBufPRG(prg *PRGReader) *BufPRGReader {
 	out := new(BufPRGReader)
 	out.Key = prg.Key
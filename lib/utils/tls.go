@@ -5,7 +5,9 @@ import (
 	"crypto/x509"
 	"errors"
 	"log"
+	"os"
 
+	"golang.org/x/xerrors"
 	"google.golang.org/grpc/credentials"
 )
 
@@ -174,6 +176,30 @@ func init() {
 	}
 }
 
+// ServerCertificate returns the certificate presented by the server of the
+// given id: the one of the PEM files certFile and keyFile if they are set,
+// or else the built-in ServerCertificates[id], which must not be used in
+// production
+func ServerCertificate(certFile, keyFile string, id int) (tls.Certificate, error) {
+	if certFile == "" && keyFile == "" {
+		if id < 0 || id >= len(ServerCertificates) {
+			return tls.Certificate{}, xerrors.Errorf("no built-in certificate for server %d", id)
+		}
+		return ServerCertificates[id], nil
+	}
+	if certFile == "" || keyFile == "" {
+		return tls.Certificate{}, xerrors.New("the certificate and the key files must be given together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, xerrors.Errorf("failed to load the certificate: %v", err)
+	}
+
+	return cert, nil
+}
+
+// LoadServersCertificates returns the credentials to connect to any of the
+// servers with the shared ServerPublicKeys
 func LoadServersCertificates() (credentials.TransportCredentials, error) {
 	cp := x509.NewCertPool()
 	for _, cert := range ServerPublicKeys {
@@ -185,3 +211,49 @@ func LoadServersCertificates() (credentials.TransportCredentials, error) {
 
 	return creds, nil
 }
+
+// LoadServerCredentials returns the credentials to connect to the server
+// whose certificate is issued by the CA in the PEM file cfg.CA, and holds
+// cfg.ServerName if it is set
+func LoadServerCredentials(cfg TLSConfig) (credentials.TransportCredentials, error) {
+	pem, err := os.ReadFile(cfg.CA)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read the CA: %v", err)
+	}
+	cp := x509.NewCertPool()
+	if !cp.AppendCertsFromPEM(pem) {
+		return nil, xerrors.Errorf("no certificate in the CA %s", cfg.CA)
+	}
+
+	return credentials.NewClientTLSFromCert(cp, cfg.ServerName), nil
+}
+
+// LoadConfigCredentials returns the credentials to connect to every server
+// address of c, as given by its TLS section, or those returned by
+// LoadServersCertificates for all the servers if the section is empty
+func LoadConfigCredentials(c *Config) (map[string]credentials.TransportCredentials, error) {
+	creds := make(map[string]credentials.TransportCredentials, len(c.Addresses))
+	if len(c.TLS) == 0 {
+		shared, err := LoadServersCertificates()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range c.Addresses {
+			creds[addr] = shared
+		}
+		return creds, nil
+	}
+
+	if err := c.validateTLS(); err != nil {
+		return nil, err
+	}
+	for _, addr := range c.Addresses {
+		cr, err := LoadServerCredentials(c.TLS[addr])
+		if err != nil {
+			return nil, xerrors.Errorf("credentials of server %s: %v", addr, err)
+		}
+		creds[addr] = cr
+	}
+
+	return creds, nil
+}
@@ -12,6 +12,22 @@ func VectorToMatrixIndices(i, numColumns int) (int, int) {
 	return i / numColumns, i % numColumns
 }
 
+// Min returns the smaller of a and b
+func Min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Max returns the larger of a and b
+func Max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 // MaxBytesLength get maximal []byte length in map[int][]byte
 func MaxBytesLength(in map[int][]byte) int {
 	max := 0
@@ -15,7 +15,8 @@ import (
 
 func TestLWE128(t *testing.T) {
 	dbLen := 1024 * 1024 // dbLen is specified in bits
-	db := database.CreateRandomBinaryLWEWithLength128(utils.RandomPRG(), dbLen)
+	db, err := database.CreateRandomBinaryLWEWithLength128(utils.RandomPRG(), dbLen)
+	require.NoError(t, err)
 	p := utils.ParamsWithDatabaseSize128(db.Info.NumRows, db.Info.NumColumns)
 	retrieveBlocksLWE128(t, db, p, "TestLWE128")
 }
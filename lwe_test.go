@@ -3,10 +3,12 @@ package main
 import (
 	"fmt"
 	"math/rand"
+	"path/filepath"
 	"testing"
 
 	"github.com/si-co/vpir-code/lib/client"
 	"github.com/si-co/vpir-code/lib/database"
+	"github.com/si-co/vpir-code/lib/matrix"
 	"github.com/si-co/vpir-code/lib/monitor"
 	"github.com/si-co/vpir-code/lib/server"
 	"github.com/si-co/vpir-code/lib/utils"
@@ -15,11 +17,86 @@ import (
 
 func TestLWE(t *testing.T) {
 	dbLen := 1024 * 1024 // dbLen is specified in bits
-	db := database.CreateRandomBinaryLWEWithLength(utils.RandomPRG(), dbLen)
+	db, err := database.CreateRandomBinaryLWEWithLength(utils.RandomPRG(), dbLen)
+	require.NoError(t, err)
 	p := utils.ParamsWithDatabaseSize(db.Info.NumRows, db.Info.NumColumns)
 	retrieveBlocksLWE(t, db, p, "TestLWE")
 }
 
+func TestLWEPersistedDigest(t *testing.T) {
+	numRows, numColumns := database.CalculateNumRowsAndColumns(1024*1024, true)
+	key := utils.RandomPRGKey()
+	path := filepath.Join(t.TempDir(), "digest")
+
+	// the first server computes and saves the digest, the second loads it
+	computed, err := database.CreateRandomBinaryLWEWithoutDigest(utils.NewPRG(key), numRows, numColumns)
+	require.NoError(t, err)
+	require.NoError(t, database.LoadOrComputeDigestLWE(path, computed))
+	loaded, err := database.CreateRandomBinaryLWEWithoutDigest(utils.NewPRG(key), numRows, numColumns)
+	require.NoError(t, err)
+	require.NoError(t, database.LoadOrComputeDigestLWE(path, loaded))
+	require.Equal(t, matrix.MatrixToBytes(database.Digest(loaded, numRows)), matrix.MatrixToBytes(loaded.DigestLWE))
+
+	p := utils.ParamsWithDatabaseSize(loaded.Info.NumRows, loaded.Info.NumColumns)
+	retrieveBlocksLWE(t, loaded, p, "TestLWEPersistedDigest")
+
+	// the digest of another db does not load, even with the same dimensions
+	other, err := database.CreateRandomBinaryLWEWithoutDigest(utils.RandomPRG(), numRows, numColumns)
+	require.NoError(t, err)
+	require.EqualError(t, database.LoadDigestLWE(path, other), "digest file of another db")
+	require.Nil(t, other.Auth)
+	other, err = database.CreateRandomBinaryLWEWithoutDigest(utils.RandomPRG(), numRows, numColumns+1)
+	require.NoError(t, err)
+	require.Error(t, database.LoadDigestLWE(path, other))
+}
+
+func TestLWEBitPacked(t *testing.T) {
+	db, err := database.CreateRandomBinaryLWEWithLength(utils.RandomPRG(), 256*256)
+	require.NoError(t, err)
+	p := utils.ParamsWithDatabaseSize(db.Info.NumRows, db.Info.NumColumns)
+	c := client.NewLWE(utils.RandomPRG(), &db.Info, p)
+	s := server.NewLWE(db)
+	packed := server.NewLWEBitPacked(db)
+
+	for j := 0; j < 10; j++ {
+		i := rand.Intn(p.L * p.M)
+		query, err := c.QueryBytes(i)
+		require.NoError(t, err)
+
+		a, err := s.AnswerBytes(query)
+		require.NoError(t, err)
+		b, err := packed.AnswerBytes(query)
+		require.NoError(t, err)
+		require.Equal(t, a, b)
+
+		res, err := c.ReconstructBytes(b)
+		require.NoError(t, err)
+		require.Equal(t, uint32(db.Matrix.Get(utils.VectorToMatrixIndices(i, db.Info.NumColumns))), res)
+	}
+}
+
+func TestLWESingleElement(t *testing.T) {
+	db, err := database.CreateRandomBinaryLWEWithLength(utils.RandomPRG(), 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, db.Info.NumRows)
+	require.Equal(t, 1, db.Info.NumColumns)
+	p := utils.ParamsWithDatabaseSize(db.Info.NumRows, db.Info.NumColumns)
+	c := client.NewLWE(utils.RandomPRG(), &db.Info, p)
+
+	for _, s := range []*server.LWE{server.NewLWE(db), server.NewLWEBitPacked(db)} {
+		query, err := c.QueryBytes(0)
+		require.NoError(t, err)
+		a, err := s.AnswerBytes(query)
+		require.NoError(t, err)
+		res, err := c.ReconstructBytes(a)
+		require.NoError(t, err)
+		require.Equal(t, uint32(db.Matrix.Get(0, 0)), res)
+	}
+
+	_, err = database.CreateRandomBinaryLWEWithLength(utils.RandomPRG(), 0)
+	require.ErrorIs(t, err, database.ErrEmptyDB)
+}
+
 func retrieveBlocksLWE(t *testing.T, db *database.LWE, params *utils.ParamsLWE, testName string) {
 	c := client.NewLWE(utils.RandomPRG(), &db.Info, params)
 	s := server.NewLWE(db)
@@ -5,6 +5,7 @@ package main
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -49,6 +50,31 @@ func TestMerkleFourServers(t *testing.T) {
 	retrieveBlocksMerkle(t, utils.RandomPRG(), db, numServers, numBlocks, "MerkleFourServers")
 }
 
+func TestMerkleReject(t *testing.T) {
+	dbLen := 8 * 16 * 16 * 64
+	nRows, blockLen := 16, 64
+	db := database.CreateRandomMerkle(utils.RandomPRG(), dbLen, nRows, blockLen)
+
+	c := client.NewPIR(utils.RandomPRG(), &db.Info)
+	s := server.NewPIR(db)
+	i := 42
+	in := make([]byte, 4)
+	binary.BigEndian.PutUint32(in, uint32(i))
+	queries, err := c.QueryBytes(in, 2)
+	require.NoError(t, err)
+	a0, err := s.AnswerBytes(queries[0])
+	require.NoError(t, err)
+	a1, err := s.AnswerBytes(queries[1])
+	require.NoError(t, err)
+
+	// corrupt the data of the retrieved block in one answer
+	ix := i / db.NumColumns
+	a1[ix*db.BlockSize] ^= 0x01
+
+	_, err = c.ReconstructBytes([][]byte{a0, a1})
+	require.True(t, errors.Is(err, client.ErrReject))
+}
+
 func retrieveBlocksMerkle(t *testing.T, rnd io.Reader, db *database.Bytes, numServers, numBlocks int, testName string) {
 	c := client.NewPIR(rnd, &db.Info)
 	servers := make([]*server.PIR, numServers)
@@ -3,10 +3,15 @@ package main
 // Test suite for classical PIR, used as baseline for the experiments.
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math"
+	"math/rand"
+	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/si-co/vpir-code/lib/client"
@@ -30,17 +35,1145 @@ func TestPIRPoint(t *testing.T) {
 	xofDB := utils.RandomPRG()
 	xof := utils.RandomPRG()
 
-	db := database.CreateRandomBytes(xofDB, dbLen, nRows, blockLen)
+	db, err := database.CreateRandomBytes(xofDB, dbLen, nRows, blockLen)
+	require.NoError(t, err)
 
 	retrievePIRPoint(t, xof, db, numBlocks, "PIRPoint")
 }
 
+func TestPIRPointMultipleServers(t *testing.T) {
+	dbLen := 8 * 16 * 16 * 64
+	nRows, blockLen := 16, 64
+	numBlocks := dbLen / (8 * blockLen)
+
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), dbLen, nRows, blockLen)
+	require.NoError(t, err)
+	c := client.NewPIR(utils.RandomPRG(), &db.Info)
+	s := server.NewPIR(db)
+
+	for _, numServers := range []int{3, 4} {
+		for _, i := range []int{0, 17, numBlocks - 1} {
+			in := make([]byte, 4)
+			binary.BigEndian.PutUint32(in, uint32(i))
+			queries, err := c.QueryBytes(in, numServers)
+			require.NoError(t, err)
+			require.Len(t, queries, numServers)
+
+			answers := make([][]byte, numServers)
+			for k := range answers {
+				answers[k], err = s.AnswerBytes(queries[k])
+				require.NoError(t, err)
+			}
+
+			res, err := c.ReconstructBytes(answers)
+			require.NoError(t, err)
+			require.Equal(t, db.Entries[i*db.BlockSize:(i+1)*db.BlockSize], res)
+		}
+	}
+
+	// a single server cannot be queried
+	_, err = c.QueryBytes(make([]byte, 4), 1)
+	require.Error(t, err)
+}
+
+func TestPIRPointBlockHashes(t *testing.T) {
+	dbLen := 8 * 16 * 16 * 64
+	nRows, blockLen := 16, 64
+	numBlocks := dbLen / (8 * blockLen)
+
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), dbLen, nRows, blockLen)
+	require.NoError(t, err)
+	require.NoError(t, database.AddBlockHashes(db, []byte("block hashes key")))
+
+	// honest servers
+	retrievePIRPoint(t, utils.RandomPRG(), db, numBlocks, "PIRPointBlockHashes")
+
+	// one server flips a byte of its answer
+	c := client.NewPIR(utils.RandomPRG(), &db.Info)
+	s := server.NewPIR(db)
+	i := 42
+	in := make([]byte, 4)
+	binary.BigEndian.PutUint32(in, uint32(i))
+	queries, err := c.QueryBytes(in, 2)
+	require.NoError(t, err)
+	a0, err := s.AnswerBytes(queries[0])
+	require.NoError(t, err)
+	a1, err := s.AnswerBytes(queries[1])
+	require.NoError(t, err)
+
+	ix := i / db.NumColumns
+	a1[ix*db.BlockSize] ^= 0x01
+
+	_, err = c.ReconstructBytes([][]byte{a0, a1})
+	require.True(t, errors.Is(err, client.ErrReject))
+
+	// blocks without a hash are not verified
+	_, err = db.VerifyBlock(numBlocks, make([]byte, blockLen))
+	require.Error(t, err)
+	_, err = db.VerifyBlock(-1, make([]byte, blockLen))
+	require.Error(t, err)
+}
+
+func TestPIRPointLayouts(t *testing.T) {
+	dbLen := 8 * 16 * 16 * 64
+	blockLen := 64
+	numBlocks := dbLen / (8 * blockLen)
+	key := new(utils.PRGKey)
+	copy(key[:], []byte("layout test key!"))
+
+	layouts := map[string]*database.Bytes{}
+	for _, layout := range []string{database.LayoutVector, database.LayoutMatrix} {
+		rebalanced, err := database.ParseLayout(layout)
+		require.NoError(t, err)
+		nRows, _ := database.CalculateNumRowsAndColumns(numBlocks, rebalanced)
+		db, err := database.CreateRandomBytes(utils.NewPRG(key), dbLen, nRows, blockLen)
+		require.NoError(t, err)
+		require.Equal(t, layout, db.Layout())
+		layouts[layout] = db
+	}
+
+	for _, i := range []int{0, 99, numBlocks - 1} {
+		in := make([]byte, 4)
+		binary.BigEndian.PutUint32(in, uint32(i))
+		var results [][]byte
+		for _, db := range layouts {
+			c := client.NewPIR(utils.RandomPRG(), &db.Info)
+			s := server.NewPIR(db)
+			queries, err := c.QueryBytes(in, 2)
+			require.NoError(t, err)
+			a0, err := s.AnswerBytes(queries[0])
+			require.NoError(t, err)
+			a1, err := s.AnswerBytes(queries[1])
+			require.NoError(t, err)
+			res, err := c.ReconstructBytes([][]byte{a0, a1})
+			require.NoError(t, err)
+			results = append(results, res.([]byte))
+		}
+		require.Equal(t, results[0], results[1])
+	}
+}
+
+func TestPIRPayloadLength(t *testing.T) {
+	// blocks of different lengths whose data end in zeros, embedded as
+	// the keys by database.GenerateRealKeyBytes
+	nRows, nCols, blockLen := 4, 4, 32
+	db := database.InitBytes(nRows, nCols, blockLen)
+	payloads := make([][]byte, nRows*nCols)
+	for i := range payloads {
+		payloads[i] = make([]byte, i+1)
+		_, err := utils.RandomPRG().Read(payloads[i][:i/2+1])
+		require.NoError(t, err)
+		block := database.PadWithSignalByte(append([]byte{}, payloads[i]...))
+		db.BlockLengths[i] = len(block)
+		db.Entries = append(db.Entries, block...)
+	}
+
+	s := server.NewPIR(db)
+	c := client.NewPIR(utils.RandomPRG(), &db.Info)
+	for i, payload := range payloads {
+		in := make([]byte, 4)
+		binary.BigEndian.PutUint32(in, uint32(i))
+		queries, err := c.QueryBytes(in, 2)
+		require.NoError(t, err)
+		answers := make([][]byte, len(queries))
+		for k, q := range queries {
+			answers[k], err = s.AnswerBytes(q)
+			require.NoError(t, err)
+		}
+		res, err := c.Reconstruct(answers)
+		require.NoError(t, err)
+		require.Len(t, res, db.BlockLengths[i])
+		data, err := database.UnPadBlockWith(res, database.DefaultPadByte)
+		require.NoError(t, err)
+		require.Equal(t, payload, data)
+	}
+}
+
+func TestPIRInvalidQuery(t *testing.T) {
+	dbLen := 8 * 16 * 16 * 64
+	nRows, blockLen := 16, 64
+
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), dbLen, nRows, blockLen)
+	require.NoError(t, err)
+	s := server.NewPIR(db)
+
+	// a client believing the db has fewer columns sends a shorter query
+	smallInfo := db.Info
+	smallInfo.NumColumns = 4
+	c := client.NewPIR(utils.RandomPRG(), &smallInfo)
+	queries, err := c.QueryBytes(make([]byte, 4), 2)
+	require.NoError(t, err)
+	require.Len(t, queries[0], 1)
+
+	_, err = s.AnswerBytes(queries[0])
+	require.Error(t, err)
+	require.True(t, errors.Is(err, server.ErrInvalidQuery))
+}
+
+func TestPIRTruncatedAnswer(t *testing.T) {
+	dbLen := 8 * 16 * 16 * 64
+	nRows, blockLen := 16, 64
+
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), dbLen, nRows, blockLen)
+	require.NoError(t, err)
+	s := server.NewPIR(db)
+	c := client.NewPIR(utils.RandomPRG(), &db.Info)
+
+	in := make([]byte, 4)
+	binary.BigEndian.PutUint32(in, uint32(17))
+	queries, err := c.QueryBytes(in, 2)
+	require.NoError(t, err)
+
+	a0, err := s.AnswerBytes(queries[0])
+	require.NoError(t, err)
+	a1, err := s.AnswerBytes(queries[1])
+	require.NoError(t, err)
+
+	// the second server drops its last block
+	_, err = c.ReconstructBytes([][]byte{a0, a1[:len(a1)-blockLen]})
+	require.EqualError(t, err, fmt.Sprintf("answer of server 1 has length %d, expected %d",
+		len(a1)-blockLen, len(a1)))
+}
+
+func TestCombineAnswerBytes(t *testing.T) {
+	sum, err := client.CombineAnswerBytes([][]byte{{0x0f, 0xaa}, {0xff, 0x0a}, {0x01, 0x00}})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xf1, 0xa0}, sum)
+
+	_, err = client.CombineAnswerBytes([][]byte{{0x0f, 0xaa}, {0xff}})
+	require.EqualError(t, err, "answer of server 1 has length 1, expected 2")
+	_, err = client.CombineAnswerBytes(nil)
+	require.Error(t, err)
+}
+
+func TestCombineAnswers(t *testing.T) {
+	sum, err := client.CombineAnswers([][]uint32{{1, field.ModP - 1}, {2, 3}})
+	require.NoError(t, err)
+	require.Equal(t, []uint32{3, 2}, sum)
+
+	_, err = client.CombineAnswers([][]uint32{{1, 2}, {1, 2, 3}})
+	require.EqualError(t, err, "answer of server 1 has length 3, expected 2")
+	_, err = client.CombineAnswers(nil)
+	require.Error(t, err)
+}
+
+func TestPIRRetrieveRecord(t *testing.T) {
+	dbLen := 8 * 16 * 16 * 64
+	nRows, blockLen := 16, 64
+
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), dbLen, nRows, blockLen)
+	require.NoError(t, err)
+
+	// a padded record spanning four blocks from block 30
+	record := make([]byte, 3*blockLen+10)
+	_, err = utils.RandomPRG().Read(record)
+	require.NoError(t, err)
+	padded := database.PadBlock(append([]byte{}, record...), blockLen)
+	require.Len(t, padded, 4*blockLen)
+	copy(db.Entries[30*blockLen:], padded)
+
+	servers := []client.Answerer{server.NewPIR(db), server.NewPIR(db)}
+	c := client.NewPIR(utils.RandomPRG(), &db.Info)
+
+	out, err := c.RetrieveRecord(30, 4, servers, false)
+	require.NoError(t, err)
+	require.Equal(t, padded, out)
+
+	out, err = c.RetrieveRecord(30, 4, servers, true)
+	require.NoError(t, err)
+	require.Equal(t, record, out)
+
+	_, err = c.RetrieveRecord(db.NumBlocks()-1, 2, servers, false)
+	require.Error(t, err)
+}
+
+func TestPIRVariableBlocks(t *testing.T) {
+	// blocks of different lengths, including an empty one and one ending
+	// with zeros
+	lengths := []int{0, 1, 17, 64, 3, 100, 42, 99}
+	blocks := make([][]byte, len(lengths))
+	for k, l := range lengths {
+		blocks[k] = make([]byte, l)
+		_, err := utils.RandomPRG().Read(blocks[k])
+		require.NoError(t, err)
+	}
+	blocks[6][41], blocks[6][40] = 0, 0
+
+	for _, rebalanced := range []bool{false, true} {
+		db, err := database.BytesFromBlocks(blocks, rebalanced)
+		require.NoError(t, err)
+		require.NoError(t, db.ValidateDimensions())
+		require.Equal(t, 100, db.BlockSize)
+
+		// the index of offsets locates every block in the packed entries
+		offsets := db.BlockOffsets()
+		require.Equal(t, len(db.Entries), offsets[db.NumBlocks()])
+		for k := range blocks {
+			require.Equal(t, blocks[k], db.Entries[offsets[k]:offsets[k+1]])
+		}
+
+		servers := []client.Answerer{server.NewPIR(db), server.NewPIR(db)}
+		c := client.NewPIR(utils.RandomPRG(), &db.Info)
+		for k := range blocks {
+			out, err := c.RetrieveRecord(k, 1, servers, false)
+			require.NoError(t, err)
+			require.Equal(t, blocks[k], out, "block %d", k)
+		}
+		// every answer is padded to the longest block
+		require.Equal(t, 2*client.ExpectedAnswerBytes(&db.Info), c.LastAnswerBytes())
+	}
+
+	_, err := database.BytesFromBlocks([][]byte{{}, {}}, false)
+	require.ErrorIs(t, err, database.ErrEmptyDB)
+
+	// a block longer than the block size is invalid
+	db, err := database.BytesFromBlocks(blocks, false)
+	require.NoError(t, err)
+	db.BlockLengths[0] = db.BlockSize + 1
+	require.Error(t, db.ValidateDimensions())
+}
+
+func TestPIREmbeddedRecords(t *testing.T) {
+	blockLen := 32
+	records := make([][]byte, 20)
+	for i := range records {
+		records[i] = make([]byte, i*7)
+		_, err := utils.RandomPRG().Read(records[i])
+		require.NoError(t, err)
+	}
+	db, spans, err := database.EmbedRecords(records, blockLen, true)
+	require.NoError(t, err)
+
+	servers := []client.Answerer{server.NewPIR(db), server.NewPIR(db)}
+	c := client.NewPIR(utils.RandomPRG(), &db.Info)
+	for i, s := range spans {
+		out, err := c.RetrieveRecord(s.StartBlock, s.NumBlocks, servers, false)
+		require.NoError(t, err)
+		// the block lengths end the retrieved blocks with the record
+		require.Len(t, out, database.RecordLengthPrefix+len(records[i]))
+		r, err := database.ExtractRecord(out)
+		require.NoError(t, err)
+		require.Equal(t, records[i], r)
+	}
+}
+
+func TestPIRStreamedRecords(t *testing.T) {
+	blockLen := 32
+	records := make([][]byte, 20)
+	for i := range records {
+		records[i] = make([]byte, i*11)
+		_, err := utils.RandomPRG().Read(records[i])
+		require.NoError(t, err)
+	}
+
+	// the producer sends on an unbuffered channel, as a file reader would
+	ch := make(chan []byte)
+	go func() {
+		defer close(ch)
+		for _, r := range records {
+			ch <- r
+		}
+	}()
+	db, spans, err := database.BuildFromRecords(context.Background(), ch, blockLen, true)
+	require.NoError(t, err)
+	require.Len(t, spans, len(records))
+
+	servers := []client.Answerer{server.NewPIR(db), server.NewPIR(db)}
+	c := client.NewPIR(utils.RandomPRG(), &db.Info)
+	i := 13
+	out, err := c.RetrieveRecord(spans[i].StartBlock, spans[i].NumBlocks, servers, false)
+	require.NoError(t, err)
+	r, err := database.ExtractRecord(out)
+	require.NoError(t, err)
+	require.Equal(t, records[i], r)
+
+	// a cancelled context stops the ingestion with the channel left open
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = database.BuildFromRecords(ctx, make(chan []byte), blockLen, true)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestPIRSingleElement(t *testing.T) {
+	blockLen := 16
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), 8*blockLen, 1, blockLen)
+	require.NoError(t, err)
+	require.Equal(t, 1, db.NumBlocks())
+	servers := []client.Answerer{server.NewPIR(db), server.NewPIR(db)}
+	out, err := client.NewPIR(utils.RandomPRG(), &db.Info).RetrieveRecord(0, 1, servers, false)
+	require.NoError(t, err)
+	require.Equal(t, db.Entries, out)
+
+	// a single record in both layouts
+	record := []byte("single")
+	for _, rebalanced := range []bool{false, true} {
+		db, spans, err := database.EmbedRecords([][]byte{record}, blockLen, rebalanced)
+		require.NoError(t, err)
+		require.Equal(t, 1, db.NumRows)
+		require.Equal(t, 1, db.NumColumns)
+
+		servers := []client.Answerer{server.NewPIR(db), server.NewPIR(db)}
+		out, err := client.NewPIR(utils.RandomPRG(), &db.Info).RetrieveRecord(spans[0].StartBlock, 1, servers, false)
+		require.NoError(t, err)
+		r, err := database.ExtractRecord(out)
+		require.NoError(t, err)
+		require.Equal(t, record, r)
+	}
+
+	// the servers reject an empty db instead of answering
+	empty := server.NewPIR(database.InitBytes(1, 0, blockLen))
+	_, err = empty.AnswerBytes(make([]byte, 1))
+	require.ErrorIs(t, err, database.ErrEmptyDB)
+	_, err = empty.AnswerBatchBytes([][]byte{make([]byte, 1)})
+	require.ErrorIs(t, err, database.ErrEmptyDB)
+	_, err = empty.AnswerRangeBytes([]byte{0, 0, 0, 1, 0})
+	require.ErrorIs(t, err, database.ErrEmptyDB)
+	_, err = empty.AnswerSeedBytes(make([]byte, len(utils.PRGKey{})))
+	require.ErrorIs(t, err, database.ErrEmptyDB)
+}
+
+func TestPIRRange(t *testing.T) {
+	db := randomBytesDB(t)
+	require.NoError(t, database.AddBlockHashes(db, utils.RandomPRGKey()[:]))
+
+	c := client.NewPIR(utils.RandomPRG(), &db.Info)
+	for _, numServers := range []int{2, 3} {
+		ss := make([]*server.PIR, numServers)
+		servers := make([]client.Answerer, numServers)
+		for k := range ss {
+			ss[k] = server.NewPIR(db)
+			servers[k] = ss[k]
+		}
+		// within a row, across two rows and up to the last block
+		for _, start := range []int{3, 14, db.NumBlocks() - 4} {
+			perPoint, err := c.RetrieveRecord(start, 4, servers, false)
+			require.NoError(t, err)
+
+			queries, err := c.QueryRange(start, 4, numServers)
+			require.NoError(t, err)
+			answers := make([][]byte, numServers)
+			for k := range ss {
+				// a single query vector per server
+				require.Len(t, queries[k], client.RangeQueryPrefix+db.NumColumns/8+1)
+				answers[k], err = ss[k].AnswerRangeBytes(queries[k])
+				require.NoError(t, err)
+			}
+			blocks, err := c.ReconstructRange(answers)
+			require.NoError(t, err)
+			require.True(t, c.LastVerified())
+			require.Len(t, blocks, 4)
+			for i, b := range blocks {
+				require.Equal(t, perPoint[i*db.BlockSize:(i+1)*db.BlockSize], b)
+			}
+		}
+	}
+
+	_, err := c.QueryRange(db.NumBlocks()-2, 4, 2)
+	require.Error(t, err)
+	_, err = c.QueryRange(0, db.NumColumns+1, 2)
+	require.Error(t, err)
+	_, err = server.NewPIR(db).AnswerRangeBytes([]byte{0, 0, 1, 0, 0, 0, 0})
+	require.ErrorIs(t, err, server.ErrInvalidQuery)
+}
+
+func TestPIRPointSeeded(t *testing.T) {
+	// vector db, so that the query vectors are longer than a seed
+	dbLen := 8 * 16 * 16 * 64
+	nRows, blockLen := 1, 64
+	numServers := 3
+
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), dbLen, nRows, blockLen)
+	require.NoError(t, err)
+	s := server.NewPIR(db)
+	c := client.NewPIR(utils.RandomPRG(), &db.Info)
+
+	for _, i := range []int{0, 17, db.NumBlocks() - 1} {
+		queries, err := c.QuerySeeded(i, numServers)
+		require.NoError(t, err)
+
+		answers := make([][]byte, numServers)
+		for k := 0; k < numServers-1; k++ {
+			answers[k], err = s.AnswerSeedBytes(queries[k])
+			require.NoError(t, err)
+		}
+		answers[numServers-1], err = s.AnswerBytes(queries[numServers-1])
+		require.NoError(t, err)
+
+		res, err := c.Reconstruct(answers)
+		require.NoError(t, err)
+		require.Equal(t, db.Entries[i*blockLen:(i+1)*blockLen], res)
+	}
+
+	// bandwidth of the seeded queries compared to the full ones
+	full := c.Query(0, numServers)
+	seeded, err := c.QuerySeeded(0, numServers)
+	require.NoError(t, err)
+	fullLen, seededLen := 0, 0
+	for k := range full {
+		fullLen += len(full[k])
+		seededLen += len(seeded[k])
+	}
+	t.Logf("query bytes for %d servers, full: %d, seeded: %d", numServers, fullLen, seededLen)
+	require.Equal(t, len(full[0])+(numServers-1)*len(utils.PRGKey{}), seededLen)
+	require.Less(t, seededLen, fullLen)
+
+	// out of range indices are rejected as by QueryBytes
+	_, err = c.QuerySeeded(db.NumBlocks(), numServers)
+	require.Error(t, err)
+	_, err = c.QuerySeeded(-1, numServers)
+	require.Error(t, err)
+}
+
+func TestPIRPointOddSize(t *testing.T) {
+	// neither layout of CalculateNumRowsAndColumns, and a number of columns
+	// that is not a multiple of 8
+	nRows, nCols, blockLen := 3, 13, 16
+	numServers := 3
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), 8*nRows*nCols*blockLen, nRows, blockLen)
+	require.NoError(t, err)
+	require.Equal(t, nCols, db.NumColumns)
+	require.Equal(t, 2, db.QueryVectorBytes())
+	s := server.NewPIR(db)
+	c := client.NewPIR(utils.RandomPRG(), &db.Info)
+
+	for i := 0; i < db.NumBlocks(); i++ {
+		full := c.Query(i, numServers)
+		for _, q := range full {
+			require.Len(t, q, db.QueryVectorBytes())
+		}
+		queries, err := c.QuerySeeded(i, numServers)
+		require.NoError(t, err)
+
+		answers := make([][]byte, numServers)
+		for k := 0; k < numServers-1; k++ {
+			answers[k], err = s.AnswerSeedBytes(queries[k])
+			require.NoError(t, err)
+		}
+		answers[numServers-1], err = s.AnswerBytes(queries[numServers-1])
+		require.NoError(t, err)
+
+		res, err := c.Reconstruct(answers)
+		require.NoError(t, err)
+		require.Equal(t, db.Entries[i*blockLen:(i+1)*blockLen], res)
+	}
+}
+
+func TestPIRPointBlockSizeOne(t *testing.T) {
+	// blocks of a single byte are multi-bit blocks, not single-bit entries
+	dbLen := 8 * 16 * 16
+	nRows, blockLen := 16, 1
+	numBlocks := dbLen / (8 * blockLen)
+
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), dbLen, nRows, blockLen)
+	require.NoError(t, err)
+	require.False(t, db.IsSingleBit())
+
+	retrievePIRPoint(t, utils.RandomPRG(), db, numBlocks, "PIRPointBlockSizeOne")
+}
+
+func TestPIRSwapDB(t *testing.T) {
+	dbLen := 8 * 16 * 16 * 64
+	nRows, blockLen := 16, 64
+	numBlocks := dbLen / (8 * blockLen)
+
+	dbA, err := database.CreateRandomBytes(utils.RandomPRG(), dbLen, nRows, blockLen)
+	require.NoError(t, err)
+	dbB, err := database.CreateRandomBytes(utils.RandomPRG(), dbLen, nRows, blockLen)
+	require.NoError(t, err)
+
+	c := client.NewPIR(utils.RandomPRG(), &dbA.Info)
+	s := server.NewPIR(dbA)
+	sA := server.NewPIR(dbA)
+	sB := server.NewPIR(dbB)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if i%2 == 0 {
+				s.SwapDB(dbB)
+			} else {
+				s.SwapDB(dbA)
+			}
+		}
+	}()
+
+	for i := 0; i < numBlocks; i++ {
+		in := make([]byte, 4)
+		binary.BigEndian.PutUint32(in, uint32(i))
+		queries, err := c.QueryBytes(in, 2)
+		require.NoError(t, err)
+
+		// every answer is computed entirely against one of the two dbs
+		a, err := s.AnswerBytes(queries[0])
+		require.NoError(t, err)
+		aA, err := sA.AnswerBytes(queries[0])
+		require.NoError(t, err)
+		aB, err := sB.AnswerBytes(queries[0])
+		require.NoError(t, err)
+		require.True(t, string(a) == string(aA) || string(a) == string(aB))
+	}
+	close(done)
+	wg.Wait()
+
+	// after the last swap, answers are computed against the new db
+	s.SwapDB(dbB)
+	retrievePIRPointServers(t, c, s, server.NewPIR(dbB), dbB, numBlocks)
+}
+
+func TestPIRAnswerStats(t *testing.T) {
+	dbLen := 8 * 16 * 16 * 64
+	nRows, blockLen := 16, 64
+
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), dbLen, nRows, blockLen)
+	require.NoError(t, err)
+
+	c := client.NewPIR(utils.RandomPRG(), &db.Info)
+	var s server.StatsServer = server.NewPIR(db)
+
+	in := make([]byte, 4)
+	binary.BigEndian.PutUint32(in, 3)
+	queries, err := c.QueryBytes(in, 2)
+	require.NoError(t, err)
+
+	a, stats, err := s.AnswerBytesWithStats(queries[0])
+	require.NoError(t, err)
+	expected, err := s.AnswerBytes(queries[0])
+	require.NoError(t, err)
+	require.Equal(t, expected, a)
+
+	require.Equal(t, len(a), stats.AnswerBytes)
+	require.Equal(t, db.NumRows*db.BlockSize, stats.AnswerBytes)
+	require.GreaterOrEqual(t, stats.ComputeMs, float64(0))
+	require.Equal(t, 1, stats.WorkersUsed)
+}
+
+func TestPIRLastSizes(t *testing.T) {
+	dbLen := 8 * 16 * 16 * 64
+	nRows, blockLen := 16, 64
+	numServers := 3
+
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), dbLen, nRows, blockLen)
+	require.NoError(t, err)
+
+	var c client.Client = client.NewPIR(utils.RandomPRG(), &db.Info)
+	s := server.NewPIR(db)
+
+	in := make([]byte, 4)
+	binary.BigEndian.PutUint32(in, 5)
+	queries, err := c.QueryBytes(in, numServers)
+	require.NoError(t, err)
+	require.Equal(t, numServers*client.ExpectedQueryBytes(&db.Info), c.LastQueryBytes())
+
+	answers := make([][]byte, numServers)
+	answerLen := 0
+	for k := range queries {
+		answers[k], err = s.AnswerBytes(queries[k])
+		require.NoError(t, err)
+		answerLen += len(answers[k])
+	}
+	_, err = c.ReconstructBytes(answers)
+	require.NoError(t, err)
+	require.Equal(t, answerLen, c.LastAnswerBytes())
+	require.Equal(t, numServers*client.ExpectedAnswerBytes(&db.Info), c.LastAnswerBytes())
+}
+
+func TestPIRAnswerBatch(t *testing.T) {
+	dbLen := 8 * 16 * 16 * 64
+	nRows, blockLen := 16, 64
+
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), dbLen, nRows, blockLen)
+	require.NoError(t, err)
+
+	c := client.NewPIR(utils.RandomPRG(), &db.Info)
+	var s server.BatchServer = server.NewPIR(db)
+
+	// the queries of the first server for a few indices
+	batch := make([][]byte, 0)
+	in := make([]byte, 4)
+	for _, index := range []int{0, 7, 42, 255} {
+		binary.BigEndian.PutUint32(in, uint32(index))
+		queries, err := c.QueryBytes(in, 2)
+		require.NoError(t, err)
+		batch = append(batch, queries[0])
+	}
+
+	answers, err := s.AnswerBatchBytes(batch)
+	require.NoError(t, err)
+	require.Len(t, answers, len(batch))
+	for i := range batch {
+		expected, err := s.AnswerBytes(batch[i])
+		require.NoError(t, err)
+		require.Equal(t, expected, answers[i])
+	}
+
+	// a short query invalidates the batch
+	_, err = s.AnswerBatchBytes([][]byte{batch[0], batch[1][:1]})
+	require.True(t, errors.Is(err, server.ErrInvalidQuery))
+}
+
+// batchCounter counts the queries answered in batches by the underlying
+// server
+type batchCounter struct {
+	server.BatchServer
+	batches, queries int
+}
+
+func (s *batchCounter) AnswerBatchBytes(queries [][]byte) ([][]byte, error) {
+	s.batches++
+	s.queries += len(queries)
+	return s.BatchServer.AnswerBatchBytes(queries)
+}
+
+func TestPIRRetrieveByIDs(t *testing.T) {
+	dbLen := 8 * 16 * 16 * 64
+	nRows, blockLen := 16, 64
+
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), dbLen, nRows, blockLen)
+	require.NoError(t, err)
+	numBlocks := db.NumBlocks()
+
+	// three ids in different blocks and an id colliding with the first one
+	ids := make([]string, 0, 4)
+	used := make(map[uint32]bool)
+	for i := 0; len(ids) < 3; i++ {
+		id := fmt.Sprintf("user%d@example.org", i)
+		if b := database.HashToIndex(id, numBlocks); !used[b] {
+			used[b] = true
+			ids = append(ids, id)
+		}
+	}
+	for i := 0; len(ids) < 4; i++ {
+		id := fmt.Sprintf("other%d@example.org", i)
+		if database.HashToIndex(id, numBlocks) == database.HashToIndex(ids[0], numBlocks) {
+			ids = append(ids, id)
+		}
+	}
+
+	// embed a value for every block, the colliding ids sharing theirs
+	values := make(map[string][]byte)
+	for _, id := range ids[:3] {
+		values[id] = make([]byte, 40)
+		_, err := utils.RandomPRG().Read(values[id])
+		require.NoError(t, err)
+		b := int(database.HashToIndex(id, numBlocks))
+		copy(db.Entries[b*blockLen:], database.PadBlock(append([]byte{}, values[id]...), blockLen))
+	}
+	values[ids[3]] = values[ids[0]]
+
+	servers := []*batchCounter{
+		{BatchServer: server.NewPIR(db)},
+		{BatchServer: server.NewPIR(db)},
+	}
+	answerers := []client.BatchAnswerer{servers[0], servers[1]}
+	c := client.NewPIR(utils.RandomPRG(), &db.Info)
+
+	out, err := c.RetrieveByIDs(ids[:3], answerers)
+	require.NoError(t, err)
+	require.Len(t, out, 3)
+	for _, id := range ids[:3] {
+		require.Equal(t, values[id], out[id], id)
+	}
+	// one batch of three queries per server
+	for _, s := range servers {
+		require.Equal(t, 1, s.batches)
+		require.Equal(t, 3, s.queries)
+	}
+	require.Equal(t, 2*3*client.ExpectedQueryBytes(&db.Info), c.LastQueryBytes())
+
+	// the colliding and the repeated ids share the query of their block
+	out, err = c.RetrieveByIDs([]string{ids[0], ids[3], ids[0]}, answerers)
+	require.NoError(t, err)
+	require.Len(t, out, 2)
+	require.Equal(t, values[ids[0]], out[ids[0]])
+	require.Equal(t, values[ids[3]], out[ids[3]])
+	for _, s := range servers {
+		require.Equal(t, 2, s.batches)
+		require.Equal(t, 4, s.queries)
+	}
+}
+
+func TestExpectedAnswerLength(t *testing.T) {
+	bytesDB := randomBytesDB(t)
+	vectorDB, err := database.CreateRandomBytes(utils.RandomPRG(), 8*32*16, 1, 16)
+	require.NoError(t, err)
+	dbs := map[string]*database.Bytes{
+		"Matrix": bytesDB,
+		"Vector": vectorDB,
+		"Merkle": database.CreateRandomMerkle(utils.RandomPRG(), 8*16*16*64, 16, 64),
+	}
+
+	for name, db := range dbs {
+		t.Run(name, func(t *testing.T) {
+			c := client.NewPIR(utils.RandomPRG(), &db.Info)
+			queries, err := c.QueryBytes(make([]byte, 4), 2)
+			require.NoError(t, err)
+			a, err := server.NewPIR(db).AnswerBytes(queries[0])
+			require.NoError(t, err)
+
+			require.Equal(t, db.NumRows*db.BlockSize, client.ExpectedAnswerElements(&db.Info))
+			require.Len(t, a, client.ExpectedAnswerBytes(&db.Info))
+
+			// an answer of the wrong length is rejected
+			_, err = c.ReconstructBytes([][]byte{a, a[1:]})
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestPIRMappedDB(t *testing.T) {
+	db := randomBytesDB(t)
+	path := filepath.Join(t.TempDir(), "db")
+	require.NoError(t, database.SaveBytesFlat(path, db))
+	mapped, unmap, err := database.MapBytes(path)
+	require.NoError(t, err)
+	defer unmap()
+
+	// the server answers the same on the in-memory and the mapped db
+	s, ms := server.NewPIR(db), server.NewPIR(mapped)
+	c := client.NewPIR(utils.RandomPRG(), &mapped.Info)
+	for _, i := range []uint32{0, 42, uint32(db.NumRows*db.NumColumns - 1)} {
+		in := make([]byte, 4)
+		binary.BigEndian.PutUint32(in, i)
+		queries, err := c.QueryBytes(in, 2)
+		require.NoError(t, err)
+
+		answers := make([][]byte, len(queries))
+		for k, q := range queries {
+			expected, err := s.AnswerBytes(q)
+			require.NoError(t, err)
+			answers[k], err = ms.AnswerBytes(q)
+			require.NoError(t, err)
+			require.Equal(t, expected, answers[k])
+		}
+		res, err := c.ReconstructBytes(answers)
+		require.NoError(t, err)
+		require.Equal(t, db.Entries[int(i)*db.BlockSize:int(i+1)*db.BlockSize], res)
+	}
+}
+
+func TestPIRWarmup(t *testing.T) {
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), 8<<22, 64, 64)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "db")
+	require.NoError(t, database.SaveBytesFlat(path, db))
+	mapped, unmap, err := database.MapBytes(path)
+	require.NoError(t, err)
+	defer unmap()
+
+	var s server.WarmupServer = server.NewPIR(mapped)
+	require.Positive(t, s.Warmup())
+
+	// the warmed up db answers as the db in memory
+	queries := client.NewPIR(utils.RandomPRG(), &mapped.Info).Query(42, 2)
+	a, err := s.AnswerBytes(queries[0])
+	require.NoError(t, err)
+	expected, err := server.NewPIR(db).AnswerBytes(queries[0])
+	require.NoError(t, err)
+	require.Equal(t, expected, a)
+}
+
+func TestPIRSharded(t *testing.T) {
+	db := randomBytesDB(t)
+	ranges, err := database.SplitColumns(db.NumColumns, 2)
+	require.NoError(t, err)
+
+	// one server process per shard and server role
+	shards := make([][]*server.PIR, 2)
+	for k := range shards {
+		for _, r := range ranges {
+			shard, err := database.ShardBytes(db, r)
+			require.NoError(t, err)
+			shards[k] = append(shards[k], server.NewPIR(shard))
+		}
+	}
+	require.Equal(t, &database.ShardRange{Start: 8, End: 16}, shards[0][1].DBInfo().ShardRange)
+
+	c, err := client.NewShardedPIR(utils.RandomPRG(), &db.Info, ranges)
+	require.NoError(t, err)
+	// row 5 and column 12, in the second shard
+	for _, i := range []uint32{5*16 + 12, 0, uint32(db.NumBlocks() - 1)} {
+		in := make([]byte, 4)
+		binary.BigEndian.PutUint32(in, i)
+		queries, err := c.QueryBytes(in, 2)
+		require.NoError(t, err)
+		require.Len(t, queries, 2*len(ranges))
+
+		answers := make([][]byte, len(queries))
+		for k := range shards {
+			for s, shard := range shards[k] {
+				answers[k*len(ranges)+s], err = shard.AnswerBytes(queries[k*len(ranges)+s])
+				require.NoError(t, err)
+			}
+		}
+		res, err := c.ReconstructBytes(answers)
+		require.NoError(t, err)
+		require.Equal(t, db.Entries[int(i)*db.BlockSize:int(i+1)*db.BlockSize], res)
+	}
+
+	// the shards must cover the db
+	_, err = client.NewShardedPIR(utils.RandomPRG(), &db.Info, ranges[1:])
+	require.Error(t, err)
+	_, err = database.ShardBytes(db, database.ShardRange{Start: 8, End: 17})
+	require.Error(t, err)
+
+	// a db without block lengths is sharded by its block size
+	noLens := &database.Bytes{Entries: db.Entries, Info: db.Info}
+	noLens.BlockLengths = nil
+	for _, r := range ranges {
+		shard, err := database.ShardBytes(noLens, r)
+		require.NoError(t, err)
+		require.Nil(t, shard.BlockLengths)
+		expected, err := database.ShardBytes(db, r)
+		require.NoError(t, err)
+		require.Equal(t, expected.Entries, shard.Entries)
+	}
+}
+
+func TestValidatePIRType(t *testing.T) {
+	classical := randomBytesDB(t)
+	merkle := database.CreateRandomMerkle(utils.RandomPRG(), 8*16*16*64, 16, 64)
+	keys, err := database.CreateRandomKeysDB(utils.RandomPRG(), 10)
+	require.NoError(t, err)
+
+	require.NoError(t, client.ValidatePIRType("pir-classic", &classical.Info))
+	require.NoError(t, client.ValidatePIRType("pointVPIR", &merkle.Info))
+	require.NoError(t, client.ValidatePIRType("fss-auth", &keys.Info))
+
+	// a db saved without a type may be of any type
+	require.NoError(t, client.ValidatePIRType("pointPIR", &database.Info{}))
+	require.NoError(t, client.ValidatePIRType("pointVPIR", &database.Info{}))
+	require.NoError(t, client.ValidatePIRType("complexPIR", &database.Info{}))
+
+	require.EqualError(t, client.ValidatePIRType("pir-merkle", &classical.Info),
+		"scheme pir-merkle needs a merkle db, the servers hold a classical db")
+	require.EqualError(t, client.ValidatePIRType("pir-classic", &merkle.Info),
+		"scheme pir-classic needs a classical db, the servers hold a merkle db")
+	require.Error(t, client.ValidatePIRType("complexPIR", &classical.Info))
+	require.Error(t, client.ValidatePIRType("unknown", &classical.Info))
+}
+
+func TestValidateServers(t *testing.T) {
+	tests := []struct {
+		scheme     string
+		numServers int
+		valid      bool
+	}{
+		{"pir-classic", 1, false},
+		{"pir-classic", 2, true},
+		{"pir-classic", 5, true},
+		{"pir-merkle", 0, false},
+		{"pir-merkle", 3, true},
+		{"pointVPIR", 2, true},
+		{"fss-classic", 1, false},
+		{"fss-classic", 2, true},
+		{"fss-auth", 3, false},
+		{"complexVPIR", 2, true},
+		{"cmp-vpir-dh", 1, true},
+		{"cmp-vpir-dh", 2, false},
+		{"cmp-vpir-lwe", 0, false},
+		{"cmp-vpir-lwe-128", 1, true},
+		{"unknown", 2, false},
+	}
+	for _, tt := range tests {
+		err := client.ValidateServers(tt.scheme, tt.numServers)
+		if tt.valid {
+			require.NoError(t, err, "%s with %d servers", tt.scheme, tt.numServers)
+		} else {
+			require.Error(t, err, "%s with %d servers", tt.scheme, tt.numServers)
+		}
+	}
+
+	// the query entry points use the same validation
+	db := randomBytesDB(t)
+	_, err := client.NewPIR(utils.RandomPRG(), &db.Info).QueryBytes(make([]byte, 4), 1)
+	require.EqualError(t, err, "scheme pir-classic needs at least 2 servers, got 1: "+
+		"a single server learns the queried index")
+	_, err = client.NewPredicateAPIR(utils.RandomPRG(), &db.Info).QueryBytes(nil, 3)
+	require.EqualError(t, err, "scheme fss-auth needs exactly 2 servers, got 3")
+}
+
+func TestRequiredServers(t *testing.T) {
+	tests := []struct {
+		collusionBound int
+		faultTolerance int
+		scheme         string
+		numServers     int
+		valid          bool
+	}{
+		{0, 0, "pir-classic", 2, true},
+		{1, 0, "pir-classic", 2, true},
+		{2, 0, "pir-merkle", 3, true},
+		{2, 1, "pointPIR", 4, true},
+		{4, 2, "pointVPIR", 7, true},
+		{-1, 0, "pir-classic", 0, false},
+		{0, -1, "pir-classic", 0, false},
+		{1, 0, "fss-classic", 2, true},
+		{0, 0, "complexVPIR", 2, true},
+		{2, 0, "fss-auth", 0, false},
+		{1, 1, "complexPIR", 0, false},
+		{0, 0, "cmp-vpir-dh", 1, true},
+		{5, 0, "cmp-vpir-lwe", 1, true},
+		{0, 2, "cmp-vpir-lwe-128", 3, true},
+		{1, 0, "unknown", 0, false},
+	}
+	for _, tt := range tests {
+		n, err := client.RequiredServers(tt.collusionBound, tt.faultTolerance, tt.scheme)
+		if !tt.valid {
+			require.Error(t, err, "%s with t=%d, f=%d", tt.scheme, tt.collusionBound, tt.faultTolerance)
+			continue
+		}
+		require.NoError(t, err, "%s with t=%d, f=%d", tt.scheme, tt.collusionBound, tt.faultTolerance)
+		require.Equal(t, tt.numServers, n, "%s with t=%d, f=%d", tt.scheme, tt.collusionBound, tt.faultTolerance)
+		// without faults, the servers are all queried
+		if tt.faultTolerance == 0 {
+			require.NoError(t, client.ValidateServers(tt.scheme, n))
+		}
+	}
+}
+
+func TestSingleServerNotPrivate(t *testing.T) {
+	db := randomBytesDB(t)
+	c := client.NewPIR(utils.RandomPRG(), &db.Info)
+	shards, err := database.SplitColumns(db.NumColumns, 2)
+	require.NoError(t, err)
+	sharded, err := client.NewShardedPIR(utils.RandomPRG(), &db.Info, shards)
+	require.NoError(t, err)
+
+	// all the multi-server queries refuse to run with a single server
+	_, err = c.QueryBytes(make([]byte, 4), 1)
+	require.ErrorIs(t, err, client.ErrNotPrivate)
+	_, err = c.QuerySeeded(0, 1)
+	require.ErrorIs(t, err, client.ErrNotPrivate)
+	_, err = c.QueryRange(0, 2, 1)
+	require.ErrorIs(t, err, client.ErrNotPrivate)
+	_, err = sharded.QueryBytes(make([]byte, 4), 1)
+	require.ErrorIs(t, err, client.ErrNotPrivate)
+	_, err = client.NewPredicatePIR(utils.RandomPRG(), &db.Info).QueryBytes(nil, 1)
+	require.ErrorIs(t, err, client.ErrNotPrivate)
+	_, err = client.NewPredicateAPIR(utils.RandomPRG(), &db.Info).QueryBytes(nil, 1)
+	require.ErrorIs(t, err, client.ErrNotPrivate)
+
+	// the single-server schemes are private with a single server
+	require.NoError(t, client.ValidateServers("cmp-vpir-lwe", 1))
+}
+
+func TestPIRLastVerified(t *testing.T) {
+	dbLen := 8 * 16 * 16 * 64
+	nRows, blockLen := 16, 64
+
+	retrieve := func(db *database.Bytes) *client.PIR {
+		c := client.NewPIR(utils.RandomPRG(), &db.Info)
+		s := server.NewPIR(db)
+		in := make([]byte, 4)
+		binary.BigEndian.PutUint32(in, 3)
+		queries, err := c.QueryBytes(in, 2)
+		require.NoError(t, err)
+		answers := make([][]byte, len(queries))
+		for i := range queries {
+			answers[i], err = s.AnswerBytes(queries[i])
+			require.NoError(t, err)
+		}
+		_, err = c.ReconstructBytes(answers)
+		require.NoError(t, err)
+		return c
+	}
+
+	// classical PIR does not verify the blocks
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), dbLen, nRows, blockLen)
+	require.NoError(t, err)
+	var v client.Verifier = retrieve(db)
+	require.False(t, v.LastVerified())
+
+	// unless the db has block hashes
+	require.NoError(t, database.AddBlockHashes(db, []byte("block hashes key")))
+	require.True(t, retrieve(db).LastVerified())
+
+	// the Merkle scheme always verifies
+	mdb := database.CreateRandomMerkle(utils.RandomPRG(), dbLen, nRows, blockLen)
+	require.True(t, retrieve(mdb).LastVerified())
+}
+
+func TestPIRNUMA(t *testing.T) {
+	// blocks of different lengths, split among the nodes by rows
+	lengths := []int{0, 1, 17, 64, 3, 100, 42, 99, 5, 60, 8, 31}
+	blocks := make([][]byte, len(lengths))
+	for k, l := range lengths {
+		blocks[k] = make([]byte, l)
+		_, err := utils.RandomPRG().Read(blocks[k])
+		require.NoError(t, err)
+	}
+	db, err := database.BytesFromBlocks(blocks, true)
+	require.NoError(t, err)
+	other := randomBytesDB(t)
+
+	// simulated sockets of uneven sizes, sharing the CPUs of the machine,
+	// more CPUs than rows and CPUs the threads cannot be pinned to
+	for _, nodes := range [][][]int{
+		{{0}},
+		{{0}, {0}},
+		{{0, 0, 0}, {0}},
+		{make([]int, 2*db.NumRows)},
+		{{0}, {1 << 20}},
+	} {
+		s, err := server.NewPIRWithNUMA(db, nodes)
+		require.NoError(t, err)
+		servers := []client.Answerer{s, server.NewPIR(db)}
+		c := client.NewPIR(utils.RandomPRG(), &db.Info)
+		for k := range blocks {
+			out, err := c.RetrieveRecord(k, 1, servers, false)
+			require.NoError(t, err)
+			require.Equal(t, blocks[k], out, "block %d, nodes %v", k, nodes)
+		}
+
+		// every CPU with rows answers on a worker
+		numCPUs := 0
+		for _, cpus := range nodes {
+			numCPUs += len(cpus)
+		}
+		_, stats, err := s.AnswerBytesWithStats(c.Query(0, 2)[0])
+		require.NoError(t, err)
+		require.Equal(t, utils.Min(numCPUs, db.NumRows), stats.WorkersUsed)
+
+		// a swapped db is split anew
+		s.SwapDB(other)
+		retrievePIRPointServers(t, client.NewPIR(utils.RandomPRG(), &other.Info), s, server.NewPIR(other), other, other.NumBlocks())
+		s.Close()
+	}
+
+	_, err = server.NewPIRWithNUMA(db, nil)
+	require.Error(t, err)
+	_, err = server.NewPIRWithNUMA(db, [][]int{{0}, {}})
+	require.Error(t, err)
+}
+
+// randomBytesDB returns a random bytes db of 16 rows of 16 blocks of 64
+// bytes, the db of most of the tests
+func randomBytesDB(t testing.TB) *database.Bytes {
+	t.Helper()
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), 8*16*16*64, 16, 64)
+	require.NoError(t, err)
+	return db
+}
+
 func retrievePIRPoint(t *testing.T, rnd io.Reader, db *database.Bytes, numBlocks int, testName string) {
 	c := client.NewPIR(rnd, &db.Info)
 	s0 := server.NewPIR(db)
 	s1 := server.NewPIR(db)
 
 	totalTimer := monitor.NewMonitor()
+	retrievePIRPointServers(t, c, s0, s1, db, numBlocks)
+	fmt.Printf("Total CPU time %s: %.2fms\n", testName, totalTimer.Record())
+}
+
+func retrievePIRPointServers(t *testing.T, c *client.PIR, s0, s1 *server.PIR, db *database.Bytes, numBlocks int) {
 	for i := 0; i < numBlocks; i++ {
 		in := make([]byte, 4)
 		binary.BigEndian.PutUint32(in, uint32(i))
@@ -58,5 +1191,418 @@ func retrievePIRPoint(t *testing.T, rnd io.Reader, db *database.Bytes, numBlocks
 		require.NoError(t, err)
 		require.Equal(t, db.Entries[i*db.BlockSize:(i+1)*db.BlockSize], res)
 	}
-	fmt.Printf("Total CPU time %s: %.2fms\n", testName, totalTimer.Record())
+}
+
+func BenchmarkAnswerPIR(b *testing.B) {
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), oneMB, 32, 512)
+	require.NoError(b, err)
+	benchmarkAnswerPoint(b, db)
+}
+
+func BenchmarkAnswerMerkle(b *testing.B) {
+	db := database.CreateRandomMerkle(utils.RandomPRG(), oneMB, 32, 512)
+	benchmarkAnswerPoint(b, db)
+}
+
+func BenchmarkAnswerPIRInto(b *testing.B) {
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), oneMB, 32, 512)
+	require.NoError(b, err)
+	s := server.NewPIR(db)
+	queries, err := client.NewPIR(utils.RandomPRG(), &db.Info).QueryBytes(make([]byte, 4), 2)
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	_, stats, err := server.BenchmarkAnswerInto(s, queries[0], b.N)
+	require.NoError(b, err)
+	b.ReportMetric(stats.MeanMs, "cpu-ms/op")
+}
+
+// BenchmarkAnswerPrecomputedPIR answers a single precomputed query, so
+// that only the server computation is measured
+func BenchmarkAnswerPrecomputedPIR(b *testing.B) {
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), oneMB, 32, 512)
+	require.NoError(b, err)
+	s := server.NewPIR(db)
+	queries, _, err := client.NewPIR(utils.RandomPRG(), &db.Info).PrecomputeQuery(42, 2)
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.AnswerBytes(queries[0]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestPIRPrecomputedQuery(t *testing.T) {
+	db := randomBytesDB(t)
+	servers := []*server.PIR{server.NewPIR(db), server.NewPIR(db)}
+	c := client.NewPIR(utils.RandomPRG(), &db.Info)
+
+	queries, qs, err := c.PrecomputeQuery(17, 2)
+	require.NoError(t, err)
+	require.Equal(t, 17, qs.Index())
+	for round := 0; round < 3; round++ {
+		answers := make([][]byte, len(servers))
+		for k, s := range servers {
+			answers[k], err = s.AnswerBytes(queries[k])
+			require.NoError(t, err)
+		}
+		// other queries in between do not change the precomputed state
+		c.Query(round, 2)
+		res, err := c.ReconstructWithState(qs, answers)
+		require.NoError(t, err)
+		require.Equal(t, db.Entries[17*db.BlockSize:18*db.BlockSize], res)
+	}
+
+	_, _, err = c.PrecomputeQuery(db.NumBlocks(), 2)
+	require.Error(t, err)
+	_, _, err = c.PrecomputeQuery(0, 1)
+	require.Error(t, err)
+	_, err = c.ReconstructWithState(nil, nil)
+	require.Error(t, err)
+}
+
+func TestPIRAnswerInto(t *testing.T) {
+	db := randomBytesDB(t)
+	s := server.NewPIR(db)
+	queries, err := client.NewPIR(utils.RandomPRG(), &db.Info).QueryBytes([]byte{0, 0, 0, 42}, 2)
+	require.NoError(t, err)
+
+	// the answer computed into the reused buffer is the one of AnswerBytes
+	for _, q := range queries {
+		expected, err := s.AnswerBytes(q)
+		require.NoError(t, err)
+		a, _, err := server.BenchmarkAnswerInto(s, q, 3)
+		require.NoError(t, err)
+		require.Equal(t, expected, a)
+	}
+}
+
+func TestPIRReconstructInto(t *testing.T) {
+	classical := randomBytesDB(t)
+	hashed := randomBytesDB(t)
+	require.NoError(t, database.AddBlockHashes(hashed, utils.RandomPRGKey()[:]))
+	merkle := database.CreateRandomMerkle(utils.RandomPRG(), 8*16*16*64, 16, 64)
+
+	for _, db := range []*database.Bytes{classical, hashed, merkle} {
+		c := client.NewPIR(utils.RandomPRG(), &db.Info)
+		s := server.NewPIR(db)
+		// a dirty buffer reused across the blocks
+		dst := make([]byte, db.BlockSize)
+		for i := range dst {
+			dst[i] = 0xff
+		}
+		for _, i := range []int{0, 17, db.NumBlocks() - 1} {
+			queries := c.Query(i, 2)
+			answers := make([][]byte, len(queries))
+			for k := range queries {
+				a, err := s.AnswerBytes(queries[k])
+				require.NoError(t, err)
+				answers[k] = a
+			}
+			expected, err := c.Reconstruct(answers)
+			require.NoError(t, err)
+			verified := c.LastVerified()
+
+			n, err := c.ReconstructBytesInto(answers, dst)
+			require.NoError(t, err)
+			require.Equal(t, expected, dst[:n])
+			require.Equal(t, verified, c.LastVerified())
+		}
+
+		// the buffer must hold a block
+		_, err := c.ReconstructBytesInto([][]byte{make([]byte, client.ExpectedAnswerBytes(&db.Info))}, dst[1:])
+		require.Error(t, err)
+	}
+}
+
+// BenchmarkPIRFirstAnswer measures the first answer on a freshly mapped
+// db, with and without warming it up, e.g., with
+//
+//	go test -run XXX -bench PIRFirstAnswer
+func BenchmarkPIRFirstAnswer(b *testing.B) {
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), 8<<22, 64, 64)
+	require.NoError(b, err)
+	path := filepath.Join(b.TempDir(), "db")
+	require.NoError(b, database.SaveBytesFlat(path, db))
+	queries := client.NewPIR(utils.RandomPRG(), &db.Info).Query(42, 2)
+
+	for _, warmup := range []bool{false, true} {
+		name := "cold"
+		if warmup {
+			name = "warm"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				mapped, unmap, err := database.MapBytes(path)
+				require.NoError(b, err)
+				s := server.NewPIR(mapped)
+				if warmup {
+					s.Warmup()
+				}
+				b.StartTimer()
+
+				_, err = s.AnswerBytes(queries[0])
+				require.NoError(b, err)
+
+				b.StopTimer()
+				require.NoError(b, unmap())
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+// BenchmarkReconstructPIR compares 1000 sequential reconstructions
+// allocating the block with as many into a reused buffer
+func BenchmarkReconstructPIR(b *testing.B) {
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), oneMB, 32, 512)
+	require.NoError(b, err)
+	s := server.NewPIR(db)
+	c := client.NewPIR(utils.RandomPRG(), &db.Info)
+	queries := c.Query(42, 2)
+	answers := make([][]byte, len(queries))
+	for k := range queries {
+		answers[k], err = s.AnswerBytes(queries[k])
+		require.NoError(b, err)
+	}
+	const reconstructions = 1000
+
+	b.Run("alloc", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < reconstructions; j++ {
+				if _, err := c.Reconstruct(answers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("into", func(b *testing.B) {
+		b.ReportAllocs()
+		dst := make([]byte, db.BlockSize)
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < reconstructions; j++ {
+				if _, err := c.ReconstructBytesInto(answers, dst); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+// BenchmarkLayout compares the vector layout, a single row, with the
+// rebalanced layout, the square matrix of CalculateNumRowsAndColumns, of the
+// same db for the IT schemes. Every line reports the bytes of the query and
+// of the answer of one server and the CPU time of the answer, e.g., with
+//
+//	go test -run XXX -bench Layout
+func BenchmarkLayout(b *testing.B) {
+	numBlocks := oneMB / (8 * testBlockLength)
+	for _, rebalanced := range []bool{false, true} {
+		layout := "vector"
+		if rebalanced {
+			layout = "rebalanced"
+		}
+		numRows, _ := database.CalculateNumRowsAndColumns(numBlocks, rebalanced)
+
+		b.Run("PIR/"+layout, func(b *testing.B) {
+			db, err := database.CreateRandomBytes(utils.RandomPRG(), oneMB, numRows, testBlockLength)
+			require.NoError(b, err)
+			benchmarkAnswerPoint(b, db)
+		})
+		b.Run("Merkle/"+layout, func(b *testing.B) {
+			db := database.CreateRandomMerkle(utils.RandomPRG(), oneMB, numRows, testBlockLength)
+			benchmarkAnswerPoint(b, db)
+		})
+	}
+}
+
+func TestExpectedQueryBytes(t *testing.T) {
+	numBlocks := 16 * 16
+	for _, rebalanced := range []bool{false, true} {
+		numRows, _ := database.CalculateNumRowsAndColumns(numBlocks, rebalanced)
+		db, err := database.CreateRandomBytes(utils.RandomPRG(), 8*numBlocks*testBlockLength, numRows, testBlockLength)
+		require.NoError(t, err)
+		queries, err := client.NewPIR(utils.RandomPRG(), &db.Info).QueryBytes(make([]byte, 4), 2)
+		require.NoError(t, err)
+		a, err := server.NewPIR(db).AnswerBytes(queries[0])
+		require.NoError(t, err)
+
+		require.Len(t, queries[0], client.ExpectedQueryBytes(&db.Info))
+		require.Len(t, a, client.ExpectedAnswerBytes(&db.Info))
+	}
+}
+
+func TestPIRIdentityDB(t *testing.T) {
+	numBlocks := 16 * 16
+	for _, rebalanced := range []bool{false, true} {
+		numRows, numColumns := database.CalculateNumRowsAndColumns(numBlocks, rebalanced)
+		db, err := database.CreateIdentityBytes(numRows, numColumns, testBlockLength)
+		require.NoError(t, err)
+		c := client.NewPIR(utils.RandomPRG(), &db.Info)
+		servers := []*server.PIR{server.NewPIR(db), server.NewPIR(db)}
+
+		// the first and last blocks of the rows, where off-by-one errors show
+		for _, i := range []int{0, 1, numColumns - 1, numColumns, numBlocks/2 + 3, numBlocks - 1} {
+			if i >= numBlocks {
+				continue
+			}
+			in := make([]byte, 4)
+			binary.BigEndian.PutUint32(in, uint32(i))
+			queries, err := c.QueryBytes(in, len(servers))
+			require.NoError(t, err)
+			answers := make([][]byte, len(servers))
+			for k, s := range servers {
+				answers[k], err = s.AnswerBytes(queries[k])
+				require.NoError(t, err)
+			}
+			res, err := c.Reconstruct(answers)
+			require.NoError(t, err)
+			require.Equal(t, uint32(i), binary.BigEndian.Uint32(res), "rebalanced: %v", rebalanced)
+		}
+	}
+
+	_, err := database.CreateIdentityBytes(4, 4, database.IdentityIndexLength-1)
+	require.Error(t, err)
+}
+
+func TestPIRReconstructStream(t *testing.T) {
+	classical := randomBytesDB(t)
+	merkle := database.CreateRandomMerkle(utils.RandomPRG(), 8*16*16*64, 16, 64)
+	const numServers, chunkLen = 3, 100
+
+	for _, db := range []*database.Bytes{classical, merkle} {
+		c := client.NewPIR(utils.RandomPRG(), &db.Info)
+		s := server.NewPIR(db)
+		for _, i := range []int{0, 17, db.NumBlocks() - 1} {
+			queries := c.Query(i, numServers)
+			answers := make([][]byte, numServers)
+			var chunks []client.AnswerChunk
+			for k := range queries {
+				a, err := s.AnswerBytes(queries[k])
+				require.NoError(t, err)
+				answers[k] = a
+				chunks = append(chunks, client.SplitAnswer(k, a, chunkLen)...)
+			}
+			expected, err := c.Reconstruct(answers)
+			require.NoError(t, err)
+
+			// the chunks of the servers arrive interleaved and out of order
+			rand.Shuffle(len(chunks), func(a, b int) { chunks[a], chunks[b] = chunks[b], chunks[a] })
+			ch := make(chan client.AnswerChunk, len(chunks))
+			for _, chunk := range chunks {
+				ch <- chunk
+			}
+			res, err := c.ReconstructStream(ch, numServers, chunkLen)
+			require.NoError(t, err)
+			require.Equal(t, expected, res)
+			require.Equal(t, db.PIRType == database.PIRTypeMerkle, c.LastVerified())
+		}
+	}
+
+	// a duplicated or a missing chunk is an error
+	c := client.NewPIR(utils.RandomPRG(), &classical.Info)
+	queries := c.Query(3, 2)
+	var chunks []client.AnswerChunk
+	for k := range queries {
+		a, err := server.NewPIR(classical).AnswerBytes(queries[k])
+		require.NoError(t, err)
+		chunks = append(chunks, client.SplitAnswer(k, a, chunkLen)...)
+	}
+	ch := make(chan client.AnswerChunk, len(chunks))
+	ch <- chunks[0]
+	ch <- chunks[0]
+	_, err := c.ReconstructStream(ch, 2, chunkLen)
+	require.ErrorContains(t, err, "duplicated chunk 0 of server 0")
+
+	ch = make(chan client.AnswerChunk, len(chunks))
+	for _, chunk := range chunks[1:] {
+		ch <- chunk
+	}
+	close(ch)
+	_, err = c.ReconstructStream(ch, 2, chunkLen)
+	require.EqualError(t, err, "1 chunks missing")
+}
+
+func TestPIRAnswerBands(t *testing.T) {
+	classical := randomBytesDB(t)
+	records := make([][]byte, 50)
+	for i := range records {
+		records[i] = make([]byte, i*5)
+		_, err := utils.RandomPRG().Read(records[i])
+		require.NoError(t, err)
+	}
+	// blocks of different lengths
+	embedded, _, err := database.EmbedRecords(records, 32, true)
+	require.NoError(t, err)
+
+	for _, db := range []*database.Bytes{classical, embedded} {
+		c := client.NewPIR(utils.RandomPRG(), &db.Info)
+		s := server.NewPIR(db)
+		queries := c.Query(db.NumBlocks()/2, 2)
+		expected, err := s.AnswerBytes(queries[0])
+		require.NoError(t, err)
+
+		// bands dividing the rows or not, and more rows than the db
+		for _, bandRows := range []int{1, 3, db.NumRows, 2 * db.NumRows} {
+			var banded []byte
+			err := s.AnswerBytesInBands(queries[0], bandRows, func(index int, band []byte) error {
+				require.Equal(t, len(banded), index*bandRows*db.BlockSize)
+				banded = append(banded, band...)
+				return nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, expected, banded, "bands of %d rows", bandRows)
+		}
+
+		// the bands are the chunks of a streamed reply
+		bandRows := 3
+		ch := make(chan client.AnswerChunk, 2*db.NumRows)
+		for k, q := range queries {
+			err := s.AnswerBytesInBands(q, bandRows, func(index int, band []byte) error {
+				ch <- client.AnswerChunk{Server: k, Index: index, Data: append([]byte(nil), band...)}
+				return nil
+			})
+			require.NoError(t, err)
+		}
+		res, err := c.ReconstructStream(ch, 2, bandRows*db.BlockSize)
+		require.NoError(t, err)
+		require.Equal(t, db.PayloadLength(db.NumBlocks()/2), len(res))
+	}
+
+	// the error of emit stops the bands
+	s := server.NewPIR(classical)
+	q := client.NewPIR(utils.RandomPRG(), &classical.Info).Query(0, 2)[0]
+	calls := 0
+	errEmit := errors.New("emit")
+	err = s.AnswerBytesInBands(q, 1, func(int, []byte) error {
+		calls++
+		return errEmit
+	})
+	require.ErrorIs(t, err, errEmit)
+	require.Equal(t, 1, calls)
+	require.Error(t, s.AnswerBytesInBands(q, 0, nil))
+}
+
+func benchmarkAnswerPoint(b *testing.B, db *database.Bytes) {
+	c := client.NewPIR(utils.RandomPRG(), &db.Info)
+	s := server.NewPIR(db)
+
+	in := make([]byte, 4)
+	binary.BigEndian.PutUint32(in, 0)
+	queries, err := c.QueryBytes(in, 2)
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	stats, err := server.BenchmarkAnswer(s, queries[0], b.N)
+	require.NoError(b, err)
+	b.ReportMetric(stats.MeanMs, "cpu-ms/op")
+	b.ReportMetric(float64(client.ExpectedQueryBytes(&db.Info)), "query-B")
+	b.ReportMetric(float64(client.ExpectedAnswerBytes(&db.Info)), "answer-B")
 }
@@ -3,12 +3,12 @@ package main
 import (
 	"context"
 	"encoding/binary"
-	"flag"
-	"fmt"
-	"log"
+	"encoding/json"
+	"io"
 	"math"
 	"math/rand"
 	"os"
+	"sort"
 	"sync"
 	"time"
 
@@ -19,6 +19,7 @@ import (
 	"github.com/si-co/vpir-code/lib/utils"
 	"golang.org/x/xerrors"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/encoding/gzip"
 )
@@ -36,46 +37,32 @@ type localClient struct {
 
 	prg        *utils.PRGReader
 	config     *utils.Config
-	flags      *flags
+	sim        *SimConfig
 	dbInfo     *database.Info
 	vpirClient client.Client
-}
-
-type flags struct {
-	// experiments flag
-	logFile        string
-	repetitions    int
-	numServers     int
-	elemBitSize    int
-	bitsToRetrieve int
 
-	// scheme flags
-	scheme string
+	// selects the servers queried by runQueries, firstHealthy if nil
+	selection selectionPolicy
 
-	// flags for complex queries
-	inputSize int
+	// writes a result per repetition, nil if no results file is given
+	results *json.Encoder
 }
 
-func parseFlags() *flags {
-	f := new(flags)
-
-	// experiments flags
-	flag.StringVar(&f.logFile, "logFile", "", "file to store logs")
-	flag.IntVar(&f.repetitions, "repetitions", -1, "experiment repetitions")
-	// default number of servers is 2
-	flag.IntVar(&f.numServers, "numServers", 2, "number of servers for the experiment")
-	flag.IntVar(&f.elemBitSize, "elemBitSize", -1, "bit size of element, in which block lengtht is specified")
-	flag.IntVar(&f.bitsToRetrieve, "bitsToRetrieve", -1, "number of bits to retrieve in experiment")
-
-	// scheme flags
-	flag.StringVar(&f.scheme, "scheme", "", "scheme to use")
-
-	// flag for complex queries
-	flag.IntVar(&f.inputSize, "inputSize", -1, "input of string to search of")
-
-	flag.Parse()
-
-	return f
+// result is the record written to the results file for every repetition of
+// an experiment. The durations are wall-clock times summed over the blocks
+// retrieved in the repetition, except for ServerComputeMs, which sums the
+// CPU time reported by the servers in their answers.
+type result struct {
+	Scheme          string  `json:"scheme"`
+	DBBytes         int     `json:"db_bytes"`
+	Repetition      int     `json:"repetition"`
+	QueryBytes      int     `json:"query_bytes"`
+	AnswerBytes     int     `json:"answer_bytes"`
+	QueryMs         float64 `json:"query_ms"`
+	AnswerMs        float64 `json:"answer_ms"`
+	ReconstructMs   float64 `json:"reconstruct_ms"`
+	ServerComputeMs float64 `json:"server_compute_ms"`
+	Error           string  `json:"error,omitempty"`
 }
 
 func newLocalClient() *localClient {
@@ -87,10 +74,17 @@ func newLocalClient() *localClient {
 			grpc.MaxCallRecvMsgSize(1024 * 1024 * 1024),
 			grpc.MaxCallSendMsgSize(1024 * 1024 * 1024),
 		},
-		prg:   utils.RandomPRG(),
-		flags: parseFlags(),
+		prg: utils.RandomPRG(),
 	}
 
+	sim, err := parseSimConfig(os.Args[1:])
+	if err != nil {
+		fatal("invalid simulation config", err)
+	}
+	lc.sim = sim
+	// the policy name was checked by the validation
+	lc.selection, _ = selectionByName(sim.Experiment.Selection)
+
 	// load configs
 	configPath := os.Getenv(configEnvKey)
 	if configPath == "" {
@@ -99,7 +93,7 @@ func newLocalClient() *localClient {
 
 	config, err := utils.LoadConfig(configPath)
 	if err != nil {
-		log.Fatalf("could not load the config file: %v", err)
+		fatal("could not load the config file", err)
 	}
 	lc.config = config
 
@@ -109,149 +103,217 @@ func newLocalClient() *localClient {
 func main() {
 	lc := newLocalClient()
 
-	// set logs to stdout
-	log.SetOutput(os.Stdout)
-	log.SetPrefix(fmt.Sprintf("[Client] "))
-	if len(lc.flags.logFile) > 0 {
-		f, err := os.Create(lc.flags.logFile)
+	// write logs either to stdout or to the log file
+	var out io.Writer = os.Stdout
+	if len(lc.sim.Experiment.LogFile) > 0 {
+		f, err := os.Create(lc.sim.Experiment.LogFile)
 		if err != nil {
-			log.Fatal("Could not open file: ", err)
+			fatal("could not open log file", err)
 		}
 		defer f.Close()
-		log.SetOutput(f)
+		out = f
 	}
+	logger = newLogger(out)
 
-	err := lc.connectToServers(lc.flags.numServers)
-	defer lc.closeConnections()
+	if len(lc.sim.Experiment.ResultsFile) > 0 {
+		f, err := os.Create(lc.sim.Experiment.ResultsFile)
+		if err != nil {
+			fatal("could not open results file", err)
+		}
+		defer f.Close()
+		lc.results = json.NewEncoder(f)
+	}
 
+	err := lc.connectToServers(lc.sim.Experiment.NumServers)
+	defer lc.closeConnections()
 	if err != nil {
-		log.Fatal(err)
+		fatal("could not connect to servers", err)
 	}
 
 	_, err = lc.exec()
 	if err != nil {
-		log.Fatal(err)
+		fatal("experiment failed", err)
 	}
 }
 
+// logger writes the logs of the client, to stdout until main sets the log
+// file
+var logger = newLogger(os.Stdout)
+
+// newLogger returns a logger writing JSON records tagged as client logs to w
+func newLogger(w io.Writer) *utils.Logger {
+	return utils.NewLogger(w, "role", "client")
+}
+
+// fatal logs err and exits. It is only called from main, the other
+// functions return their errors.
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
+
 func (lc *localClient) exec() (string, error) {
-	lc.retrieveDBInfo()
+	if err := client.ValidateServers(lc.sim.Scheme.Name, lc.numQueried()); err != nil {
+		return "", err
+	}
+	if err := lc.retrieveDBInfo(); err != nil {
+		return "", err
+	}
+	if err := client.ValidatePIRType(lc.sim.Scheme.Name, lc.dbInfo); err != nil {
+		return "", err
+	}
 
 	// start correct client
-	switch lc.flags.scheme {
+	switch lc.sim.Scheme.Name {
 	case "pir-classic", "pir-merkle":
+		if err := checkLayout(lc.sim.Scheme.Layout, lc.dbInfo); err != nil {
+			return "", err
+		}
+		if err := checkDBConfig(lc.config.DB, lc.dbInfo); err != nil {
+			return "", err
+		}
 		// get and store db info.
 		lc.vpirClient = client.NewPIR(lc.prg, lc.dbInfo)
-		lc.retrievePointPIR()
+		return "", lc.retrievePointPIR()
 	case "fss-classic":
 		lc.vpirClient = client.NewPredicatePIR(lc.prg, lc.dbInfo)
-		lc.retrieveComplexPIR()
+		return "", lc.retrieveComplexPIR()
 	case "fss-auth":
 		lc.vpirClient = client.NewPredicateAPIR(lc.prg, lc.dbInfo)
-		lc.retrieveComplexPIR()
+		return "", lc.retrieveComplexPIR()
 	default:
-		return "", xerrors.Errorf("wrong scheme: %s", lc.flags.scheme)
+		return "", xerrors.Errorf("wrong scheme: %s", lc.sim.Scheme.Name)
 	}
-
-	return "", nil
 }
 
-func (lc *localClient) retrieveComplexPIR() {
-	stringToSearch := utils.Ranstring(lc.flags.inputSize)
+func (lc *localClient) retrieveComplexPIR() error {
+	stringToSearch := utils.Ranstring(lc.sim.Complex.InputSize)
 
 	in := utils.ByteToBits([]byte(stringToSearch))
 	q := &query.ClientFSS{
-		Info:  &query.Info{Target: query.UserId, FromStart: lc.flags.inputSize},
+		Info:  &query.Info{Target: query.UserId, FromStart: lc.sim.Complex.InputSize},
 		Input: in,
 	}
-	for j := 0; j < lc.flags.repetitions; j++ {
-		log.Printf("start repetition %d out of %d", j+1, lc.flags.repetitions)
-
-		// data for statistics
-		bw := 0
-		t := time.Now()
-
-		queryBytes, err := q.Encode()
-		if err != nil {
-			log.Fatal(err)
-		}
-		queries, err := lc.vpirClient.QueryBytes(queryBytes, len(lc.connections))
-		if err != nil {
-			log.Fatal("error when executing query:", err)
-		}
-		log.Printf("done with queries computation")
-
-		// store bw for queries
-		for _, q := range queries {
-			bw += len(q)
-		}
-
-		// send queries to servers
-		answers := lc.runQueries(queries)
-
-		// reconstruct
-		_, err = lc.vpirClient.ReconstructBytes(answers)
-		if err != nil {
-			log.Fatal("error during reconstruction:", err)
-		}
-		log.Printf("done with block reconstruction")
-
-		// user time elapsed
-		elapsedTime := time.Since(t)
-		log.Printf("stats,%d,%d,%f", j, bw, elapsedTime.Seconds())
+	queryBytes, err := q.Encode()
+	if err != nil {
+		return err
 	}
 
+	return lc.repeat(func(res *result) error {
+		return lc.retrieveBlock(queryBytes, res)
+	})
 }
 
-func (lc *localClient) retrievePointPIR() {
-	numTotalBlocks := lc.dbInfo.NumRows * lc.dbInfo.NumColumns
-	numRetrieveBlocks := bitsToBlocks(lc.dbInfo.BlockSize, lc.flags.elemBitSize, lc.flags.bitsToRetrieve)
+func (lc *localClient) retrievePointPIR() error {
+	numTotalBlocks := lc.dbInfo.NumBlocks()
+	numRetrieveBlocks := bitsToBlocks(lc.dbInfo.BlockSize, lc.sim.Point.ElemBitSize, lc.sim.Point.BitsToRetrieve)
 
 	// pick a random block index to start the retrieval
 	startIndex := rand.Intn(numTotalBlocks - numRetrieveBlocks)
 
 	queryByte := make([]byte, 4)
-	for j := 0; j < lc.flags.repetitions; j++ {
-		log.Printf("start repetition %d out of %d", j+1, lc.flags.repetitions)
-
-		// data for statistics
-		bw := 0
-		t := time.Now()
-
+	return lc.repeat(func(res *result) error {
 		// retrieve appropriate number of blocks
 		for i := 0; i < numRetrieveBlocks; i++ {
 			binary.BigEndian.PutUint32(queryByte, uint32(startIndex+i))
-			queries, err := lc.vpirClient.QueryBytes(queryByte, len(lc.connections))
-			if err != nil {
-				log.Fatal("error when executing query:", err)
+			if err := lc.retrieveBlock(queryByte, res); err != nil {
+				return err
 			}
-			log.Printf("done with queries computation")
+		}
 
-			// store bw for queries
-			for _, q := range queries {
-				bw += len(q)
-			}
+		return nil
+	})
+}
 
-			// send queries to servers
-			answers := lc.runQueries(queries)
+// repeat runs a repetition of the experiment lc.sim.Experiment.Repetitions times and
+// logs its bandwidth and its duration, as measured in the result filled by
+// run. A failed repetition, e.g., because a server did not answer, is logged
+// and the experiment goes on. The result of every repetition, failed or not,
+// is written to lc.results. The returned error counts the failed
+// repetitions.
+func (lc *localClient) repeat(run func(*result) error) error {
+	failed := 0
+	for j := 0; j < lc.sim.Experiment.Repetitions; j++ {
+		logger.Info("start repetition", "repetition", j+1, "repetitions", lc.sim.Experiment.Repetitions)
+
+		res := &result{
+			Scheme:     lc.sim.Scheme.Name,
+			DBBytes:    lc.dbInfo.SizeBytes(),
+			Repetition: j,
+		}
+		t := time.Now()
+		err := run(res)
+		if err != nil {
+			failed++
+			res.Error = err.Error()
+			logger.Error("repetition failed", "repetition", j+1, "error", err)
+		} else {
+			// user time elapsed
+			logger.Info("stats", "repetition", j, "bandwidth", res.QueryBytes, "seconds", time.Since(t).Seconds())
+		}
 
-			// reconstruct
-			_, err = lc.vpirClient.ReconstructBytes(answers)
-			if err != nil {
-				log.Fatal("error during reconstruction:", err)
+		if lc.results != nil {
+			if err := lc.results.Encode(res); err != nil {
+				return xerrors.Errorf("failed to write result: %v", err)
 			}
-			log.Printf("done with block reconstruction")
 		}
+	}
+	if failed > 0 {
+		return xerrors.Errorf("%d out of %d repetitions failed", failed, lc.sim.Experiment.Repetitions)
+	}
+
+	return nil
+}
 
-		// user time elapsed
-		elapsedTime := time.Since(t)
-		log.Printf("stats,%d,%d,%f", j, bw, elapsedTime.Seconds())
+// retrieveBlock queries the servers for the given client input and
+// reconstructs the result. It adds the sizes of the queries and of the
+// answers and the duration of every phase to res.
+func (lc *localClient) retrieveBlock(in []byte, res *result) error {
+	t := time.Now()
+	queries, err := lc.vpirClient.QueryBytes(in, lc.numQueried())
+	if err != nil {
+		return xerrors.Errorf("error when executing query: %v", err)
+	}
+	bw := lc.vpirClient.LastQueryBytes()
+	res.QueryBytes += bw
+	res.QueryMs += msSince(t)
+	logger.Info("done", "phase", "query", "query_size", bw, "duration", time.Since(t))
+
+	// send queries to servers
+	t = time.Now()
+	resps, servers, err := lc.runQueries(queries)
+	if err != nil {
+		return err
+	}
+	res.AnswerMs += msSince(t)
+	answers := make([][]byte, len(resps))
+	for i, r := range resps {
+		answers[i] = r.GetAnswer()
+		res.ServerComputeMs += r.GetComputeMs()
+	}
+	logger.Info("done", "phase", "answer", "servers", servers, "duration", time.Since(t))
+
+	// reconstruct
+	t = time.Now()
+	_, err = lc.vpirClient.ReconstructBytes(answers)
+	if err != nil {
+		return xerrors.Errorf("error during reconstruction: %v", err)
 	}
+	res.AnswerBytes += lc.vpirClient.LastAnswerBytes()
+	res.ReconstructMs += msSince(t)
+	logger.Info("done", "phase", "reconstruct", "duration", time.Since(t))
+
+	return nil
+}
+
+// msSince returns the milliseconds elapsed since t
+func msSince(t time.Time) float64 {
+	return float64(time.Since(t)) / float64(time.Millisecond)
 }
 
 func (lc *localClient) connectToServers(numServers int) error {
 	// load servers certificates
-	creds, err := utils.LoadServersCertificates()
+	creds, err := utils.LoadConfigCredentials(lc.config)
 	if err != nil {
 		return xerrors.Errorf("could not load servers certificates: %v", err)
 	}
@@ -259,7 +321,7 @@ func (lc *localClient) connectToServers(numServers int) error {
 	// connect to servers and store connections
 	lc.connections = make(map[string]*grpc.ClientConn)
 	for _, s := range lc.config.Addresses[0:numServers] {
-		conn, err := connectToServer(creds, s)
+		conn, err := connectToServer(creds[s], s)
 		if err != nil {
 			return xerrors.Errorf("failed to connect: %v", err)
 		}
@@ -274,21 +336,27 @@ func (lc *localClient) closeConnections() {
 	for _, conn := range lc.connections {
 		err := conn.Close()
 		if err != nil {
-			log.Printf("failed to close conn: %v", err)
+			logger.Warn("failed to close conn", "server", conn.Target(), "error", err)
 		}
 	}
 }
 
-func (lc *localClient) retrieveDBInfo() {
+func (lc *localClient) retrieveDBInfo() error {
 	subCtx, cancel := context.WithTimeout(lc.ctx, time.Hour)
 	defer cancel()
 
+	type result struct {
+		target string
+		info   *database.Info
+		err    error
+	}
 	wg := sync.WaitGroup{}
-	resCh := make(chan *database.Info, len(lc.connections))
+	resCh := make(chan result, len(lc.connections))
 	for _, conn := range lc.connections {
 		wg.Add(1)
 		go func(conn *grpc.ClientConn) {
-			resCh <- dbInfo(subCtx, conn, lc.callOptions)
+			info, err := dbInfo(subCtx, conn, lc.callOptions)
+			resCh <- result{conn.Target(), info, err}
 			wg.Done()
 		}(conn)
 	}
@@ -296,39 +364,91 @@ func (lc *localClient) retrieveDBInfo() {
 	close(resCh)
 
 	dbInfo := make([]*database.Info, 0)
-	for i := range resCh {
-		dbInfo = append(dbInfo, i)
+	targets := make([]string, 0)
+	for r := range resCh {
+		if r.err != nil {
+			return r.err
+		}
+		dbInfo = append(dbInfo, r.info)
+		targets = append(targets, r.target)
 	}
 
 	// check if db info are all equal before returning
 	if !equalDBInfo(dbInfo) {
-		log.Fatal("got different database info from servers")
+		return xerrors.New("got different database info from servers")
+	}
+	if err := database.CheckMerkleRoots(dbInfo, targets); err != nil {
+		return err
 	}
 
-	log.Printf("databaseInfo: %#v", dbInfo[0])
+	logger.Info("got database info", "rows", dbInfo[0].NumRows, "columns", dbInfo[0].NumColumns,
+		"block_size", dbInfo[0].BlockSize, "pir_type", dbInfo[0].PIRType)
 
 	lc.dbInfo = dbInfo[0]
+
+	return nil
 }
 
-func dbInfo(ctx context.Context, conn *grpc.ClientConn, opts []grpc.CallOption) *database.Info {
+func dbInfo(ctx context.Context, conn *grpc.ClientConn, opts []grpc.CallOption) (*database.Info, error) {
 	c := proto.NewVPIRClient(conn)
 	q := &proto.DatabaseInfoRequest{}
 	answer, err := c.DatabaseInfo(ctx, q, opts...)
 	if err != nil {
-		log.Fatalf("could not send database info request to %s: %v",
+		return nil, xerrors.Errorf("could not send database info request to %s: %v",
 			conn.Target(), err)
 	}
-	log.Printf("sent databaseInfo request to %s", conn.Target())
+	logger.Info("sent databaseInfo request", "server", conn.Target())
 
 	dbInfo := &database.Info{
 		NumRows:    int(answer.GetNumRows()),
 		NumColumns: int(answer.GetNumColumns()),
 		BlockSize:  int(answer.GetBlockLength()),
-		PIRType:    answer.GetPirType(),
+		PIRType:    database.PIRType(answer.GetPirType()),
 		Merkle:     &database.Merkle{Root: answer.GetRoot(), ProofLen: int(answer.GetProofLen())},
 	}
 
-	return dbInfo
+	return dbInfo, nil
+}
+
+// checkLayout returns an error if the db served does not have the expected
+// layout. An empty layout accepts any db.
+func checkLayout(layout string, info *database.Info) error {
+	if layout == "" {
+		return nil
+	}
+	rebalanced, err := database.ParseLayout(layout)
+	if err != nil {
+		return err
+	}
+	if rebalanced && info.NumRows != info.NumColumns {
+		return xerrors.Errorf("matrix layout requires a square db, got %d rows and %d columns",
+			info.NumRows, info.NumColumns)
+	}
+	if info.Layout() != layout {
+		return xerrors.Errorf("expected %s layout, servers have %s", layout, info.Layout())
+	}
+
+	return nil
+}
+
+// checkDBConfig returns an error if the db served does not match the db
+// defined in the config file. A config without db section accepts any db.
+func checkDBConfig(params *utils.DBConfig, info *database.Info) error {
+	if params == nil {
+		return nil
+	}
+	if params.BlockLen > 0 && info.BlockSize != params.BlockLen {
+		return xerrors.Errorf("expected block length %d, servers have %d",
+			params.BlockLen, info.BlockSize)
+	}
+	if params.Layout != "" {
+		return checkLayout(params.Layout, info)
+	}
+	if params.NRows == 1 && info.NumRows != 1 {
+		return xerrors.Errorf("expected a single row, servers have %d", info.NumRows)
+	}
+
+	return nil
 }
 
 func equalDBInfo(info []*database.Info) bool {
@@ -353,7 +473,7 @@ func connectToServer(creds credentials.TransportCredentials, address string) (*g
 		return nil, xerrors.Errorf("did not connect to %s: %v", address, err)
 	}
 
-	log.Println("connected to server", address)
+	logger.Info("connected to server", "server", address)
 
 	return conn, nil
 }
@@ -363,42 +483,132 @@ func bitsToBlocks(blockSize, elemSize, numBits int) int {
 	return int(math.Ceil(float64(numBits) / float64(blockSize*elemSize)))
 }
 
-func (lc *localClient) runQueries(queries [][]byte) [][]byte {
+// numQueried returns the number of servers to query, i.e., the
+// queryServers key if set and all the connected servers otherwise
+func (lc *localClient) numQueried() int {
+	if lc.sim.Experiment.QueryServers > 0 && lc.sim.Experiment.QueryServers < len(lc.connections) {
+		return lc.sim.Experiment.QueryServers
+	}
+	return len(lc.connections)
+}
+
+// selectionPolicy orders the addresses of the connected servers. runQueries
+// sends the queries to the first servers in this order and fails over to
+// the next ones.
+type selectionPolicy func(connections map[string]*grpc.ClientConn) []string
+
+// firstHealthy orders the servers by address, with the servers whose
+// connection is failing or closed last
+func firstHealthy(connections map[string]*grpc.ClientConn) []string {
+	healthy := make([]string, 0, len(connections))
+	unhealthy := make([]string, 0)
+	for addr, conn := range connections {
+		switch conn.GetState() {
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			unhealthy = append(unhealthy, addr)
+		default:
+			healthy = append(healthy, addr)
+		}
+	}
+	sort.Strings(healthy)
+	sort.Strings(unhealthy)
+
+	return append(healthy, unhealthy...)
+}
+
+// newRoundRobin returns a policy rotating the order of firstHealthy by one
+// server at every call, to spread the queries among the servers
+func newRoundRobin() selectionPolicy {
+	var mu sync.Mutex
+	next := 0
+	return func(connections map[string]*grpc.ClientConn) []string {
+		order := firstHealthy(connections)
+		if len(order) == 0 {
+			return order
+		}
+		mu.Lock()
+		start := next % len(order)
+		next++
+		mu.Unlock()
+
+		rotated := make([]string, 0, len(order))
+		rotated = append(rotated, order[start:]...)
+		return append(rotated, order[:start]...)
+	}
+}
+
+// runQueries sends each query to a different server, in parallel, selecting
+// the servers with lc.selection. A query whose server fails is sent to the
+// next server not selected yet. It returns the responses and the addresses
+// of the servers that answered, in the order of the queries, or an error if
+// there are no servers left to fail over to.
+func (lc *localClient) runQueries(queries [][]byte) ([]*proto.QueryResponse, []string, error) {
 	subCtx, cancel := context.WithTimeout(lc.ctx, time.Hour)
 	defer cancel()
 
+	selection := lc.selection
+	if selection == nil {
+		selection = firstHealthy
+	}
+	order := selection(lc.connections)
+	if len(order) < len(queries) {
+		return nil, nil, xerrors.Errorf("%d queries for %d servers", len(queries), len(order))
+	}
+
+	// next server to fail over to
+	var mu sync.Mutex
+	next := len(queries)
+
+	answers := make([]*proto.QueryResponse, len(queries))
+	servers := make([]string, len(queries))
+	errs := make([]error, len(queries))
 	wg := sync.WaitGroup{}
-	resCh := make(chan []byte, len(lc.connections))
-	j := 0
-	for _, conn := range lc.connections {
+	for j := range queries {
 		wg.Add(1)
-		go func(j int, conn *grpc.ClientConn) {
-			resCh <- queryServer(subCtx, conn, lc.callOptions, queries[j])
-			wg.Done()
-		}(j, conn)
-		j++
+		go func(j int) {
+			defer wg.Done()
+			addr := order[j]
+			for {
+				a, err := queryServer(subCtx, lc.connections[addr], lc.callOptions, queries[j])
+				if err == nil {
+					answers[j], servers[j] = a, addr
+					return
+				}
+				logger.Warn("server failed", "server", addr, "error", err)
+
+				mu.Lock()
+				if next == len(order) {
+					mu.Unlock()
+					errs[j] = err
+					return
+				}
+				addr = order[next]
+				next++
+				mu.Unlock()
+			}
+		}(j)
 	}
 	wg.Wait()
-	close(resCh)
 
-	// combinate answers of all the servers
-	q := make([][]byte, 0)
-	for v := range resCh {
-		q = append(q, v)
+	for _, err := range errs {
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
-	return q
+	return answers, servers, nil
 }
 
-func queryServer(ctx context.Context, conn *grpc.ClientConn, opts []grpc.CallOption, query []byte) []byte {
+func queryServer(ctx context.Context, conn *grpc.ClientConn, opts []grpc.CallOption, query []byte) (*proto.QueryResponse, error) {
+	t := time.Now()
 	c := proto.NewVPIRClient(conn)
 	q := &proto.QueryRequest{Query: query}
 	answer, err := c.Query(ctx, q, opts...)
 	if err != nil {
-		log.Fatalf("could not query %s: %v",
-			conn.Target(), err)
+		return nil, xerrors.Errorf("could not query %s: %v", conn.Target(), err)
 	}
-	log.Printf("sent query to %s", conn.Target())
+	logger.Info("sent query", "server", conn.Target(), "query_size", len(query),
+		"answer_size", len(answer.GetAnswer()), "duration", time.Since(t))
 
-	return answer.GetAnswer()
+	return answer, nil
 }
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/si-co/vpir-code/lib/client"
+	"github.com/si-co/vpir-code/lib/database"
+	"github.com/si-co/vpir-code/lib/proto"
+	"github.com/si-co/vpir-code/lib/proto/prototest"
+	"github.com/si-co/vpir-code/lib/server"
+	"github.com/si-co/vpir-code/lib/utils"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding/gzip"
+)
+
+// failingServer fails the first failures queries and then answers with the
+// underlying server
+type failingServer struct {
+	server.Server
+	failures int32
+}
+
+func (s *failingServer) AnswerBytes(q []byte) ([]byte, error) {
+	if atomic.AddInt32(&s.failures, -1) >= 0 {
+		return nil, errors.New("server down")
+	}
+	return s.Server.AnswerBytes(q)
+}
+
+func TestServerFailureDoesNotStopClient(t *testing.T) {
+	db := randomBytesDB(t)
+
+	lc := &localClient{
+		ctx:         context.Background(),
+		callOptions: []grpc.CallOption{grpc.UseCompressor(gzip.Name)},
+		connections: make(map[string]*grpc.ClientConn),
+		prg:         utils.RandomPRG(),
+		sim: &SimConfig{
+			Experiment: ExperimentConfig{Repetitions: 3},
+			Point:      PointConfig{ElemBitSize: 8, BitsToRetrieve: 8 * 64},
+		},
+	}
+
+	// the second server fails to answer the first query
+	servers := []server.Server{server.NewPIR(db), &failingServer{Server: server.NewPIR(db), failures: 1}}
+	for i, s := range servers {
+		l := prototest.NewLoopback(prototest.NewVPIRServer(s))
+		defer l.Close()
+		conn, err := l.Dial(lc.ctx)
+		require.NoError(t, err)
+		lc.connections[fmt.Sprintf("server%d", i)] = conn
+	}
+	defer lc.closeConnections()
+
+	require.NoError(t, lc.retrieveDBInfo())
+	lc.vpirClient = client.NewPIR(lc.prg, lc.dbInfo)
+
+	// the failed repetition is reported, the other ones are executed
+	err := lc.retrievePointPIR()
+	require.EqualError(t, err, "1 out of 3 repetitions failed")
+}
+
+func TestDifferentMerkleRoots(t *testing.T) {
+	dbLen := 8 * 16 * 16 * 64
+	lc := &localClient{
+		ctx:         context.Background(),
+		callOptions: []grpc.CallOption{grpc.UseCompressor(gzip.Name)},
+		connections: make(map[string]*grpc.ClientConn),
+	}
+
+	// two Merkle dbs of the same size with different random entries
+	for i := 0; i < 2; i++ {
+		db := database.CreateRandomMerkle(utils.RandomPRG(), dbLen, 16, 64)
+		l := prototest.NewLoopback(prototest.NewVPIRServer(server.NewPIR(db)))
+		defer l.Close()
+		conn, err := l.Dial(lc.ctx)
+		require.NoError(t, err)
+		lc.connections[fmt.Sprintf("server%d", i)] = conn
+	}
+	defer lc.closeConnections()
+
+	err := lc.retrieveDBInfo()
+	require.ErrorContains(t, err, "different Merkle roots")
+	require.Nil(t, lc.dbInfo)
+}
+
+// countingServer counts the queries it answers
+type countingServer struct {
+	server.Server
+	queries int32
+}
+
+func (s *countingServer) AnswerBytes(q []byte) ([]byte, error) {
+	atomic.AddInt32(&s.queries, 1)
+	return s.Server.AnswerBytes(q)
+}
+
+func TestRunQueriesSubsetOfServers(t *testing.T) {
+	db := randomBytesDB(t)
+
+	n, k := 5, 3
+	lc := &localClient{
+		ctx:         context.Background(),
+		callOptions: []grpc.CallOption{grpc.UseCompressor(gzip.Name)},
+		connections: make(map[string]*grpc.ClientConn),
+		prg:         utils.RandomPRG(),
+		sim:         &SimConfig{Experiment: ExperimentConfig{QueryServers: k}},
+	}
+
+	// the first server can be made to fail
+	servers := make([]*countingServer, n)
+	down := &failingServer{}
+	for i := range servers {
+		servers[i] = &countingServer{Server: server.NewPIR(db)}
+		var s server.Server = servers[i]
+		if i == 0 {
+			down.Server = servers[i]
+			s = down
+		}
+		l := prototest.NewLoopback(prototest.NewVPIRServer(s))
+		defer l.Close()
+		conn, err := l.Dial(lc.ctx)
+		require.NoError(t, err)
+		lc.connections[fmt.Sprintf("server%d", i)] = conn
+	}
+	defer lc.closeConnections()
+
+	require.NoError(t, lc.retrieveDBInfo())
+	c := client.NewPIR(lc.prg, lc.dbInfo)
+	require.Equal(t, k, lc.numQueried())
+
+	in := []byte{0, 0, 0, 42}
+	queries, err := c.QueryBytes(in, lc.numQueried())
+	require.NoError(t, err)
+	resps, addrs, err := lc.runQueries(queries)
+	require.NoError(t, err)
+	require.Len(t, resps, k)
+	require.Equal(t, []string{"server0", "server1", "server2"}, addrs)
+
+	// exactly k servers are queried
+	queried := 0
+	for _, s := range servers {
+		queried += int(atomic.LoadInt32(&s.queries))
+	}
+	require.Equal(t, k, queried)
+
+	res, err := c.ReconstructBytes(answersOf(resps))
+	require.NoError(t, err)
+	require.Equal(t, db.Entries[42*64:43*64], res)
+
+	// the query of a failing server is sent to the next one
+	atomic.StoreInt32(&down.failures, 1<<30)
+	resps, addrs, err = lc.runQueries(queries)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"server1", "server2", "server3"}, addrs)
+	res, err = c.ReconstructBytes(answersOf(resps))
+	require.NoError(t, err)
+	require.Equal(t, db.Entries[42*64:43*64], res)
+
+	// the round-robin policy rotates the selected servers
+	lc.selection = newRoundRobin()
+	_, first, err := lc.runQueries(queries)
+	require.NoError(t, err)
+	_, second, err := lc.runQueries(queries)
+	require.NoError(t, err)
+	require.NotEqual(t, first, second)
+}
+
+func answersOf(resps []*proto.QueryResponse) [][]byte {
+	answers := make([][]byte, len(resps))
+	for i, r := range resps {
+		answers[i] = r.GetAnswer()
+	}
+	return answers
+}
+
+// randomBytesDB returns a random bytes db of 16 rows of 16 blocks of 64
+// bytes, the db of most of the tests
+func randomBytesDB(t testing.TB) *database.Bytes {
+	t.Helper()
+	db, err := database.CreateRandomBytes(utils.RandomPRG(), 8*16*16*64, 16, 64)
+	require.NoError(t, err)
+	return db
+}
+
+func TestResultsFile(t *testing.T) {
+	db := randomBytesDB(t)
+
+	var results bytes.Buffer
+	lc := &localClient{
+		ctx:         context.Background(),
+		callOptions: []grpc.CallOption{grpc.UseCompressor(gzip.Name)},
+		connections: make(map[string]*grpc.ClientConn),
+		prg:         utils.RandomPRG(),
+		results:     json.NewEncoder(&results),
+		sim: &SimConfig{
+			Experiment: ExperimentConfig{Repetitions: 3},
+			Scheme:     SchemeConfig{Name: "pir-classic"},
+			Point:      PointConfig{ElemBitSize: 8, BitsToRetrieve: 2 * 8 * 64},
+		},
+	}
+	for i := 0; i < 2; i++ {
+		l := prototest.NewLoopback(prototest.NewVPIRServer(server.NewPIR(db)))
+		defer l.Close()
+		conn, err := l.Dial(lc.ctx)
+		require.NoError(t, err)
+		lc.connections[fmt.Sprintf("server%d", i)] = conn
+	}
+	defer lc.closeConnections()
+
+	require.NoError(t, lc.retrieveDBInfo())
+	lc.vpirClient = client.NewPIR(lc.prg, lc.dbInfo)
+	require.NoError(t, lc.retrievePointPIR())
+
+	// one JSON record per repetition, for two blocks each
+	dec := json.NewDecoder(&results)
+	for j := 0; j < lc.sim.Experiment.Repetitions; j++ {
+		var res result
+		require.NoError(t, dec.Decode(&res))
+		require.Equal(t, "pir-classic", res.Scheme)
+		require.Equal(t, len(db.Entries), res.DBBytes)
+		require.Equal(t, j, res.Repetition)
+		require.Equal(t, 2*2*(db.NumColumns/8+1), res.QueryBytes)
+		require.Equal(t, 2*2*db.NumRows*db.BlockSize, res.AnswerBytes)
+		require.Positive(t, res.AnswerMs)
+		require.Empty(t, res.Error)
+	}
+	require.False(t, dec.More())
+}
+
+const testSimConfig = `
+[experiment]
+repetitions = 10
+numServers = 3
+queryServers = 2
+selection = "round-robin"
+
+[scheme]
+name = "pir-merkle"
+layout = "matrix"
+
+[point]
+elemBitSize = 8
+bitsToRetrieve = 8192
+`
+
+func TestParseSimConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sim.toml")
+	require.NoError(t, os.WriteFile(path, []byte(testSimConfig), 0o644))
+
+	c, err := parseSimConfig([]string{"-sim", path})
+	require.NoError(t, err)
+	require.Equal(t, ExperimentConfig{
+		Repetitions:  10,
+		NumServers:   3,
+		QueryServers: 2,
+		Selection:    "round-robin",
+	}, c.Experiment)
+	require.Equal(t, SchemeConfig{Name: "pir-merkle", Layout: "matrix"}, c.Scheme)
+	require.Equal(t, PointConfig{ElemBitSize: 8, BitsToRetrieve: 8192}, c.Point)
+	// the keys missing from the file keep their default
+	require.Equal(t, -1, c.Complex.InputSize)
+
+	// the flags override the file, wherever they are given
+	c, err = parseSimConfig([]string{"-repetitions", "5", "-sim", path, "-logFile", "client.log"})
+	require.NoError(t, err)
+	require.Equal(t, 5, c.Experiment.Repetitions)
+	require.Equal(t, "client.log", c.Experiment.LogFile)
+	require.Equal(t, 3, c.Experiment.NumServers)
+
+	// flags only
+	c, err = parseSimConfig([]string{"-scheme", "fss-auth", "-repetitions", "1", "-inputSize", "4"})
+	require.NoError(t, err)
+	require.Equal(t, "fss-auth", c.Scheme.Name)
+	require.Equal(t, 2, c.Experiment.NumServers)
+
+	// invalid configs
+	for _, args := range [][]string{
+		{"-sim", path, "-scheme", "pir-unknown"},
+		{"-sim", path, "-selection", "random"},
+		{"-sim", path, "-layout", "diagonal"},
+		{"-sim", path, "-repetitions", "0"},
+		{"-sim", path, "-queryServers", "4"},
+		{"-sim", path, "-elemBitSize", "-1"},
+		{"-sim", path, "-scheme", "fss-classic"},
+	} {
+		_, err := parseSimConfig(args)
+		require.Error(t, err, "%v", args)
+	}
+	_, err = parseSimConfig([]string{"-sim", filepath.Join(t.TempDir(), "missing.toml")})
+	require.Error(t, err)
+}
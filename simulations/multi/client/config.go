@@ -0,0 +1,193 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/BurntSushi/toml"
+	"github.com/si-co/vpir-code/lib/database"
+	"golang.org/x/xerrors"
+)
+
+// SimConfig defines an experiment run by the client. It is read from the
+// TOML file given by the -sim flag, if any, and every key can be overridden
+// by the corresponding flag:
+//
+//	[experiment]
+//	logFile = "client.log"     # file to store logs, stdout if empty
+//	results = "results.json"   # file to store one JSON result per repetition
+//	repetitions = 30           # experiment repetitions
+//	numServers = 2             # number of servers to connect to
+//	queryServers = 2           # servers queried out of numServers, all if not positive
+//	selection = "first"        # policy selecting the queried servers: first|round-robin
+//
+//	[scheme]
+//	name = "pir-classic"       # pir-classic, pir-merkle, fss-classic or fss-auth
+//	layout = "vector"          # optional, expected db layout for pir-classic and pir-merkle
+//
+//	[point]
+//	elemBitSize = 8            # bit size of an element, in which the block length is given
+//	bitsToRetrieve = 8192      # number of bits retrieved per repetition
+//
+//	[complex]
+//	inputSize = 16             # size in bytes of the string searched
+type SimConfig struct {
+	Experiment ExperimentConfig `toml:"experiment"`
+	Scheme     SchemeConfig     `toml:"scheme"`
+	Point      PointConfig      `toml:"point"`
+	Complex    ComplexConfig    `toml:"complex"`
+}
+
+// ExperimentConfig defines the parameters common to all the experiments
+type ExperimentConfig struct {
+	LogFile      string `toml:"logFile"`
+	ResultsFile  string `toml:"results"`
+	Repetitions  int    `toml:"repetitions"`
+	NumServers   int    `toml:"numServers"`
+	QueryServers int    `toml:"queryServers"`
+	Selection    string `toml:"selection"`
+}
+
+// SchemeConfig defines the scheme of the experiment
+type SchemeConfig struct {
+	Name   string `toml:"name"`
+	Layout string `toml:"layout"`
+}
+
+// PointConfig defines the parameters of the point queries, used by the
+// pir-classic and pir-merkle schemes
+type PointConfig struct {
+	ElemBitSize    int `toml:"elemBitSize"`
+	BitsToRetrieve int `toml:"bitsToRetrieve"`
+}
+
+// ComplexConfig defines the parameters of the complex queries, used by the
+// fss-classic and fss-auth schemes
+type ComplexConfig struct {
+	InputSize int `toml:"inputSize"`
+}
+
+// defaultSimConfig returns the config used for the keys given neither in
+// the file nor by the flags
+func defaultSimConfig() *SimConfig {
+	return &SimConfig{
+		Experiment: ExperimentConfig{
+			Repetitions:  -1,
+			NumServers:   2,
+			QueryServers: -1,
+			Selection:    "first",
+		},
+		Point: PointConfig{
+			ElemBitSize:    -1,
+			BitsToRetrieve: -1,
+		},
+		Complex: ComplexConfig{InputSize: -1},
+	}
+}
+
+// flagSet returns the flags overriding the keys of c, and the flag giving
+// the file to load c from
+func (c *SimConfig) flagSet(file *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	fs.StringVar(file, "sim", "", "TOML file defining the experiment, overridden by the other flags")
+
+	// experiment flags
+	fs.StringVar(&c.Experiment.LogFile, "logFile", c.Experiment.LogFile, "file to store logs")
+	fs.StringVar(&c.Experiment.ResultsFile, "results", c.Experiment.ResultsFile, "file to store one JSON result per repetition")
+	fs.IntVar(&c.Experiment.Repetitions, "repetitions", c.Experiment.Repetitions, "experiment repetitions")
+	fs.IntVar(&c.Experiment.NumServers, "numServers", c.Experiment.NumServers, "number of servers for the experiment")
+	fs.IntVar(&c.Experiment.QueryServers, "queryServers", c.Experiment.QueryServers, "number of servers queried out of numServers, all by default")
+	fs.StringVar(&c.Experiment.Selection, "selection", c.Experiment.Selection, "policy selecting the queried servers: first|round-robin")
+
+	// scheme flags
+	fs.StringVar(&c.Scheme.Name, "scheme", c.Scheme.Name, "scheme to use")
+	fs.StringVar(&c.Scheme.Layout, "layout", c.Scheme.Layout, "expected db layout for pir-classic and pir-merkle: vector|matrix")
+
+	// point queries flags
+	fs.IntVar(&c.Point.ElemBitSize, "elemBitSize", c.Point.ElemBitSize, "bit size of element, in which block length is specified")
+	fs.IntVar(&c.Point.BitsToRetrieve, "bitsToRetrieve", c.Point.BitsToRetrieve, "number of bits to retrieve in experiment")
+
+	// complex queries flags
+	fs.IntVar(&c.Complex.InputSize, "inputSize", c.Complex.InputSize, "input of string to search of")
+
+	return fs
+}
+
+// parseSimConfig returns the validated config defined by the command-line
+// arguments args, i.e., the file given by -sim, if any, overridden by the
+// other flags
+func parseSimConfig(args []string) (*SimConfig, error) {
+	var file string
+	c := defaultSimConfig()
+	if err := c.flagSet(&file).Parse(args); err != nil {
+		return nil, err
+	}
+
+	if file != "" {
+		c = defaultSimConfig()
+		if _, err := toml.DecodeFile(file, c); err != nil {
+			return nil, xerrors.Errorf("toml decoding: %v", err)
+		}
+		// only the flags given in args are set again
+		if err := c.flagSet(&file).Parse(args); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Validate returns an error if c does not define an experiment the client
+// can run
+func (c *SimConfig) Validate() error {
+	e := c.Experiment
+	if e.Repetitions <= 0 {
+		return xerrors.Errorf("repetitions must be positive, got %d", e.Repetitions)
+	}
+	if e.NumServers <= 0 {
+		return xerrors.Errorf("numServers must be positive, got %d", e.NumServers)
+	}
+	if e.QueryServers > e.NumServers {
+		return xerrors.Errorf("%d servers queried out of %d", e.QueryServers, e.NumServers)
+	}
+	if _, err := selectionByName(e.Selection); err != nil {
+		return err
+	}
+
+	switch c.Scheme.Name {
+	case "pir-classic", "pir-merkle":
+		if c.Scheme.Layout != "" {
+			if _, err := database.ParseLayout(c.Scheme.Layout); err != nil {
+				return err
+			}
+		}
+		if c.Point.ElemBitSize <= 0 || c.Point.BitsToRetrieve <= 0 {
+			return xerrors.Errorf("%s requires positive elemBitSize and bitsToRetrieve, got %d and %d",
+				c.Scheme.Name, c.Point.ElemBitSize, c.Point.BitsToRetrieve)
+		}
+	case "fss-classic", "fss-auth":
+		if c.Complex.InputSize <= 0 {
+			return xerrors.Errorf("%s requires a positive inputSize, got %d",
+				c.Scheme.Name, c.Complex.InputSize)
+		}
+	default:
+		return xerrors.Errorf("unknown scheme: %q", c.Scheme.Name)
+	}
+
+	return nil
+}
+
+// selectionByName returns the selection policy with the given name
+func selectionByName(name string) (selectionPolicy, error) {
+	switch name {
+	case "first":
+		return firstHealthy, nil
+	case "round-robin":
+		return newRoundRobin(), nil
+	default:
+		return nil, xerrors.Errorf("unknown selection policy: %q", name)
+	}
+}
@@ -1,5 +1,6 @@
 import os
 import argparse
+import json
 import numpy as np
 import matplotlib.lines as mlines
 import matplotlib.patches as mpatches
@@ -26,13 +27,28 @@ def load_config(file):
 def statistic(a):
     return np.median(a)
 
+def parseLogLine(line):
+    # logs are JSON records, older logs have free-form lines
+    if line.startswith("{"):
+        record = json.loads(line)
+        if record.get("msg") != "stats":
+            return None
+        return record
+    if "stats" in line:
+        return line.replace("\n", "").partition("stats,")[2].split(",")
+    return None
+
 def parseClientLog(file):
     tm, bw = [], []
     with open(file, "r") as f:
         for line in f:
-            if "stats" in line:
-                # parse log
-                stats = line.replace("\n", "").partition("stats,")[2].split(",")
+            stats = parseLogLine(line)
+            if stats is None:
+                continue
+            if isinstance(stats, dict):
+                bw.append(float(stats["bandwidth"]))
+                tm.append(float(stats["seconds"]))
+            else:
                 bw.append(float(stats[1]))
                 tm.append(float(stats[2]))
 
@@ -42,9 +58,12 @@ def parseServerLog(file):
     bw = []
     with open(file, "r") as f:
         for line in f:
-            if "stats" in line:
-                # parse log
-                stats = line.replace("\n", "").partition("stats,")[2].split(",")
+            stats = parseLogLine(line)
+            if stats is None:
+                continue
+            if isinstance(stats, dict):
+                bw.append(float(stats["answer_size"]))
+            else:
                 bw.append(float(stats[0]))
 
     return bw
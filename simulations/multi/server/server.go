@@ -3,9 +3,10 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
 	"math"
 	"net"
 	"net/http"
@@ -13,14 +14,18 @@ import (
 	"os/signal"
 	"runtime"
 	"syscall"
+	"time"
 
 	"github.com/si-co/vpir-code/lib/database"
 	"github.com/si-co/vpir-code/lib/proto"
 	"github.com/si-co/vpir-code/lib/server"
 	"github.com/si-co/vpir-code/lib/utils"
+	"golang.org/x/xerrors"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -32,29 +37,36 @@ const (
 )
 
 func main() {
-	sid := readServerID()
+	sid, err := readServerID()
+	if err != nil {
+		fatal("could not read server id", err)
+	}
 	logFile := flag.String("logFile", "", "write log to file instead of stdout/stderr")
 	scheme := flag.String("scheme", "", "scheme to use: pir-classic, pir-merkle")
 	elemBitSize := flag.Int("elemBitSize", -1, "bit size of element, in which block lengtht is specified")
 	dbLen := flag.Int("dbLen", -1, "DB length in bits")
 	nRows := flag.Int("nRows", -1, "number of rows in the DB representation")
 	blockLen := flag.Int("blockLen", -1, "block size for DB")
+	layout := flag.String("layout", "", "db layout for pir-classic and pir-merkle: vector|matrix, overrides nRows")
+	certFile := flag.String("cert", "", "PEM file of the TLS certificate of the server, instead of the built-in one for its id")
+	keyFile := flag.String("key", "", "PEM file of the private key of the certificate given with -cert")
 
 	flag.Parse()
 
 	// write either to stdout or to logfile
-	log.SetOutput(os.Stdout)
-	log.SetPrefix(fmt.Sprintf("[Server %v] ", sid))
+	var out io.Writer = os.Stdout
 	if len(*logFile) > 0 {
 		f, err := os.Create(*logFile)
 		if err != nil {
-			log.Fatal("Could not open file: ", err)
+			fatal("could not open log file", err)
 		}
 		defer f.Close()
-		log.SetOutput(f)
+		out = f
 	}
+	logger = newLogger(out, sid)
 
-	log.Println("flags:", sid, *logFile, *scheme, *dbLen, *elemBitSize, *nRows, *blockLen)
+	logger.Info("flags", "log_file", *logFile, "scheme", *scheme, "db_len", *dbLen,
+		"elem_bit_size", *elemBitSize, "rows", *nRows, "block_len", *blockLen, "layout", *layout)
 
 	// configs
 	configPath := os.Getenv(configEnvKey)
@@ -64,18 +76,29 @@ func main() {
 
 	config, err := utils.LoadConfig(configPath)
 	if err != nil {
-		log.Fatalf("could not load the server config file: %v", err)
+		fatal("could not load the server config file", err)
 	}
 	addr := config.Addresses[sid]
 
+	// db parameters from the config, overridden by the flags
+	params := new(utils.DBConfig)
+	if config.DB != nil {
+		*params = *config.DB
+	}
+	overrideDBParams(params, *scheme, *dbLen, *elemBitSize, *nRows, *blockLen, *layout)
+
 	// run server with TLS
+	cert, err := utils.ServerCertificate(*certFile, *keyFile, sid)
+	if err != nil {
+		fatal("could not load the TLS certificate", err)
+	}
 	cfg := &tls.Config{
-		Certificates: []tls.Certificate{utils.ServerCertificates[sid]},
+		Certificates: []tls.Certificate{cert},
 		ClientAuth:   tls.NoClientCert,
 	}
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		fatal("failed to listen", err)
 	}
 	rpcServer := grpc.NewServer(
 		grpc.MaxRecvMsgSize(1024*1024*1024),
@@ -83,62 +106,20 @@ func main() {
 		grpc.Creds(credentials.NewTLS(cfg)),
 	)
 
-	// initialize DB PRG
-	prgKey := new(utils.PRGKey)
-	copy(prgKey[:], []byte(dbPRGkey))
-	dbPRG := utils.NewPRG(prgKey)
-
-	// Find the total number of blocks in the db
-	numBlocks := *dbLen
-	if (*scheme)[:3] != "cmp" {
-		numBlocks = *dbLen / ((*elemBitSize) * (*blockLen))
-	}
-	// matrix db
-	if *nRows != 1 {
-		utils.IncreaseToNextSquare(&numBlocks)
-		*nRows = int(math.Sqrt(float64(numBlocks)))
-	}
-
-	// initialize db
-	var dbBytes *database.Bytes
-	var dbFSS *database.DB
-	switch *scheme {
-	case "pir-classic":
-		dbBytes = database.CreateRandomBytes(dbPRG, *dbLen, *nRows, *blockLen)
-	case "pir-merkle":
-		dbBytes = database.CreateRandomMerkle(dbPRG, *dbLen, *nRows, *blockLen)
-	case "fss-classic", "fss-auth":
-		numIdenfitiers := 100000
-		dbFSS, err = database.CreateRandomKeysDB(dbPRG, numIdenfitiers)
-		if err != nil {
-			log.Fatal(err)
-		}
-	default:
-		log.Fatal("unknow scheme: " + string(*scheme))
+	s, err := newDBServer(params, sid)
+	if err != nil {
+		fatal("could not create the db", err)
 	}
 
 	// GC after db creation
 	runtime.GC()
 
-	// select correct server
-	var s server.Server
-	switch *scheme {
-	case "pir-classic", "pir-merkle":
-		s = server.NewPIR(dbBytes)
-	case "fss-classic":
-		s = server.NewPredicatePIR(dbFSS, byte(sid))
-	case "fss-auth":
-		s = server.NewPredicateAPIR(dbFSS, byte(sid))
-	default:
-		log.Fatal("unknow scheme for server: " + string(*scheme))
-	}
-
 	// start server
 	proto.RegisterVPIRServer(rpcServer, &vpirServer{
 		Server: s,
-		scheme: *scheme,
+		scheme: params.Scheme,
 	})
-	log.Printf("is listening at %s", addr)
+	logger.Info("listening", "address", addr)
 
 	// listen signals from os
 	sigCh := make(chan os.Signal, 1)
@@ -146,7 +127,7 @@ func main() {
 	errCh := make(chan error, 1)
 
 	go func() {
-		log.Println("starting grpc server")
+		logger.Info("starting grpc server")
 		if err := rpcServer.Serve(lis); err != nil {
 			errCh <- err
 		}
@@ -155,7 +136,7 @@ func main() {
 	// start HTTP server for tests
 	host, _, err := net.SplitHostPort(addr)
 	if err != nil {
-		log.Fatal("impossible to parse addr for HTTP server")
+		fatal("impossible to parse addr for HTTP server", err)
 	}
 	h := func(w http.ResponseWriter, _ *http.Request) {
 		sigCh <- os.Interrupt
@@ -169,12 +150,118 @@ func main() {
 
 	select {
 	case err := <-errCh:
-		log.Fatalf("failed to serve: %v", err)
+		fatal("failed to serve", err)
 	case <-sigCh:
 		rpcServer.GracefulStop()
 		lis.Close()
 		srv.Shutdown(context.Background())
-		log.Println("clean shutdown of server done")
+		logger.Info("clean shutdown of server done")
+	}
+}
+
+// logger writes the logs of the server, to stdout until main sets the log
+// file
+var logger = utils.NewLogger(os.Stdout, "role", "server")
+
+// newLogger returns a logger writing JSON records tagged with the server id
+// to w
+func newLogger(w io.Writer, sid int) *utils.Logger {
+	return utils.NewLogger(w, "role", "server", "sid", sid)
+}
+
+// fatal logs err and exits. It is only called from main, the other
+// functions return their errors.
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// overrideDBParams sets the db parameters given as flags, i.e., the ones
+// different from their default value
+func overrideDBParams(params *utils.DBConfig, scheme string, dbLen, elemBitSize, nRows, blockLen int, layout string) {
+	if scheme != "" {
+		params.Scheme = scheme
+	}
+	if dbLen != -1 {
+		params.DBLen = dbLen
+	}
+	if elemBitSize != -1 {
+		params.ElemBitSize = elemBitSize
+	}
+	if nRows != -1 {
+		params.NRows = nRows
+	}
+	if blockLen != -1 {
+		params.BlockLen = blockLen
+	}
+	if layout != "" {
+		params.Layout = layout
+	}
+}
+
+// newDBServer builds the db defined by params and returns the server for
+// its scheme
+func newDBServer(params *utils.DBConfig, sid int) (server.Server, error) {
+	nRows := params.NRows
+	if params.Layout != "" {
+		rebalanced, err := database.ParseLayout(params.Layout)
+		if err != nil {
+			return nil, err
+		}
+		// any number of rows different from 1 selects the matrix layout,
+		// the actual number is computed below
+		nRows = 1
+		if rebalanced {
+			nRows = 0
+		}
+	}
+
+	// initialize DB PRG
+	prgKey := new(utils.PRGKey)
+	copy(prgKey[:], []byte(dbPRGkey))
+	dbPRG := utils.NewPRG(prgKey)
+
+	// Find the total number of blocks in the db
+	numBlocks := params.DBLen
+	if len(params.Scheme) < 3 || params.Scheme[:3] != "cmp" {
+		if params.ElemBitSize <= 0 || params.BlockLen <= 0 {
+			return nil, xerrors.Errorf("invalid element bit size %d or block length %d",
+				params.ElemBitSize, params.BlockLen)
+		}
+		numBlocks = params.DBLen / (params.ElemBitSize * params.BlockLen)
+	}
+	// matrix db
+	if nRows != 1 {
+		utils.IncreaseToNextSquare(&numBlocks)
+		nRows = int(math.Sqrt(float64(numBlocks)))
+	}
+
+	switch params.Scheme {
+	case "pir-classic":
+		// align the db length to the geometry of the db
+		realizableLen := database.RealizableBytesLength(params.DBLen, nRows, params.BlockLen)
+		if realizableLen != params.DBLen {
+			logger.Warn("db length does not fit the db geometry", "db_len", params.DBLen, "realizable_len", realizableLen)
+		}
+		db, err := database.CreateRandomBytes(dbPRG, realizableLen, nRows, params.BlockLen)
+		if err != nil {
+			return nil, err
+		}
+		return server.NewPIR(db), nil
+	case "pir-merkle":
+		return server.NewPIR(database.CreateRandomMerkle(dbPRG, params.DBLen, nRows, params.BlockLen)), nil
+	case "fss-classic", "fss-auth":
+		numIdenfitiers := 100000
+		db, err := database.CreateRandomKeysDB(dbPRG, numIdenfitiers)
+		if err != nil {
+			return nil, err
+		}
+		if params.Scheme == "fss-classic" {
+			return server.NewPredicatePIR(db, byte(sid)), nil
+		}
+		return server.NewPredicateAPIR(db, byte(sid)), nil
+	default:
+		return nil, xerrors.Errorf("unknown scheme: %s", params.Scheme)
 	}
 }
 
@@ -189,7 +276,7 @@ type vpirServer struct {
 
 func (s *vpirServer) DatabaseInfo(ctx context.Context, r *proto.DatabaseInfoRequest) (
 	*proto.DatabaseInfoResponse, error) {
-	log.Print("got databaseInfo request")
+	logger.Info("got databaseInfo request")
 
 	dbInfo := s.Server.DBInfo()
 
@@ -201,7 +288,7 @@ func (s *vpirServer) DatabaseInfo(ctx context.Context, r *proto.DatabaseInfoRequ
 		NumRows:     uint32(dbInfo.NumRows),
 		NumColumns:  uint32(dbInfo.NumColumns),
 		BlockLength: uint32(dbInfo.BlockSize),
-		PirType:     dbInfo.PIRType,
+		PirType:     string(dbInfo.PIRType),
 		Root:        dbInfo.Root,
 		ProofLen:    uint32(dbInfo.ProofLen),
 	}
@@ -211,30 +298,59 @@ func (s *vpirServer) DatabaseInfo(ctx context.Context, r *proto.DatabaseInfoRequ
 
 func (s *vpirServer) Query(ctx context.Context, qr *proto.QueryRequest) (
 	*proto.QueryResponse, error) {
-	log.Print("got query request")
+	t := time.Now()
+	resp, err := s.answer(qr.GetQuery())
+	if err != nil {
+		logger.Error("query failed", "query_size", len(qr.GetQuery()), "error", err)
+		if errors.Is(err, server.ErrInvalidQuery) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, err
+	}
+	logger.Info("stats", "query_size", len(qr.GetQuery()), "answer_size", len(resp.Answer),
+		"duration", time.Since(t))
+
+	return resp, nil
+}
+
+// answer computes the response to the query, including the answer
+// statistics if the server reports them
+func (s *vpirServer) answer(q []byte) (*proto.QueryResponse, error) {
+	ss, ok := s.Server.(server.StatsServer)
+	if !ok {
+		a, err := s.Server.AnswerBytes(q)
+		if err != nil {
+			return nil, err
+		}
+		return &proto.QueryResponse{Answer: a}, nil
+	}
 
-	a, err := s.Server.AnswerBytes(qr.GetQuery())
+	a, stats, err := ss.AnswerBytesWithStats(q)
 	if err != nil {
 		return nil, err
 	}
-	answerLen := len(a)
-	log.Printf("stats,%d", answerLen)
 
-	return &proto.QueryResponse{Answer: a}, nil
+	return &proto.QueryResponse{
+		Answer:      a,
+		AnswerBytes: uint64(stats.AnswerBytes),
+		ComputeMs:   stats.ComputeMs,
+		WorkersUsed: uint32(stats.WorkersUsed),
+	}, nil
 }
 
-func readServerID() int {
+func readServerID() (int, error) {
 	file, err := os.Open("sid")
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
+	defer file.Close()
 
 	var sid int
 
 	_, err = fmt.Fscanf(file, "%d\n", &sid) // give a pattern to scan
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
-	return sid
+	return sid, nil
 }
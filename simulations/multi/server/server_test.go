@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/si-co/vpir-code/lib/proto"
+	"github.com/si-co/vpir-code/lib/utils"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `
+[servers]
+  [servers.0]
+  ip = "127.0.0.1"
+  port = 50050
+
+[db]
+  scheme = "pir-classic"
+  dbLen = 8192
+  elemBitSize = 8
+  nRows = 1
+  blockLen = 16
+`
+
+func TestDBServerFromConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(path, []byte(testConfig), 0o644))
+
+	config, err := utils.LoadConfig(path)
+	require.NoError(t, err)
+	require.NotNil(t, config.DB)
+
+	// flags left to their default do not override the config
+	params := new(utils.DBConfig)
+	*params = *config.DB
+	overrideDBParams(params, "", -1, -1, -1, -1, "")
+	require.Equal(t, *config.DB, *params)
+
+	s, err := newDBServer(params, 0)
+	require.NoError(t, err)
+
+	vs := &vpirServer{Server: s, scheme: params.Scheme}
+	info, err := vs.DatabaseInfo(context.Background(), &proto.DatabaseInfoRequest{})
+	require.NoError(t, err)
+
+	// 8192 bits are 64 blocks of 16 bytes
+	require.Equal(t, uint32(1), info.GetNumRows())
+	require.Equal(t, uint32(64), info.GetNumColumns())
+	require.Equal(t, uint32(16), info.GetBlockLength())
+}
+
+func TestDBServerFromConfigMatrix(t *testing.T) {
+	params := &utils.DBConfig{
+		Scheme:      "pir-classic",
+		DBLen:       8192,
+		ElemBitSize: 8,
+		NRows:       1,
+		BlockLen:    16,
+	}
+	overrideDBParams(params, "", -1, -1, -1, -1, "matrix")
+
+	s, err := newDBServer(params, 0)
+	require.NoError(t, err)
+
+	vs := &vpirServer{Server: s, scheme: params.Scheme}
+	info, err := vs.DatabaseInfo(context.Background(), &proto.DatabaseInfoRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint32(8), info.GetNumRows())
+	require.Equal(t, uint32(8), info.GetNumColumns())
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"math"
@@ -62,6 +63,8 @@ func main() {
 	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to file")
 	memprofile := flag.String("memprofile", "", "write mem profile to file")
 	indivConfigFile := flag.String("config", "", "config file for simulation")
+	lweDigests := flag.String("lweDigests", "", "directory caching the digests of the cmp-vpir-lwe dbs, which are then generated from a fixed seed")
+	numa := flag.Bool("numa", false, "answer cmp-vpir-dh queries with a thread pinned to every CPU of the NUMA nodes, see "+server.NUMANodesEnv)
 	flag.Parse()
 
 	// CPU profiling
@@ -129,21 +132,29 @@ func main() {
 		dbElliptic := new(database.Elliptic)
 		dbLWE := new(database.LWE)
 		dbLWE128 := new(database.LWE128)
+		var err error
 		switch s.Primitive[:3] {
 		case "cmp":
 			if s.Primitive == "cmp-vpir-dh" {
 				log.Printf("Generating elliptic db of size %d\n", dbLen)
-				dbElliptic = database.CreateRandomEllipticWithDigest(dbPRG, dbLen, group.P256, true)
+				dbElliptic, err = database.CreateRandomEllipticWithDigest(dbPRG, dbLen, group.P256, true)
 			} else if s.Primitive == "cmp-vpir-lwe" {
 				log.Printf("Generating LWE db of size %d\n", dbLen)
-				dbLWE = database.CreateRandomBinaryLWEWithLength(dbPRG, dbLen)
+				if *lweDigests != "" {
+					dbLWE, err = lweWithCachedDigest(*lweDigests, dbLen)
+				} else {
+					dbLWE, err = database.CreateRandomBinaryLWEWithLength(dbPRG, dbLen)
+				}
 			} else if s.Primitive == "cmp-vpir-lwe-128" {
 				log.Printf("Generating LWE128 db of size %d\n", dbLen)
-				dbLWE128 = database.CreateRandomBinaryLWEWithLength128(dbPRG, dbLen)
+				dbLWE128, err = database.CreateRandomBinaryLWEWithLength128(dbPRG, dbLen)
 			} else {
 				log.Fatal("unknow primitive type:", s.Primitive)
 			}
 		}
+		if err != nil {
+			log.Fatal(err)
+		}
 
 		// GC after DB creation
 		runtime.GC()
@@ -153,17 +164,17 @@ func main() {
 		var results []*Chunk
 		switch s.Primitive {
 		case "cmp-vpir-dh":
-			log.Printf("db info: %#v", dbElliptic.Info)
-			results = pirElliptic(dbElliptic, s.Repetitions)
+			log.Printf("db info: %v", dbElliptic.Info)
+			results = pirElliptic(dbElliptic, s.Repetitions, *numa)
 		case "cmp-vpir-lwe": // LWE uses Amplify
-			log.Printf("db info: %#v", dbLWE.Info)
+			log.Printf("db info: %v", dbLWE.Info)
 			rep, ok := tECC[dbLen]
 			if !ok {
 				panic("tECC not defined for this db length")
 			}
 			results = pirLWE(dbLWE, s.Repetitions, rep)
 		case "cmp-vpir-lwe-128":
-			log.Printf("db info: %#v", dbLWE128.Info)
+			log.Printf("db info: %v", dbLWE128.Info)
 			results = pirLWE128(dbLWE128, s.Repetitions)
 		case "preprocessing":
 			log.Printf("Merkle preprocessing evaluation for dbLen %d bits\n", dbLen)
@@ -244,6 +255,23 @@ func pirLWE128(db *database.LWE128, nRepeat int) []*Chunk {
 	return results
 }
 
+// lweWithCachedDigest returns a LWE db of dbLen bits generated from a
+// fixed seed, so that its digest, computed on the first run, is loaded
+// from dir on the next ones
+func lweWithCachedDigest(dir string, dbLen int) (*database.LWE, error) {
+	numRows, numColumns := database.CalculateNumRowsAndColumns(dbLen, true)
+	db, err := database.CreateRandomBinaryLWEWithoutDigest(utils.NewPRG(new(utils.PRGKey)), numRows, numColumns)
+	if err != nil {
+		return nil, err
+	}
+	digestPath := path.Join(dir, fmt.Sprintf("lwe-%d.digest", dbLen))
+	if err := database.LoadOrComputeDigestLWE(digestPath, db); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
 // LWE uses Amplify
 func pirLWE(db *database.LWE, nRepeat, tECC int) []*Chunk {
 	numRetrievedBlocks := 1
@@ -285,13 +313,23 @@ func pirLWE(db *database.LWE, nRepeat, tECC int) []*Chunk {
 	return results
 }
 
-func pirElliptic(db *database.Elliptic, nRepeat int) []*Chunk {
+func pirElliptic(db *database.Elliptic, nRepeat int, numa bool) []*Chunk {
 	numRetrievedBlocks := 1
 	results := make([]*Chunk, nRepeat)
 
 	prg := utils.RandomPRG()
 	c := client.NewDH(prg, &db.Info)
 	s := server.NewDH(db)
+	if numa {
+		nodes, err := server.NUMANodes()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if s, err = server.NewDHWithNUMA(db, nodes); err != nil {
+			log.Fatal(err)
+		}
+		defer s.Close()
+	}
 
 	for j := 0; j < nRepeat; j++ {
 		log.Printf("start repetition %d out of %d", j+1, nRepeat)
@@ -301,7 +339,7 @@ func pirElliptic(db *database.Elliptic, nRepeat int) []*Chunk {
 		results[j].Digest = float64(len(db.SubDigests)) + float64(len(db.Digest))
 
 		// pick a random block index to start the retrieval
-		index := rand.Intn(db.NumRows * db.NumColumns)
+		index := rand.Intn(db.NumBlocks())
 		results[j].CPU[0] = initBlock(1)
 		results[j].Bandwidth[0] = initBlock(1)
 